@@ -2,16 +2,16 @@ package config
 
 // DBConfig is the configurations for connecting database
 type DBConfig struct {
-	Driver string // db driver name: sqlite, mysql, postgres
-	DSN    string // db connection string
+	Driver string `validate:"required,oneof=sqlite mysql postgres"` // db driver name: sqlite, mysql, postgres
+	DSN    string `validate:"required"`                             // db connection string
 }
 
 // HTTPConfig is the configurations for HTTP server
 type HTTPConfig struct {
-	Addr string // listen address: ":8080"
-	//Https       bool   `json:"https"`    // enable https?
-	//TLSCertPath string `json:"tls_cert_path"` // path to tls cert file
-	//TLSKeyPath  string `json:"tls_key_path"`  // path to tls key file
+	Addr        string // listen address: ":8080"
+	Https       bool   // enable https?
+	TLSCertPath string `validate:"required_if=Https true"` // path to tls cert file
+	TLSKeyPath  string `validate:"required_if=Https true"` // path to tls key file
 }
 
 // BaseConfig includes common config for services
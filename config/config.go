@@ -7,18 +7,52 @@ import (
 	"fmt"
 	"github.com/cdfmlr/crud/log"
 	"github.com/fsnotify/fsnotify"
+	"github.com/go-playground/validator/v10"
 	"github.com/spf13/viper"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var logger = log.ZoneLogger("crud/config")
 
 // Init reads configure, write values into given configModel
 //  - FromFile: read config from file
+//  - FromFiles: read and merge config from files
+//  - FromReader, FromBytes: read config from an io.Reader or bytes
+//  - FromRemote: read config from a remote key/value store (etcd, consul, ...)
 //  - FromEnv: read config from environment variables
 //  - WatchFileChange: watch config file and reload config when changed
+//  - WatchSignal: reload config when receiving a signal
+//  - WatchRemoteChange: watch remote config and reload config when changed
+//  - WithValidation: validate config by the `validate` struct tags
+//
+// Fields are defaulted by their `default:"..."` struct tags (if any), values
+// from the options take precedence over the defaults.
+//
+// The configModel is bound to the package, read it by Snapshot if it
+// can be reloaded (by WatchFileChange).
 func Init(configModel any, options ...Option) error {
+	mu.Lock()
+	bound = configModel
+	mu.Unlock()
+	sources.Store(configModel, options)
+
+	viperMu.Lock()
+	err := setDefaultsFromTags(configModel)
+	viperMu.Unlock()
+	if err != nil {
+		logger.WithError(err).
+			Error("Init config: setDefaultsFromTags error")
+		return err
+	}
+
 	for _, option := range options {
 		err := option(configModel)
 		if err != nil {
@@ -30,6 +64,57 @@ func Init(configModel any, options ...Option) error {
 
 type Option func(configModel any) error
 
+// mu guards the config bound by Init against the reloads.
+var (
+	mu    sync.RWMutex
+	bound any // the configModel of Init
+)
+
+// viperMu guards the global viper instance: it is read and written by the
+// options, the watchers and the reloads only with viperMu locked.
+// It is always locked before mu.
+var viperMu sync.Mutex
+
+// Snapshot returns a copy of the config bound by Init:
+//     var config MyConfig
+//     config.Init(&config, FromFile(path), WatchFileChange(hook))
+//     ...
+//     current := config.Snapshot[MyConfig]()
+//
+// It is safe to call Snapshot while the config is being reloaded, and the
+// copy is consistent: it is never changed by the reloads. So callers should
+// read the config via Snapshot, instead of holding the struct pointer
+// given to Init, if the config can be reloaded (by WatchFileChange).
+//
+// The zero value of T is returned if T is not the type of the bound config.
+func Snapshot[T any]() T {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	config, ok := bound.(*T)
+	if !ok || config == nil {
+		logger.WithField("config", fmt.Sprintf("%T", bound)).
+			WithField("want", fmt.Sprintf("%T", new(T))).
+			Warn("Snapshot: config type mismatch, return zero value")
+		return *new(T)
+	}
+	return *config
+}
+
+// unmarshal viper config into config, with viperMu and mu locked.
+func unmarshal(config any) error {
+	viperMu.Lock()
+	defer viperMu.Unlock()
+	return unmarshalLocked(config)
+}
+
+// unmarshalLocked is unmarshal for the callers holding viperMu.
+func unmarshalLocked(config any) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return viper.Unmarshal(config)
+}
+
 // FromFile reads config from file at path, and unmarshal to config.
 // YAML, JSON, TOML, etc. files are supported.
 // This is the recommended way to read config.
@@ -45,19 +130,99 @@ func FromFile(path string) Option {
 	}
 }
 
+// FromFiles reads and merges config from files at paths in order, so the
+// values in later files override the ones in earlier files, for example:
+//     config.Init(&config, FromFiles("config.yaml", "config.local.yaml"))
+// Missing files are skipped.
+//
+// Notice: WatchFileChange only watches the last existing file.
+func FromFiles(paths ...string) Option {
+	return func(config any) error {
+		err := readFromFiles(paths, config)
+		if err != nil {
+			logger.WithError(err).
+				Error("Init config FromFiles: readFromFiles error")
+			return err
+		}
+		return nil
+	}
+}
+
+// FromReader reads config in the format from r, and unmarshal to config.
+// Supported formats are the ones supported by viper: "yaml", "json",
+// "toml", etc. It's useful for tests and for the config embedded into the
+// binary (by go:embed), see also FromBytes.
+//
+// Notice: r is consumed, so it can not be re-read by WatchSignal,
+// use FromBytes instead if needed.
+func FromReader(r io.Reader, format string) Option {
+	return func(config any) error {
+		err := readFromReader(r, format, config)
+		if err != nil {
+			logger.WithError(err).
+				Error("Init config FromReader: readFromReader error")
+			return err
+		}
+		return nil
+	}
+}
+
+// FromBytes reads config in the format from b, and unmarshal to config.
+// For example, with a config file embedded:
+//     //go:embed config.yaml
+//     var configYAML []byte
+//     config.Init(&config, FromBytes(configYAML, "yaml"))
+// See FromReader for the supported formats.
+func FromBytes(b []byte, format string) Option {
+	return func(config any) error {
+		err := readFromReader(bytes.NewReader(b), format, config)
+		if err != nil {
+			logger.WithError(err).
+				Error("Init config FromBytes: readFromReader error")
+			return err
+		}
+		return nil
+	}
+}
+
 // WatchFileChange works with FromFile:
 //     var config MyConfig
-//     config.Init(&config, FromFile(path), WatchFileChange(hook))
+//     watchFile, stop := WatchFileChange(hook)
+//     defer stop()
+//     config.Init(&config, FromFile(path), watchFile)
 //
-// WatchFileChange watch current viper config file,
-// and reload config when changed.
+// WatchFileChange watches the current config file (the one read by the
+// last FromFile or FromFiles before it), and reloads config when changed:
+// the options given to Init are re-run as WatchSignal does, and then the
+// hook is called. If an option fails while reloading, the config is kept
+// unchanged and the hook is not called.
 //
 // Notice: you do not need to reset your `config` variable in the hook,
-// we will do it for you. BUT THIS FEATURE MAKE THE `config` NOT THREAD-SAFE.
-// It's to be fixed in the future.
-func WatchFileChange(hook func(oldConfig any, newConfig any)) Option {
-	return func(config any) error {
-		err := watch(config, hook)
+// we will do it for you. The `config` is replaced while reloading, so read
+// it by Snapshot, which is thread-safe, instead of accessing it directly.
+//
+// stop stops watching the file. It is safe to call stop more than once.
+func WatchFileChange(hook func(oldConfig any, newConfig any)) (option Option, stop func()) {
+	var registered atomic.Bool
+	done := make(chan struct{})
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() { close(done) })
+	}
+
+	option = func(config any) error {
+		if !registered.CompareAndSwap(false, true) { // re-running by WatchSignal
+			return nil
+		}
+		viperMu.Lock()
+		path := viper.ConfigFileUsed()
+		viperMu.Unlock()
+		if path == "" {
+			logger.Warn("Init config WatchFileChange: no config file to watch, ignored")
+			return nil
+		}
+		err := watch(config, path, hook, done)
 		if err != nil {
 			logger.WithError(err).
 				Error("Init config WatchFileChange: watch error")
@@ -65,6 +230,170 @@ func WatchFileChange(hook func(oldConfig any, newConfig any)) Option {
 		}
 		return nil
 	}
+	return option, stop
+}
+
+// WithValidation validates the config by the `validate` struct tags of
+// go-playground/validator, for example:
+//     type DBConfig struct {
+//         Driver string `validate:"required,oneof=sqlite mysql postgres"`
+//         DSN    string `validate:"required"`
+//     }
+// Init returns an ErrInvalidConfig error listing the offending fields:
+//     invalid config: DB.Driver: oneof=sqlite mysql postgres; DB.DSN: required
+//
+// Options are applied in order, so put it after the config sources
+// (FromFile, FromEnv, ...):
+//     config.Init(&config, FromFile(path), FromEnv("MYAPP"), WithValidation())
+func WithValidation() Option {
+	return func(config any) error {
+		mu.RLock()
+		err := validate(config)
+		mu.RUnlock()
+		if err != nil {
+			logger.WithError(err).
+				Error("Init config WithValidation: validate error")
+			return err
+		}
+		return nil
+	}
+}
+
+// WatchSignal reloads the config when receiving the signal sig, for example:
+//     watchSignal, stop := WatchSignal(syscall.SIGHUP, hook)
+//     defer stop()
+//     config.Init(&config, FromFile(path), FromEnv("MYAPP"), watchSignal)
+// The options given to Init are re-run (re-reading the file and the
+// environment variables, etc.), and then the hook is called.
+// It provides a reload path for env-only deployments, where the config
+// file (if any) never changes.
+//
+// It composes with WatchFileChange: each file change or signal fires the
+// hook once, and the watchers are not registered again while reloading.
+// If an option fails while reloading (e.g. WithValidation), the config
+// is kept unchanged and the hook is not called.
+//
+// stop stops relaying the signal (by signal.Stop) and the reloads.
+// It is safe to call stop more than once.
+func WatchSignal(sig os.Signal, hook func(oldConfig any, newConfig any)) (option Option, stop func()) {
+	var registered atomic.Bool
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+		})
+	}
+
+	option = func(config any) error {
+		if !registered.CompareAndSwap(false, true) { // re-running by WatchSignal
+			return nil
+		}
+		if !configMustPtrToStruct(config) {
+			return ErrConfigNotPtrToStruct
+		}
+
+		signal.Notify(signals, sig)
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case <-signals:
+				}
+				oldConfig, err := reloadSources(config)
+				if err != nil {
+					logger.WithError(err).
+						Error("WatchSignal: reloadSources error")
+					continue
+				}
+				hook(oldConfig, config)
+			}
+		}()
+		return nil
+	}
+	return option, stop
+}
+
+// FromRemote reads config from a remote key/value store, and unmarshal to
+// config. The provider is one of viper.SupportedRemoteProviders ("etcd",
+// "etcd3", "consul", ...), for example:
+//     import _ "github.com/spf13/viper/remote"  // enable the remote features
+//
+//     config.Init(&config,
+//         FromRemote("consul", "localhost:8500", "/config/myapp.yaml"),
+//         WatchRemoteChange(hook))
+// The config format is derived from the extension of path ("yaml" above),
+// or the json format if there is no extension.
+//
+// Notice: the remote features of viper are enabled by the blank import of
+// github.com/spf13/viper/remote (which is not imported by this package to
+// avoid pulling the clients of all providers), otherwise an error is
+// returned.
+//
+// The remote provider is added to viper once, re-running the option (by
+// WatchSignal) re-reads the config from it.
+func FromRemote(provider, endpoint, path string) Option {
+	var added atomic.Bool
+	return func(config any) error {
+		if !added.Load() {
+			err := addRemoteProvider(provider, endpoint, path)
+			if err != nil {
+				logger.WithError(err).
+					Error("Init config FromRemote: addRemoteProvider error")
+				return err
+			}
+			added.Store(true)
+		}
+		err := readFromRemote(path, config)
+		if err != nil {
+			logger.WithError(err).
+				Error("Init config FromRemote: readFromRemote error")
+			return err
+		}
+		return nil
+	}
+}
+
+// RemotePollInterval is the interval of WatchRemoteChange checking the
+// remote config for changes.
+var RemotePollInterval = 5 * time.Second
+
+// WatchRemoteChange works with FromRemote, it watches the remote config,
+// and reloads config when changed. The hook is the same as WatchFileChange:
+//     watchRemote, stop := WatchRemoteChange(hook)
+//     defer stop()
+//     config.Init(&config, FromRemote(provider, endpoint, path), watchRemote)
+//
+// Viper keeps the remote config up to date (by
+// viper.WatchRemoteConfigOnChannel), and the changes are picked up
+// every RemotePollInterval, until stop is called.
+// It is safe to call stop more than once.
+func WatchRemoteChange(hook func(oldConfig any, newConfig any)) (option Option, stop func()) {
+	var registered atomic.Bool
+	done := make(chan struct{})
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() { close(done) })
+	}
+
+	option = func(config any) error {
+		if !registered.CompareAndSwap(false, true) { // re-running by WatchSignal
+			return nil
+		}
+		err := watchRemote(config, hook, done)
+		if err != nil {
+			logger.WithError(err).
+				Error("Init config WatchRemoteChange: watchRemote error")
+			return err
+		}
+		return nil
+	}
+	return option, stop
 }
 
 // FromEnv reads config from environment variables, and unmarshal to config
@@ -95,12 +424,134 @@ func readFromFile(path string, config any) error {
 		return ErrConfigNotPtrToStruct
 	}
 
+	viperMu.Lock()
+	defer viperMu.Unlock()
+
 	viper.SetConfigFile(path)
 	err := viper.ReadInConfig()
 	if err != nil {
 		return err
 	}
-	return viper.Unmarshal(config)
+	return unmarshalLocked(config)
+}
+
+// readFromReader reads config in the format from r, and unmarshal to config
+func readFromReader(r io.Reader, format string, config any) error {
+	if !configMustPtrToStruct(config) {
+		return ErrConfigNotPtrToStruct
+	}
+
+	viperMu.Lock()
+	defer viperMu.Unlock()
+
+	viper.SetConfigType(format)
+	err := viper.ReadConfig(r)
+	if err != nil {
+		return err
+	}
+	return unmarshalLocked(config)
+}
+
+// readFromFiles reads and merges config files at paths in order,
+// and unmarshal to config. Missing files are skipped.
+func readFromFiles(paths []string, config any) error {
+	if !configMustPtrToStruct(config) {
+		return ErrConfigNotPtrToStruct
+	}
+
+	viperMu.Lock()
+	defer viperMu.Unlock()
+
+	for _, path := range paths {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			logger.WithField("path", path).
+				Info("readFromFiles: config file not found, skipped")
+			continue
+		}
+
+		viper.SetConfigFile(path)
+		if err := viper.MergeInConfig(); err != nil {
+			return err
+		}
+	}
+	return unmarshalLocked(config)
+}
+
+// addRemoteProvider adds the remote provider to viper
+func addRemoteProvider(provider, endpoint, path string) error {
+	viperMu.Lock()
+	defer viperMu.Unlock()
+	return viper.AddRemoteProvider(provider, endpoint, path)
+}
+
+// readFromRemote reads config at path from the remote provider (added by
+// addRemoteProvider), and unmarshal to config
+func readFromRemote(path string, config any) error {
+	if !configMustPtrToStruct(config) {
+		return ErrConfigNotPtrToStruct
+	}
+
+	format := strings.TrimPrefix(filepath.Ext(path), ".")
+	if format == "" {
+		format = "json"
+	}
+
+	viperMu.Lock()
+	defer viperMu.Unlock()
+
+	viper.SetConfigType(format)
+	err := viper.ReadRemoteConfig()
+	if err != nil {
+		return err
+	}
+	return unmarshalLocked(config)
+}
+
+// watchRemote watches the remote config, and reload config when changed,
+// until done is closed
+func watchRemote(config any, hook func(oldConfig any, newConfig any), done <-chan struct{}) error {
+	if !configMustPtrToStruct(config) {
+		return ErrConfigNotPtrToStruct
+	}
+
+	viperMu.Lock()
+	err := viper.GetViper().WatchRemoteConfigOnChannel()
+	viperMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	go pollRemote(config, hook, RemotePollInterval, done)
+	return nil
+}
+
+// pollRemote reloads the config from viper (with viperMu locked) every
+// interval, and calls the hook if it is changed, until done is closed.
+func pollRemote(config any, hook func(oldConfig any, newConfig any), interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		oldConfig, err := reload(config, unmarshal)
+		if err != nil {
+			logger.WithError(err).
+				Error("watchRemote: reload error")
+			continue
+		}
+
+		mu.RLock()
+		changed := !reflect.DeepEqual(oldConfig, config)
+		mu.RUnlock()
+		if changed {
+			hook(oldConfig, config)
+		}
+	}
 }
 
 // readFromEnv read config from environment variables, and unmarshal to config
@@ -109,6 +560,9 @@ func readFromEnv(prefix string, config any) error {
 		return ErrConfigNotPtrToStruct
 	}
 
+	viperMu.Lock()
+	defer viperMu.Unlock()
+
 	// AutomaticEnv would not create keys.
 	if len(viper.AllKeys()) == 0 {
 		_ = setDefaultStruct(config)
@@ -118,11 +572,13 @@ func readFromEnv(prefix string, config any) error {
 	// Get("foo.bar") => PREFIX_FOO_BAR
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
+	bindEnvKeys(config)
 
-	return viper.Unmarshal(config)
+	return unmarshalLocked(config)
 }
 
-// setDefaultStruct reads config from a struct
+// setDefaultStruct reads config from a struct, with viperMu locked by the
+// caller
 func setDefaultStruct(config any) error {
 	if !configMustPtrToStruct(config) {
 		return ErrConfigNotPtrToStruct
@@ -139,29 +595,156 @@ func setDefaultStruct(config any) error {
 	return err
 }
 
-// watch current viper config file, and reload config when changed
-// TODO: I don't think this is thread-safe
-func watch(config any, hook func(oldConfig any, newConfig any)) error {
+// watch the config file at path, and reload config (by reloadSources)
+// when it is changed, until done is closed.
+//
+// The directory of the file is watched instead of the file itself, so
+// that the changes are still seen after the file is replaced (by editors
+// renaming a temporary file over it, or the symlinks of a Kubernetes
+// ConfigMap being swapped). The events are debounced by fileChangeDelay,
+// so a file written in several steps (truncated and then written) is
+// reloaded once, after the last step.
+func watch(config any, path string, hook func(oldConfig any, newConfig any), done <-chan struct{}) error {
 	if !configMustPtrToStruct(config) {
 		return ErrConfigNotPtrToStruct
 	}
 
-	viper.OnConfigChange(func(e fsnotify.Event) {
-		v := reflect.ValueOf(config).Elem()
-		oldConfig := reflect.New(v.Type()).Interface()
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		_ = watcher.Close()
+		return err
+	}
 
-		err := deepCopyStruct(config, oldConfig)
-		if err != nil {
-			logger.WithError(err).
-				Errorf("OnConfigChange: deepCopyStruct error")
+	go func() {
+		defer watcher.Close()
+
+		debounce := time.NewTimer(fileChangeDelay)
+		debounce.Stop()
+		defer debounce.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.WithError(err).
+					Error("watch: fsnotify error")
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == path &&
+					(event.Has(fsnotify.Write) || event.Has(fsnotify.Create)) {
+					debounce.Reset(fileChangeDelay)
+				}
+			case <-debounce.C:
+				oldConfig, err := reloadSources(config)
+				if err != nil {
+					logger.WithError(err).
+						Error("watch: reloadSources error")
+					continue
+				}
+				hook(oldConfig, config)
+			}
 		}
+	}()
+	return nil
+}
+
+// fileChangeDelay is the quiet period after the last change of the
+// watched file before it is reloaded.
+const fileChangeDelay = 100 * time.Millisecond
+
+// reload loads config into a new copy of config, and then replaces the
+// config with it (with mu locked). So the copies returned by Snapshot are
+// never changed (e.g. sharing slices) by the reloading.
+// It returns a copy of the old config.
+func reload(config any, load func(newConfig any) error) (oldConfig any, err error) {
+	v := reflect.ValueOf(config).Elem()
+
+	mu.RLock()
+	newConfig := reflect.New(v.Type())
+	err = deepCopyStruct(config, newConfig.Interface())
+	mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := load(newConfig.Interface()); err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	old := reflect.New(v.Type())
+	old.Elem().Set(v)
+	v.Set(newConfig.Elem())
+
+	return old.Interface(), nil
+}
+
+var (
+	// sources: configModel => []Option, the options given to Init
+	sources sync.Map
+	// reloadMu serializes reloadSources
+	reloadMu sync.Mutex
+)
+
+// reloadSources re-runs the options given to Init to reload the config.
+// The watchers are registered once, and re-running them is a no-op.
+func reloadSources(config any) (oldConfig any, err error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
 
-		_ = viper.Unmarshal(config)
-		hook(oldConfig, config)
+	options, ok := sources.Load(config)
+	if !ok {
+		return nil, ErrConfigNotInit
+	}
+	return reload(config, func(newConfig any) error {
+		for _, option := range options.([]Option) {
+			if err := option(newConfig); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
+}
 
-	viper.WatchConfig()
-	return nil
+// validate the config by the `validate` struct tags.
+func validate(config any) error {
+	if !configMustPtrToStruct(config) {
+		return ErrConfigNotPtrToStruct
+	}
+
+	err := validator.New().Struct(config)
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return err
+	}
+
+	fields := make([]string, 0, len(validationErrors))
+	for _, fieldError := range validationErrors {
+		// Namespace: "MyConfig.DB.DSN" => "DB.DSN"
+		_, field, _ := strings.Cut(fieldError.Namespace(), ".")
+		rule := fieldError.Tag()
+		if fieldError.Param() != "" {
+			rule += "=" + fieldError.Param()
+		}
+		fields = append(fields, field+": "+rule)
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidConfig, strings.Join(fields, "; "))
 }
 
 func deepCopyStruct(src any, dst any) error {
@@ -198,4 +781,8 @@ func configMustPtrToStruct(config any) bool {
 	return true
 }
 
-var ErrConfigNotPtrToStruct = errors.New("config must be a pointer to struct")
+var (
+	ErrConfigNotPtrToStruct = errors.New("config must be a pointer to struct")
+	ErrInvalidConfig        = errors.New("invalid config")
+	ErrConfigNotInit        = errors.New("config not bound by Init")
+)
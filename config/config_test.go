@@ -1,11 +1,16 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"github.com/spf13/viper"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"reflect"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -90,11 +95,11 @@ func Test_readFromEnv(t *testing.T) {
 	}{
 		{"prefix_CRUD",
 			func() {
-				_ = os.Setenv("CRUD_DB_DRIVER", "sqlite")
-				_ = os.Setenv("CRUD_DB_DSN", "./test.db")
-				_ = os.Setenv("CRUD_HTTP_ADDR", ":8080")
-				_ = os.Setenv("CRUD_LOGLEVEL", "info")
-				_ = os.Setenv("CRUD_NAME", "Test Config")
+				t.Setenv("CRUD_DB_DRIVER", "sqlite")
+				t.Setenv("CRUD_DB_DSN", "./test.db")
+				t.Setenv("CRUD_HTTP_ADDR", ":8080")
+				t.Setenv("CRUD_LOGLEVEL", "info")
+				t.Setenv("CRUD_NAME", "Test Config")
 			},
 			args{
 				"CRUD",
@@ -105,11 +110,11 @@ func Test_readFromEnv(t *testing.T) {
 		},
 		{"no_prefix",
 			func() {
-				_ = os.Setenv("DB_DRIVER", "sqlite")
-				_ = os.Setenv("DB_DSN", "./test.db")
-				_ = os.Setenv("HTTP_ADDR", ":8080")
-				_ = os.Setenv("LOGLEVEL", "info")
-				_ = os.Setenv("NAME", "Test Config")
+				t.Setenv("DB_DRIVER", "sqlite")
+				t.Setenv("DB_DSN", "./test.db")
+				t.Setenv("HTTP_ADDR", ":8080")
+				t.Setenv("LOGLEVEL", "info")
+				t.Setenv("NAME", "Test Config")
 			},
 			args{
 				"",
@@ -134,57 +139,55 @@ func Test_readFromEnv(t *testing.T) {
 }
 
 func Test_watch(t *testing.T) {
-	type args struct {
-		config any
-		hook   func(oldConfig any, newConfig any)
+	var config testConfig
+	fired := make(chan string, 10)
+	watchFile, stop := WatchFileChange(func(oldConfig any, newConfig any) {
+		t.Logf("old config: %#v\n", oldConfig)
+		t.Logf("new config: %#v\n", newConfig)
+		fired <- Snapshot[testConfig]().Name
+	})
+	defer stop()
+	if err := Init(&config, FromFile("./test_config.yaml"), watchFile); err != nil {
+		t.Fatalf("Init() error = %v", err)
 	}
-	tests := []struct {
-		name     string
-		args     args
-		setup    func()
-		change   func()
-		rollback func()
-	}{
-		{
-			"watch",
-			args{
-				&testConfigInstance,
-				func(oldConfig any, newConfig any) {
-					t.Logf("old config: %#v\n", oldConfig)
-					t.Logf("new config: %#v\n", newConfig)
-					t.Logf("now config: %#v\n", testConfigInstance)
-				},
-			},
-			func() {
-				_ = readFromFile("./test_config.yaml", &testConfigInstance)
-			},
-			func() {
-				fmt.Println("change config")
-				err := changeLine("./test_config.yaml", 6 /* Name */, "Name: Config Changed")
-				if err != nil {
-					t.Fatalf("changeLine() error = %v", err)
-				}
-			},
-			func() {
-				fmt.Println("rollback config")
-				err := changeLine("./test_config.yaml", 6 /* Name */, "Name: Test Config")
-				if err != nil {
-					t.Fatalf("changeLine() error = %v", err)
-				}
-			},
-		},
+	defer func() {
+		err := changeLine("./test_config.yaml", 6 /* Name */, "Name: Test Config")
+		if err != nil {
+			t.Fatalf("changeLine() error = %v", err)
+		}
+	}()
+
+	fmt.Println("change config")
+	err := changeLine("./test_config.yaml", 6 /* Name */, "Name: Config Changed")
+	if err != nil {
+		t.Fatalf("changeLine() error = %v", err)
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			tt.setup()
-			err := watch(tt.args.config, tt.args.hook)
-			if err != nil {
-				t.Errorf("watch() error = %v", err)
-			}
-			tt.change()
-			time.Sleep(1 * time.Second)
-			tt.rollback()
-		})
+	select {
+	case got := <-fired:
+		if got != "Config Changed" {
+			t.Errorf("Snapshot().Name = %q, want %q", got, "Config Changed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("config change not watched")
+	}
+
+	// the changes after stop are not watched
+	stop()
+	stop()
+	time.Sleep(100 * time.Millisecond)
+	for len(fired) > 0 {
+		<-fired
+	}
+
+	fmt.Println("rollback config")
+	err = changeLine("./test_config.yaml", 6 /* Name */, "Name: Test Config")
+	if err != nil {
+		t.Fatalf("changeLine() error = %v", err)
+	}
+	select {
+	case got := <-fired:
+		t.Errorf("hook fired after stop: %v", got)
+	case <-time.After(200 * time.Millisecond):
 	}
 }
 
@@ -209,14 +212,16 @@ func TestInit(t *testing.T) {
 	}
 	var config MyConfig
 
+	watchFile, stop := WatchFileChange(func(oldConfig any, newConfig any) {
+		logger.WithField("oldConfig", oldConfig).
+			WithField("newConfig", newConfig).
+			Info("config changed")
+	})
+	defer stop()
 	err := Init(&config,
 		FromFile("./test_config.yaml"),
 		FromEnv("MYAPP"),
-		WatchFileChange(func(oldConfig any, newConfig any) {
-			logger.WithField("oldConfig", oldConfig).
-				WithField("newConfig", newConfig).
-				Info("config changed")
-		}),
+		watchFile,
 	)
 	if err != nil {
 		t.Errorf("Init() error = %v", err)
@@ -237,16 +242,332 @@ func ExampleInit() {
 	}
 	var config MyConfig
 
+	watchFile, stop := WatchFileChange(func(oldConfig any, newConfig any) {
+		logger.WithField("oldConfig", oldConfig).
+			WithField("newConfig", newConfig).
+			Info("config changed")
+	})
+	defer stop()
 	err := Init(&config,
 		FromFile("./test_config.yaml"),
 		FromEnv("MYAPP"),
-		WatchFileChange(func(oldConfig any, newConfig any) {
-			logger.WithField("oldConfig", oldConfig).
-				WithField("newConfig", newConfig).
-				Info("config changed")
-		}),
+		watchFile,
 	)
 	if err != nil {
 		logger.WithError(err).Fatal("failed to read config.")
 	}
 }
+
+func TestSnapshot(t *testing.T) {
+	path := t.TempDir() + "/config.yaml"
+	if err := os.WriteFile(path, []byte("Name: v0\n"), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	var config testConfig
+	changed := make(chan struct{}, 1)
+	watchFile, stop := WatchFileChange(func(oldConfig any, newConfig any) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	defer stop()
+	err := Init(&config, FromFile(path), watchFile)
+	if err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if got := Snapshot[testConfig]().Name; got != "v0" {
+		t.Errorf("Snapshot().Name = %q, want %q", got, "v0")
+	}
+
+	done := make(chan struct{})
+	go func() { // reads while the watcher reloads
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				_ = Snapshot[testConfig]().Name
+			}
+		}
+	}()
+	defer close(done)
+
+	if err := os.WriteFile(path, []byte("Name: v1\n"), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("config change not watched")
+	}
+	if got := Snapshot[testConfig]().Name; got != "v1" {
+		t.Errorf("Snapshot().Name = %q, want %q", got, "v1")
+	}
+	if got := Snapshot[BaseConfig](); got != (BaseConfig{}) {
+		t.Errorf("Snapshot[BaseConfig]() = %v, want zero value", got)
+	}
+}
+
+func TestWithValidation(t *testing.T) {
+	path := t.TempDir() + "/config.yaml"
+	content := "DB:\n  Driver: oracle\nHTTP:\n  Addr: \":8080\"\nName: Test Config\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	var config testConfig
+	err := Init(&config, FromFile(path), WithValidation())
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Init() error = %v, want %v", err, ErrInvalidConfig)
+	}
+	for _, want := range []string{"DB.Driver: oneof=sqlite mysql postgres", "DB.DSN: required"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Init() error = %q, want it contains %q", err, want)
+		}
+	}
+
+	var valid testConfig
+	if err := Init(&valid, FromFile("./test_config.yaml"), WithValidation()); err != nil {
+		t.Errorf("Init() valid config error = %v", err)
+	}
+}
+
+func TestFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	base := "DB:\n  Driver: sqlite\n  DSN: ./base.db\nHTTP:\n  Addr: \":8080\"\nName: Base\n"
+	local := "DB:\n  DSN: ./local.db\nName: Local\n"
+	if err := os.WriteFile(dir+"/config.yaml", []byte(base), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if err := os.WriteFile(dir+"/config.local.yaml", []byte(local), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	var config testConfig
+	err := Init(&config, FromFiles(
+		dir+"/config.yaml",
+		dir+"/missing.yaml", // skipped
+		dir+"/config.local.yaml",
+	))
+	if err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	want := testConfig{
+		BaseConfig: BaseConfig{
+			DB:   DBConfig{Driver: "sqlite", DSN: "./local.db"},
+			HTTP: HTTPConfig{Addr: ":8080"},
+		},
+		Name: "Local",
+	}
+	if config.DB != want.DB || config.HTTP != want.HTTP || config.Name != want.Name {
+		t.Errorf("Init() got config = %+v, want %+v", config, want)
+	}
+}
+
+func TestInit_defaultTags(t *testing.T) {
+	type defaultsConfig struct {
+		Level   string        `default:"info"`
+		Port    int           `default:"8080"`
+		Title   string        `default:"default"`
+		Timeout time.Duration `default:"5s"`
+		Origins []string      `default:"a.com, b.com"`
+		Debug   bool
+	}
+
+	path := t.TempDir() + "/config.yaml"
+	if err := os.WriteFile(path, []byte("Port: 9090\nTitle: file\n"), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	t.Setenv("DEFAULTS_TITLE", "env")
+	t.Setenv("DEFAULTS_DEBUG", "true")
+
+	var config defaultsConfig
+	if err := Init(&config, FromFile(path), FromEnv("DEFAULTS")); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	// default < file < env
+	want := defaultsConfig{
+		Level:   "info",
+		Port:    9090,
+		Title:   "env",
+		Timeout: 5 * time.Second,
+		Origins: []string{"a.com", "b.com"},
+		Debug:   true,
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("Init() got config = %+v, want %+v", config, want)
+	}
+
+	type badDefault struct {
+		Port int `default:"eighty"`
+	}
+	if err := Init(&badDefault{}); err == nil {
+		t.Errorf("Init() with bad default error = nil, want an error")
+	}
+}
+
+func TestWatchSignal(t *testing.T) {
+	type signalConfig struct {
+		Greeting string
+	}
+	t.Setenv("SIGNAL_GREETING", "hello")
+
+	var config signalConfig
+	fired := make(chan any, 2)
+	watchSignal, stop := WatchSignal(syscall.SIGHUP, func(oldConfig any, newConfig any) {
+		fired <- oldConfig
+	})
+	defer stop()
+	err := Init(&config, FromEnv("SIGNAL"), watchSignal)
+	if err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if got := Snapshot[signalConfig]().Greeting; got != "hello" {
+		t.Fatalf("Snapshot().Greeting = %q, want %q", got, "hello")
+	}
+
+	t.Setenv("SIGNAL_GREETING", "bonjour")
+	process, _ := os.FindProcess(os.Getpid())
+	if err := process.Signal(syscall.SIGHUP); err != nil {
+		t.Skipf("send signal: %v", err)
+	}
+
+	select {
+	case oldConfig := <-fired:
+		if got := oldConfig.(*signalConfig).Greeting; got != "hello" {
+			t.Errorf("old config Greeting = %q, want %q", got, "hello")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("hook not fired")
+	}
+	if got := Snapshot[signalConfig]().Greeting; got != "bonjour" {
+		t.Errorf("Snapshot().Greeting = %q, want %q", got, "bonjour")
+	}
+
+	select {
+	case <-fired:
+		t.Errorf("hook fired twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	stop()
+	stop()
+	t.Setenv("SIGNAL_GREETING", "hola")
+	// SIGHUP is still relayed (and ignored) by the channel of the
+	// test, so the process is not terminated.
+	ignore := make(chan os.Signal, 1)
+	signal.Notify(ignore, syscall.SIGHUP)
+	defer signal.Stop(ignore)
+	_ = process.Signal(syscall.SIGHUP)
+
+	select {
+	case <-fired:
+		t.Errorf("hook fired after stop")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if got := Snapshot[signalConfig]().Greeting; got != "bonjour" {
+		t.Errorf("Snapshot().Greeting after stop = %q, want %q", got, "bonjour")
+	}
+}
+
+func TestFromReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		option Option
+	}{
+		{"yaml", FromReader(bytes.NewBufferString("DB:\n  Driver: mysql\n  DSN: dsn\nName: yaml\n"), "yaml")},
+		{"json", FromBytes([]byte(`{"DB": {"Driver": "mysql", "DSN": "dsn"}, "Name": "json"}`), "json")},
+		{"toml", FromBytes([]byte("Name = \"toml\"\n[DB]\nDriver = \"mysql\"\nDSN = \"dsn\"\n"), "toml")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config testConfig
+			if err := Init(&config, tt.option); err != nil {
+				t.Fatalf("Init() error = %v", err)
+			}
+			want := DBConfig{Driver: "mysql", DSN: "dsn"}
+			if config.DB != want || config.Name != tt.name {
+				t.Errorf("Init() got config = %+v, want DB %+v and Name %q", config, want, tt.name)
+			}
+		})
+	}
+
+	var config testConfig
+	if err := Init(&config, FromBytes([]byte("{bad json"), "json")); err == nil {
+		t.Errorf("Init() with bad json error = nil, want an error")
+	}
+}
+
+func TestFromRemote(t *testing.T) {
+	var config testConfig
+
+	// unsupported provider
+	err := Init(&config, FromRemote("zookeeper", "localhost:2181", "/config.yaml"))
+	if !errors.As(err, new(viper.UnsupportedRemoteProviderError)) {
+		t.Errorf("Init() error = %v, want UnsupportedRemoteProviderError", err)
+	}
+
+	// the remote features are not enabled (viper/remote not imported)
+	err = Init(&config, FromRemote("consul", "localhost:8500", "/config.yaml"))
+	if !errors.As(err, new(viper.RemoteConfigError)) {
+		t.Errorf("Init() error = %v, want RemoteConfigError", err)
+	}
+}
+
+func Test_pollRemote(t *testing.T) {
+	type remoteConfig struct {
+		RemotePolled string
+	}
+	setViper := func(key string, value any) {
+		viperMu.Lock()
+		defer viperMu.Unlock()
+		viper.Set(key, value)
+	}
+	setViper("RemotePolled", "before")
+
+	var config remoteConfig
+	if err := unmarshal(&config); err != nil {
+		t.Fatalf("unmarshal() error = %v", err)
+	}
+
+	fired := make(chan [2]string, 10)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		pollRemote(&config, func(oldConfig any, newConfig any) {
+			mu.RLock()
+			defer mu.RUnlock()
+			fired <- [2]string{oldConfig.(*remoteConfig).RemotePolled, newConfig.(*remoteConfig).RemotePolled}
+		}, 10*time.Millisecond, done)
+		close(stopped)
+	}()
+
+	// unchanged: the hook is not called
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case got := <-fired:
+		t.Errorf("hook fired without changes: %v", got)
+	default:
+	}
+
+	setViper("RemotePolled", "after")
+	select {
+	case got := <-fired:
+		if got != [2]string{"before", "after"} {
+			t.Errorf("hook got (old, new) = %v, want (before, after)", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("hook not fired")
+	}
+
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("pollRemote not stopped")
+	}
+}
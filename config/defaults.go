@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setDefaultsFromTags seeds viper defaults from the `default` struct tags
+// of config, for example:
+//     type MyConfig struct {
+//         LogLevel string        `default:"info"`
+//         Timeout  time.Duration `default:"5s"`
+//         Origins  []string      `default:"a.com,b.com"`
+//     }
+// The values are parsed into the types of the fields. Values from files,
+// environment variables, etc. take precedence over the defaults.
+func setDefaultsFromTags(config any) error {
+	if !configMustPtrToStruct(config) {
+		return ErrConfigNotPtrToStruct
+	}
+
+	var err error
+	walkKeys(reflect.TypeOf(config).Elem(), "", func(key string, field reflect.StructField) {
+		tag, ok := field.Tag.Lookup("default")
+		if !ok || err != nil {
+			return
+		}
+		var value any
+		value, err = parseDefault(tag, field.Type)
+		if err != nil {
+			err = fmt.Errorf("default of %s: %w", key, err)
+			return
+		}
+		viper.SetDefault(key, value)
+	})
+	return err
+}
+
+// bindEnvKeys binds all keys of config to the environment variables,
+// because viper.AutomaticEnv would not create keys.
+func bindEnvKeys(config any) {
+	walkKeys(reflect.TypeOf(config).Elem(), "", func(key string, field reflect.StructField) {
+		_ = viper.BindEnv(key)
+	})
+}
+
+// walkKeys calls fn for each leaf field of the struct type t, with its
+// viper key ("foo.bar"). The keys are named as viper.Unmarshal does: the
+// mapstructure tag or the (lowercase) field name, squashed structs are
+// flattened.
+func walkKeys(t reflect.Type, prefix string, fn func(key string, field reflect.StructField)) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		key := strings.ToLower(name)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() == reflect.Struct && fieldType != reflect.TypeOf(time.Time{}) {
+			if strings.Contains(options, "squash") {
+				walkKeys(fieldType, prefix, fn)
+			} else {
+				walkKeys(fieldType, key, fn)
+			}
+			continue
+		}
+		fn(key, field)
+	}
+}
+
+// parseDefault parses the default value s into type t.
+func parseDefault(s string, t reflect.Type) (any, error) {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return time.ParseDuration(s)
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return parseDefault(s, t.Elem())
+	case reflect.String:
+		return s, nil
+	case reflect.Bool:
+		return strconv.ParseBool(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(s, 0, t.Bits())
+		return reflect.ValueOf(v).Convert(t).Interface(), err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(s, 0, t.Bits())
+		return reflect.ValueOf(v).Convert(t).Interface(), err
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(s, t.Bits())
+		return reflect.ValueOf(v).Convert(t).Interface(), err
+	case reflect.Slice:
+		values := reflect.MakeSlice(t, 0, 0)
+		for _, item := range strings.Split(s, ",") {
+			v, err := parseDefault(strings.TrimSpace(item), t.Elem())
+			if err != nil {
+				return nil, err
+			}
+			values = reflect.Append(values, reflect.ValueOf(v))
+		}
+		return values.Interface(), nil
+	}
+	return nil, fmt.Errorf("unsupported type %v", t)
+}
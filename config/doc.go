@@ -16,7 +16,8 @@
 //      file aside.
 //
 // You can use config.Init to read (and watch) config from file or environment
-// variables and bind it to a struct.
+// variables and bind it to a struct. And read it by config.Snapshot, which is
+// thread-safe while the config is reloading.
 //
 // This package also provides a BaseConfig struct, which is a base struct for
 // common configs can be applied in a crud app. It's a good idea to embed it
@@ -31,14 +32,18 @@
 //        }
 //        var config MyConfig
 //
+//        // only file changes are watched, env changes will not trigger.
+//        watchFile, stop := WatchFileChange(func(oldConfig any, newConfig any) {
+//            logger.WithField("oldConfig", oldConfig).
+//                WithField("newConfig", newConfig).
+//                Info("config changed")
+//        })
+//        defer stop()
+//
 //        err := Init(&config,
 //            FromFile("./test_config.yaml"),  // read config from file
 //            FromEnv("MYAPP"),  // read config from env with prefix MYAPP, for example MYAPP_FOO, MYAPP_DB_DSN
-//            WatchFileChange(func(oldConfig any, newConfig any) {  // only file changes are watched, env changes will not trigger.
-//                logger.WithField("oldConfig", oldConfig).
-//                    WithField("newConfig", newConfig).
-//                    Info("config changed")
-//            }),
+//            watchFile,
 //        )
 //        if err != nil {
 //            logger.WithError(err).Fatal("failed to read config.")
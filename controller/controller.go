@@ -5,13 +5,16 @@
 // is actually a gin.HandlerFunc:
 //
 //   - GET    /models     => GetListHandler[Model]: to retrieve a model list
+//   - GET    /models?q=  => SearchHandler[Model] : to search models by a keyword
 //   - GET    /models/:id => GetByIDHandler[Model]: to retrieve a model by id
 //   - POST   /models     => CreateHandler[Model] : to create a new model
 //   - PUT    /models/:id => UpdateHandler[Model] : to update an existing model
+//   - PATCH  /models/:id => PatchHandler[Model]  : to partially update an existing model
 //   - DELETE /models/:id => DeleteHandler[Model] : to delete an existing model
 //
 //   - GET    /models/:id/field => GetFieldHandler[Model]     : to retrieve a field (nested model) of a model
 //   - POST   /models/:id/field => CreateNestedHandler[Model] : to create a nested model (association)
+//   - PUT    /models/:id/field => ReplaceNestedHandler[Model]: to replace the nested models (associations)
 //   - DELETE /models/:id/field => DeleteNestedHandler[Model] : to delete an association record
 //
 // The controller are all generic functions, which is available in Go 1.18 and
@@ -29,4 +32,4 @@ package controller
 
 import "github.com/cdfmlr/crud/log"
 
-var logger = log.ZoneLogger("crud/controller")
+var logger = log.ContextZoneLogger("crud/controller")
@@ -1,3 +1,2348 @@
 package controller
 
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/service"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
 // TODO: test controllers
+
+type testTodo struct {
+	orm.BasicModel
+	Title    string `json:"title"`
+	Done     bool   `json:"done"`
+	Priority int    `json:"priority"`
+}
+
+type testProject struct {
+	orm.BasicModel
+	Title string      `json:"title"`
+	Todos []*testTodo `json:"todos" gorm:"many2many:test_project_todos"`
+}
+
+// setupTestDB connects orm.DB to a fresh in-memory sqlite database with
+// testTodo and testProject registered and the given todos created.
+// It returns a pointer to the SQL statements executed by queries.
+func setupTestDB(t *testing.T, todos ...*testTodo) *[]string {
+	t.Helper()
+
+	db, cleanup, err := orm.ConnectTestDB(&testTodo{}, &testProject{}, orm.WithTestDBLogger(gormlogger.Discard))
+	if err != nil {
+		t.Fatalf("connect test db: %v", err)
+	}
+	t.Cleanup(cleanup)
+
+	for _, todo := range todos {
+		if err := db.Create(todo).Error; err != nil {
+			t.Fatalf("create todo: %v", err)
+		}
+	}
+
+	var queries []string
+	_ = db.Callback().Query().After("gorm:query").
+		Register("test:record_sql", func(tx *gorm.DB) {
+			queries = append(queries, tx.Statement.SQL.String())
+		})
+	return &queries
+}
+
+// serve handles a request with the given handler on the route path.
+func serve(handler gin.HandlerFunc, method, route, target string, body io.Reader) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Handle(method, route, handler)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, body)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// decodeBody decodes the JSON response body into a map.
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body %q: %v", w.Body.String(), err)
+	}
+	return body
+}
+
+func TestGetListHandler_multipleFilters(t *testing.T) {
+	queries := setupTestDB(t,
+		&testTodo{Title: "a", Done: true, Priority: 1},
+		&testTodo{Title: "a", Done: false, Priority: 2},
+		&testTodo{Title: "b", Done: true, Priority: 2},
+	)
+
+	w := serve(GetListHandler[testTodo](), http.MethodGet, "/todos",
+		"/todos?filter_by=title&filter_value=a&filter_by=priority&filter_value=2", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	todos, _ := decodeBody(t, w)["testTodos"].([]any)
+	if len(todos) != 1 {
+		t.Errorf("got %v todos, want 1: %s", len(todos), w.Body)
+	}
+	if len(*queries) == 0 {
+		t.Fatalf("no query executed")
+	}
+	sql := (*queries)[len(*queries)-1]
+	for _, cond := range []string{"`title` = ", "`priority` = "} {
+		if !strings.Contains(sql, cond) {
+			t.Errorf("WHERE condition %q not found in %q", cond, sql)
+		}
+	}
+}
+
+func TestGetListHandler_filterOp(t *testing.T) {
+	setupTestDB(t,
+		&testTodo{Title: "a", Done: true, Priority: 1},
+		&testTodo{Title: "b", Done: false, Priority: 2},
+		&testTodo{Title: "c", Done: false, Priority: 3},
+	)
+
+	tests := []struct {
+		name      string
+		target    string
+		wantCode  int
+		wantCount int
+	}{
+		{"ne", "/todos?filter_by=title&filter_op=ne&filter_value=a", http.StatusOK, 2},
+		{"eq by default", "/todos?filter_by=title&filter_value=a", http.StatusOK, 1},
+		{"gte", "/todos?filter_by=priority&filter_op=gte&filter_value=2", http.StatusOK, 2},
+		{"mixed", "/todos?filter_by=title&filter_op=&filter_value=b&filter_by=priority&filter_op=ne&filter_value=3", http.StatusOK, 1},
+		{"unknown op", "/todos?filter_by=title&filter_op=like&filter_value=a", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(GetListHandler[testTodo](), http.MethodGet, "/todos", tt.target+"&total=true", nil)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			body := decodeBody(t, w)
+			if todos, _ := body["testTodos"].([]any); len(todos) != tt.wantCount {
+				t.Errorf("got %v todos, want %v: %s", len(todos), tt.wantCount, w.Body)
+			}
+			if total, _ := body["total"].(float64); int(total) != tt.wantCount {
+				t.Errorf("total = %v, want %v", total, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestGetListHandler_filterZeroValue(t *testing.T) {
+	setupTestDB(t,
+		&testTodo{Title: "a", Done: true, Priority: 1},
+		&testTodo{Title: "b", Done: false, Priority: 0},
+		&testTodo{Title: "", Done: false, Priority: 2},
+	)
+
+	tests := []struct {
+		name      string
+		target    string
+		wantCode  int
+		wantCount int
+	}{
+		{"false", "/todos?filter_by=done&filter_value=false", http.StatusOK, 2},
+		{"true", "/todos?filter_by=done&filter_value=true", http.StatusOK, 1},
+		{"empty bool", "/todos?filter_by=done&filter_value=", http.StatusOK, 2},
+		{"zero int", "/todos?filter_by=priority&filter_value=0", http.StatusOK, 1},
+		{"empty int", "/todos?filter_by=priority&filter_value=", http.StatusOK, 1},
+		{"empty string", "/todos?filter_by=title&filter_value=", http.StatusOK, 1},
+		{"no value", "/todos?filter_by=done", http.StatusOK, 3},
+		{"placeholder of filter_in", "/todos?filter_by=title&filter_value=&filter_in=a,b", http.StatusOK, 2},
+		{"invalid bool", "/todos?filter_by=done&filter_value=maybe", http.StatusBadRequest, 0},
+		{"invalid int", "/todos?filter_by=priority&filter_value=high", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(GetListHandler[testTodo](), http.MethodGet, "/todos", tt.target+"&total=true", nil)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			body := decodeBody(t, w)
+			if todos, _ := body["testTodos"].([]any); len(todos) != tt.wantCount {
+				t.Errorf("got %v todos, want %v: %s", len(todos), tt.wantCount, w.Body)
+			}
+			if total, _ := body["total"].(float64); int(total) != tt.wantCount {
+				t.Errorf("total = %v, want %v", total, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestGetListHandler_typedFilterValue(t *testing.T) {
+	setupTestDB(t,
+		&testTodo{Title: "a", Done: true, Priority: 9},
+		&testTodo{Title: "b", Done: false, Priority: 10},
+		&testTodo{Title: "c", Done: true, Priority: 100},
+	)
+
+	tests := []struct {
+		name      string
+		target    string
+		wantCode  int
+		wantCount int
+	}{
+		{"int eq", "/todos?filter_by=priority&filter_value=10", http.StatusOK, 1},
+		{"int gt", "/todos?filter_by=priority&filter_op=gt&filter_value=9", http.StatusOK, 2},
+		{"int in", "/todos?filter_by=priority&filter_in=9,100", http.StatusOK, 2},
+		{"int between", "/todos?between_field=priority&between_low=9&between_high=10", http.StatusOK, 2},
+		{"bool eq", "/todos?filter_by=done&filter_value=true", http.StatusOK, 2},
+		{"bool as number", "/todos?filter_by=done&filter_value=0", http.StatusOK, 1},
+		{"bool in", "/todos?filter_by=done&filter_in=true,false", http.StatusOK, 3},
+		{"bool and int", "/todos?filter_by=done&filter_value=true&filter_by=priority&filter_op=lt&filter_value=10", http.StatusOK, 1},
+		{"time between", "/todos?between_field=created_at&between_low=2000-01-01&between_high=2999-12-31T00:00:00Z", http.StatusOK, 3},
+		{"invalid int in", "/todos?filter_by=priority&filter_in=9,ten", http.StatusBadRequest, 0},
+		{"invalid int between", "/todos?between_field=priority&between_low=low", http.StatusBadRequest, 0},
+		{"invalid time", "/todos?between_field=created_at&between_low=yesterday", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(GetListHandler[testTodo](), http.MethodGet, "/todos", tt.target+"&total=true", nil)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			body := decodeBody(t, w)
+			if todos, _ := body["testTodos"].([]any); len(todos) != tt.wantCount {
+				t.Errorf("got %v todos, want %v: %s", len(todos), tt.wantCount, w.Body)
+			}
+			if total, _ := body["total"].(float64); int(total) != tt.wantCount {
+				t.Errorf("total = %v, want %v", total, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestParseFieldValue(t *testing.T) {
+	setupTestDB(t)
+	type testMeasure struct {
+		ID      uint
+		Count   int
+		Ratio   float64
+		Enabled *bool
+		At      time.Time
+		Label   string
+	}
+
+	tests := []struct {
+		field, value string
+		want         any
+		wantErr      bool
+	}{
+		{"ID", "7", uint64(7), false},
+		{"Count", "-3", int64(-3), false},
+		{"Count", "", 0, false},
+		{"Ratio", "0.5", 0.5, false},
+		{"Ratio", "", 0.0, false},
+		{"Enabled", "true", true, false},
+		{"At", "2024-01-02", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), false},
+		{"At", "2024-01-02T15:04:05Z", time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), false},
+		{"Label", "30", "30", false},
+		{"Count", "3.5", nil, true},
+		{"Ratio", "half", nil, true},
+		{"Enabled", "yes", nil, true},
+		{"At", "01/02/2024", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field+"="+tt.value, func(t *testing.T) {
+			_, field, err := modelField(&testMeasure{}, tt.field)
+			if err != nil {
+				t.Fatalf("modelField: %v", err)
+			}
+			got, err := parseFieldValue(field, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidFieldValue) {
+					t.Errorf("err = %v, want ErrInvalidFieldValue", err)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetListHandler_between(t *testing.T) {
+	setupTestDB(t,
+		&testTodo{Title: "a", Priority: 1},
+		&testTodo{Title: "b", Priority: 2},
+		&testTodo{Title: "c", Priority: 3},
+		&testTodo{Title: "d", Priority: 4},
+	)
+
+	tests := []struct {
+		name      string
+		target    string
+		wantCode  int
+		wantCount int
+	}{
+		{"both", "/todos?between_field=priority&between_low=2&between_high=3", http.StatusOK, 2},
+		{"low only", "/todos?between_field=priority&between_low=2", http.StatusOK, 3},
+		{"high only", "/todos?between_field=priority&between_high=2", http.StatusOK, 2},
+		{"with filter", "/todos?between_field=priority&between_low=2&filter_by=title&filter_value=d", http.StatusOK, 1},
+		{"unknown field", "/todos?between_field=1=1%20OR%20priority&between_low=2", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(GetListHandler[testTodo](), http.MethodGet, "/todos", tt.target+"&total=true", nil)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			body := decodeBody(t, w)
+			if todos, _ := body["testTodos"].([]any); len(todos) != tt.wantCount {
+				t.Errorf("got %v todos, want %v: %s", len(todos), tt.wantCount, w.Body)
+			}
+			if total, _ := body["total"].(float64); int(total) != tt.wantCount {
+				t.Errorf("total = %v, want %v", total, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestGetListHandler_null(t *testing.T) {
+	setupTestDB(t, &testTodo{Title: "a"}, &testTodo{Title: "b"}, &testTodo{Title: "c"})
+	orm.DB.Delete(&testTodo{}, 1)
+
+	tests := []struct {
+		name      string
+		target    string
+		wantCode  int
+		wantCount int
+	}{
+		{"null", "/todos?with_trashed=true&null=deleted_at", http.StatusOK, 2},
+		{"not null", "/todos?with_trashed=true&not_null=DeletedAt", http.StatusOK, 1},
+		{"null and filter", "/todos?with_trashed=true&null=deleted_at&filter_by=title&filter_value=b", http.StatusOK, 1},
+		{"unknown null", "/todos?null=1=1%20OR%20deleted_at", http.StatusBadRequest, 0},
+		{"unknown not_null", "/todos?not_null=password", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(GetListHandler[testTodo](WithTrashedAccess()), http.MethodGet, "/todos", tt.target, nil)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			if todos, _ := decodeBody(t, w)["testTodos"].([]any); len(todos) != tt.wantCount {
+				t.Errorf("got %v todos, want %v: %s", len(todos), tt.wantCount, w.Body)
+			}
+		})
+	}
+}
+
+func TestGetListHandler_pageSize(t *testing.T) {
+	var todos []*testTodo
+	for i := 0; i < 30; i++ {
+		todos = append(todos, &testTodo{Title: "t"})
+	}
+	setupTestDB(t, todos...)
+
+	SetPageSize[testTodo](10, 20)
+	t.Cleanup(func() { pageSizeRegistry.Delete(reflect.TypeOf((*testTodo)(nil))) })
+
+	tests := []struct {
+		name      string
+		target    string
+		wantCount int
+	}{
+		{"default", "/todos", 10},
+		{"given", "/todos?limit=15", 15},
+		{"capped", "/todos?limit=100", 20},
+		{"last page", "/todos?limit=20&offset=25", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(GetListHandler[testTodo](), http.MethodGet, "/todos", tt.target, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+			}
+			if todos, _ := decodeBody(t, w)["testTodos"].([]any); len(todos) != tt.wantCount {
+				t.Errorf("got %v todos, want %v", len(todos), tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestPageSize_limit(t *testing.T) {
+	tests := []struct {
+		name      string
+		pageSize  PageSize
+		requested int
+		want      int
+	}{
+		{"unset", PageSize{}, 0, 0},
+		{"unset given", PageSize{}, 1000, 1000},
+		{"default", PageSize{DefaultLimit: 50}, 0, 50},
+		{"default given", PageSize{DefaultLimit: 50}, 1000, 1000},
+		{"max", PageSize{MaxLimit: 100}, 0, 100},
+		{"max given", PageSize{MaxLimit: 100}, 1000, 100},
+		{"both", PageSize{DefaultLimit: 50, MaxLimit: 100}, 0, 50},
+		{"both given", PageSize{DefaultLimit: 50, MaxLimit: 100}, 80, 80},
+		{"default over max", PageSize{DefaultLimit: 500, MaxLimit: 100}, 0, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pageSize.limit(tt.requested); got != tt.want {
+				t.Errorf("limit(%v) = %v, want %v", tt.requested, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetListHandler_scope(t *testing.T) {
+	setupTestDB(t,
+		&testTodo{Title: "a", Done: true},
+		&testTodo{Title: "b", Done: false},
+		&testTodo{Title: "c", Done: false},
+	)
+	service.RegisterScope("testUndone", func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("done = ?", false)
+	})
+	service.RegisterModelScope[testTodo]("testTitleC", func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("title = ?", "c")
+	})
+	service.RegisterModelScope[testProject]("testProjectOnly", func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("1 = 0")
+	})
+
+	tests := []struct {
+		name      string
+		target    string
+		wantCode  int
+		wantCount int
+	}{
+		{"scope", "/todos?scope=testUndone&total=true", http.StatusOK, 2},
+		{"scope and filter", "/todos?scope=testUndone&filter_by=title&filter_value=c&total=true", http.StatusOK, 1},
+		{"model scope", "/todos?scope=testTitleC&scope=testUndone&total=true", http.StatusOK, 1},
+		{"unknown scope", "/todos?scope=nope", http.StatusBadRequest, 0},
+		{"scope of another model", "/todos?scope=testProjectOnly", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(GetListHandler[testTodo](), http.MethodGet, "/todos", tt.target, nil)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			body := decodeBody(t, w)
+			if todos, _ := body["testTodos"].([]any); len(todos) != tt.wantCount {
+				t.Errorf("got %v todos, want %v: %s", len(todos), tt.wantCount, w.Body)
+			}
+			if total, _ := body["total"].(float64); int(total) != tt.wantCount {
+				t.Errorf("total = %v, want %v", total, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestGetListHandler_fields(t *testing.T) {
+	setupTestDB(t, &testTodo{Title: "a", Done: true, Priority: 1})
+
+	tests := []struct {
+		name     string
+		handler  gin.HandlerFunc
+		target   string
+		wantCode int
+		wantKeys []string
+	}{
+		{"list", GetListHandler[testTodo](), "/todos?fields=title", http.StatusOK, []string{"ID", "title"}},
+		{"list multiple", GetListHandler[testTodo](), "/todos?fields=Title,priority&fields=done", http.StatusOK,
+			[]string{"ID", "done", "priority", "title"}},
+		{"list unknown", GetListHandler[testTodo](), "/todos?fields=title,nope", http.StatusBadRequest, nil},
+		{"get", GetByIDHandler[testTodo]("id"), "/todos/1?fields=priority", http.StatusOK, []string{"ID", "priority"}},
+		{"get unknown", GetByIDHandler[testTodo]("id"), "/todos/1?fields=nope", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := "/todos"
+			if strings.HasPrefix(tt.target, "/todos/") {
+				route = "/todos/:id"
+			}
+			w := serve(tt.handler, http.MethodGet, route, tt.target, nil)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			body := decodeBody(t, w)
+			todo, _ := body["testTodo"].(map[string]any)
+			if todos, ok := body["testTodos"].([]any); ok && len(todos) == 1 {
+				todo, _ = todos[0].(map[string]any)
+			}
+			var keys []string
+			for key := range todo {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("keys = %v, want %v: %s", keys, tt.wantKeys, w.Body)
+			}
+			if todo["ID"] != 1.0 {
+				t.Errorf("ID = %v, want 1", todo["ID"])
+			}
+		})
+	}
+
+	w := serve(GetListHandler[testTodo](), http.MethodGet, "/todos", "/todos?format=csv&fields=title", nil)
+	if want := "ID,title\n1,a\n"; w.Body.String() != want {
+		t.Errorf("csv = %q, want %q", w.Body, want)
+	}
+}
+
+func TestGetListHandler_ndjson(t *testing.T) {
+	var todos []*testTodo
+	for i := 0; i < 250; i++ {
+		todos = append(todos, &testTodo{Title: "t", Priority: i % 2})
+	}
+	setupTestDB(t, todos...)
+	SetPageSize[testTodo](0, 100)
+	t.Cleanup(func() { pageSizeRegistry.Delete(reflect.TypeOf((*testTodo)(nil))) })
+
+	tests := []struct {
+		name      string
+		options   []ListOption
+		target    string
+		accept    string
+		wantLines int
+	}{
+		{"stream", []ListOption{WithStream()}, "/todos?stream=true", "", 250},
+		{"accept", []ListOption{WithStream()}, "/todos", "application/x-ndjson", 250},
+		{"format", []ListOption{WithStream()}, "/todos?format=ndjson", "", 250},
+		{"pagination ignored", []ListOption{WithStream()}, "/todos?stream=true&limit=10&offset=5", "", 250},
+		{"filter", []ListOption{WithStream()}, "/todos?stream=true&filter_by=priority&filter_value=1", "", 125},
+		{"empty", []ListOption{WithStream()}, "/todos?stream=true&filter_by=title&filter_value=nope", "", 0},
+		{"capped without WithStream", nil, "/todos?stream=true", "", 100},
+		{"capped limit without WithStream", nil, "/todos?stream=true&limit=1000", "", 100},
+		{"paginated without WithStream", nil, "/todos?stream=true&limit=10&offset=245", "", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.GET("/todos", GetListHandler[testTodo](tt.options...))
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+				t.Errorf("Content-Type = %q, want application/x-ndjson", ct)
+			}
+			lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+			if tt.wantLines == 0 && w.Body.Len() == 0 {
+				lines = nil
+			}
+			if len(lines) != tt.wantLines {
+				t.Fatalf("got %v lines, want %v", len(lines), tt.wantLines)
+			}
+			for _, line := range lines {
+				var todo testTodo
+				if err := json.Unmarshal([]byte(line), &todo); err != nil || todo.ID == 0 {
+					t.Fatalf("line %q: %v", line, err)
+				}
+			}
+		})
+	}
+}
+
+func Test_splitFilterIn(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want []string
+	}{
+		{"single", "open", []string{"open"}},
+		{"multiple", "open,closed", []string{"open", "closed"}},
+		{"escaped", `a\,b,c`, []string{"a,b", "c"}},
+		{"empty item", "a,,b", []string{"a", "", "b"}},
+		{"backslash", `a\b`, []string{`a\b`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitFilterIn(tt.s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitFilterIn() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetListHandler_orderByMultipleFields(t *testing.T) {
+	setupTestDB(t,
+		&testTodo{Title: "a", Priority: 1},
+		&testTodo{Title: "b", Priority: 2},
+		&testTodo{Title: "c", Priority: 2},
+	)
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+		want     []string // titles
+	}{
+		{"suffix", "/todos?order_by=priority:desc&order_by=title:asc",
+			http.StatusOK, []string{"b", "c", "a"}},
+		{"mixed", "/todos?order_by=priority:desc&order_by=title&desc=true",
+			http.StatusOK, []string{"c", "b", "a"}},
+		{"legacy", "/todos?order_by=title&desc=true",
+			http.StatusOK, []string{"c", "b", "a"}},
+		{"invalid direction", "/todos?order_by=title:up",
+			http.StatusBadRequest, nil},
+		{"invalid field", "/todos?order_by=title%20desc,%20(SELECT%201)",
+			http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(GetListHandler[testTodo](), http.MethodGet, "/todos", tt.target, nil)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.want == nil {
+				return
+			}
+			var got []string
+			todos, _ := decodeBody(t, w)["testTodos"].([]any)
+			for _, todo := range todos {
+				got = append(got, todo.(map[string]any)["title"].(string))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetListHandler_validateFields(t *testing.T) {
+	setupTestDB(t, &testTodo{Title: "a"})
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+	}{
+		{"column", "/todos?order_by=created_at&filter_by=title&filter_value=a", http.StatusOK},
+		{"field name", "/todos?order_by=CreatedAt&filter_by=Title&filter_value=a", http.StatusOK},
+		{"qualified", "/todos?order_by=test_todos.id", http.StatusOK},
+		{"injection", "/todos?order_by=1;DROP%20TABLE%20test_todos", http.StatusBadRequest},
+		{"unknown order_by", "/todos?order_by=password", http.StatusBadRequest},
+		{"unknown table", "/todos?order_by=users.id", http.StatusBadRequest},
+		{"unknown filter_by", "/todos?filter_by=1=1%20OR%20title&filter_value=a", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(GetListHandler[testTodo](), http.MethodGet, "/todos", tt.target, nil)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+		})
+	}
+
+	if !orm.DB.Migrator().HasTable(&testTodo{}) {
+		t.Errorf("table dropped")
+	}
+}
+
+func TestGetListHandler_pagination(t *testing.T) {
+	var todos []*testTodo
+	for i := 0; i < 25; i++ {
+		todos = append(todos, &testTodo{Title: "t"})
+	}
+	setupTestDB(t, todos...)
+
+	tests := []struct {
+		name   string
+		target string
+		want   any
+	}{
+		{"hasNext", "/todos?limit=10&offset=10&total=true",
+			map[string]any{"limit": 10.0, "offset": 10.0, "total": 25.0, "hasNext": true}},
+		{"last page", "/todos?limit=10&offset=20&total=true",
+			map[string]any{"limit": 10.0, "offset": 20.0, "total": 25.0, "hasNext": false}},
+		{"no total", "/todos?limit=10", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(GetListHandler[testTodo](), http.MethodGet, "/todos", tt.target, nil)
+			if got := decodeBody(t, w)["pagination"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pagination = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetListHandler_totalStrategy(t *testing.T) {
+	var todos []*testTodo
+	for i := 1; i <= 25; i++ {
+		title := map[bool]string{true: "even", false: "odd"}[i%2 == 0]
+		todos = append(todos, &testTodo{Title: title, Priority: i})
+	}
+	t.Cleanup(func() { WithTotalStrategy(TotalAuto) })
+
+	tests := []struct {
+		name        string
+		strategy    TotalStrategy
+		target      string
+		wantLen     int
+		wantTotal   float64
+		wantQueries int
+	}{
+		{"count", TotalCount, "/todos?filter_by=title&filter_value=even&limit=5&total=true", 5, 12, 2},
+		{"window", TotalWindow, "/todos?filter_by=title&filter_value=even&limit=5&total=true", 5, 12, 1},
+		{"window last page", TotalWindow, "/todos?filter_by=title&filter_value=odd&limit=5&offset=10&total=true", 3, 13, 1},
+		{"window empty page", TotalWindow, "/todos?filter_by=title&filter_value=odd&limit=5&offset=20&total=true", 0, 13, 2},
+		{"window with fields", TotalWindow, "/todos?filter_by=title&filter_value=odd&fields=title&limit=5&total=true", 5, 13, 1},
+		{"auto on sqlite", TotalAuto, "/todos?filter_by=title&filter_value=even&limit=5&total=true", 5, 12, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries := setupTestDB(t, todos...)
+			WithTotalStrategy(tt.strategy)
+
+			w := serve(GetListHandler[testTodo](), http.MethodGet, "/todos", tt.target, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+			}
+			body := decodeBody(t, w)
+			if got, _ := body["testTodos"].([]any); len(got) != tt.wantLen {
+				t.Errorf("len(todos) = %v, want %v", len(got), tt.wantLen)
+			}
+			if body["total"] != tt.wantTotal {
+				t.Errorf("total = %v, want %v", body["total"], tt.wantTotal)
+			}
+			if len(*queries) != tt.wantQueries {
+				t.Errorf("queries = %v, want %v queries", *queries, tt.wantQueries)
+			}
+		})
+	}
+}
+
+func TestPatchHandler(t *testing.T) {
+	setupTestDB(t, &testTodo{Title: "a", Done: true, Priority: 3})
+
+	w := serve(PatchHandler[testTodo]("id"), http.MethodPatch, "/todos/:id",
+		"/todos/1", strings.NewReader(`{"done": false, "id": 42}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	var got testTodo
+	if err := orm.DB.First(&got, 1).Error; err != nil {
+		t.Fatalf("get todo: %v", err)
+	}
+	if got.Done != false {
+		t.Errorf("done = %v, want false", got.Done)
+	}
+	if got.Title != "a" || got.Priority != 3 {
+		t.Errorf("other fields changed: %+v", got)
+	}
+
+	w = serve(PatchHandler[testTodo]("id"), http.MethodPatch, "/todos/:id",
+		"/todos/1", strings.NewReader(`{"nope": 1}`))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown field: status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+
+	w = serve(PatchHandler[testTodo]("id"), http.MethodPatch, "/todos/:id",
+		"/todos/2", strings.NewReader(`{"done": true}`))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("not found: status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestUpdateHandler_mode(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []UpdateOption
+		body    string
+		want    testTodo
+	}{
+		{"full", nil, `{"title": "b"}`,
+			testTodo{Title: "b", Done: true, Priority: 3}},
+		{"full zero", nil, `{"title": "b", "done": false, "priority": 0}`,
+			testTodo{Title: "b", Done: false, Priority: 0}},
+		{"partial", []UpdateOption{WithUpdateMode(UpdateModePartial)}, `{"title": "b"}`,
+			testTodo{Title: "b", Done: true, Priority: 3}},
+		{"partial zero", []UpdateOption{WithUpdateMode(UpdateModePartial)}, `{"title": "b", "done": false, "priority": 0}`,
+			testTodo{Title: "b", Done: true, Priority: 3}},
+		{"partial nothing", []UpdateOption{WithUpdateMode(UpdateModePartial)}, `{"done": false}`,
+			testTodo{Title: "a", Done: true, Priority: 3}},
+		{"unknown mode", []UpdateOption{WithUpdateMode("nope")}, `{"title": "b", "done": false}`,
+			testTodo{Title: "b", Done: false, Priority: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t, &testTodo{Title: "a", Done: true, Priority: 3})
+
+			w := serve(UpdateHandler[testTodo]("id", tt.options...), http.MethodPut, "/todos/:id",
+				"/todos/1", strings.NewReader(tt.body))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+			}
+
+			var got testTodo
+			if err := orm.DB.First(&got, 1).Error; err != nil {
+				t.Fatalf("get todo: %v", err)
+			}
+			if got.Title != tt.want.Title || got.Done != tt.want.Done || got.Priority != tt.want.Priority {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateHandler_binding(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     []CreateOption
+		contentType string
+		body        string
+		wantCode    int
+	}{
+		{"json by default", nil, "application/json", `{"title": "a", "priority": 2}`, http.StatusOK},
+		{"form by default", nil, "application/x-www-form-urlencoded", "Title=a&Priority=2", http.StatusBadRequest},
+		{"form", []CreateOption{WithBinding(binding.Form)}, "application/x-www-form-urlencoded", "Title=a&Priority=2", http.StatusOK},
+		{"content type: form", []CreateOption{WithBinding(nil)}, "application/x-www-form-urlencoded", "Title=a&Priority=2", http.StatusOK},
+		{"content type: json", []CreateOption{WithBinding(nil)}, "application/json", `{"title": "a", "priority": 2}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.POST("/todos", CreateHandler[testTodo](tt.options...))
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var got testTodo
+			if err := orm.DB.First(&got).Error; err != nil {
+				t.Fatalf("get todo: %v", err)
+			}
+			if got.Title != "a" || got.Priority != 2 {
+				t.Errorf("created %+v, want title a and priority 2", got)
+			}
+		})
+	}
+}
+
+func TestCreateHandler_idempotency(t *testing.T) {
+	setupTestDB(t)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/todos", CreateHandler[testTodo](WithIdempotency(nil, time.Hour)))
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+	count := func() (count int64) {
+		orm.DB.Model(&testTodo{}).Count(&count)
+		return count
+	}
+
+	first := post("k1", `{"title": "a"}`)
+	if first.Code != http.StatusOK || count() != 1 {
+		t.Fatalf("first: status = %v, count = %v: %s", first.Code, count(), first.Body)
+	}
+
+	repeat := post("k1", `{"title": "a"}`)
+	if repeat.Code != first.Code || repeat.Body.String() != first.Body.String() {
+		t.Errorf("repeat: got %v %s, want %v %s", repeat.Code, repeat.Body, first.Code, first.Body)
+	}
+	if ct := repeat.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("repeat: Content-Type = %q, want json", ct)
+	}
+	if count() != 1 {
+		t.Errorf("repeat: created again, count = %v, want 1", count())
+	}
+
+	if w := post("k2", `{"title": "b"}`); w.Code != http.StatusOK || count() != 2 {
+		t.Errorf("another key: status = %v, count = %v, want 2", w.Code, count())
+	}
+	if w := post("", `{"title": "c"}`); w.Code != http.StatusOK || count() != 3 {
+		t.Errorf("no key: status = %v, count = %v, want 3", w.Code, count())
+	}
+
+	if w := post("k3", `not json`); w.Code != http.StatusBadRequest {
+		t.Fatalf("failed: status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+	if w := post("k3", `{"title": "d"}`); w.Code != http.StatusOK || count() != 4 {
+		t.Errorf("retry after failure: status = %v, count = %v, want 4", w.Code, count())
+	}
+
+	if w := post("k1", `{"title": "other"}`); w.Code != http.StatusUnprocessableEntity || count() != 4 {
+		t.Errorf("key reused: status = %v, count = %v, want %v and 4", w.Code, count(), http.StatusUnprocessableEntity)
+	}
+}
+
+func TestCreateHandler_idempotencyScope(t *testing.T) {
+	setupTestDB(t)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/todos", CreateHandler[testTodo](WithIdempotency(nil, time.Hour),
+		WithIdempotencyScope(func(c *gin.Context) string {
+			return c.GetHeader("X-User")
+		})))
+
+	post := func(user string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(`{"title": "a"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(IdempotencyKeyHeader, "k1")
+		req.Header.Set("X-User", user)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	alice, bob := post("alice"), post("bob")
+	if alice.Code != http.StatusOK || bob.Code != http.StatusOK {
+		t.Fatalf("status = %v, %v, want %v", alice.Code, bob.Code, http.StatusOK)
+	}
+	if alice.Body.String() == bob.Body.String() {
+		t.Errorf("bob got the response of alice: %s", bob.Body)
+	}
+	if again := post("alice"); again.Body.String() != alice.Body.String() {
+		t.Errorf("alice repeat = %s, want %s", again.Body, alice.Body)
+	}
+}
+
+func TestDeleteHandler(t *testing.T) {
+	setupTestDB(t, &testTodo{Title: "a"})
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+		wantRows float64
+	}{
+		{"existing", "/todos/1", http.StatusOK, 1},
+		{"deleted", "/todos/1", http.StatusNotFound, 0},
+		{"nonexistent", "/todos/42", http.StatusNotFound, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(DeleteHandler[testTodo]("id"), http.MethodDelete, "/todos/:id", tt.target, nil)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			body := decodeBody(t, w)
+			if body["deleted"] != true || body["rowsAffected"] != tt.wantRows {
+				t.Errorf("body = %v, want deleted with rowsAffected %v", body, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestRegisterValidator(t *testing.T) {
+	setupTestDB(t, &testTodo{Title: "a", Priority: 1})
+	RegisterValidator(func(todo *testTodo) error {
+		if todo.Done && todo.Priority > 0 {
+			return FieldErrors{"priority": "must be 0 when done"}
+		}
+		return nil
+	}, func(todo *testTodo) error {
+		if todo.Title == "" {
+			return errors.New("empty title")
+		}
+		return nil
+	})
+	t.Cleanup(func() { validatorsRegistry.Delete(reflect.TypeOf((*testTodo)(nil))) })
+
+	tests := []struct {
+		name       string
+		handler    gin.HandlerFunc
+		method     string
+		body       string
+		wantCode   int
+		wantFields map[string]any
+	}{
+		{"create", CreateHandler[testTodo](), http.MethodPost, `{"title": "b", "done": true}`, http.StatusOK, nil},
+		{"create fields", CreateHandler[testTodo](), http.MethodPost, `{"title": "b", "done": true, "priority": 2}`,
+			http.StatusBadRequest, map[string]any{"priority": "must be 0 when done"}},
+		{"create error", CreateHandler[testTodo](), http.MethodPost, `{"done": true}`, http.StatusBadRequest, nil},
+		{"update", UpdateHandler[testTodo]("id"), http.MethodPut, `{"title": "c"}`, http.StatusOK, nil},
+		{"update fields", UpdateHandler[testTodo]("id"), http.MethodPut, `{"done": true}`,
+			http.StatusBadRequest, map[string]any{"priority": "must be 0 when done"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before []testTodo
+			orm.DB.Order("id").Find(&before)
+
+			w := serve(tt.handler, tt.method, "/todos/:id", "/todos/1", strings.NewReader(tt.body))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode == http.StatusOK {
+				return
+			}
+
+			body := decodeBody(t, w)
+			if tt.wantFields != nil && !reflect.DeepEqual(body["fields"], tt.wantFields) {
+				t.Errorf("fields = %v, want %v", body["fields"], tt.wantFields)
+			}
+			var after []testTodo
+			orm.DB.Order("id").Find(&after)
+			if !reflect.DeepEqual(after, before) {
+				t.Errorf("rejected, but written: %v => %v", before, after)
+			}
+		})
+	}
+}
+
+func TestCreateHandler_clientID(t *testing.T) {
+	setupTestDB(t)
+
+	create := func(handler gin.HandlerFunc, body string) []any {
+		t.Helper()
+		w := serve(handler, http.MethodPost, "/todos", "/todos", strings.NewReader(body))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+		}
+		var ids []any
+		switch created := decodeBody(t, w); {
+		case created["testTodo"] != nil:
+			ids = append(ids, created["testTodo"].(map[string]any)["ID"])
+		default:
+			for _, todo := range created["testTodos"].([]any) {
+				ids = append(ids, todo.(map[string]any)["ID"])
+			}
+		}
+		return ids
+	}
+
+	if got := create(CreateHandler[testTodo](), `{"ID": 999, "title": "a"}`); !reflect.DeepEqual(got, []any{1.0}) {
+		t.Errorf("ignored: created ids = %v, want [1]", got)
+	}
+	if got := create(BulkCreateHandler[testTodo](), `[{"ID": 998, "title": "b"}, {"title": "c"}]`); !reflect.DeepEqual(got, []any{2.0, 3.0}) {
+		t.Errorf("ignored in batch: created ids = %v, want [2 3]", got)
+	}
+	var count int64
+	orm.DB.Model(&testTodo{}).Where("id > 3").Count(&count)
+	if count != 0 {
+		t.Errorf("todos with client ids = %v, want 0", count)
+	}
+
+	AllowClientID[testTodo](true)
+	t.Cleanup(func() { clientIDRegistry.Delete(reflect.TypeOf((*testTodo)(nil))) })
+	if got := create(CreateHandler[testTodo](), `{"ID": 999, "title": "d"}`); !reflect.DeepEqual(got, []any{999.0}) {
+		t.Errorf("allowed: created ids = %v, want [999]", got)
+	}
+}
+
+func TestBulkCreateHandler(t *testing.T) {
+	setupTestDB(t, &testTodo{Title: "a"})
+	RegisterValidator(func(todo *testTodo) error {
+		if todo.Done && todo.Priority > 0 {
+			return FieldErrors{"priority": "must be 0 when done"}
+		}
+		return nil
+	})
+	t.Cleanup(func() { validatorsRegistry.Delete(reflect.TypeOf((*testTodo)(nil))) })
+	AllowClientID[testTodo](true) // to roll back on a duplicate id
+	t.Cleanup(func() { clientIDRegistry.Delete(reflect.TypeOf((*testTodo)(nil))) })
+
+	tests := []struct {
+		name      string
+		options   []BulkCreateOption
+		body      string
+		wantCode  int
+		wantCount int64
+		wantItems []any // indexes of the failed items
+	}{
+		{"created", nil, `[{"title": "b"}, {"title": "c"}]`, http.StatusOK, 3, nil},
+		{"empty", nil, `[]`, http.StatusBadRequest, 1, nil},
+		{"not array", nil, `{"title": "b"}`, http.StatusBadRequest, 1, nil},
+		{"malformed", nil, `[{"title": "b"},`, http.StatusBadRequest, 1, nil},
+		{"invalid items", nil, `[{"title": "b"}, {"title": 1}, {"done": true, "priority": 2}]`,
+			http.StatusBadRequest, 1, []any{1.0, 2.0}},
+		{"rolled back", nil, `[{"title": "b"}, {"ID": 1, "title": "dup"}]`,
+			http.StatusUnprocessableEntity, 1, []any{1.0}},
+		{"max batch size", []BulkCreateOption{WithMaxBatchSize(2)}, `[{"title": "b"}, {"title": "c"}]`,
+			http.StatusOK, 3, nil},
+		{"too large", []BulkCreateOption{WithMaxBatchSize(2)}, `[{"title": "b"}, {"title": "c"}, {"title": "d"}]`,
+			http.StatusRequestEntityTooLarge, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orm.DB.Where("id > 1").Delete(&testTodo{})
+
+			w := serve(BulkCreateHandler[testTodo](tt.options...), http.MethodPost, "/todos/batch", "/todos/batch", strings.NewReader(tt.body))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+
+			var count int64
+			orm.DB.Model(&testTodo{}).Count(&count)
+			if count != tt.wantCount {
+				t.Errorf("count = %v, want %v", count, tt.wantCount)
+			}
+
+			body := decodeBody(t, w)
+			if tt.wantCode == http.StatusOK {
+				todos, _ := body["testTodos"].([]any)
+				if len(todos) != 2 || todos[0].(map[string]any)["ID"] == 0.0 {
+					t.Errorf("created = %v, want 2 with ids", body["testTodos"])
+				}
+				return
+			}
+			var indexes []any
+			items, _ := body["items"].([]any)
+			for _, item := range items {
+				indexes = append(indexes, item.(map[string]any)["index"])
+			}
+			if !reflect.DeepEqual(indexes, tt.wantItems) {
+				t.Errorf("failed items = %v, want indexes %v", body["items"], tt.wantItems)
+			}
+		})
+	}
+}
+
+func TestGetListHandler_withTrashed(t *testing.T) {
+	setupTestDB(t, &testTodo{Title: "a"}, &testTodo{Title: "b"})
+
+	w := serve(DeleteHandler[testTodo]("id"), http.MethodDelete, "/todos/:id", "/todos/1", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	count := func(target string) int {
+		w := serve(GetListHandler[testTodo](WithTrashedAccess()), http.MethodGet, "/todos", target, nil)
+		todos, _ := decodeBody(t, w)["testTodos"].([]any)
+		return len(todos)
+	}
+	if got := count("/todos"); got != 1 {
+		t.Errorf("without trashed: got %v todos, want 1", got)
+	}
+	// denied by default
+	w = serve(GetListHandler[testTodo](), http.MethodGet, "/todos", "/todos?with_trashed=true", nil)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("with trashed without the access: status = %v, want %v: %s", w.Code, http.StatusForbidden, w.Body)
+	}
+	w = serve(func(c *gin.Context) { AllowTrashed(c); GetListHandler[testTodo]()(c) }, http.MethodGet, "/todos", "/todos?with_trashed=true", nil)
+	if todos, _ := decodeBody(t, w)["testTodos"].([]any); w.Code != http.StatusOK || len(todos) != 2 {
+		t.Errorf("with trashed allowed by AllowTrashed: status = %v, got %v todos, want 2: %s", w.Code, len(todos), w.Body)
+	}
+	if got := count("/todos?with_trashed=true"); got != 2 {
+		t.Errorf("with trashed: got %v todos, want 2", got)
+	}
+
+	if _, err := service.HardDelete[testTodo](context.Background(), 1); err != nil {
+		t.Fatalf("HardDelete: %v", err)
+	}
+	if got := count("/todos?with_trashed=true"); got != 1 {
+		t.Errorf("after HardDelete: got %v todos, want 1", got)
+	}
+}
+
+func TestGetListHandler_onlyTrashed(t *testing.T) {
+	setupTestDB(t, &testTodo{Title: "a"}, &testTodo{Title: "b"}, &testTodo{Title: "c"})
+	orm.DB.Delete(&testTodo{}, 1)
+
+	tests := []struct {
+		name      string
+		target    string
+		wantCount int
+	}{
+		{"default", "/todos?total=true", 2},
+		{"with trashed", "/todos?total=true&with_trashed=true", 3},
+		{"only trashed", "/todos?total=true&only_trashed=true", 1},
+		{"only over with", "/todos?total=true&with_trashed=true&only_trashed=true", 1},
+		{"only trashed and filter", "/todos?total=true&only_trashed=true&filter_by=title&filter_value=b", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(GetListHandler[testTodo](WithTrashedAccess()), http.MethodGet, "/todos", tt.target, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+			}
+			body := decodeBody(t, w)
+			if todos, _ := body["testTodos"].([]any); len(todos) != tt.wantCount {
+				t.Errorf("got %v todos, want %v: %s", len(todos), tt.wantCount, w.Body)
+			}
+			if total, _ := body["total"].(float64); int(total) != tt.wantCount {
+				t.Errorf("total = %v, want %v: %s", total, tt.wantCount, w.Body)
+			}
+		})
+	}
+
+	for target, wantCode := range map[string]int{
+		"/todos/1":                   http.StatusUnprocessableEntity, // not found
+		"/todos/1?with_trashed=true": http.StatusOK,
+		"/todos/1?only_trashed=true": http.StatusOK,
+		"/todos/2?only_trashed=true": http.StatusUnprocessableEntity,
+	} {
+		w := serve(GetByIDHandler[testTodo]("id", WithTrashedAccess()), http.MethodGet, "/todos/:id", target, nil)
+		if w.Code != wantCode {
+			t.Errorf("GET %v: status = %v, want %v: %s", target, w.Code, wantCode, w.Body)
+		}
+	}
+
+	type testNote struct {
+		orm.HardModel
+		Text string
+	}
+	w := serve(GetListHandler[testNote](WithTrashedAccess()), http.MethodGet, "/notes", "/notes?only_trashed=true", nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("not soft-deletable: status = %v, want %v: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+}
+
+func TestGetByIDHandler_preloadOrderAndLimit(t *testing.T) {
+	setupTestDB(t)
+	project := testProject{Title: "p"}
+	for i := 1; i <= 5; i++ {
+		project.Todos = append(project.Todos, &testTodo{Title: "t", Priority: i})
+	}
+	if err := orm.DB.Create(&project).Error; err != nil {
+		t.Fatalf("create project: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+		want     []float64 // priorities
+	}{
+		{"order and limit", "/projects/1?preload=Todos&preload_order=Todos:priority%20desc&preload_limit=Todos:3",
+			http.StatusOK, []float64{5, 4, 3}},
+		{"colon direction", "/projects/1?preload=Todos&preload_order=Todos:priority:asc&preload_limit=Todos:2",
+			http.StatusOK, []float64{1, 2}},
+		{"unknown field", "/projects/1?preload=Todos&preload_order=Todos:nope",
+			http.StatusBadRequest, nil},
+		{"not preloaded", "/projects/1?preload_limit=Todos:2",
+			http.StatusBadRequest, nil},
+		{"invalid limit", "/projects/1?preload=Todos&preload_limit=Todos:x",
+			http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(GetByIDHandler[testProject]("id"), http.MethodGet, "/projects/:id", tt.target, nil)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.want == nil {
+				return
+			}
+			var got []float64
+			p, _ := decodeBody(t, w)["testProject"].(map[string]any)
+			todos, _ := p["todos"].([]any)
+			for _, todo := range todos {
+				got = append(got, todo.(map[string]any)["priority"].(float64))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreloadLimit_multiParent(t *testing.T) {
+	setupTestDB(t)
+	for _, title := range []string{"p", "q"} {
+		project := testProject{Title: title, Todos: []*testTodo{{Title: "t1"}, {Title: "t2"}}}
+		if err := orm.DB.Create(&project).Error; err != nil {
+			t.Fatalf("create project: %v", err)
+		}
+	}
+
+	w := serve(GetListHandler[testProject](), http.MethodGet, "/projects", "/projects?preload=Todos&preload_limit=Todos:1", nil)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "preload_limit") {
+		t.Errorf("list: status = %v, want %v: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+	w = serve(GetFieldHandler[testProject]("id", "todos"), http.MethodGet, "/projects/:id/todos", "/projects/1/todos?preload_limit=Todos:1", nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("slice field: status = %v, want %v: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+}
+
+func TestGetByIDHandler_unknownPreload(t *testing.T) {
+	setupTestDB(t)
+	if err := orm.DB.Create(&testProject{Title: "p", Todos: []*testTodo{{Title: "t"}}}).Error; err != nil {
+		t.Fatalf("create project: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+		wantErr  string
+	}{
+		{"valid", "/projects/1?preload=Todos", http.StatusOK, ""},
+		{"typo", "/projects/1?preload=Todoz", http.StatusBadRequest, "unknown association: Todoz (valid: Todos)"},
+		{"nested", "/projects/1?preload=Todos.Tags", http.StatusBadRequest, "unknown association: Tags (testTodo has no associations)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(GetByIDHandler[testProject]("id"), http.MethodGet, "/projects/:id", tt.target, nil)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if got, _ := decodeBody(t, w)["error"].(string); !strings.Contains(got, tt.wantErr) {
+				t.Errorf("error = %q, want it contains %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetDefaultPreloads(t *testing.T) {
+	setupTestDB(t)
+	project := testProject{Title: "p", Todos: []*testTodo{{Title: "a"}, {Title: "b"}}}
+	if err := orm.DB.Create(&project).Error; err != nil {
+		t.Fatalf("create project: %v", err)
+	}
+	SetDefaultPreloads[testProject]("Todos")
+	t.Cleanup(func() { SetDefaultPreloads[testProject]() })
+
+	todos := func(t *testing.T, w *httptest.ResponseRecorder, key string) any {
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+		}
+		body := decodeBody(t, w)[key]
+		if projects, ok := body.([]any); ok && len(projects) > 0 {
+			body = projects[0]
+		}
+		return body.(map[string]any)["todos"]
+	}
+
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		route   string
+		target  string
+		key     string
+		wantLen int // -1 for not preloaded
+	}{
+		{"get by id", GetByIDHandler[testProject]("id"), "/projects/:id", "/projects/1", "testProject", 2},
+		{"list", GetListHandler[testProject](), "/projects", "/projects", "testProjects", 2},
+		{"preload disabled", GetByIDHandler[testProject]("id"), "/projects/:id", "/projects/1?preload=", "testProject", -1},
+		{"with preload order", GetByIDHandler[testProject]("id"), "/projects/:id", "/projects/1?preload_limit=Todos:1", "testProject", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.handler, http.MethodGet, tt.route, tt.target, nil)
+			got, _ := todos(t, w, tt.key).([]any)
+			if tt.wantLen < 0 {
+				if got != nil {
+					t.Errorf("todos = %v, want not preloaded", got)
+				}
+				return
+			}
+			if len(got) != tt.wantLen {
+				t.Errorf("len(todos) = %v, want %v", len(got), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestResponseError_apiError(t *testing.T) {
+	setupTestDB(t)
+	WithAPIError()
+	t.Cleanup(func() { apiErrorEnabled.Store(false) })
+
+	tests := []struct {
+		name     string
+		handler  gin.HandlerFunc
+		target   string
+		wantCode int
+		want     string
+	}{
+		{"not found", GetByIDHandler[testTodo]("id"), "/todos/1",
+			http.StatusUnprocessableEntity, ErrorCodeNotFound},
+		{"bad request", GetByIDHandler[testTodo]("id"), "/todos/1?order_by=nope",
+			http.StatusBadRequest, ErrorCodeBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.handler, http.MethodGet, "/todos/:id", tt.target, nil)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %v, want %v", w.Code, tt.wantCode)
+			}
+			apiError, _ := decodeBody(t, w)["error"].(map[string]any)
+			if apiError["code"] != tt.want || apiError["message"] == "" {
+				t.Errorf("error = %v, want code %v", apiError, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseErrorAuto(t *testing.T) {
+	tests := []struct {
+		name     string
+		respond  func(c *gin.Context, code int, err error)
+		err      error
+		wantCode int
+	}{
+		{"ResponseError keeps the code", ResponseError, context.DeadlineExceeded, http.StatusUnprocessableEntity},
+		{"invalid id", ResponseErrorAuto, fmt.Errorf("%w: %q", ErrInvalidID, "abc"), http.StatusBadRequest},
+		{"deadline exceeded", ResponseErrorAuto, fmt.Errorf("query: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{"canceled", ResponseErrorAuto, context.Canceled, http.StatusServiceUnavailable},
+		{"other", ResponseErrorAuto, errors.New("boom"), http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(func(c *gin.Context) {
+				tt.respond(c, CodeProcessFailed, tt.err)
+			}, http.MethodGet, "/", "/", nil)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %v, want %v", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestNewAPIError(t *testing.T) {
+	apiError := &APIError{Code: "CUSTOM", Message: "custom"}
+	if got := NewAPIError(http.StatusBadRequest, fmt.Errorf("wrap: %w", apiError)); got != apiError {
+		t.Errorf("NewAPIError() = %v, want %v", got, apiError)
+	}
+	if got := NewAPIError(http.StatusForbidden, errors.New("no")); got.Code != "FORBIDDEN" {
+		t.Errorf("NewAPIError().Code = %v, want FORBIDDEN", got.Code)
+	}
+}
+
+func TestCreateHandler_validationErrors(t *testing.T) {
+	type testValidated struct {
+		orm.BasicModel
+		Title    string `json:"title" binding:"required"`
+		Priority int    `json:"priority" binding:"min=1"`
+	}
+
+	w := serve(CreateHandler[testValidated](), http.MethodPost, "/todos",
+		"/todos", strings.NewReader(`{"priority": 0}`))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+	body := decodeBody(t, w)
+	want := map[string]any{"title": "required", "priority": "min=1"}
+	if body["error"] != ErrValidationFailed.Error() || !reflect.DeepEqual(body["fields"], want) {
+		t.Errorf("body = %v, want fields %v", body, want)
+	}
+
+	w = serve(CreateHandler[testValidated](), http.MethodPost, "/todos",
+		"/todos", strings.NewReader(`{"title": `))
+	if body := decodeBody(t, w); w.Code != http.StatusBadRequest || body["fields"] != nil {
+		t.Errorf("non-validation error: status = %v, body = %v", w.Code, body)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	setupTestDB(t, &testTodo{Title: "a"})
+	t.Cleanup(func() { hooksRegistry.Delete(reflect.TypeOf((*testTodo)(nil))) })
+
+	var events []string
+	record := func(event string) Hook[testTodo] {
+		return func(c *gin.Context, todo *testTodo) error {
+			events = append(events, event+":"+todo.Title)
+			return nil
+		}
+	}
+	RegisterHooks[testTodo](
+		WithBeforeCreate(func(c *gin.Context, todo *testTodo) error {
+			if todo.Title == "forbidden" {
+				return fmt.Errorf("%w: no way", ErrForbidden)
+			}
+			if todo.Title == "" {
+				return errors.New("empty title")
+			}
+			todo.Priority = 42
+			return nil
+		}),
+		WithAfterCreate(record("created")),
+		WithBeforeDelete(record("deleting")),
+		WithAfterDelete(record("deleted")),
+	)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{"ok", `{"title": "b"}`, http.StatusOK},
+		{"forbidden", `{"title": "forbidden"}`, http.StatusForbidden},
+		{"bad request", `{"title": ""}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(CreateHandler[testTodo](), http.MethodPost, "/todos", "/todos", strings.NewReader(tt.body))
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+		})
+	}
+
+	var created testTodo
+	if err := orm.DB.Where("title = ?", "b").First(&created).Error; err != nil || created.Priority != 42 {
+		t.Errorf("created = %+v, %v, want priority set by the hook", created, err)
+	}
+	var count int64
+	orm.DB.Model(&testTodo{}).Where("title = ?", "forbidden").Count(&count)
+	if count != 0 {
+		t.Errorf("forbidden todo is created")
+	}
+
+	serve(DeleteHandler[testTodo]("id"), http.MethodDelete, "/todos/:id", "/todos/1", nil)
+	if want := []string{"created:b", "deleting:a", "deleted:a"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestModelOption(t *testing.T) {
+	setupTestDB(t, &testTodo{Title: "a"}, &testTodo{Title: "b"}, &testTodo{Title: "c"})
+
+	options := []ModelOption{
+		WithPageSize(2, 2),
+		WithClientID(true),
+	}
+	create := CreateHandler[testTodo](options[1])
+
+	w := serve(create, http.MethodPost, "/todos", "/todos", strings.NewReader(`{"ID": 99, "title": "d"}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("create: status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if err := orm.DB.First(&testTodo{}, 99).Error; err != nil {
+		t.Errorf("create: the id of the client is not used: %v", err)
+	}
+
+	// not leaked to the other handlers of the model
+	if w := serve(CreateHandler[testTodo](), http.MethodPost, "/todos", "/todos", strings.NewReader(`{"ID": 98, "title": "e"}`)); w.Code != http.StatusOK {
+		t.Errorf("create without options: status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if err := orm.DB.First(&testTodo{}, 98).Error; err == nil {
+		t.Errorf("create without options: the id of the client is used")
+	}
+
+	list := func(handler gin.HandlerFunc) int {
+		todos, _ := decodeBody(t, serve(handler, http.MethodGet, "/todos", "/todos", nil))["testTodos"].([]any)
+		return len(todos)
+	}
+	if got := list(GetListHandler[testTodo](options[0])); got != 2 {
+		t.Errorf("list with WithPageSize = %v todos, want 2", got)
+	}
+	if got := list(GetListHandler[testTodo]()); got != 5 {
+		t.Errorf("list without options = %v todos, want 5", got)
+	}
+}
+
+func TestGetListHandler_csv(t *testing.T) {
+	setupTestDB(t,
+		&testTodo{Title: "a, b", Done: true, Priority: 1},
+		&testTodo{Title: "c", Done: false, Priority: 2},
+	)
+
+	w := serve(GetListHandler[testTodo](), http.MethodGet, "/todos",
+		"/todos?format=csv&order_by=priority&desc=true", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if got := w.Header().Get("Content-Disposition"); !strings.Contains(got, `filename="testTodos.csv"`) {
+		t.Errorf("Content-Disposition = %q, want testTodos.csv", got)
+	}
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %v lines, want 3: %s", len(lines), w.Body)
+	}
+	if want := "ID,CreatedAt,UpdatedAt,DeletedAt,title,done,priority"; lines[0] != want {
+		t.Errorf("header = %q, want %q", lines[0], want)
+	}
+	if !strings.HasPrefix(lines[1], "2,") || !strings.HasSuffix(lines[1], ",,c,false,2") {
+		t.Errorf("row 1 = %q, want todo c", lines[1])
+	}
+	if !strings.HasSuffix(lines[2], `,"a, b",true,1`) {
+		t.Errorf("row 2 = %q, want todo \"a, b\"", lines[2])
+	}
+}
+
+func TestRender_negotiation(t *testing.T) {
+	setupTestDB(t, &testTodo{Title: "a", Priority: 1})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/todos/:id", GetByIDHandler[testTodo]("id"))
+
+	tests := []struct {
+		accept, target  string
+		wantContentType string
+		wantBody        []string
+	}{
+		{"", "/todos/1", "application/json", []string{`"testTodo":{`, `"title":"a"`}},
+		{"application/json", "/todos/1", "application/json", []string{`"testTodo":{`}},
+		{"application/xml", "/todos/1", "application/xml", []string{"<testTodo>", "<Title>a</Title>"}},
+		{"text/html, application/xml;q=0.9", "/todos/1", "application/xml", []string{"<testTodo>"}},
+		{"application/xml", "/todos/2", "application/xml", []string{"<error>record not found</error>"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept+" "+tt.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.Header.Set("Accept", tt.accept)
+			r.ServeHTTP(w, req)
+
+			if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.wantContentType) {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("body %s does not contain %s", w.Body, want)
+				}
+			}
+		})
+	}
+}
+
+type testCategory struct {
+	Name string `json:"name"`
+}
+
+type testStatus struct {
+	Name string `json:"name"`
+}
+
+func (testStatus) ResponseName() (singular, plural string) {
+	return "status", "statuses"
+}
+
+type testUserModel struct {
+	Name string `json:"name"`
+}
+
+func (testUserModel) ResourceName() string {
+	return "user"
+}
+
+func TestSuccessResponseBody_responseKey(t *testing.T) {
+	RegisterResponseKey[testCategory]("category", "categories")
+
+	tests := []struct {
+		name    string
+		model   any
+		wantKey string
+	}{
+		{"default", testTodo{}, "testTodo"},
+		{"default plural", []*testTodo{}, "testTodos"},
+		{"registered", &testCategory{}, "category"},
+		{"registered plural", []testCategory{}, "categories"},
+		{"namer", testStatus{}, "status"},
+		{"namer plural", []*testStatus{}, "statuses"},
+		{"resource namer", &testUserModel{}, "user"},
+		{"resource namer plural", []testUserModel{}, "users"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := SuccessResponseBody(tt.model)
+			if _, ok := body[tt.wantKey]; !ok || len(body) != 1 {
+				t.Errorf("SuccessResponseBody() = %v, want key %q", body, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestWithEnvelope(t *testing.T) {
+	setupTestDB(t, &testTodo{Title: "a"}, &testTodo{Title: "b"})
+	t.Cleanup(func() { WithEnvelope(EnvelopeTyped) })
+
+	tests := []struct {
+		style     EnvelopeStyle
+		handler   gin.HandlerFunc
+		method    string
+		target    string
+		wantBody  string
+		wantTotal string
+	}{
+		{EnvelopeTyped, GetByIDHandler[testTodo]("id"), http.MethodGet, "/todos/1", `{"testTodo":{`, ""},
+		{EnvelopeData, GetByIDHandler[testTodo]("id"), http.MethodGet, "/todos/1", `{"data":{`, ""},
+		{EnvelopeData, GetListHandler[testTodo](), http.MethodGet, "/todos?total=true", `{"data":[`, ""},
+		{EnvelopeBare, GetByIDHandler[testTodo]("id"), http.MethodGet, "/todos/1", `{"ID":1,`, ""},
+		{EnvelopeBare, GetListHandler[testTodo](), http.MethodGet, "/todos?total=true", `[{"ID":1,`, "2"},
+		{EnvelopeBare, DeleteHandler[testTodo]("id"), http.MethodDelete, "/todos/2", `{"deleted":true,"rowsAffected":1}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.style)+" "+tt.target, func(t *testing.T) {
+			WithEnvelope(tt.style)
+
+			route := "/todos"
+			if strings.HasPrefix(tt.target, "/todos/") {
+				route = "/todos/:id"
+			}
+			w := serve(tt.handler, tt.method, route, tt.target, nil)
+
+			if !strings.HasPrefix(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want prefix %s", w.Body, tt.wantBody)
+			}
+			if got := w.Header().Get("X-Total-Count"); got != tt.wantTotal {
+				t.Errorf("X-Total-Count = %q, want %q", got, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestCreateNestedHandler_respond(t *testing.T) {
+	tests := []struct {
+		name     string
+		respond  []NestedResponse
+		wantKeys []string
+	}{
+		{"default", nil, []string{"testProject"}},
+		{"child", []NestedResponse{RespondChild}, []string{"testTodo"}},
+		{"parent with child", []NestedResponse{RespondParentWithChild}, []string{"testProject", "child"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			orm.DB.Create(&testProject{Title: "p"})
+
+			w := serve(CreateNestedHandler[testProject, testTodo]("id", "todos", tt.respond...),
+				http.MethodPost, "/projects/:id/todos", "/projects/1/todos", strings.NewReader(`{"title": "t"}`))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+			}
+
+			body := decodeBody(t, w)
+			if len(body) != len(tt.wantKeys) {
+				t.Errorf("body = %v, want keys %v", body, tt.wantKeys)
+			}
+			for _, key := range tt.wantKeys {
+				if _, ok := body[key].(map[string]any); !ok {
+					t.Errorf("body[%q] = %v, want an object", key, body[key])
+				}
+			}
+			if child, ok := body["testTodo"].(map[string]any); ok && child["ID"] != float64(1) {
+				t.Errorf("child ID = %v, want 1", child["ID"])
+			}
+		})
+	}
+}
+
+func TestGetFieldHandler_pagination(t *testing.T) {
+	setupTestDB(t)
+	project := testProject{Title: "p"}
+	for i := 1; i <= 30; i++ {
+		title := map[bool]string{true: "even", false: "odd"}[i%2 == 0]
+		project.Todos = append(project.Todos, &testTodo{Title: title, Priority: i})
+	}
+	orm.DB.Create(&project)
+	orm.DB.Create(&testProject{Title: "q", Todos: []*testTodo{{Title: "other"}}})
+
+	tests := []struct {
+		name      string
+		query     string
+		wantLen   int
+		wantTotal float64
+		wantFirst float64 // priority
+	}{
+		{"page", "limit=10&total=true", 10, 30, 1},
+		{"offset and order", "limit=10&offset=5&order_by=priority&desc=true&total=true", 10, 30, 25},
+		{"filtered", "limit=10&filter_by=title&filter_value=even&total=true", 10, 15, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(GetFieldHandler[testProject]("id", "todos"),
+				http.MethodGet, "/projects/:id/todos", "/projects/1/todos?"+tt.query, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+			}
+
+			body := decodeBody(t, w)
+			todos, _ := body["testTodos"].([]any)
+			if len(todos) != tt.wantLen {
+				t.Fatalf("len(todos) = %v, want %v", len(todos), tt.wantLen)
+			}
+			if body["total"] != tt.wantTotal {
+				t.Errorf("total = %v, want %v", body["total"], tt.wantTotal)
+			}
+			if first := todos[0].(map[string]any)["priority"]; first != tt.wantFirst {
+				t.Errorf("first priority = %v, want %v", first, tt.wantFirst)
+			}
+		})
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	setupTestDB(t,
+		&testTodo{Title: "learn golang", Priority: 1},
+		&testTodo{Title: "Go shopping", Priority: 2},
+		&testTodo{Title: "100% done", Priority: 3, Done: true},
+		&testTodo{Title: "1000 done", Priority: 20},
+	)
+
+	tests := []struct {
+		name      string
+		query     string
+		want      []string // titles
+		wantTotal float64
+	}{
+		{"keyword", "q=go&order_by=id", []string{"learn golang", "Go shopping"}, 2},
+		{"string fields only", "q=2&order_by=id", nil, 0},
+		{"wildcard escaped", "q=0%25&order_by=id", []string{"100% done"}, 1},
+		{"with filter", "q=done&filter_by=priority&filter_value=20", []string{"1000 done"}, 1},
+		{"with page", "q=o&order_by=id&limit=1&offset=1", []string{"Go shopping"}, 4},
+		{"no keyword", "order_by=id&limit=1", []string{"learn golang"}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(SearchHandler[testTodo]("title", "Priority", "nope"),
+				http.MethodGet, "/todos", "/todos?total=true&"+tt.query, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+			}
+
+			body := decodeBody(t, w)
+			var got []string
+			todos, _ := body["testTodos"].([]any)
+			for _, todo := range todos {
+				got = append(got, todo.(map[string]any)["title"].(string))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if body["total"] != tt.wantTotal {
+				t.Errorf("total = %v, want %v", body["total"], tt.wantTotal)
+			}
+		})
+	}
+}
+
+type testProfile struct {
+	orm.BasicModel
+	TestUserID uint   `json:"userId"`
+	Bio        string `json:"bio"`
+}
+
+type testUser struct {
+	orm.BasicModel
+	Name      string       `json:"name"`
+	Profile   *testProfile `json:"profile"`
+	AvatarURL string       `json:"avatarURL"`
+}
+
+func TestCreateHandler_reload(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []CreateOption
+		wantBio string // updated by the database trigger
+	}{
+		{"default", nil, "hi"},
+		{"reload", []CreateOption{WithReload()}, "HI"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			if err := orm.RegisterModel(&testUser{}, &testProfile{}); err != nil {
+				t.Fatalf("register model: %v", err)
+			}
+			orm.DB.Exec(`CREATE TRIGGER upper_bio AFTER INSERT ON test_profiles BEGIN
+				UPDATE test_profiles SET bio = upper(bio) WHERE id = new.id; END`)
+
+			w := serve(CreateHandler[testUser](tt.options...), http.MethodPost, "/users", "/users",
+				strings.NewReader(`{"name": "u", "profile": {"bio": "hi"}}`))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+			}
+
+			user, _ := decodeBody(t, w)["testUser"].(map[string]any)
+			profile, _ := user["profile"].(map[string]any)
+			if profile["ID"] != float64(1) || profile["userId"] != float64(1) {
+				t.Errorf("profile = %v, want ID and userId 1", profile)
+			}
+			if profile["bio"] != tt.wantBio {
+				t.Errorf("profile bio = %v, want %v", profile["bio"], tt.wantBio)
+			}
+		})
+	}
+}
+
+type testUUIDModel struct {
+	ID uuid.UUID
+}
+
+func (m testUUIDModel) Identity() (fieldName string, value any) {
+	return "ID", m.ID
+}
+
+type testStringIDModel struct {
+	Code string `gorm:"primaryKey"`
+}
+
+func (m testStringIDModel) Identity() (fieldName string, value any) {
+	return "Code", m.Code
+}
+
+func Test_parseID(t *testing.T) {
+	id := uuid.Must(uuid.NewV4())
+
+	tests := []struct {
+		name    string
+		parse   func(raw string) (any, error)
+		raw     string
+		want    any
+		wantErr bool
+	}{
+		{"uint", parseID[testTodo], "42", uint(42), false},
+		{"uint not a number", parseID[testTodo], "notanumber", nil, true},
+		{"uint negative", parseID[testTodo], "-1", nil, true},
+		{"uuid", parseID[testUUIDModel], id.String(), id, false},
+		{"uuid malformed", parseID[testUUIDModel], "42", nil, true},
+		{"string", parseID[testStringIDModel], "abc", "abc", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.parse(tt.raw)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInvalidID)) {
+				t.Fatalf("parseID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseID() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlers_invalidID(t *testing.T) {
+	setupTestDB(t)
+	orm.DB.Create(&testProject{Title: "p", Todos: []*testTodo{{Title: "t"}}})
+
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		method  string
+		route   string
+		target  string
+	}{
+		{"get", GetByIDHandler[testTodo]("id"), http.MethodGet, "/todos/:id", "/todos/notanumber"},
+		{"get field", GetFieldHandler[testProject]("id", "todos"), http.MethodGet, "/projects/:id/todos", "/projects/notanumber/todos"},
+		{"update", UpdateHandler[testTodo]("id"), http.MethodPut, "/todos/:id", "/todos/notanumber"},
+		{"patch", PatchHandler[testTodo]("id"), http.MethodPatch, "/todos/:id", "/todos/notanumber"},
+		{"delete", DeleteHandler[testTodo]("id"), http.MethodDelete, "/todos/:id", "/todos/notanumber"},
+		{"create nested", CreateNestedHandler[testProject, testTodo]("id", "todos"), http.MethodPost, "/projects/:id/todos", "/projects/notanumber/todos"},
+		{"delete nested", DeleteNestedHandler[testProject, testTodo]("id", "todos", "todoId"), http.MethodDelete, "/projects/:id/todos/:todoId", "/projects/1/todos/notanumber"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.handler, tt.method, tt.route, tt.target, strings.NewReader(`{"title": "x"}`))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %v, want %v: %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), ErrInvalidID.Error()) {
+				t.Errorf("body = %s, want %q", w.Body, ErrInvalidID)
+			}
+		})
+	}
+}
+
+func TestWithRESTStatus(t *testing.T) {
+	t.Cleanup(func() { restStatusEnabled.Store(false) })
+
+	tests := []struct {
+		name         string
+		rest         bool
+		handler      gin.HandlerFunc
+		route        string
+		target       string
+		wantCode     int
+		wantLocation string
+	}{
+		{"create", false, CreateHandler[testTodo](), "/todos", "/todos", http.StatusOK, ""},
+		{"create rest", true, CreateHandler[testTodo](), "/todos", "/todos", http.StatusCreated, "/todos/1"},
+		{"nested rest", true, CreateNestedHandler[testProject, testTodo]("id", "todos"),
+			"/projects/:id/todos", "/projects/1/todos", http.StatusCreated, "/projects/1/todos/1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			orm.DB.Create(&testProject{Title: "p"})
+			restStatusEnabled.Store(tt.rest)
+
+			w := serve(tt.handler, http.MethodPost, tt.route, tt.target, strings.NewReader(`{"title": "t"}`))
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestFileUploadHandler(t *testing.T) {
+	setupTestDB(t)
+	if err := orm.RegisterModel(&testUser{}, &testProfile{}); err != nil {
+		t.Fatalf("register model: %v", err)
+	}
+	orm.DB.Create(&testUser{Name: "a"})
+
+	dir := t.TempDir()
+	store := service.NewLocalFileStore(dir, "/avatars")
+	handler := FileUploadHandler[testUser]("id", "avatarURL", store,
+		WithContentTypes("image/*"), WithMaxFileSize(1024))
+
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 100)
+	upload := func(target, formField, content string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		if formField != "" {
+			part, _ := form.CreateFormFile(formField, "me.PNG")
+			_, _ = part.Write([]byte(content))
+		}
+		_ = form.Close()
+
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.POST("/users/:id/avatar", handler)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, target, &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name      string
+		target    string
+		formField string
+		content   string
+		wantCode  int
+	}{
+		{"not found", "/users/2/avatar", "file", png, http.StatusNotFound},
+		{"missing file", "/users/1/avatar", "", "", http.StatusBadRequest},
+		{"too large", "/users/1/avatar", "file", png + strings.Repeat("\x00", 1024), http.StatusRequestEntityTooLarge},
+		{"unsupported type", "/users/1/avatar", "file", "plain text", http.StatusUnsupportedMediaType},
+		{"uploaded", "/users/1/avatar", "file", png, http.StatusOK},
+		{"replaced", "/users/1/avatar", "file", png, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := upload(tt.target, tt.formField, tt.content)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+		})
+	}
+
+	var user testUser
+	orm.DB.First(&user, 1)
+	if !strings.HasPrefix(user.AvatarURL, "/avatars/avatarurl-1-") || !strings.HasSuffix(user.AvatarURL, ".png") {
+		t.Fatalf("AvatarURL = %q, want /avatars/avatarurl-1-*.png", user.AvatarURL)
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("files saved = %v, want 1", len(files))
+	}
+	if saved, _ := os.ReadFile(filepath.Join(dir, files[0].Name())); string(saved) != png {
+		t.Errorf("saved file content mismatch: %q", saved)
+	}
+	if want := "/avatars/" + files[0].Name(); user.AvatarURL != want {
+		t.Errorf("AvatarURL = %q, want the replacing %q", user.AvatarURL, want)
+	}
+
+	// the model is gone while the file is saved: the file is deleted
+	handler = FileUploadHandler[testUser]("id", "avatarURL", deletingStore{store})
+	if w := upload("/users/1/avatar", "file", png); w.Code != http.StatusNotFound {
+		t.Errorf("update failed: status = %v, want %v: %s", w.Code, http.StatusNotFound, w.Body)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("files left = %v, want only the previous one", len(files))
+	}
+}
+
+// deletingStore deletes the users before saving the files.
+type deletingStore struct {
+	*service.LocalFileStore
+}
+
+func (s deletingStore) Save(ctx context.Context, filename string, r io.Reader) (string, error) {
+	orm.DB.Exec("DELETE FROM test_users")
+	return s.LocalFileStore.Save(ctx, filename, r)
+}
+
+func TestETag(t *testing.T) {
+	setupTestDB(t, &testTodo{Title: "a"})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/todos/:id", GetByIDHandler[testTodo]("id"))
+	r.PUT("/todos/:id", UpdateHandler[testTodo]("id"))
+	r.PATCH("/todos/:id", PatchHandler[testTodo]("id"))
+	send := func(method, header, value, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/todos/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(http.MethodGet, "", "", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("GET: status = %v, ETag = %q, want 200 with an ETag", w.Code, etag)
+	}
+	if w := send(http.MethodGet, "If-None-Match", etag, ""); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("GET If-None-Match: status = %v, body = %q, want 304 without body", w.Code, w.Body)
+	}
+	if w := send(http.MethodGet, "If-None-Match", `"other", W/`+etag, ""); w.Code != http.StatusNotModified {
+		t.Errorf("GET If-None-Match list: status = %v, want 304", w.Code)
+	}
+
+	if w := send(http.MethodPut, "If-Match", etag, `{"title": "b"}`); w.Code != http.StatusOK {
+		t.Fatalf("PUT If-Match: status = %v, want 200: %s", w.Code, w.Body)
+	}
+	// the etag is stale now
+	if w := send(http.MethodGet, "If-None-Match", etag, ""); w.Code != http.StatusOK {
+		t.Errorf("GET If-None-Match stale: status = %v, want 200", w.Code)
+	}
+	if w := send(http.MethodPut, "If-Match", etag, `{"title": "c"}`); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT If-Match stale: status = %v, want 412", w.Code)
+	}
+	if w := send(http.MethodPatch, "If-Match", etag, `{"title": "c"}`); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PATCH If-Match stale: status = %v, want 412", w.Code)
+	}
+	if w := send(http.MethodPut, "If-Match", "*", `{"title": "d"}`); w.Code != http.StatusOK {
+		t.Errorf("PUT If-Match *: status = %v, want 200", w.Code)
+	}
+
+	var todo testTodo
+	orm.DB.First(&todo, 1)
+	if todo.Title != "d" {
+		t.Errorf("title = %q, want %q", todo.Title, "d")
+	}
+}
+
+func TestETag_concurrentUpdate(t *testing.T) {
+	setupTestDB(t, &testTodo{Title: "a"})
+
+	// another request updates the model after the If-Match check
+	RegisterHooks[testTodo](WithBeforeUpdate(func(c *gin.Context, model *testTodo) error {
+		return orm.DB.Model(&testTodo{}).Where("id = ?", 1).Update("priority", gorm.Expr("priority + 1")).Error
+	}))
+	t.Cleanup(func() { hooksRegistry.Delete(reflect.TypeOf((*testTodo)(nil))) })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/todos/:id", GetByIDHandler[testTodo]("id"))
+	r.PUT("/todos/:id", UpdateHandler[testTodo]("id"))
+	r.PATCH("/todos/:id", PatchHandler[testTodo]("id"))
+
+	for _, method := range []string{http.MethodPut, http.MethodPatch} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos/1", nil))
+		etag := w.Header().Get("ETag")
+
+		w = httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/todos/1", strings.NewReader(`{"title": "b"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", etag)
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusPreconditionFailed {
+			t.Errorf("%s: status = %v, want 412: %s", method, w.Code, w.Body)
+		}
+	}
+
+	var todo testTodo
+	orm.DB.First(&todo, 1)
+	if todo.Title != "a" || todo.Priority != 2 {
+		t.Errorf("todo = %q (priority %v), want %q (priority 2): the concurrent updates are lost", todo.Title, todo.Priority, "a")
+	}
+}
+
+func TestSetJSONEncoder(t *testing.T) {
+	setupTestDB(t, &testTodo{Title: "a"}, &testTodo{Title: "b"})
+
+	calls := 0
+	SetJSONEncoder(func(v any) ([]byte, error) {
+		calls++
+		return json.Marshal(v)
+	})
+	t.Cleanup(func() { SetJSONEncoder(nil) })
+
+	w := serve(GetListHandler[testTodo](), http.MethodGet, "/todos", "/todos", nil)
+	if calls != 1 {
+		t.Errorf("encoder calls = %v, want 1", calls)
+	}
+	if got, _ := decodeBody(t, w)["testTodos"].([]any); len(got) != 2 {
+		t.Errorf("todos = %v, want 2 of them", got)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	calls = 0
+	w = serve(GetListHandler[testTodo](), http.MethodGet, "/todos", "/todos?stream=true", nil)
+	if lines := strings.Count(w.Body.String(), "\n"); calls != 2 || lines != 2 {
+		t.Errorf("ndjson: encoder calls = %v, lines = %v, want 2 and 2", calls, lines)
+	}
+
+	SetJSONEncoder(func(v any) ([]byte, error) {
+		return nil, errors.New("marshal failed")
+	})
+	w = serve(GetListHandler[testTodo](), http.MethodGet, "/todos", "/todos", nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("marshal failed: status = %v, want %v", w.Code, http.StatusInternalServerError)
+	}
+}
+
+// BenchmarkRender measures rendering a list response by the default
+// encoder of gin and the one set by SetJSONEncoder. Replace json.Marshal
+// below with the encoder of interest (e.g. jsoniter) to compare:
+//    go test -run=^$ -bench=Render ./controller
+func BenchmarkRender(b *testing.B) {
+	todos := make([]*testTodo, 100)
+	for i := range todos {
+		todos[i] = &testTodo{Title: fmt.Sprintf("todo %d", i), Priority: i}
+	}
+	gin.SetMode(gin.TestMode)
+
+	for _, bb := range []struct {
+		name    string
+		marshal func(v any) ([]byte, error)
+	}{
+		{"gin", nil},
+		{"SetJSONEncoder", json.Marshal},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			SetJSONEncoder(bb.marshal)
+			defer SetJSONEncoder(nil)
+			for i := 0; i < b.N; i++ {
+				c, _ := gin.CreateTestContext(httptest.NewRecorder())
+				c.Request = httptest.NewRequest(http.MethodGet, "/todos", nil)
+				ResponseSuccess(c, todos)
+			}
+		})
+	}
+}
+
+func TestModelColumn_withoutDB(t *testing.T) {
+	db := orm.Current()
+	orm.SetDB(nil)
+	t.Cleanup(func() { orm.SetDB(db) })
+
+	if column, err := modelColumn(&testTodo{}, "Priority"); err != nil || column != "priority" {
+		t.Errorf("modelColumn(Priority) = %q, %v, want priority", column, err)
+	}
+	if _, err := modelColumn(&testTodo{}, "1=1 OR priority"); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("modelColumn(unknown) error = %v, want ErrUnknownField", err)
+	}
+	if _, err := parseSparseFields([]string{"title,password"}, &testTodo{}); !errors.Is(err, ErrInvalidFields) {
+		t.Errorf("parseSparseFields(unknown) error = %v, want ErrInvalidFields", err)
+	}
+	if err := checkAssociation(&testProject{}, "Owner"); !errors.Is(err, ErrUnknownAssociation) {
+		t.Errorf("checkAssociation(unknown) error = %v, want ErrUnknownAssociation", err)
+	}
+
+	todo := testTodo{BasicModel: orm.BasicModel{ID: 999}}
+	stripClientID(&todo, modelConfig{})
+	if todo.ID != 0 {
+		t.Errorf("stripClientID: id = %v, want 0", todo.ID)
+	}
+}
+
+func TestSearchColumns(t *testing.T) {
+	columns, err := searchColumns(new(testTodo), []string{"Title", "test_todos.title", "priority", "nope"})
+	if want := []string{"title", "test_todos.title"}; !reflect.DeepEqual(columns, want) {
+		t.Errorf("searchColumns() columns = %v, want %v", columns, want)
+	}
+	if !errors.Is(err, ErrInvalidSearchField) || !errors.Is(err, ErrUnknownField) {
+		t.Errorf("searchColumns() error = %v, want %v and %v", err, ErrInvalidSearchField, ErrUnknownField)
+	}
+
+	if _, err := searchColumns(new(testTodo), []string{"title"}); err != nil {
+		t.Errorf("searchColumns(title) error = %v, want nil", err)
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	type requestKey struct{}
+	var ctx context.Context
+	serve(func(c *gin.Context) {
+		c.Set("tenant", "acme")
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestKey{}, "request"))
+		ctx = requestContext(c)
+		c.Set("tenant", "other") // the keys are copied
+	}, http.MethodGet, "/", "/", nil)
+
+	// c is recycled by gin now
+	if got := ctx.Value("tenant"); got != "acme" {
+		t.Errorf(`Value("tenant") = %v, want acme`, got)
+	}
+	if got := ctx.Value(requestKey{}); got != "request" {
+		t.Errorf("Value(requestKey{}) = %v, want request", got)
+	}
+	if got := ctx.Value("missing"); got != nil {
+		t.Errorf(`Value("missing") = %v, want nil`, got)
+	}
+}
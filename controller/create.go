@@ -1,10 +1,22 @@
 package controller
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/cdfmlr/crud/orm"
 	"github.com/cdfmlr/crud/service"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"io"
+	"net/http"
 	"reflect"
+	"sync"
+	"time"
 )
 
 // CreateHandler handles
@@ -14,51 +26,588 @@ import (
 // Request body:
 //  - {...}  // fields of the model T
 //
+// The primary key in the request body is ignored for the models with an
+// auto-increment one, see AllowClientID.
+//
+// Validators: see RegisterValidator.
+// Hooks (see RegisterHooks): BeforeCreate, AfterCreate
+//
+// Options: WithReload reloads the created model (with all associations)
+// before responding, to fill in the fields generated by the database.
+// WithIdempotency replays the response of the first request for the
+// repeated requests of the same Idempotency-Key header.
+// WithBinding binds the request body in other formats (like HTML forms)
+// than JSON.
+//
 // Response:
 //  - 200 OK: { T: {...} }
+//  - 201 Created: { T: {...} }, Location: /T/:id  // if RESTStatusEnabled
 //  - 400 Bad Request: { error: "request band failed" }
+//  - 400 Bad Request: { error: "validation failed", fields: { "title": "required" } }
+//  - 403 Forbidden: { error: "forbidden" }  // by hooks
 //  - 422 Unprocessable Entity: { error: "create process failed" }
-func CreateHandler[T any]() gin.HandlerFunc {
-	return func(c *gin.Context) {
+func CreateHandler[T any](options ...CreateOption) gin.HandlerFunc {
+	var config createConfig
+	for _, option := range options {
+		option.applyCreate(&config)
+	}
+	create := func(c *gin.Context) {
 		var model T
-		if err := c.ShouldBindJSON(&model); err != nil {
+		if err := config.bind(c, &model); err != nil {
 			logger.WithContext(c).WithError(err).
 				Warn("CreateHandler: Bind failed")
-			ResponseError(c, CodeBadRequest, err)
+			ResponseBindError(c, &model, err)
+			return
+		}
+		stripClientID(&model, config.modelConfig)
+		if !runValidators(c, &model) {
+			return
+		}
+		hooks := getHooks[T]()
+		if !runBeforeHooks(c, hooks.BeforeCreate, &model) {
 			return
 		}
+
 		logger.WithContext(c).Tracef("CreateHandler: Create %#v", model)
-		err := service.Create(c, &model, service.IfNotExist())
+		err := service.Create(requestContext(c), &model, service.IfNotExist())
 		if err != nil {
 			logger.WithContext(c).WithError(err).
 				Warn("CreateHandler: Create failed")
+			ResponseErrorAuto(c, CodeProcessFailed, err)
+			return
+		}
+
+		if config.reload {
+			if err := service.Reload(requestContext(c), &model, service.PreloadAll()); err != nil {
+				logger.WithContext(c).WithError(err).
+					Warn("CreateHandler: Reload failed")
+				ResponseErrorAuto(c, CodeProcessFailed, err)
+				return
+			}
+		}
+
+		if !runAfterHooks(c, hooks.AfterCreate, &model) {
+			return
+		}
+		ResponseCreated(c, model)
+	}
+
+	if config.idempotency == nil {
+		return create
+	}
+	return func(c *gin.Context) {
+		serveIdempotent(c, config, create)
+	}
+}
+
+// BulkCreateHandler handles
+//    POST /T/batch
+// creates the models T in the request body in a transaction (see
+// service.CreateMany), responds with the created models T if successful.
+//
+// The batch is all-or-nothing: if any item fails the binding, the
+// validators or the creation, none of the items is created, and the
+// failed items are responded with their indexes in the request body.
+// The primary keys are ignored as in CreateHandler.
+//
+// The batch is limited to DefaultMaxBatchSize items, use WithMaxBatchSize
+// to change it.
+//
+// Request body:
+//  - [{...}, ...]  // fields of the models T
+//
+// Validators: see RegisterValidator.
+// Hooks (see RegisterHooks): BeforeCreate, AfterCreate (for each item)
+//
+// Response:
+//  - 200 OK: { Ts: [{...}, ...] }
+//  - 201 Created: { Ts: [{...}, ...] }  // if RESTStatusEnabled
+//  - 400 Bad Request: { error: "request band failed" }
+//  - 400 Bad Request: { error: "empty batch" }
+//  - 400 Bad Request: { error: "validation failed", items: [{ index: 1, error: "validation failed", fields: {...} }] }
+//  - 403 Forbidden: { error: "forbidden" }  // by hooks
+//  - 413 Request Entity Too Large: { error: "batch too large: limit 1000 items" }
+//  - 422 Unprocessable Entity: { error: "batch failed", items: [{ index: 1, error: "..." }] }
+func BulkCreateHandler[T any](options ...BulkCreateOption) gin.HandlerFunc {
+	config := bulkCreateConfig{maxSize: DefaultMaxBatchSize}
+	for _, option := range options {
+		option.applyBulkCreate(&config)
+	}
+	return func(c *gin.Context) {
+		items, err := bindBatch(c, config.maxSize)
+		if errors.Is(err, ErrBatchTooLarge) {
+			logger.WithContext(c).WithError(err).
+				Warn("BulkCreateHandler: batch too large")
+			ResponseError(c, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("BulkCreateHandler: Bind failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		if len(items) == 0 {
+			ResponseError(c, CodeBadRequest, ErrEmptyBatch)
+			return
+		}
+
+		models := make([]*T, len(items))
+		var invalid []gin.H
+		for i, item := range items {
+			models[i] = new(T)
+			if err := bindItem(item, models[i], config.modelConfig); err != nil {
+				invalid = append(invalid, itemError(i, models[i], err))
+			}
+		}
+		if len(invalid) > 0 {
+			logger.WithContext(c).WithField("invalid", len(invalid)).
+				Warn("BulkCreateHandler: validation failed")
+			responseItemErrors(c, CodeBadRequest, ErrValidationFailed, invalid)
+			return
+		}
+
+		hooks := getHooks[T]()
+		for _, model := range models {
+			if !runBeforeHooks(c, hooks.BeforeCreate, model) {
+				return
+			}
+		}
+
+		logger.WithContext(c).Tracef("BulkCreateHandler: Create %d items", len(models))
+		err = service.CreateMany(requestContext(c), models)
+		var itemErr *service.ItemError
+		if errors.As(err, &itemErr) {
+			logger.WithContext(c).WithError(err).
+				Warn("BulkCreateHandler: CreateMany failed")
+			responseItemErrors(c, CodeProcessFailed, ErrBatchFailed,
+				[]gin.H{itemError(itemErr.Index, models[itemErr.Index], itemErr.Err)})
+			return
+		}
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("BulkCreateHandler: CreateMany failed")
 			ResponseError(c, CodeProcessFailed, err)
 			return
 		}
-		c.JSON(200, SuccessResponseBody(model))
+
+		for _, model := range models {
+			if !runAfterHooks(c, hooks.AfterCreate, model) {
+				return
+			}
+		}
+		ResponseCreated(c, models)
+	}
+}
+
+// DefaultMaxBatchSize is the default limit of the items in a request of
+// BulkCreateHandler.
+const DefaultMaxBatchSize = 1000
+
+// BulkCreateOption is an option of BulkCreateHandler: WithMaxBatchSize or
+// a ModelOption.
+type BulkCreateOption interface {
+	applyBulkCreate(config *bulkCreateConfig)
+}
+
+type bulkCreateOptionFunc func(config *bulkCreateConfig)
+
+func (f bulkCreateOptionFunc) applyBulkCreate(config *bulkCreateConfig) { f(config) }
+
+type bulkCreateConfig struct {
+	modelConfig
+
+	maxSize int
+}
+
+// WithMaxBatchSize limits the items in a request of BulkCreateHandler to
+// n (DefaultMaxBatchSize by default). The larger batches are rejected with
+// 413 Request Entity Too Large. Zero or negative n means no limit.
+func WithMaxBatchSize(n int) BulkCreateOption {
+	return bulkCreateOptionFunc(func(config *bulkCreateConfig) {
+		config.maxSize = n
+	})
+}
+
+// bindBatch decodes the JSON array of the request body into items.
+// It stops with ErrBatchTooLarge at the item exceeding the maxSize
+// (if positive), without reading the rest of the body.
+func bindBatch(c *gin.Context, maxSize int) ([]json.RawMessage, error) {
+	if c.Request.Body == nil {
+		return nil, ErrEmptyBatch
+	}
+	decoder := json.NewDecoder(c.Request.Body)
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token != json.Delim('[') {
+		return nil, fmt.Errorf("invalid batch: want a JSON array, got %v", token)
+	}
+	var items []json.RawMessage
+	for decoder.More() {
+		if maxSize > 0 && len(items) >= maxSize {
+			return nil, fmt.Errorf("%w: limit %d items", ErrBatchTooLarge, maxSize)
+		}
+		var item json.RawMessage
+		if err := decoder.Decode(&item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if _, err := decoder.Token(); err != nil { // the closing ]
+		return nil, err
+	}
+	return items, nil
+}
+
+// bindItem decodes an item of a batch request body into model and
+// validates it by the binding validator and the validators of model T.
+func bindItem[T any](item json.RawMessage, model *T, config modelConfig) error {
+	if err := json.Unmarshal(item, model); err != nil {
+		return err
+	}
+	stripClientID(model, config)
+	if err := binding.Validator.ValidateStruct(model); err != nil {
+		return err
+	}
+	return validateModel(model)
+}
+
+// clientIDRegistry: reflect.Type of *T => bool, see AllowClientID.
+var clientIDRegistry sync.Map
+
+// AllowClientID sets whether the clients can choose the primary key of the
+// models T they create (by CreateHandler and BulkCreateHandler), e.g.
+// POST /users {"id": 999, ...}.
+//
+// By default, it is not allowed for the models with an auto-increment
+// primary key (like orm.BasicModel), whose keys are left to the database,
+// so that a client can not collide with the others nor probe the sequence:
+// the key in the request body is ignored. It is allowed for the other ones
+// (like a UUID key generated by the clients).
+//
+//    AllowClientID[User](true)      // accept the ids of the clients
+//    AllowClientID[Document](false) // ignore them, e.g. generated in a BeforeCreate hook
+//
+// Notice: it is set per model type (all the routes), use WithClientID (or
+// router.WithClientID) to set it for the routes of a handler. It does not
+// affect the nested routes (see CreateNestedHandler), which refer to the
+// existing models by their ids.
+func AllowClientID[T any](allowed bool) {
+	clientIDRegistry.Store(reflect.TypeOf((*T)(nil)), allowed)
+}
+
+// stripClientID zeros the primary key of the model created by a client,
+// unless it is allowed by the config (see WithClientID) or AllowClientID.
+func stripClientID[T any](model *T, config modelConfig) {
+	s, err := orm.ParseSchema(model)
+	if err != nil {
+		return
+	}
+	field := s.PrioritizedPrimaryField
+	if field == nil {
+		return
+	}
+
+	allowed := !field.AutoIncrement
+	if v, ok := clientIDRegistry.Load(reflect.TypeOf((*T)(nil))); ok {
+		allowed = v.(bool)
+	}
+	if config.clientID != nil {
+		allowed = *config.clientID
+	}
+	if allowed {
+		return
+	}
+
+	value := reflect.ValueOf(model).Elem()
+	if _, zero := field.ValueOf(context.Background(), value); !zero {
+		logger.WithField("model", fmt.Sprintf("%T", model)).
+			Debug("stripClientID: the primary key from the client is ignored")
+		_ = field.Set(context.Background(), value, reflect.Zero(field.FieldType).Interface())
+	}
+}
+
+// itemError builds the error of the item at index of a batch request:
+//    { index: 1, error: "...", fields: {...} }
+// where the fields are the field-level validation errors, if any.
+func itemError(index int, model any, err error) gin.H {
+	item := gin.H{"index": index, "error": err.Error()}
+	fields, ok := bindErrorFields(model, err)
+	if !ok {
+		fields, ok = validatorErrorFields(err)
+	}
+	if ok {
+		item["error"] = ErrValidationFailed.Error()
+		item["fields"] = fields
+	}
+	return item
+}
+
+// responseItemErrors responds the errors of the failed items of a batch:
+//    { error: "...", items: [{ index: 1, error: "..." }, ...] }
+// or the APIError with the items in the details if APIErrorEnabled.
+func responseItemErrors(c *gin.Context, code int, err error, items []gin.H) {
+	if APIErrorEnabled() {
+		apiError := NewAPIError(code, err)
+		if errors.Is(err, ErrValidationFailed) {
+			apiError.Code = ErrorCodeValidationFailed
+		}
+		apiError.Details = gin.H{"items": items}
+		ResponseError(c, code, apiError)
+		return
+	}
+	Render(c, code, gin.H{
+		"error": err.Error(),
+		"items": items,
+	})
+}
+
+// CreateOption is an option of CreateHandler: WithReload, WithBinding,
+// WithIdempotency, WithIdempotencyScope or a ModelOption.
+type CreateOption interface {
+	applyCreate(config *createConfig)
+}
+
+type createOptionFunc func(config *createConfig)
+
+func (f createOptionFunc) applyCreate(config *createConfig) { f(config) }
+
+type createConfig struct {
+	modelConfig
+
+	reload bool
+
+	binding     binding.Binding
+	bindingAuto bool // binding by the Content-Type
+
+	idempotency      service.IdempotencyStore
+	idempotencyTTL   time.Duration
+	idempotencyScope func(c *gin.Context) string
+}
+
+// WithReload makes CreateHandler reload the created model from the
+// database with all associations preloaded (see service.Reload) before
+// responding, so that the generated fields (ids, timestamps, defaults)
+// of the model and the nested models are in the response.
+// It costs an extra query.
+func WithReload() CreateOption {
+	return createOptionFunc(func(config *createConfig) {
+		config.reload = true
+	})
+}
+
+// WithBinding sets the binding of the request body of CreateHandler,
+// which is binding.JSON by default. For example, to accept HTML form posts
+// (application/x-www-form-urlencoded or multipart/form-data):
+//    CreateHandler[User](WithBinding(binding.Form))
+// The fields are bound by the form tags (or the field names) of the model
+// then, see gin binding for details.
+//
+// A nil binding selects the binding by the Content-Type of each request
+// (see gin.Context.ShouldBind), so that JSON, forms, XML... all work.
+func WithBinding(b binding.Binding) CreateOption {
+	return createOptionFunc(func(config *createConfig) {
+		config.binding = b
+		config.bindingAuto = b == nil
+	})
+}
+
+// bind binds the request body into model by the binding of the config.
+func (config *createConfig) bind(c *gin.Context, model any) error {
+	switch {
+	case config.bindingAuto:
+		return c.ShouldBind(model)
+	case config.binding != nil:
+		return c.ShouldBindWith(model, config.binding)
+	}
+	return c.ShouldBindJSON(model)
+}
+
+// IdempotencyKeyHeader is the request header of the idempotency key.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// DefaultIdempotencyTTL is how long the responses are kept for the
+// idempotency keys by default.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// WithIdempotency makes CreateHandler idempotent for the requests with an
+// Idempotency-Key header: the success response of the first request is
+// stored with the key in the store, and replayed (without creating the
+// model again) for the repeated requests of the key in ttl. So that a
+// client can retry a POST safely.
+//
+// The store defaults to a service.GormIdempotencyStore if nil, and the ttl
+// defaults to DefaultIdempotencyTTL if not positive. The keys are scoped by
+// the request path, and by the client if WithIdempotencyScope is given.
+// The requests without the header are not affected.
+//
+// A key is bound to the request body of its first request: a repeated
+// request of the key with another body is rejected with 422 Unprocessable
+// Entity (ErrIdempotencyKeyReused), instead of replaying a response which
+// is not its own.
+//
+// Notice: concurrent requests of the same key (before the first one
+// completes) are not deduplicated.
+func WithIdempotency(store service.IdempotencyStore, ttl time.Duration) CreateOption {
+	if store == nil {
+		store = service.NewGormIdempotencyStore()
+	}
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	return createOptionFunc(func(config *createConfig) {
+		config.idempotency = store
+		config.idempotencyTTL = ttl
+	})
+}
+
+// WithIdempotencyScope scopes the idempotency keys of WithIdempotency by
+// the client: scope returns the principal of the request (e.g. the user id
+// set by an auth middleware), so that the clients do not share the keys,
+// and one can not replay the response of another:
+//    CreateHandler[Order](WithIdempotency(nil, 0),
+//        WithIdempotencyScope(func(c *gin.Context) string {
+//            return c.GetString("user")
+//        }))
+// Without it, the keys are shared by all the clients of the route.
+func WithIdempotencyScope(scope func(c *gin.Context) string) CreateOption {
+	return createOptionFunc(func(config *createConfig) {
+		config.idempotencyScope = scope
+	})
+}
+
+// serveIdempotent replays the stored response of the idempotency key of the
+// request if any, otherwise serves the request by handler and stores the
+// success response.
+func serveIdempotent(c *gin.Context, config createConfig, handler gin.HandlerFunc) {
+	store, ttl := config.idempotency, config.idempotencyTTL
+	key := c.GetHeader(IdempotencyKeyHeader)
+	if key == "" {
+		handler(c)
+		return
+	}
+	key = c.Request.URL.Path + " " + key
+	if config.idempotencyScope != nil {
+		key = config.idempotencyScope(c) + " " + key
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		logger.WithContext(c).WithError(err).
+			Warn("serveIdempotent: read request body failed")
+		ResponseError(c, CodeBadRequest, err)
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	hash := sha256.Sum256(body)
+	requestHash := hex.EncodeToString(hash[:])
+
+	response, err := store.Get(requestContext(c), key)
+	if err != nil { // serve it as if there is no idempotency
+		logger.WithContext(c).WithError(err).
+			Warn("serveIdempotent: get stored response failed")
+	}
+	if response != nil && response.RequestHash != "" && response.RequestHash != requestHash {
+		logger.WithContext(c).WithField("key", key).
+			Warn("serveIdempotent: idempotency key reused with another request body")
+		ResponseError(c, CodeProcessFailed, ErrIdempotencyKeyReused)
+		return
+	}
+	if response != nil {
+		if response.Location != "" {
+			c.Header("Location", response.Location)
+		}
+		c.Data(response.Status, response.ContentType, response.Body)
+		return
 	}
+
+	recorder := &responseRecorder{ResponseWriter: c.Writer}
+	c.Writer = recorder
+	handler(c)
+	c.Writer = recorder.ResponseWriter
+
+	if status := recorder.Status(); status < 200 || status >= 300 {
+		return
+	}
+	err = store.Put(requestContext(c), key, &service.IdempotentResponse{
+		RequestHash: requestHash,
+		Status:      recorder.Status(),
+		ContentType: recorder.Header().Get("Content-Type"),
+		Location:    recorder.Header().Get("Location"),
+		Body:        recorder.body.Bytes(),
+	}, ttl)
+	if err != nil {
+		logger.WithContext(c).WithError(err).
+			Warn("serveIdempotent: store response failed")
+	}
+}
+
+// responseRecorder is a gin.ResponseWriter that records the body written.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
 }
 
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// NestedResponse is the shape of the success response of
+// CreateNestedHandler.
+type NestedResponse int
+
+const (
+	// RespondParent responds the updated parent model (the default):
+	//    { P: {...} }
+	RespondParent NestedResponse = iota
+	// RespondChild responds the created (or linked) child model,
+	// with its generated id:
+	//    { T: {...} }
+	RespondChild
+	// RespondParentWithChild responds the updated parent model,
+	// and the child under the "child" key:
+	//    { P: {...}, child: {...} }
+	RespondParentWithChild
+)
+
 // CreateNestedHandler handles
 //    POST /P/:parentIDRouteParam/T
 // where:
 //  - P is the parent model, T is the child model
 //  - parentIDRouteParam is the route param name of the parent model P
 //  - field is the field name of the child model T in the parent model P
-// responds with the updated parent model P
+// responds with the updated parent model P, or the child model T by the
+// optional respond (see NestedResponse).
 //
 // Request body:
 //  - {...}  // fields of the child model T
 //
 // Response:
-//  - 200 OK: { P: {...} }
+//  - 200 OK: { P: {...} }, { T: {...} } or { P: {...}, child: {...} }
+//  - 201 Created: ..., Location: /P/:parentID/T/:childID  // if RESTStatusEnabled
 //  - 400 Bad Request: { error: "request band failed" }
+//  - 400 Bad Request: { error: "validation failed", fields: { "title": "required" } }
 //  - 422 Unprocessable Entity: { error: "create process failed" }
-func CreateNestedHandler[P orm.Model, T orm.Model](parentIDRouteParam string, field string) gin.HandlerFunc {
+func CreateNestedHandler[P orm.Model, T orm.Model](parentIDRouteParam string, field string, respond ...NestedResponse) gin.HandlerFunc {
+	response := RespondParent
+	if len(respond) > 0 {
+		response = respond[0]
+	}
 	return func(c *gin.Context) {
-		parentID := c.Param(parentIDRouteParam)
-		if parentID == "" {
-			ResponseError(c, CodeBadRequest, ErrMissingParentID)
+		parentID, err := paramID[P](c, parentIDRouteParam)
+		if errors.Is(err, ErrMissingID) {
+			err = ErrMissingParentID
+		}
+		if err != nil {
+			ResponseError(c, CodeBadRequest, err)
 			return
 		}
 
@@ -66,28 +615,28 @@ func CreateNestedHandler[P orm.Model, T orm.Model](parentIDRouteParam string, fi
 		if err := c.ShouldBindJSON(&child); err != nil {
 			logger.WithContext(c).WithError(err).
 				Warn("CreateNestedHandler: Bind failed")
-			ResponseError(c, CodeBadRequest, err)
+			ResponseBindError(c, &child, err)
 			return
 		}
 
 		if _, childID := child.Identity(); !reflect.ValueOf(childID).IsZero() {
 			// child id exists: add to join table, but do not update child's fields
 			logger.WithField("childID", childID).Debug("CreateNestedHandler: child model has ID, add to join table, but do not update child's fields")
-			if err := service.GetByID[T](c, childID, &child); err != nil {
+			if err := service.GetByID[T](requestContext(c), childID, &child); err != nil {
 				logger.WithContext(c).WithError(err).
 					WithField("note", "try to query it because child id exists in request").
 					Warn("CreateNestedHandler: GetByID[Child] failed")
-				ResponseError(c, CodeNotFound, err)
+				ResponseErrorAuto(c, CodeNotFound, err)
 				return
 			}
 		}
 		// else: id is not set: create new child
 
 		var parent P
-		if err := service.GetByID[P](c, parentID, &parent); err != nil {
+		if err := service.GetByID[P](requestContext(c), parentID, &parent); err != nil {
 			logger.WithContext(c).WithError(err).
 				Warn("CreateNestedHandler: GetByID[Parent] failed")
-			ResponseError(c, CodeNotFound, err)
+			ResponseErrorAuto(c, CodeNotFound, err)
 			return
 		}
 
@@ -97,11 +646,95 @@ func CreateNestedHandler[P orm.Model, T orm.Model](parentIDRouteParam string, fi
 		//field := strings.ToUpper(field)[:1] + field[1:]
 		field := nameToField(field, parent)
 
-		err := service.Create(c, &child, service.NestInto(&parent, field))
+		err = service.Create(requestContext(c), &child, service.NestInto(&parent, field))
 		if err != nil {
 			logger.WithContext(c).WithError(err).
 				Warn("CreateNestedHandler: CreateNest failed")
-			ResponseError(c, CodeProcessFailed, err)
+			ResponseErrorAuto(c, CodeProcessFailed, err)
+			return
+		}
+
+		if location, ok := locationOf(c, child); ok && RESTStatusEnabled() {
+			c.Header("Location", location) // of the child
+		}
+		switch response {
+		case RespondChild:
+			ResponseCreated(c, child)
+		case RespondParentWithChild:
+			ResponseCreated(c, parent, gin.H{"child": child})
+		default:
+			ResponseCreated(c, parent)
+		}
+	}
+}
+
+// ReplaceNestedHandler handles
+//    PUT /P/:parentIDRouteParam/T
+// where:
+//  - P is the parent model, T is the child model
+//  - parentIDRouteParam is the route param name of the parent model P
+//  - field is the field name of the child model T in the parent model P
+// sets the children in the request body as the complete list of the field
+// (see service.ReplaceAssociation): the ones not in the list are detached.
+// Unlike CreateNestedHandler, which appends a child to the field.
+// Responds with the updated parent model P.
+//
+// Request body:
+//  - [{...}, ...]  // children T: new ones, or existing ones with only id
+//
+// Response:
+//  - 200 OK: { P: {...} }
+//  - 400 Bad Request: { error: "request band failed" }
+//  - 400 Bad Request: { error: "validation failed", fields: { "title": "required" } }
+//  - 404 Not Found: { error: "record not found" }  // parent or child with id
+//  - 422 Unprocessable Entity: { error: "replace process failed" }
+func ReplaceNestedHandler[P orm.Model, T orm.Model](parentIDRouteParam string, field string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		parentID, err := paramID[P](c, parentIDRouteParam)
+		if errors.Is(err, ErrMissingID) {
+			err = ErrMissingParentID
+		}
+		if err != nil {
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+
+		var children []*T
+		if err := c.ShouldBindJSON(&children); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ReplaceNestedHandler: Bind failed")
+			ResponseBindError(c, &children, err)
+			return
+		}
+
+		for _, child := range children {
+			if _, childID := (*child).Identity(); !reflect.ValueOf(childID).IsZero() {
+				// child id exists: link it, but do not update child's fields
+				if err := service.GetByID[T](requestContext(c), childID, child); err != nil {
+					logger.WithContext(c).WithError(err).
+						WithField("childID", childID).
+						Warn("ReplaceNestedHandler: GetByID[Child] failed")
+					ResponseErrorAuto(c, CodeNotFound, err)
+					return
+				}
+			}
+		}
+
+		var parent P
+		if err := service.GetByID[P](requestContext(c), parentID, &parent); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ReplaceNestedHandler: GetByID[Parent] failed")
+			ResponseErrorAuto(c, CodeNotFound, err)
+			return
+		}
+
+		field := nameToField(field, parent)
+
+		err = service.ReplaceAssociation(requestContext(c), &parent, field, children)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ReplaceNestedHandler: ReplaceAssociation failed")
+			ResponseErrorAuto(c, CodeProcessFailed, err)
 			return
 		}
 		ResponseSuccess(c, parent)
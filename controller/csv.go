@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"database/sql/driver"
+	"encoding/csv"
+	"fmt"
+	"github.com/cdfmlr/crud/service"
+	"github.com/gin-gonic/gin"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// csvFlushRows is the number of rows written between two flushes
+// of the response writer when streaming CSV.
+const csvFlushRows = 100
+
+// wantsCSV reports whether the client asks for a CSV response,
+// by the format=csv query param or the Accept: text/csv header.
+func wantsCSV(c *gin.Context, request GetRequestOptions) bool {
+	if request.Format != "" {
+		return strings.EqualFold(request.Format, "csv")
+	}
+	return strings.Contains(c.GetHeader("Accept"), "text/csv")
+}
+
+// csvColumn is a top-level field of the model exported in CSV.
+type csvColumn struct {
+	name  string // the header
+	index []int  // the field index for reflect.Value.FieldByIndex
+}
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+)
+
+// csvColumns returns the columns of model type t: the exported top-level
+// fields of basic types (including time.Time and driver.Valuer, e.g.
+// gorm.DeletedAt), named by their json tags. Associations and other
+// nested structures are skipped.
+func csvColumns(t reflect.Type) []csvColumn {
+	var columns []csvColumn
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct &&
+			field.Type.Kind() != reflect.Ptr { // embedded fields are promoted
+			for _, column := range csvColumns(fieldType) {
+				column.index = append([]int{i}, column.index...)
+				columns = append(columns, column)
+			}
+			continue
+		}
+		if !isCSVType(fieldType) {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		columns = append(columns, csvColumn{name: name, index: []int{i}})
+	}
+	return columns
+}
+
+// isCSVType reports whether values of type t can be written in a CSV cell.
+func isCSVType(t reflect.Type) bool {
+	if t == timeType || t.Implements(valuerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// csvCell formats the value of a field into a CSV cell.
+func csvCell(value reflect.Value) string {
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+
+	v := value.Interface()
+	if valuer, ok := v.(driver.Valuer); ok {
+		var err error
+		if v, err = valuer.Value(); err != nil || v == nil {
+			return ""
+		}
+	}
+	if t, ok := v.(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// responseCSV streams the models T queried with the options to client as a
+// CSV file (named Ts.csv), with a header row of the column names.
+// Only the columns of the fields are written if not nil.
+func responseCSV[T any](c *gin.Context, fields *sparseFields, options []service.QueryOption) {
+	var columns []csvColumn
+	for _, column := range csvColumns(reflect.TypeOf(*new(T))) {
+		if fields.has(column.name) {
+			columns = append(columns, column)
+		}
+	}
+	writer := csv.NewWriter(c.Writer)
+
+	started := false
+	start := func() error {
+		started = true
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition",
+			fmt.Sprintf(`attachment; filename="%s.csv"`, ResponseKey(reflect.TypeOf(*new(T)), true)))
+		c.Status(CodeSuccess)
+
+		header := make([]string, len(columns))
+		for i, column := range columns {
+			header[i] = column.name
+		}
+		return writer.Write(header)
+	}
+
+	count := 0
+	record := make([]string, len(columns))
+	err := service.Stream[T](requestContext(c), func(model *T) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		value := reflect.ValueOf(model).Elem()
+		for i, column := range columns {
+			record[i] = csvCell(value.FieldByIndex(column.index))
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+
+		count++
+		if count%csvFlushRows == 0 {
+			writer.Flush()
+			c.Writer.Flush()
+		}
+		return writer.Error()
+	}, options...)
+
+	if err != nil && !started {
+		logger.WithContext(c).WithError(err).
+			Warn("responseCSV: Stream failed")
+		ResponseErrorAuto(c, CodeProcessFailed, err)
+		return
+	}
+	if err != nil { // the response is partially sent, nothing can be done
+		logger.WithContext(c).WithError(err).
+			Error("responseCSV: Stream interrupted")
+		return
+	}
+
+	if !started { // empty result set: header only
+		_ = start()
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		logger.WithContext(c).WithError(err).
+			Warn("responseCSV: write CSV failed")
+	}
+}
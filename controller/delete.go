@@ -1,9 +1,11 @@
 package controller
 
 import (
+	"errors"
 	"github.com/cdfmlr/crud/orm"
 	"github.com/cdfmlr/crud/service"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // DeleteHandler handles
@@ -12,32 +14,67 @@ import (
 //
 // Request body: none
 //
+// Hooks (see RegisterHooks): BeforeDelete, AfterDelete
+//
 // Response:
-//  - 200 OK: { deleted: true }
-//  - 400 Bad Request: { error: "missing id" }
+//  - 200 OK: { deleted: true, rowsAffected: 1 }
+//  - 400 Bad Request: { error: "missing id" } or { error: "invalid id format" }
+//  - 403 Forbidden: { error: "forbidden" }  // by hooks
+//  - 404 Not Found: { error: "record not found" }
 //  - 422 Unprocessable Entity: { error: "delete process failed" }
 func DeleteHandler[T orm.Model](idParam string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param(idParam)
-		if id == "" {
+		id, err := paramID[T](c, idParam)
+		if err != nil {
 			logger.WithContext(c).
-				WithField("idParam", idParam).
+				WithField("idParam", idParam).WithError(err).
 				Warn("DeleteHandler: read id param failed")
-			ResponseError(c, CodeBadRequest, ErrMissingID)
+			ResponseError(c, CodeBadRequest, err)
 			return
 		}
 		logger.WithContext(c).
 			Tracef("DeleteHandler: Delete %T, id=%v", *new(T), id)
 
-		_, err := service.DeleteByID[T](c, id)
+		hooks := getHooks[T]()
+		var model *T
+		if len(hooks.BeforeDelete) > 0 || len(hooks.AfterDelete) > 0 {
+			var err error
+			if model, err = getModelByID[T](c, idParam); err != nil {
+				logger.WithContext(c).WithError(err).
+					Warn("DeleteHandler: getModelByID failed")
+				responseDeleteError(c, err)
+				return
+			}
+		}
+		if !runBeforeHooks(c, hooks.BeforeDelete, model) {
+			return
+		}
+
+		rowsAffected, err := service.DeleteByID[T](requestContext(c), id)
 		if err != nil {
-			ResponseError(c, CodeProcessFailed, err)
+			logger.WithContext(c).WithError(err).
+				Warn("DeleteHandler: DeleteByID failed")
+			responseDeleteError(c, err)
 			return
 		}
-		ResponseSuccess(c, nil, gin.H{"deleted": true})
+
+		if !runAfterHooks(c, hooks.AfterDelete, model) {
+			return
+		}
+		ResponseSuccess(c, nil, gin.H{"deleted": true, "rowsAffected": rowsAffected})
 	}
 }
 
+// responseDeleteError responds the error of deleting: 404 Not Found if the
+// record is not found, otherwise 422 Unprocessable Entity.
+func responseDeleteError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ResponseErrorAuto(c, CodeNotFound, err)
+		return
+	}
+	ResponseErrorAuto(c, CodeProcessFailed, err)
+}
+
 // DeleteNestedHandler handles
 //    DELETE /P/:parentIdParam/T/:childIdParam
 // where:
@@ -50,24 +87,27 @@ func DeleteHandler[T orm.Model](idParam string) gin.HandlerFunc {
 //
 // Response:
 //  - 200 OK: { deleted: true }
-//  - 400 Bad Request: { error: "missing id" }
+//  - 400 Bad Request: { error: "missing id" } or { error: "invalid id format" }
 //  - 422 Unprocessable Entity: { error: "delete process failed" }
 func DeleteNestedHandler[P orm.Model, T orm.Model](parentIdParam string, field string, childIdParam string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		parentId := c.Param(parentIdParam)
-		if parentId == "" {
+		parentId, err := paramID[P](c, parentIdParam)
+		if errors.Is(err, ErrMissingID) {
+			err = ErrMissingParentID
+		}
+		if err != nil {
 			logger.WithContext(c).
-				WithField("parentIdParam", parentIdParam).
+				WithField("parentIdParam", parentIdParam).WithError(err).
 				Warn("DeleteNestedHandler: read id param failed")
-			ResponseError(c, CodeBadRequest, ErrMissingParentID)
+			ResponseError(c, CodeBadRequest, err)
 			return
 		}
-		childId := c.Param(childIdParam)
-		if childId == "" {
+		childId, err := paramID[T](c, childIdParam)
+		if err != nil {
 			logger.WithContext(c).
-				WithField("childIdParam", childIdParam).
+				WithField("childIdParam", childIdParam).WithError(err).
 				Warn("DeleteNestedHandler: read id param failed")
-			ResponseError(c, CodeBadRequest, ErrMissingID)
+			ResponseError(c, CodeBadRequest, err)
 			return
 		}
 		//field := strings.ToUpper(field)[:1] + field[1:]
@@ -76,11 +116,11 @@ func DeleteNestedHandler[P orm.Model, T orm.Model](parentIdParam string, field s
 		logger.WithContext(c).
 			Tracef("DeleteNestedHandler: Delete %v of %v, parentId=%v, field=%v, childId=%v", *new(T), *new(P), parentId, field, childId)
 
-		err := service.DeleteNestedByID[P, T](c, parentId, field, childId)
+		err = service.DeleteNestedByID[P, T](requestContext(c), parentId, field, childId)
 		if err != nil {
 			logger.WithContext(c).WithError(err).
 				Warn("DeleteNestedHandler: Delete failed")
-			ResponseError(c, CodeProcessFailed, err)
+			ResponseErrorAuto(c, CodeProcessFailed, err)
 			return
 		}
 		ResponseSuccess(c, nil, gin.H{"deleted": true})
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"sync/atomic"
+)
+
+// EnvelopeStyle is the style of the success response bodies.
+type EnvelopeStyle string
+
+const (
+	// EnvelopeTyped wraps the model with its type name (the default):
+	//    { User: {...} } or { Users: [...], total: 100 }
+	// See SuccessResponseBody and RegisterResponseKey.
+	EnvelopeTyped EnvelopeStyle = "typed"
+	// EnvelopeData always wraps the model with "data":
+	//    { data: {...} } or { data: [...], total: 100 }
+	EnvelopeData EnvelopeStyle = "data"
+	// EnvelopeBare responds the model directly:
+	//    {...} or [...]
+	// The additions (like total) are dropped, except for responses without
+	// a model (e.g. { deleted: true }). The total of a list is sent in the
+	// X-Total-Count header instead.
+	EnvelopeBare EnvelopeStyle = "bare"
+)
+
+// responseEnvelope is set by WithEnvelope, nil for EnvelopeTyped.
+var responseEnvelope atomic.Pointer[EnvelopeStyle]
+
+// ResponseEnvelope returns the style of the success response bodies
+// of the crud handlers, EnvelopeTyped by default. Use WithEnvelope to
+// change it.
+func ResponseEnvelope() EnvelopeStyle {
+	if style := responseEnvelope.Load(); style != nil {
+		return *style
+	}
+	return EnvelopeTyped
+}
+
+// WithEnvelope sets the ResponseEnvelope style. Unknown styles are ignored.
+// Call it before serving.
+func WithEnvelope(style EnvelopeStyle) {
+	switch style {
+	case EnvelopeTyped, EnvelopeData, EnvelopeBare:
+		responseEnvelope.Store(&style)
+	default:
+		logger.WithField("style", style).
+			Warn("WithEnvelope: unknown envelope style. Ignored.")
+	}
+}
+
+// ResponseBody builds the success response body in the ResponseEnvelope style.
+func ResponseBody(model any, addition ...gin.H) any {
+	switch ResponseEnvelope() {
+	case EnvelopeData:
+		res := gin.H{}
+		if model != nil {
+			res["data"] = model
+		}
+		for _, h := range addition {
+			for k, v := range h {
+				res[k] = v
+			}
+		}
+		return res
+	case EnvelopeBare:
+		if model != nil {
+			return model
+		}
+		return SuccessResponseBody(nil, addition...)
+	}
+	return SuccessResponseBody(model, addition...)
+}
+
+// totalHeader is the response header of the total count in the bare style.
+const totalHeader = "X-Total-Count"
+
+// setTotalHeader sets the X-Total-Count header in the bare style,
+// if there is a total in the additions.
+func setTotalHeader(c *gin.Context, addition ...gin.H) {
+	if ResponseEnvelope() != EnvelopeBare {
+		return
+	}
+	for _, h := range addition {
+		if total, ok := h["total"]; ok {
+			c.Header(totalHeader, fmt.Sprint(total))
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// apiErrorEnabled is set by WithAPIError, see APIErrorEnabled.
+var apiErrorEnabled atomic.Bool
+
+// APIErrorEnabled reports whether the error responses are switched to the
+// structured (machine-readable) APIError envelope:
+//    { error: { code: "NOT_FOUND", message: "...", details: {...} } }
+// It is disabled by default, which keeps the legacy error responses:
+//    { error: "error message" }
+//
+// Use WithAPIError (or router.WithAPIError) to enable it.
+func APIErrorEnabled() bool {
+	return apiErrorEnabled.Load()
+}
+
+// WithAPIError enables the APIError responses of the crud handlers,
+// see APIErrorEnabled. Call it before serving.
+func WithAPIError() {
+	apiErrorEnabled.Store(true)
+}
+
+// Error codes of APIError. Codes for other http status are derived from the
+// status text: 403 => "FORBIDDEN", 500 => "INTERNAL_SERVER_ERROR", etc.
+const (
+	ErrorCodeBadRequest    = "BAD_REQUEST"
+	ErrorCodeNotFound      = "NOT_FOUND"
+	ErrorCodeProcessFailed = "UNPROCESSABLE_ENTITY"
+
+	ErrorCodeValidationFailed = "VALIDATION_FAILED"
+)
+
+// APIError is a structured error with a machine-readable code, a
+// human-readable message and optional details (e.g. field-level errors).
+//
+// Handlers can return an APIError to ResponseError to control the code
+// and details, other errors are wrapped by NewAPIError.
+type APIError struct {
+	Code    string `json:"code" xml:"code"`
+	Message string `json:"message" xml:"message"`
+	Details gin.H  `json:"details,omitempty" xml:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// NewAPIError wraps err into an APIError, with the code derived from
+// the err (for known errors) or the http status.
+// If err is (or wraps) an APIError already, it is returned as it is.
+func NewAPIError(status int, err error) *APIError {
+	var apiError *APIError
+	if errors.As(err, &apiError) {
+		return apiError
+	}
+	return &APIError{
+		Code:    errorCode(status, err),
+		Message: err.Error(),
+	}
+}
+
+// errorCode gets the APIError code for the err responded with status.
+func errorCode(status int, err error) string {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrorCodeNotFound
+	case status == CodeBadRequest:
+		return ErrorCodeBadRequest
+	case status == CodeNotFound:
+		return ErrorCodeNotFound
+	case status == CodeProcessFailed:
+		return ErrorCodeProcessFailed
+	}
+	text := http.StatusText(status)
+	if text == "" {
+		return "UNKNOWN_ERROR"
+	}
+	return strings.ToUpper(strings.ReplaceAll(text, " ", "_"))
+}
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/service"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// etagOf returns the ETag of the response body data: a strong validator
+// (quoted) of its hash, which changes as soon as any byte of the response
+// changes.
+func etagOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatch reports whether the etag matches the value of an If-Match or
+// If-None-Match header: a list of ETags, or "*" matching any. The weak
+// validators (W/"...") are compared as the strong ones.
+func etagMatch(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// currentETag returns the ETag of the model T with the id as GetByIDHandler
+// responds it without query params (i.e. with the default preloads, see
+// SetDefaultPreloads and WithDefaultPreloads of the config), to be checked
+// against the If-Match header. The model is returned as well.
+func currentETag[T orm.Model](c *gin.Context, idParam string, config modelConfig) (string, *T, error) {
+	var request GetRequestOptions
+	withDefaultPreloads(&request, new(T), config)
+	options, err := buildQueryOptions(request, new(T))
+	if err != nil {
+		return "", nil, err
+	}
+	options = append(options, service.ReadPrimary()) // the latest, to check the update against
+	model, err := getModelByID[T](c, idParam, options...)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := marshalJSON(ResponseBody(model))
+	if err != nil {
+		return "", nil, err
+	}
+	return etagOf(data), model, nil
+}
+
+// checkIfMatch checks the If-Match header of the update request against the
+// current ETag of the model T (see currentETag). It responds the error and
+// returns false if the model is not found (404) or the ETag mismatches
+// (412 Precondition Failed), i.e. the model is changed since the client
+// got it. The requests without If-Match pass.
+//
+// The conditions returned are to be given to the update (see
+// service.Update), which fails with service.ErrNotUpdated if the model is
+// changed after the check, by the UpdatedAt (the field updated on each
+// update by gorm, see unchangedCondition). The check is not atomic for the
+// models without one.
+func checkIfMatch[T orm.Model](c *gin.Context, idParam string, name string, config modelConfig) (conditions []service.QueryOption, ok bool) {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		return nil, true
+	}
+	etag, model, err := currentETag[T](c, idParam, config)
+	if err != nil {
+		logger.WithContext(c).WithError(err).
+			Warn(name + ": currentETag failed")
+		ResponseErrorAuto(c, CodeNotFound, err)
+		return nil, false
+	}
+	if !etagMatch(ifMatch, etag) {
+		logger.WithContext(c).WithField("ifMatch", ifMatch).WithField("etag", etag).
+			Warn(name + ": If-Match mismatch")
+		ResponseError(c, CodePreconditionFailed, ErrPreconditionFailed)
+		return nil, false
+	}
+	if condition, ok := unchangedCondition(model); ok {
+		conditions = append(conditions, condition)
+	}
+	return conditions, true
+}
+
+// unchangedCondition returns the condition that the model is unchanged in
+// the database since it was read: the UpdatedAt (the field with the
+// autoUpdateTime of gorm) is still the read one. ok is false if the model
+// has no such field.
+func unchangedCondition[T any](model *T) (condition service.QueryOption, ok bool) {
+	s, err := orm.ParseSchema(model)
+	if err != nil {
+		return nil, false
+	}
+	for _, field := range s.Fields {
+		if field.AutoUpdateTime == 0 || field.DBName == "" {
+			continue
+		}
+		value, _ := field.ValueOf(context.Background(), reflect.ValueOf(model).Elem())
+		return service.Where(clause.Eq{
+			Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName},
+			Value:  value,
+		}), true
+	}
+	return nil, false
+}
+
+// responsePreconditionFailed responds 412 Precondition Failed if the err
+// is the service.ErrNotUpdated of the conditions of checkIfMatch, i.e.
+// the model is changed by another request during the update.
+// It returns false for the other errors.
+func responsePreconditionFailed(c *gin.Context, err error) bool {
+	if !errors.Is(err, service.ErrNotUpdated) {
+		return false
+	}
+	ResponseError(c, CodePreconditionFailed, fmt.Errorf("%w: %w", ErrPreconditionFailed, err))
+	return true
+}
+
+// responseSuccessETag responds the model like ResponseSuccess, with the
+// ETag of the JSON body (the bytes responded), or 304 Not Modified without
+// a body if the If-None-Match header matches it. The XML responses (see
+// Render) are not tagged.
+func responseSuccessETag(c *gin.Context, model any) {
+	if c.NegotiateFormat(binding.MIMEJSON, binding.MIMEXML, binding.MIMEXML2) != binding.MIMEJSON {
+		ResponseSuccess(c, model)
+		return
+	}
+	data, err := marshalJSON(ResponseBody(model))
+	if err != nil {
+		logger.WithContext(c).WithError(err).
+			Error("responseSuccessETag: marshal failed")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	etag := etagOf(data)
+	c.Header("ETag", etag)
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" && etagMatch(ifNoneMatch, etag) {
+		c.Status(CodeNotModified)
+		return
+	}
+	c.Data(http.StatusOK, binding.MIMEJSON+"; charset=utf-8", data)
+}
@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/service"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+)
+
+// sparseFields is the sparse fieldset of a request (the fields query
+// param): the columns to select, and the keys kept in the responses.
+// A nil *sparseFields keeps all the fields.
+type sparseFields struct {
+	columns []string        // to select from the database
+	keys    map[string]bool // json names of the fields to respond
+}
+
+// parseSparseFields parses the fields query params (comma separated field
+// names, columns or json names of the model) into the sparseFields.
+// The primary keys are always included, so that the responded models are
+// still addressable. Associations can be listed to keep the preloaded ones.
+// Unknown fields are rejected with ErrInvalidFields.
+//
+// It returns nil if there are no fields requested.
+func parseSparseFields(fields []string, model any) (*sparseFields, error) {
+	var names []string
+	for _, field := range fields {
+		for _, name := range strings.Split(field, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	s, err := orm.ParseSchema(model)
+	if err != nil {
+		return nil, err
+	}
+
+	sparse := &sparseFields{keys: map[string]bool{}}
+	add := func(f *schema.Field) {
+		if f.DBName != "" && !sparse.keys[jsonKey(f)] {
+			sparse.columns = append(sparse.columns, f.DBName)
+		}
+		sparse.keys[jsonKey(f)] = true
+	}
+	for _, f := range s.PrimaryFields {
+		add(f)
+	}
+	for _, name := range names {
+		f := s.LookUpField(name)
+		if f == nil {
+			if field, ok := jsonNameToField(model, name); ok {
+				f = s.LookUpField(field)
+			}
+		}
+		if f == nil || f.StructField.Tag.Get("json") == "-" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidFields, name)
+		}
+		add(f)
+	}
+	return sparse, nil
+}
+
+// jsonKey returns the key of the field in the JSON responses:
+// the json name given by the json tag, or the field name.
+func jsonKey(f *schema.Field) string {
+	name, _, _ := strings.Cut(f.StructField.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// option returns the query option selecting the columns.
+func (f *sparseFields) option() service.QueryOption {
+	return service.Select(f.columns...)
+}
+
+// has reports whether the field of the json name key is kept.
+func (f *sparseFields) has(key string) bool {
+	return f == nil || f.keys[key]
+}
+
+// sparse wraps the model to respond only the fields kept.
+// The model is returned as it is if f is nil.
+func sparse[T any](f *sparseFields, model *T) any {
+	if f == nil {
+		return model
+	}
+	return sparseModel[T]{model: model, keys: f.keys}
+}
+
+// sparseSlice is the slice version of sparse.
+func sparseSlice[T any](f *sparseFields, models []*T) any {
+	if f == nil {
+		return models
+	}
+	res := make([]sparseModel[T], len(models))
+	for i, model := range models {
+		res[i] = sparseModel[T]{model: model, keys: f.keys}
+	}
+	return res
+}
+
+// sparseModel is a model T that is marshalled to JSON with only the
+// keys. It is named as T in the response bodies (see ResponseNamer).
+//
+// XML responses are not filtered: the fields not selected are
+// responded with the zero values.
+type sparseModel[T any] struct {
+	model *T
+	keys  map[string]bool
+}
+
+func (m sparseModel[T]) ResponseName() (singular, plural string) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	return ResponseKey(t, false), ResponseKey(t, true)
+}
+
+func (m sparseModel[T]) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(m.model)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for key := range fields {
+		if !m.keys[key] {
+			delete(fields, key)
+		}
+	}
+	return json.Marshal(fields)
+}
+
+func (m sparseModel[T]) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(m.model, start)
+}
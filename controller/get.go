@@ -2,31 +2,223 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/cdfmlr/crud/orm"
 	"github.com/cdfmlr/crud/service"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/schema"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // GetRequestOptions is the query options (?opt=val) for GET requests:
 //
 //     limit=10&offset=4&                 # pagination
 //     order_by=id&desc=true&             # ordering
+//     order_by=priority:desc&order_by=created_at:asc&  # ordering by multiple fields
 //     filter_by=name&filter_value=John&  # filtering
-//     total=true&                        # return total count (all available records under the filter, ignoring pagination)
+//     filter_by=status&filter_op=ne&filter_value=done&  # filtering by an operator: eq (default), ne, gt, gte, lt, lte
+//     filter_by=status&filter_in=open,closed&  # filtering by a list of values (WHERE status IN ...), use \, to escape a comma
+//     between_field=created_at&between_low=2024-01-01&between_high=2024-02-01&  # range (inclusive), either bound can be omitted
+//     null=assignee_id&not_null=due_at&  # WHERE assignee_id IS NULL AND due_at IS NOT NULL
+//     scope=active&                      # apply the named scope registered by service.RegisterScope (or RegisterModelScope)
+//     fields=id,name&                    # sparse fieldset: select and respond only these fields (and the id)
+//     with_trashed=true&                 # include soft-deleted records (see WithTrashedAccess)
+//     only_trashed=true&                 # only the soft-deleted records (overrides with_trashed)
+//     total=true&                        # return total count (all available records under the filter, ignoring pagination) and pagination metadata
 //     preload=Product&preload=Product.Manufacturer  # preloading: loads nested models as well
+//     preload=Orders&preload_order=Orders:created_at desc&preload_limit=Orders:5  # ordering and limiting a preload
+//     format=csv                         # download as a CSV file (GetListHandler only)
+//     stream=true                        # stream the records in NDJSON (all of them, ignoring the pagination, with WithStream)
+//     q=golang                           # search in the text columns (SearchHandler only)
+//
+// Fields in order_by, filter_by, between_field, null and not_null must be fields (like "CreatedAt") or
+// columns (like "created_at") of the model, unknown fields are rejected.
+//
+// The preload_order (field[:asc|:desc] or "field desc") and preload_limit
+// are given per preloaded association, in form of "Association:value".
+// The preload_limit limits the total number of associations loaded (it is
+// the LIMIT of the preloading query), which is the number per parent only
+// if there is a single parent. So it is only accepted by GetByIDHandler
+// (and GetFieldHandler of a non-slice field), and rejected by the list
+// handlers, instead of silently missing the children of some parents.
+//
+// The fields are field names, columns or json names of the model, comma
+// separated, unknown fields are rejected. The primary key is always
+// included. Associations can be listed to keep the preloaded ones, which
+// need their foreign keys listed as well.
+//
+// Multiple order_by are applied in the given order. An order_by without the
+// ":asc" or ":desc" suffix is descending iff desc=true.
+//
+// The i-th between_field is paired with the i-th between_low and
+// between_high (empty for open-ended), they are AND-ed with the filters.
+//
+// Multiple filters are AND-ed together. The i-th filter_by is paired with
+// the i-th filter_value (or filter_in), use empty placeholders to align them
+// when mixing the two kinds of filters:
+//
+//     filter_by=name&filter_value=John&filter_by=age&filter_value=30
+//     filter_by=name&filter_value=John&filter_in=&filter_by=status&filter_value=&filter_in=open,closed
+//
+// A given filter_value filters for it even if it is empty (unless it is the
+// placeholder of a filter_in), which is the zero value of the field. The
+// values (of filter_value, filter_in, between_low and between_high) of
+// boolean, numeric and time fields are parsed as such, the invalid ones
+// are rejected:
+//
+//     filter_by=done&filter_value=false      # WHERE done = false
+//     filter_by=count&filter_value=          # WHERE count = 0
+//     filter_by=age&filter_in=18,19,20       # WHERE age IN (18, 19, 20)
+//
+// The times are in RFC 3339 (2024-01-02T15:04:05Z), or 2024-01-02T15:04:05,
+// 2024-01-02 15:04:05 or 2024-01-02 in UTC.
+//
+// The i-th filter_op (empty for eq) applies to the i-th filter_value:
+//
+//     filter_by=status&filter_op=ne&filter_value=done&filter_by=priority&filter_op=gte&filter_value=3
+//     filter_by=name&filter_op=&filter_value=John&filter_by=status&filter_op=ne&filter_value=done
 //
 // It is used in GetListHandler, GetByIDHandler and GetFieldHandler, to bind
 // the query parameters in the GET request url.
 type GetRequestOptions struct {
-	Limit       int      `form:"limit"`
-	Offset      int      `form:"offset"`
-	OrderBy     string   `form:"order_by"`
-	Descending  bool     `form:"desc"`
-	FilterBy    string   `form:"filter_by"`
-	FilterValue string   `form:"filter_value"`
-	Preload     []string `form:"preload"` // fields to preload
-	Total       bool     `form:"total"`   // return total count ?
+	Limit        int      `form:"limit"`
+	Offset       int      `form:"offset"`
+	OrderBy      []string `form:"order_by"`
+	Descending   bool     `form:"desc"`
+	FilterBy     []string `form:"filter_by"`
+	FilterValue  []string `form:"filter_value"`
+	FilterOp     []string `form:"filter_op"`     // operator of the filter_value: eq (default), ne, gt, gte, lt, lte
+	FilterIn     []string `form:"filter_in"`     // comma separated values
+	BetweenField []string `form:"between_field"` // field of the range
+	BetweenLow   []string `form:"between_low"`   // lower bound (inclusive)
+	BetweenHigh  []string `form:"between_high"`  // upper bound (inclusive)
+	Null         []string `form:"null"`          // fields that are null
+	NotNull      []string `form:"not_null"`      // fields that are not null
+	Scope        []string `form:"scope"`         // names of the scopes to apply
+	Fields       []string `form:"fields"`        // comma separated fields to respond (sparse fieldset)
+	Preload      []string `form:"preload"`       // fields to preload
+	PreloadOrder []string `form:"preload_order"` // Association:field[:asc|:desc]
+	PreloadLimit []string `form:"preload_limit"` // Association:limit
+	Total        bool     `form:"total"`         // return total count ?
+	WithTrashed  bool     `form:"with_trashed"`  // include soft-deleted records ?
+	OnlyTrashed  bool     `form:"only_trashed"`  // only soft-deleted records ?
+	Format       string   `form:"format"`        // response format: "csv", "ndjson" or json by default
+	Stream       bool     `form:"stream"`        // stream in NDJSON (same as format=ndjson) ?
+	Search       string   `form:"q"`             // search keyword (SearchHandler only)
+}
+
+// Pagination is the pagination metadata in the list responses:
+//
+//     { "limit": 10, "offset": 20, "total": 350, "hasNext": true }
+//
+// It is derived from the limit and offset of the request and the total count.
+type Pagination struct {
+	Limit   int   `json:"limit"`
+	Offset  int   `json:"offset"`
+	Total   int64 `json:"total"`
+	HasNext bool  `json:"hasNext"` // are there more records after this page ?
+}
+
+// newPagination builds the Pagination of the request.
+func newPagination(request GetRequestOptions, total int64) Pagination {
+	return Pagination{
+		Limit:   request.Limit,
+		Offset:  request.Offset,
+		Total:   total,
+		HasNext: request.Limit > 0 && int64(request.Offset+request.Limit) < total,
+	}
+}
+
+// PageSize limits the page size (the limit) of the list requests of a model:
+// DefaultLimit is applied to the requests without a limit, and the limits
+// greater than MaxLimit are capped to it. Zero means no default or no cap.
+type PageSize struct {
+	DefaultLimit int
+	MaxLimit     int
+}
+
+// limit returns the limit to apply for the requested one.
+func (p PageSize) limit(requested int) int {
+	limit := requested
+	if limit <= 0 {
+		limit = p.DefaultLimit
+	}
+	if p.MaxLimit > 0 && (limit <= 0 || limit > p.MaxLimit) {
+		limit = p.MaxLimit
+	}
+	return limit
+}
+
+// pageSizeRegistry: reflect.Type of T => PageSize
+var pageSizeRegistry sync.Map
+
+// SetPageSize sets the PageSize of the list requests of model T
+// (GetListHandler and SearchHandler), for example:
+//    SetPageSize[User](50, 500)
+// makes GET /users respond at most 50 users if no limit is given,
+// and at most 500 users whatever the limit is.
+//
+// There is no limit by default, which responds the whole table.
+//
+// Notice: it is set per model type (all the routes), use WithPageSize (or
+// router.WithPageSize) to set it for the routes of a handler.
+func SetPageSize[T any](defaultLimit, maxLimit int) {
+	pageSizeRegistry.Store(reflect.TypeOf((*T)(nil)), PageSize{
+		DefaultLimit: defaultLimit,
+		MaxLimit:     maxLimit,
+	})
+}
+
+// getPageSize returns the PageSize of model T set by SetPageSize.
+func getPageSize[T any]() PageSize {
+	if pageSize, ok := pageSizeRegistry.Load(reflect.TypeOf((*T)(nil))); ok {
+		return pageSize.(PageSize)
+	}
+	return PageSize{}
+}
+
+// defaultPreloadRegistry: reflect.Type of *T => []string
+var defaultPreloadRegistry sync.Map
+
+// SetDefaultPreloads sets the associations of model T preloaded by default
+// in GetByIDHandler and GetListHandler (and GetFieldHandler when T is the
+// model of the field), for example:
+//    SetDefaultPreloads[User]("Profile", "Orders")
+// makes GET /users/1 respond the user with the profile and the orders
+// loaded, as if it were GET /users/1?preload=Profile&preload=Orders.
+//
+// The explicit preload params of a request win: the defaults are applied
+// only to the requests without any preload param. An empty preload param
+// (GET /users/1?preload=) preloads nothing.
+//
+// Notice: it is set per model type (all the routes), use
+// WithDefaultPreloads (or router.WithDefaultPreloads) to set it for the
+// routes of a handler.
+func SetDefaultPreloads[T any](fields ...string) {
+	defaultPreloadRegistry.Store(reflect.TypeOf((*T)(nil)), fields)
+}
+
+// withDefaultPreloads sets the default preloads of the model (a pointer to
+// the model struct) to the request, unless there are preload params in it.
+// The ones of the config (see WithDefaultPreloads) win over the ones set by
+// SetDefaultPreloads.
+func withDefaultPreloads(request *GetRequestOptions, model any, config modelConfig) {
+	if len(request.Preload) > 0 {
+		return
+	}
+	if config.preloads != nil {
+		request.Preload = *config.preloads
+		return
+	}
+	if fields, ok := defaultPreloadRegistry.Load(reflect.TypeOf(model)); ok {
+		request.Preload = fields.([]string)
+	}
 }
 
 // GetListHandler handles
@@ -34,58 +226,322 @@ type GetRequestOptions struct {
 // It returns a list of models.
 //
 // QueryOptions (See GetRequestOptions for more details):
-//    limit, offset, order_by, desc, filter_by, filter_value, preload, total.
+//    limit, offset, order_by, desc, filter_by, filter_value, filter_in, with_trashed, only_trashed,
+//    fields, preload, preload_order, total, format, stream.
+//
+// The limit defaults to and is capped by the PageSize of T, see SetPageSize.
+// So are the NDJSON streams, unless WithStream is given, which streams all
+// the records under the filters, ignoring the pagination.
+// The total is counted by the ListTotalStrategy.
+// The associations set by SetDefaultPreloads are preloaded if there is no
+// preload param.
 //
 // Response:
 //  - 200 OK: { Ts: [{...}, ...] }
+//  - 200 OK: { Ts: [{...}, ...], total: 350, pagination: {...} }  // if total=true
+//  - 200 OK: Ts.csv  // if format=csv or Accept: text/csv, streamed row by row
+//  - 200 OK: {...}\n{...}\n...  // if stream=true or Accept: application/x-ndjson, streamed row by row (the page only, without WithStream)
 //  - 400 Bad Request: { error: "request band failed" }
+//  - 400 Bad Request: { error: "invalid fields: \"foo\"" }
+//  - 403 Forbidden: { error: "soft-deleted records forbidden" }  // with_trashed without WithTrashedAccess
 //  - 422 Unprocessable Entity: { error: "get process failed" }
-func GetListHandler[T any]() gin.HandlerFunc {
+func GetListHandler[T any](options ...ListOption) gin.HandlerFunc {
+	var config listConfig
+	for _, option := range options {
+		option.applyList(&config)
+	}
+	name := "GetListHandler"
+	if config.searchFields != nil {
+		name = "SearchHandler"
+	}
+	return listHandler[T](name, config)
+}
+
+// ListOption is an option of GetListHandler: WithStream, WithSearchFields
+// or a ModelOption.
+type ListOption interface {
+	applyList(config *listConfig)
+}
+
+type listOptionFunc func(config *listConfig)
+
+func (f listOptionFunc) applyList(config *listConfig) { f(config) }
+
+type listConfig struct {
+	modelConfig
+
+	stream       bool
+	searchFields []string
+}
+
+// WithStream makes GetListHandler stream all the records (under the
+// filters) for the NDJSON requests (stream=true, format=ndjson or Accept:
+// application/x-ndjson), ignoring the limit and the offset. Without it,
+// the NDJSON responses are paginated like the JSON ones, i.e. capped by the
+// PageSize of T.
+//
+// Only enable it for the models which are fine to be dumped entirely by
+// any client.
+func WithStream() ListOption {
+	return listOptionFunc(func(config *listConfig) {
+		config.stream = true
+	})
+}
+
+// WithSearchFields makes GetListHandler a SearchHandler of the fields.
+func WithSearchFields(fields ...string) ListOption {
+	return listOptionFunc(func(config *listConfig) {
+		config.searchFields = append([]string{}, fields...)
+	})
+}
+
+// SearchHandler handles
+//    GET /T?q=keyword
+// It returns a list of models, like GetListHandler, but only the ones
+// matching the keyword in any of the fields (see service.Search):
+//    WHERE (title LIKE %keyword% OR body LIKE %keyword% ...)
+// The search is combined with the filters and the pagination.
+// Without the q, it is the same as GetListHandler.
+//
+// The fields (field names or columns) must be the string columns of T.
+// They are checked once here: the unknown and the non-string ones are
+// not searched, with an error logged.
+//
+// QueryOptions (See GetRequestOptions for more details):
+//    q, limit, offset, order_by, desc, filter_by, filter_value, filter_in, with_trashed, only_trashed,
+//    fields, preload, preload_order, total, format, stream.
+//
+// Response: see GetListHandler.
+//
+// It is the same as GetListHandler[T](WithSearchFields(fields...)), use
+// that to give other ListOptions.
+func SearchHandler[T any](fields ...string) gin.HandlerFunc {
+	return GetListHandler[T](WithSearchFields(fields...))
+}
+
+// listHandler is the GetListHandler (named name) of the config.
+func listHandler[T any](name string, config listConfig) gin.HandlerFunc {
+	var columns []string
+	if len(config.searchFields) > 0 {
+		var err error
+		columns, err = searchColumns(new(T), config.searchFields)
+		if err != nil {
+			logger.WithField("model", fmt.Sprintf("%T", *new(T))).WithError(err).
+				Error(name + ": invalid search fields. Ignored.")
+		}
+	}
 	return func(c *gin.Context) {
 		var request GetRequestOptions
 		if err := c.ShouldBind(&request); err != nil {
 			logger.WithContext(c).WithError(err).
-				Warn("GetListHandler: bind request failed")
+				Warn(name + ": bind request failed")
 			ResponseError(c, CodeBadRequest, err)
 			return
 		}
+		if len(request.PreloadLimit) > 0 {
+			ResponseError(c, CodeBadRequest, ErrPreloadLimitMultiParent)
+			return
+		}
+		if !checkTrashedAccess(c, request, config.modelConfig, name) {
+			return
+		}
+		ndjson := wantsNDJSON(c, request)
+		if ndjson && config.stream { // streams the full set
+			request.Limit, request.Offset = 0, 0
+		} else {
+			request.Limit = pageSizeOf[T](config.modelConfig).limit(request.Limit)
+		}
+		withDefaultPreloads(&request, new(T), config.modelConfig)
+
+		options, err := buildQueryOptions(request, new(T))
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn(name + ": buildQueryOptions failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		fields, err := parseSparseFields(request.Fields, new(T))
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn(name + ": parseSparseFields failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		if fields != nil {
+			options = append(options, fields.option())
+		}
 
-		options := buildQueryOptions(request)
+		var search []service.QueryOption
+		if request.Search != "" && len(columns) > 0 {
+			option := service.Search(request.Search, columns...)
+			search = append(search, option)
+			options = append(options, option)
+		}
+
+		if ndjson {
+			responseNDJSON[T](c, fields, options)
+			return
+		}
+		if wantsCSV(c, request) {
+			responseCSV[T](c, fields, options)
+			return
+		}
 
 		var dest []*T
-		err := service.GetMany[T](c, &dest, options...)
+		total, counted, err := getPage[T](c, request, &dest, options)
 		if err != nil {
 			logger.WithContext(c).WithError(err).
-				Warn("GetListHandler: GetMany failed")
-			ResponseError(c, CodeProcessFailed, err)
+				Warn(name + ": getPage failed")
+			ResponseErrorAuto(c, CodeProcessFailed, err)
 			return
 		}
 
 		var addition []gin.H
 		if request.Total {
-			total, err := getCount[T](c, request.FilterBy, request.FilterValue)
+			if !counted {
+				total, err = getCount[T](c, request, search...)
+			}
 			if err != nil {
 				logger.WithContext(c).WithError(err).
-					Warn("GetListHandler: getCount failed")
+					Warn(name + ": getCount failed")
 				addition = append(addition, gin.H{"totalError": err.Error()})
 			} else {
-				addition = append(addition, gin.H{"total": total})
+				addition = append(addition, gin.H{
+					"total":      total,
+					"pagination": newPagination(request, total),
+				})
 			}
 		}
-		ResponseSuccess(c, dest, addition...)
+		ResponseSuccess(c, sparseSlice(fields, dest), addition...)
+	}
+}
+
+// TotalStrategy is how the total (of the total=true requests) is counted
+// in GetListHandler and SearchHandler. The response is the same whatever
+// the strategy is.
+type TotalStrategy string
+
+const (
+	// TotalAuto counts by TotalWindow on postgres, and by TotalCount on
+	// other databases (the default).
+	TotalAuto TotalStrategy = "auto"
+	// TotalCount counts by a separate COUNT query after getting the page.
+	TotalCount TotalStrategy = "count"
+	// TotalWindow counts by the window function COUNT(*) OVER() in the
+	// same query of the page, see service.GetManyWithTotal. It falls back
+	// to TotalCount for the requests with preloads (which are not loaded
+	// with the window count), the empty pages, and the databases without
+	// window functions.
+	TotalWindow TotalStrategy = "window"
+)
+
+// listTotalStrategy is set by WithTotalStrategy, nil for TotalAuto.
+var listTotalStrategy atomic.Pointer[TotalStrategy]
+
+// ListTotalStrategy returns the TotalStrategy of the list handlers,
+// TotalAuto by default. Use WithTotalStrategy to change it.
+func ListTotalStrategy() TotalStrategy {
+	if strategy := listTotalStrategy.Load(); strategy != nil {
+		return *strategy
+	}
+	return TotalAuto
+}
+
+// WithTotalStrategy sets the ListTotalStrategy of the list handlers.
+// Unknown strategies are ignored. Call it before serving.
+func WithTotalStrategy(strategy TotalStrategy) {
+	switch strategy {
+	case TotalAuto, TotalCount, TotalWindow:
+		listTotalStrategy.Store(&strategy)
+	default:
+		logger.WithField("strategy", strategy).
+			Warn("WithTotalStrategy: unknown total strategy. Ignored.")
+	}
+}
+
+// windowTotal reports whether the total of the request is counted by
+// TotalWindow, see ListTotalStrategy.
+func windowTotal(request GetRequestOptions) bool {
+	if !request.Total || len(request.Preload) > 0 {
+		return false
+	}
+	switch ListTotalStrategy() {
+	case TotalWindow:
+		return true
+	case TotalAuto:
+		return orm.DialectName() == "postgres"
+	}
+	return false
+}
+
+// getPage gets the page of models into dest. The total is counted in the
+// same query if the request wants it and the ListTotalStrategy allows,
+// in which case counted is true. Otherwise, count it by getCount.
+func getPage[T any](c *gin.Context, request GetRequestOptions, dest *[]*T, options []service.QueryOption) (total int64, counted bool, err error) {
+	if windowTotal(request) {
+		total, err = service.GetManyWithTotal[T](requestContext(c), dest, options...)
+		switch {
+		case err == nil:
+			return total, len(*dest) > 0, nil // empty page: count it separately
+		case !errors.Is(err, service.ErrUnsupportedDialect):
+			return 0, false, err
+		}
+		logger.WithContext(c).WithError(err).
+			Debug("getPage: window total unavailable, fallback to count")
+	}
+	err = service.GetMany[T](requestContext(c), dest, options...)
+	return 0, false, err
+}
+
+// searchColumns resolves the columns of the search fields (field names or
+// columns) of the model. The unknown and the non-string fields are
+// skipped, and reported in the error.
+func searchColumns(model any, fields []string) ([]string, error) {
+	columns := make([]string, 0, len(fields))
+	var errs []error
+	for _, field := range fields {
+		column, f, err := modelField(model, field)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if f.DataType != schema.String {
+			errs = append(errs, fmt.Errorf("%w: %q is not a string column", ErrInvalidSearchField, field))
+			continue
+		}
+		columns = append(columns, column)
 	}
+	return columns, errors.Join(errs...)
 }
 
 // GetByIDHandler handles
 //    GET /T/:idParam
 //
-// QueryOptions (See GetRequestOptions for more details): preload
+// QueryOptions (See GetRequestOptions for more details):
+//    fields, preload, preload_order, preload_limit
+//
+// The associations set by SetDefaultPreloads are preloaded if there is no
+// preload param.
+//
+// The response carries an ETag header, the hash of the model responded.
+// A request with an If-None-Match header of the ETag (e.g. polling)
+// is responded 304 Not Modified without a body if the model is unchanged.
+// And the ETag (of the request without query params) can be sent in the
+// If-Match header of the update requests, see UpdateHandler.
 //
 // Response:
 //  - 200 OK: { T: {...} }
+//  - 304 Not Modified  // if If-None-Match matches the ETag
 //  - 400 Bad Request: { error: "request band failed" }
+//  - 400 Bad Request: { error: "invalid id format" }  // e.g. "abc" for an integer id
+//  - 400 Bad Request: { error: "invalid fields: \"foo\"" }
+//  - 403 Forbidden: { error: "soft-deleted records forbidden" }  // with_trashed without WithTrashedAccess
 //  - 422 Unprocessable Entity: { error: "get process failed" }
-func GetByIDHandler[T orm.Model](idParam string) gin.HandlerFunc {
+func GetByIDHandler[T orm.Model](idParam string, options ...ModelOption) gin.HandlerFunc {
+	var config modelConfig
+	for _, option := range options {
+		option(&config)
+	}
 	return func(c *gin.Context) {
 		var request GetRequestOptions
 		if err := c.ShouldBind(&request); err != nil {
@@ -94,17 +550,38 @@ func GetByIDHandler[T orm.Model](idParam string) gin.HandlerFunc {
 			ResponseError(c, CodeBadRequest, err)
 			return
 		}
+		if !checkTrashedAccess(c, request, config, "GetByIDHandler") {
+			return
+		}
+		withDefaultPreloads(&request, new(T), config)
 
-		options := buildQueryOptions(request)
+		options, err := buildQueryOptions(request, new(T))
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("GetByIDHandler: buildQueryOptions failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		fields, err := parseSparseFields(request.Fields, new(T))
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("GetByIDHandler: parseSparseFields failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		if fields != nil {
+			options = append(options, fields.option())
+		}
 
 		dest, err := getModelByID[T](c, idParam, options...)
 		if err != nil {
 			logger.WithContext(c).WithError(err).
 				Warn("GetByIDHandler: getModelByID failed")
-			ResponseError(c, CodeProcessFailed, err)
+			ResponseErrorAuto(c, CodeProcessFailed, err)
 			return
 		}
-		ResponseSuccess(c, dest)
+
+		responseSuccessETag(c, sparse(fields, dest))
 	}
 }
 
@@ -112,17 +589,30 @@ func GetByIDHandler[T orm.Model](idParam string) gin.HandlerFunc {
 //    GET /T/:idParam/field
 //
 // QueryOptions (See GetRequestOptions for more details):
-//    limit, offset, order_by, desc, filter_by, filter_value, preload, total.
+//    limit, offset, order_by, desc, filter_by, filter_value, filter_in, with_trashed, only_trashed,
+//    preload, preload_order, preload_limit (of a non-slice field only), total.
 // Notice, all GetRequestOptions will be conditions for the field, for example:
 //    GET /user/123/order?preload=Product
-// Preloads User.Order.Product instead of User.Product.
+// Preloads User.Order.Product instead of User.Product. So are the default
+// preloads: the ones of the field model (set by SetDefaultPreloads) apply.
+// Of the options, only WithTrashedAccess is used.
 //
 // Response:
 //  - 200 OK: { Fs: [{...}, ...] }  // field models
+//  - 200 OK: { Fs: [{...}, ...], total: 350, pagination: {...} }  // if total=true
 //  - 400 Bad Request: { error: "request band failed" }
+//  - 403 Forbidden: { error: "soft-deleted records forbidden" }  // with_trashed without WithTrashedAccess
 //  - 422 Unprocessable Entity: { error: "get process failed" }
-func GetFieldHandler[T orm.Model](idParam string, field string) gin.HandlerFunc {
+func GetFieldHandler[T orm.Model](idParam string, field string, options ...ModelOption) gin.HandlerFunc {
+	var config modelConfig
+	for _, option := range options {
+		option(&config)
+	}
 	field = nameToField(field, *new(T))
+	fieldModel := newFieldModel[T](field)
+
+	structField, _ := reflect.TypeOf(*new(T)).FieldByName(field)
+	isSliceField := structField.Type != nil && structField.Type.Kind() == reflect.Slice
 
 	return func(c *gin.Context) {
 		var request GetRequestOptions
@@ -132,13 +622,31 @@ func GetFieldHandler[T orm.Model](idParam string, field string) gin.HandlerFunc
 			ResponseError(c, CodeBadRequest, err)
 			return
 		}
-		options := buildQueryOptions(request)
+		if isSliceField && len(request.PreloadLimit) > 0 {
+			ResponseError(c, CodeBadRequest, ErrPreloadLimitMultiParent)
+			return
+		}
+		if !checkTrashedAccess(c, request, config, "GetFieldHandler") {
+			return
+		}
+		withDefaultPreloads(&request, fieldModel, modelConfig{})
+		options, err := buildQueryOptions(request, fieldModel)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("GetFieldHandler: buildQueryOptions failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
 
-		model, err := getModelByID[T](c, idParam, service.Preload(field, options...))
+		var modelOptions []service.QueryOption
+		if !isSliceField { // slices are queried by GetAssociations below
+			modelOptions = append(modelOptions, service.Preload(field, options...))
+		}
+		model, err := getModelByID[T](c, idParam, modelOptions...)
 		if err != nil {
 			logger.WithContext(c).WithError(err).
 				Warn("GetFieldHandler: getModelByID failed")
-			ResponseError(c, CodeProcessFailed, err)
+			ResponseErrorAuto(c, CodeProcessFailed, err)
 			return
 		}
 
@@ -146,15 +654,31 @@ func GetFieldHandler[T orm.Model](idParam string, field string) gin.HandlerFunc
 			Elem(). // because model is a pointer
 			FieldByName(field)
 
+		if isSliceField {
+			// the page and the total are scoped by the same association query
+			dest := reflect.New(fieldValue.Type())
+			err := service.GetAssociations(requestContext(c), model, field, dest.Interface(), options...)
+			if err != nil {
+				logger.WithContext(c).WithError(err).
+					Warn("GetFieldHandler: GetAssociations failed")
+				ResponseErrorAuto(c, CodeProcessFailed, err)
+				return
+			}
+			fieldValue = dest.Elem()
+		}
+
 		var addition []gin.H
-		if request.Total && fieldValue.Kind() == reflect.Slice {
-			total, err := getAssociationCount(c, model, field, request.FilterBy, request.FilterValue)
+		if request.Total && isSliceField {
+			total, err := getAssociationCount(c, model, field, fieldModel, request)
 			if err != nil {
 				logger.WithContext(c).WithError(err).
 					Warn("GetFieldHandler: getAssociationCount failed")
 				addition = append(addition, gin.H{"totalError": err.Error()})
 			} else {
-				addition = append(addition, gin.H{"total": total})
+				addition = append(addition, gin.H{
+					"total":      total,
+					"pagination": newPagination(request, total),
+				})
 			}
 		}
 
@@ -162,52 +686,299 @@ func GetFieldHandler[T orm.Model](idParam string, field string) gin.HandlerFunc
 	}
 }
 
-func buildQueryOptions(request GetRequestOptions) []service.QueryOption {
+// buildQueryOptions builds the query options of the request for the model.
+// Field names in order_by and filter_by are checked against the columns
+// of the model, unknown fields are rejected.
+func buildQueryOptions(request GetRequestOptions, model any) ([]service.QueryOption, error) {
 	var options []service.QueryOption
 	if request.Limit > 0 {
 		options = append(options, service.WithPage(request.Limit, request.Offset))
 	}
-	if request.OrderBy != "" {
-		options = append(options, service.OrderBy(request.OrderBy, request.Descending))
+	for _, orderBy := range request.OrderBy {
+		field, descending, err := parseOrderBy(orderBy, request.Descending)
+		if err != nil {
+			return nil, err
+		}
+		if field == "" {
+			continue
+		}
+		column, err := modelColumn(model, field)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidOrderBy, err)
+		}
+		options = append(options, service.OrderBy(column, descending))
+	}
+	filters, err := buildFilterOptions(request, model)
+	if err != nil {
+		return nil, err
 	}
-	if request.FilterBy != "" && request.FilterValue != "" {
-		options = append(options, service.FilterBy(request.FilterBy, request.FilterValue))
+	options = append(options, filters...)
+	preloads, err := buildPreloadOptions(request, model)
+	if err != nil {
+		return nil, err
 	}
+	options = append(options, preloads...)
+	return options, nil
+}
+
+// buildPreloadOptions builds the preload options of the request, with the
+// preload_order and preload_limit as the conditions of the preloading.
+func buildPreloadOptions(request GetRequestOptions, model any) ([]service.QueryOption, error) {
+	conditions := map[string][]service.QueryOption{}
+
+	for _, preloadOrder := range request.PreloadOrder {
+		association, orderBy, _ := strings.Cut(preloadOrder, ":")
+		if f := strings.Fields(orderBy); len(f) == 2 { // "field desc"
+			orderBy = f[0] + ":" + f[1]
+		}
+		field, descending, err := parseOrderBy(orderBy, false)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidPreload, err)
+		}
+		column, err := modelColumn(newFieldModelOf(model, association), field)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrInvalidPreload, association, err)
+		}
+		conditions[association] = append(conditions[association], service.OrderBy(column, descending))
+	}
+
+	for _, preloadLimit := range request.PreloadLimit {
+		association, limit, _ := strings.Cut(preloadLimit, ":")
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%w: invalid limit %q", ErrInvalidPreload, preloadLimit)
+		}
+		conditions[association] = append(conditions[association], service.WithPage(n, 0))
+	}
+
+	var options []service.QueryOption
 	for _, field := range request.Preload {
+		if field == "" { // preload= disables the default preloads
+			continue
+		}
+		if err := checkAssociation(model, field); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidPreload, err)
+		}
 		// logger.WithField("field", field).Debug("Preload field")
-		options = append(options, service.Preload(field))
+		options = append(options, service.Preload(field, conditions[field]...))
+		delete(conditions, field)
+	}
+	for association := range conditions {
+		return nil, fmt.Errorf("%w: %q is not preloaded", ErrInvalidPreload, association)
+	}
+	return options, nil
+}
+
+// ErrPreloadLimitMultiParent rejects the preload_limit of the requests of
+// multiple parents, see GetRequestOptions.
+var ErrPreloadLimitMultiParent = fmt.Errorf("%w: preload_limit is only supported for a single model", ErrInvalidPreload)
+
+// identifierRegexp matches a column name, optionally qualified by a table name.
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// parseOrderBy parses an order_by entry "field[:asc|:desc]".
+// defaultDescending is used if the direction suffix is absent.
+func parseOrderBy(orderBy string, defaultDescending bool) (field string, descending bool, err error) {
+	field, direction, found := strings.Cut(orderBy, ":")
+	descending = defaultDescending
+	if found {
+		switch strings.ToLower(direction) {
+		case "asc":
+			descending = false
+		case "desc":
+			descending = true
+		default:
+			return "", false, fmt.Errorf("%w: unknown direction %q", ErrInvalidOrderBy, direction)
+		}
+	}
+	if field != "" && !identifierRegexp.MatchString(field) {
+		return "", false, fmt.Errorf("%w: invalid field %q", ErrInvalidOrderBy, field)
+	}
+	return field, descending, nil
+}
+
+// trashedAllowedKey is the key in the gin.Context set by AllowTrashed.
+const trashedAllowedKey = "crud/controller.trashedAllowed"
+
+// AllowTrashed allows the request to read the soft-deleted records
+// (with_trashed and only_trashed), which are denied by default (see
+// WithTrashedAccess). It is for the authorization middlewares before the
+// get handlers: router.WithAuthorizer calls it when the Authorizer allows
+// the ActionReadTrashed.
+func AllowTrashed(c *gin.Context) {
+	c.Set(trashedAllowedKey, true)
+}
+
+// checkTrashedAccess responds 403 Forbidden and returns false if the
+// request asks for the soft-deleted records without the access, see
+// WithTrashedAccess and AllowTrashed.
+func checkTrashedAccess(c *gin.Context, request GetRequestOptions, config modelConfig, name string) bool {
+	if !request.WithTrashed && !request.OnlyTrashed {
+		return true
+	}
+	if config.trashed || c.GetBool(trashedAllowedKey) {
+		return true
+	}
+	logger.WithContext(c).
+		Warn(name + ": soft-deleted records not allowed")
+	ResponseError(c, CodeForbidden, ErrTrashedForbidden)
+	return false
+}
+
+// buildFilterOptions builds the WHERE conditions of the request.
+// It is shared by the queries and the counts, so that the total
+// is counted under the same filter.
+func buildFilterOptions(request GetRequestOptions, model any) ([]service.QueryOption, error) {
+	var options []service.QueryOption
+	if request.OnlyTrashed {
+		column, err := softDeleteColumn(model)
+		if err != nil {
+			return nil, fmt.Errorf("%w: only_trashed: not soft-deletable: %w", ErrInvalidFilterBy, err)
+		}
+		options = append(options, service.WithTrashed(), service.IsNotNull(column))
+	} else if request.WithTrashed {
+		options = append(options, service.WithTrashed())
+	}
+	for i, field := range request.FilterBy {
+		if field == "" {
+			continue
+		}
+		column, schemaField, err := modelField(model, field)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidFilterBy, err)
+		}
+		op := indexOrEmpty(request.FilterOp, i)
+		if op != "" && !service.IsFilterOperator(op) {
+			return nil, fmt.Errorf("%w: %w: %q", ErrInvalidFilterBy, service.ErrInvalidOperator, op)
+		}
+		in := indexOrEmpty(request.FilterIn, i)
+		// an empty filter_value is the zero value, unless it is the
+		// placeholder of a filter_in.
+		if i < len(request.FilterValue) && (request.FilterValue[i] != "" || in == "") {
+			value, err := parseFieldValue(schemaField, request.FilterValue[i])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidFilterBy, err)
+			}
+			if op == "" || op == "eq" {
+				options = append(options, service.FilterBy(column, value))
+			} else {
+				options = append(options, service.FilterByOp(column, op, value))
+			}
+		}
+		if in != "" {
+			var values []any
+			for _, v := range splitFilterIn(in) {
+				value, err := parseFieldValue(schemaField, v)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %w", ErrInvalidFilterBy, err)
+				}
+				values = append(values, value)
+			}
+			options = append(options, service.FilterIn(column, values...))
+		}
 	}
-	return options
+	for i, field := range request.BetweenField {
+		if field == "" {
+			continue
+		}
+		column, schemaField, err := modelField(model, field)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidBetween, err)
+		}
+		var low, high any // nil for open-ended
+		if v := indexOrEmpty(request.BetweenLow, i); v != "" {
+			if low, err = parseFieldValue(schemaField, v); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidBetween, err)
+			}
+		}
+		if v := indexOrEmpty(request.BetweenHigh, i); v != "" {
+			if high, err = parseFieldValue(schemaField, v); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidBetween, err)
+			}
+		}
+		options = append(options, service.Between(column, low, high))
+	}
+	for _, field := range request.Null {
+		column, err := modelColumn(model, field)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidNull, err)
+		}
+		options = append(options, service.IsNull(column))
+	}
+	for _, field := range request.NotNull {
+		column, err := modelColumn(model, field)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidNull, err)
+		}
+		options = append(options, service.IsNotNull(column))
+	}
+	for _, name := range request.Scope {
+		scope, ok := service.ScopeOf(model, name)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, name)
+		}
+		options = append(options, scope)
+	}
+	return options, nil
+}
+
+// indexOrEmpty returns s[i] or "" if i is out of range.
+func indexOrEmpty(s []string, i int) string {
+	if i < len(s) {
+		return s[i]
+	}
+	return ""
 }
 
-// getModelByID gets idParam from url and get model from database
+// splitFilterIn splits the comma separated filter_in values.
+// A comma can be escaped by a backslash: `a\,b,c` => ["a,b", "c"].
+func splitFilterIn(s string) []string {
+	var values []string
+	var value strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == ',':
+			value.WriteByte(',')
+			i++
+		case s[i] == ',':
+			values = append(values, value.String())
+			value.Reset()
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+	return append(values, value.String())
+}
+
+// getModelByID gets idParam from url (see paramID) and get model from database
 func getModelByID[T orm.Model](c *gin.Context, idParam string, options ...service.QueryOption) (*T, error) {
 	var model T
 
-	id := c.Param(idParam)
-	if id == "" {
-		logger.WithContext(c).WithField("idParam", idParam).
-			Warn("getModelByID: id is empty")
-		return &model, ErrMissingID
+	id, err := paramID[T](c, idParam)
+	if err != nil {
+		logger.WithContext(c).WithField("idParam", idParam).WithError(err).
+			Warn("getModelByID: read id param failed")
+		return &model, err
 	}
 
-	err := service.GetByID[T](c, id, &model, options...)
+	err = service.GetByID[T](requestContext(c), id, &model, options...)
 	return &model, err
 }
 
-func getCount[T any](ctx context.Context, filterBy string, filterValue any) (total int64, err error) {
-	var option []service.QueryOption
-	if filterBy != "" && filterValue != "" {
-		option = append(option, service.FilterBy(filterBy, filterValue))
+func getCount[T any](ctx context.Context, request GetRequestOptions, extra ...service.QueryOption) (total int64, err error) {
+	options, err := buildFilterOptions(request, new(T))
+	if err != nil {
+		return 0, err
 	}
-	total, err = service.Count[T](ctx, option...)
+	options = append(options, extra...)
+	total, err = service.Count[T](ctx, options...)
 	return total, err
 }
 
-func getAssociationCount(ctx context.Context, model any, field string, filterBy string, filterValue any) (total int64, err error) {
-	var options []service.QueryOption
-	if filterBy != "" && filterValue != "" {
-		options = append(options, service.FilterBy(filterBy, filterValue))
+func getAssociationCount(ctx context.Context, model any, field string, fieldModel any, request GetRequestOptions) (total int64, err error) {
+	options, err := buildFilterOptions(request, fieldModel)
+	if err != nil {
+		return 0, err
 	}
 	count, err := service.CountAssociations(ctx, model, field, options...)
 	return count, err
@@ -1,10 +1,53 @@
 package controller
 
 import (
+	"context"
+	"encoding"
+	"fmt"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"maps"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// requestContext returns the context for the service calls of the request
+// c: the context of c.Request, carrying a copy of the keys set on c (by
+// c.Set, e.g. the "request_id", or a "tenant" read by the scopes).
+//
+// c itself is not passed to the service calls: gin recycles it for the
+// later requests once the handler returns, while it may still be read by
+// the database driver (database/sql watches the Done channel of the query
+// context in a goroutine) or by the Async event handlers.
+func requestContext(c *gin.Context) context.Context {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+	return keysContext{Context: ctx, keys: maps.Clone(c.Keys)}
+}
+
+// keysContext looks up the string keys in keys before the parent
+// Context, as gin.Context does.
+type keysContext struct {
+	context.Context
+	keys map[string]any
+}
+
+func (ctx keysContext) Value(key any) any {
+	if k, ok := key.(string); ok {
+		if value, ok := ctx.keys[k]; ok {
+			return value
+		}
+	}
+	return ctx.Context.Value(key)
+}
+
 // nameToField converts name to the right field name in the structure.
 // For example:
 //    type User struct {
@@ -38,3 +81,308 @@ func nameToField(name string, structure any) string {
 
 	return name
 }
+
+// modelColumn converts field (a field name or a column name of the model)
+// to the database column name. It is used to whitelist the field names from
+// clients before putting them into SQL.
+//
+// A field qualified by the table name of the model (like "users.name") is
+// accepted as well, and the result is qualified too.
+func modelColumn(model any, field string) (string, error) {
+	column, _, err := modelField(model, field)
+	return column, err
+}
+
+// softDeleteColumn returns the column of the soft delete field (a
+// gorm.DeletedAt, whatever its name) of the model.
+func softDeleteColumn(model any) (string, error) {
+	s, err := orm.ParseSchema(model)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range s.Fields {
+		if f.DBName != "" && f.FieldType == reflect.TypeOf(gorm.DeletedAt{}) {
+			return f.DBName, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no gorm.DeletedAt in %s", ErrUnknownField, s.Name)
+}
+
+// modelField is modelColumn with the schema field of the column.
+func modelField(model any, field string) (string, *schema.Field, error) {
+	s, err := orm.ParseSchema(model)
+	if err != nil {
+		return "", nil, err
+	}
+
+	table, name, qualified := strings.Cut(field, ".")
+	if !qualified {
+		name = table
+	} else if table != s.Table {
+		return "", nil, fmt.Errorf("%w: %q", ErrUnknownField, field)
+	}
+
+	f := s.LookUpField(name)
+	if f == nil || f.DBName == "" {
+		return "", nil, fmt.Errorf("%w: %q", ErrUnknownField, field)
+	}
+	if qualified {
+		return s.Table + "." + f.DBName, f, nil
+	}
+	return f.DBName, f, nil
+}
+
+// parseFieldValue converts the value from the query string to the Go type
+// of the field: booleans, integers, floats and times, so that they are
+// compared to the column as such (e.g. "false" to a boolean column, "30"
+// to an integer column), whichever the dialect. An empty value is the zero
+// value of the type. Values of other types are returned as is.
+//
+// The times are in RFC 3339 ("2024-01-02T15:04:05Z"), or without the zone
+// ("2024-01-02T15:04:05", "2024-01-02 15:04:05", "2024-01-02") in UTC.
+func parseFieldValue(field *schema.Field, value string) (any, error) {
+	if field == nil {
+		return value, nil
+	}
+	fieldType := field.FieldType
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+
+	var parsed any
+	var err error
+	switch {
+	case fieldType == timeType:
+		if value == "" {
+			return time.Time{}, nil
+		}
+		parsed, err = parseTime(value)
+	case fieldType.Kind() == reflect.Bool:
+		if value == "" {
+			return false, nil
+		}
+		parsed, err = strconv.ParseBool(value)
+	case fieldType.Kind() >= reflect.Int && fieldType.Kind() <= reflect.Int64:
+		if value == "" {
+			return 0, nil
+		}
+		parsed, err = strconv.ParseInt(value, 10, 64)
+	case fieldType.Kind() >= reflect.Uint && fieldType.Kind() <= reflect.Uint64:
+		if value == "" {
+			return 0, nil
+		}
+		parsed, err = strconv.ParseUint(value, 10, 64)
+	case fieldType.Kind() == reflect.Float32 || fieldType.Kind() == reflect.Float64:
+		if value == "" {
+			return 0.0, nil
+		}
+		parsed, err = strconv.ParseFloat(value, 64)
+	default:
+		return value, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q is not a %v of %v", ErrInvalidFieldValue, value, fieldType, field.Name)
+	}
+	return parsed, nil
+}
+
+// timeLayouts are the layouts accepted by parseTime, in order.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseTime parses s in one of the timeLayouts.
+func parseTime(s string) (t time.Time, err error) {
+	for _, layout := range timeLayouts {
+		if t, err = time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return t, err
+}
+
+// newFieldModel returns a pointer to a new value of the element type of
+// the field of struct T: []*F, []F, *F or F => *F
+func newFieldModel[T any](field string) any {
+	return newFieldModelOf(*new(T), field)
+}
+
+// newFieldModelOf is the non-generic version of newFieldModel, and the field
+// can be a nested path like "Orders.Product". It returns nil if the field
+// is not found.
+func newFieldModelOf(structure any, field string) any {
+	fieldType := reflect.TypeOf(structure)
+	for _, name := range strings.Split(field, ".") {
+		for fieldType.Kind() == reflect.Ptr || fieldType.Kind() == reflect.Slice || fieldType.Kind() == reflect.Array {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() != reflect.Struct {
+			return nil
+		}
+		structField, ok := fieldType.FieldByName(name)
+		if !ok {
+			return nil
+		}
+		fieldType = structField.Type
+	}
+	for fieldType.Kind() == reflect.Ptr || fieldType.Kind() == reflect.Slice || fieldType.Kind() == reflect.Array {
+		fieldType = fieldType.Elem()
+	}
+	return reflect.New(fieldType).Interface()
+}
+
+// checkAssociation checks the association path (like "Orders.Items") of the
+// model segment by segment against the relationships in its gorm schema.
+// An ErrUnknownAssociation is returned for the first unknown segment, listing
+// the valid ones in its place.
+func checkAssociation(model any, path string) error {
+	s, err := orm.ParseSchema(model)
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Split(path, ".") {
+		relationship, ok := s.Relationships.Relations[name]
+		if !ok && len(s.Relationships.Relations) == 0 {
+			return fmt.Errorf("%w: %s (%s has no associations)", ErrUnknownAssociation, name, s.Name)
+		}
+		if !ok {
+			valid := make([]string, 0, len(s.Relationships.Relations))
+			for relation := range s.Relationships.Relations {
+				valid = append(valid, relation)
+			}
+			sort.Strings(valid)
+			return fmt.Errorf("%w: %s (valid: %s)", ErrUnknownAssociation, name, strings.Join(valid, ", "))
+		}
+		s = relationship.FieldSchema
+	}
+	return nil
+}
+
+// valuesToColumns converts the keys of values (field names, column names or
+// json names of the fields) to the database column names of the model.
+// Unknown keys are rejected.
+func valuesToColumns(model any, values map[string]any) (map[string]any, error) {
+	columns := make(map[string]any, len(values))
+	for key, value := range values {
+		column, err := modelColumn(model, key)
+		if err != nil {
+			field, ok := jsonNameToField(model, key)
+			if !ok {
+				return nil, err
+			}
+			if column, err = modelColumn(model, field); err != nil {
+				return nil, err
+			}
+		}
+		columns[column] = value
+	}
+	return columns, nil
+}
+
+// jsonNameToField finds the name of the field in the structure whose
+// json name (given by the json tag) is jsonName.
+func jsonNameToField(structure any, jsonName string) (string, bool) {
+	reflectType := reflect.TypeOf(structure)
+	if reflectType.Kind() == reflect.Ptr {
+		reflectType = reflectType.Elem()
+	}
+	if reflectType.Kind() != reflect.Struct {
+		return "", false
+	}
+
+	for _, field := range reflect.VisibleFields(reflectType) {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if field.IsExported() && name == jsonName {
+			return field.Name, true
+		}
+	}
+	return "", false
+}
+
+// fieldToJSONName gets the json name (given by the json tag) of the field
+// in the structure. The field name is returned as it is if there is no
+// json name for it.
+func fieldToJSONName(structure any, field string) string {
+	reflectType := reflect.TypeOf(structure)
+	if reflectType.Kind() == reflect.Ptr {
+		reflectType = reflectType.Elem()
+	}
+	if reflectType.Kind() != reflect.Struct {
+		return field
+	}
+
+	structField, ok := reflectType.FieldByName(field)
+	if !ok {
+		return field
+	}
+	name, _, _ := strings.Cut(structField.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field
+	}
+	return name
+}
+
+// paramID reads the id of model T from the route param idParam, parsed by
+// parseID. It returns ErrMissingID if the param is empty, or ErrInvalidID
+// if it can not be parsed.
+//
+// The leading slash of a wildcard param (e.g. "/org/team" of *idParam)
+// is stripped, so that ids with slashes can be used.
+func paramID[T orm.Model](c *gin.Context, idParam string) (any, error) {
+	raw := strings.TrimPrefix(c.Param(idParam), "/")
+	if raw == "" {
+		return nil, ErrMissingID
+	}
+	return parseID[T](raw)
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// parseID parses the raw id into the Go type of the identity field of model
+// T (see orm.Model): integers, unsigned integers, strings, and the types
+// implementing encoding.TextUnmarshaler (e.g. uuid.UUID). So that a
+// malformed id (like "abc" for an integer id) is rejected with
+// ErrInvalidID before querying the database.
+// The raw id is returned as it is for other types.
+func parseID[T orm.Model](raw string) (any, error) {
+	idField, _ := orm.IdentityColumn(*new(T))
+	modelType := reflect.TypeOf(*new(T))
+	if modelType.Kind() == reflect.Ptr {
+		modelType = modelType.Elem()
+	}
+	if modelType.Kind() != reflect.Struct {
+		return raw, nil
+	}
+	field, ok := modelType.FieldByName(idField)
+	if !ok {
+		return raw, nil
+	}
+
+	invalid := fmt.Errorf("%w: %q", ErrInvalidID, raw)
+	t := field.Type
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		id := reflect.New(t)
+		if err := id.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(raw)); err != nil {
+			return nil, invalid
+		}
+		return id.Elem().Interface(), nil
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(raw, 10, t.Bits())
+		if err != nil {
+			return nil, invalid
+		}
+		return reflect.ValueOf(v).Convert(t).Interface(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(raw, 10, t.Bits())
+		if err != nil {
+			return nil, invalid
+		}
+		return reflect.ValueOf(v).Convert(t).Interface(), nil
+	}
+	return raw, nil
+}
@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"reflect"
+	"sync"
+)
+
+// Hook is a lifecycle hook invoked by the handlers around creating,
+// updating and deleting a model T.
+//
+// A Before hook aborts the request if it returns an error: the client gets
+// a 403 Forbidden if the error is (or wraps) ErrForbidden, otherwise a 400
+// Bad Request. An After hook is invoked after the database operation
+// succeeded, an error returned from it is responded as 422 Unprocessable
+// Entity (but the operation is NOT rolled back).
+type Hook[T any] func(c *gin.Context, model *T) error
+
+// Hooks are the lifecycle hooks of a model T.
+type Hooks[T any] struct {
+	BeforeCreate []Hook[T]
+	AfterCreate  []Hook[T]
+	BeforeUpdate []Hook[T]
+	AfterUpdate  []Hook[T]
+	BeforeDelete []Hook[T]
+	AfterDelete  []Hook[T]
+}
+
+// HookOption adds hooks to the Hooks of model T.
+type HookOption[T any] func(hooks *Hooks[T])
+
+// WithBeforeCreate adds a hook invoked by CreateHandler before creating the model.
+func WithBeforeCreate[T any](hook Hook[T]) HookOption[T] {
+	return func(hooks *Hooks[T]) {
+		hooks.BeforeCreate = append(hooks.BeforeCreate, hook)
+	}
+}
+
+// WithAfterCreate adds a hook invoked by CreateHandler after the model is created.
+func WithAfterCreate[T any](hook Hook[T]) HookOption[T] {
+	return func(hooks *Hooks[T]) {
+		hooks.AfterCreate = append(hooks.AfterCreate, hook)
+	}
+}
+
+// WithBeforeUpdate adds a hook invoked by UpdateHandler and PatchHandler
+// before updating the model. UpdateHandler passes the model with the updates
+// applied, while PatchHandler passes the model currently in the database.
+func WithBeforeUpdate[T any](hook Hook[T]) HookOption[T] {
+	return func(hooks *Hooks[T]) {
+		hooks.BeforeUpdate = append(hooks.BeforeUpdate, hook)
+	}
+}
+
+// WithAfterUpdate adds a hook invoked by UpdateHandler and PatchHandler
+// after the model is updated.
+func WithAfterUpdate[T any](hook Hook[T]) HookOption[T] {
+	return func(hooks *Hooks[T]) {
+		hooks.AfterUpdate = append(hooks.AfterUpdate, hook)
+	}
+}
+
+// WithBeforeDelete adds a hook invoked by DeleteHandler before deleting the model.
+func WithBeforeDelete[T any](hook Hook[T]) HookOption[T] {
+	return func(hooks *Hooks[T]) {
+		hooks.BeforeDelete = append(hooks.BeforeDelete, hook)
+	}
+}
+
+// WithAfterDelete adds a hook invoked by DeleteHandler after the model is deleted.
+func WithAfterDelete[T any](hook Hook[T]) HookOption[T] {
+	return func(hooks *Hooks[T]) {
+		hooks.AfterDelete = append(hooks.AfterDelete, hook)
+	}
+}
+
+// hooksRegistry: reflect.Type of T => *Hooks[T]
+var hooksRegistry sync.Map
+
+// hooksMu serializes RegisterHooks
+var hooksMu sync.Mutex
+
+// RegisterHooks registers the lifecycle hooks for model T.
+// It can be called multiple times, hooks are invoked in the registered order.
+//
+// Notice: hooks are registered per model type (not per route), use
+// router.WithHooks to register them when building the router.
+func RegisterHooks[T any](options ...HookOption[T]) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+
+	hooks := *getHooks[T]() // copy on write: handlers may be reading it
+	for _, option := range options {
+		option(&hooks)
+	}
+	hooksRegistry.Store(reflect.TypeOf((*T)(nil)), &hooks)
+}
+
+// getHooks returns the registered Hooks of model T.
+func getHooks[T any]() *Hooks[T] {
+	if hooks, ok := hooksRegistry.Load(reflect.TypeOf((*T)(nil))); ok {
+		return hooks.(*Hooks[T])
+	}
+	return &Hooks[T]{}
+}
+
+// runBeforeHooks runs the hooks and responds the error if any.
+// It returns false if the request is aborted.
+func runBeforeHooks[T any](c *gin.Context, hooks []Hook[T], model *T) bool {
+	for _, hook := range hooks {
+		if err := hook(c, model); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("runBeforeHooks: aborted by hook")
+			code := CodeBadRequest
+			if errors.Is(err, ErrForbidden) {
+				code = CodeForbidden
+			}
+			ResponseError(c, code, err)
+			return false
+		}
+	}
+	return true
+}
+
+// runAfterHooks runs the hooks and responds the error if any.
+// It returns false if the request is aborted.
+func runAfterHooks[T any](c *gin.Context, hooks []Hook[T], model *T) bool {
+	for _, hook := range hooks {
+		if err := hook(c, model); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("runAfterHooks: aborted by hook")
+			ResponseError(c, CodeProcessFailed, fmt.Errorf("%w: %w", ErrHookFailed, err))
+			return false
+		}
+	}
+	return true
+}
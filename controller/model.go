@@ -0,0 +1,75 @@
+package controller
+
+// ModelOption is an option of the handlers of a model, setting for the
+// routes of the handlers what SetPageSize, SetDefaultPreloads and
+// AllowClientID set for the model type: the options given here override
+// the per-type ones.
+//
+// It is accepted by all the handlers of the model, and ignored by the ones
+// not using it (e.g. WithPageSize by CreateHandler), so that the same
+// options can be given to all of them, like router.Crud does:
+//
+//    preload := WithDefaultPreloads("Profile")
+//    r.GET("/users/:id", GetByIDHandler[User]("id", preload))
+//    r.PUT("/users/:id", UpdateHandler[User]("id", preload))
+type ModelOption func(config *modelConfig)
+
+type modelConfig struct {
+	pageSize *PageSize
+	preloads *[]string
+	clientID *bool
+	trashed  bool
+}
+
+func (o ModelOption) applyList(config *listConfig)             { o(&config.modelConfig) }
+func (o ModelOption) applyCreate(config *createConfig)         { o(&config.modelConfig) }
+func (o ModelOption) applyBulkCreate(config *bulkCreateConfig) { o(&config.modelConfig) }
+func (o ModelOption) applyUpdate(config *updateConfig)         { o(&config.modelConfig) }
+
+// WithPageSize sets the PageSize of the list handlers (GetListHandler and
+// SearchHandler) of the route, see SetPageSize.
+func WithPageSize(defaultLimit, maxLimit int) ModelOption {
+	return func(config *modelConfig) {
+		config.pageSize = &PageSize{DefaultLimit: defaultLimit, MaxLimit: maxLimit}
+	}
+}
+
+// WithDefaultPreloads sets the default preloads of the get handlers
+// (GetListHandler, GetByIDHandler) of the route, see SetDefaultPreloads.
+// Give it to UpdateHandler and PatchHandler as well, which check the
+// If-Match header against the ETag of GetByIDHandler.
+func WithDefaultPreloads(fields ...string) ModelOption {
+	return func(config *modelConfig) {
+		preloads := append([]string{}, fields...)
+		config.preloads = &preloads
+	}
+}
+
+// WithClientID sets whether the clients can choose the primary key of the
+// models they create by the create handlers (CreateHandler and
+// BulkCreateHandler) of the route, see AllowClientID.
+func WithClientID(allowed bool) ModelOption {
+	return func(config *modelConfig) {
+		config.clientID = &allowed
+	}
+}
+
+// WithTrashedAccess allows the clients to read the soft-deleted records
+// (with_trashed and only_trashed, see GetRequestOptions) by the get
+// handlers (GetListHandler, GetByIDHandler and GetFieldHandler) of the
+// route. They are denied with 403 Forbidden by default, unless an
+// authorization middleware allowed them for the request, see AllowTrashed.
+func WithTrashedAccess() ModelOption {
+	return func(config *modelConfig) {
+		config.trashed = true
+	}
+}
+
+// pageSizeOf returns the PageSize of model T of the config, or the one set
+// by SetPageSize.
+func pageSizeOf[T any](config modelConfig) PageSize {
+	if config.pageSize != nil {
+		return *config.pageSize
+	}
+	return getPageSize[T]()
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"github.com/cdfmlr/crud/service"
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+// ndjsonFlushRows is the number of rows written between two flushes
+// of the response writer when streaming NDJSON.
+const ndjsonFlushRows = 100
+
+// wantsNDJSON reports whether the client asks for a streaming NDJSON
+// response, by the stream=true or format=ndjson query param or the
+// Accept: application/x-ndjson header.
+func wantsNDJSON(c *gin.Context, request GetRequestOptions) bool {
+	if request.Stream {
+		return true
+	}
+	if request.Format != "" {
+		return strings.EqualFold(request.Format, "ndjson")
+	}
+	return strings.Contains(c.GetHeader("Accept"), "application/x-ndjson")
+}
+
+// responseNDJSON streams the models T queried with the options to client
+// in NDJSON (newline delimited JSON): one JSON object per model per line.
+// The models are read row by row (see service.Stream), so that the memory
+// use does not grow with the number of rows. Only the fields are responded
+// if not nil.
+func responseNDJSON[T any](c *gin.Context, fields *sparseFields, options []service.QueryOption) {
+	started := false
+	count := 0
+	err := service.Stream[T](requestContext(c), func(model *T) error {
+		if !started {
+			started = true
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Status(CodeSuccess)
+		}
+		data, err := marshalJSON(sparse(fields, model))
+		if err != nil {
+			return err
+		}
+		if _, err := c.Writer.Write(append(data, '\n')); err != nil {
+			return err
+		}
+
+		count++
+		if count%ndjsonFlushRows == 0 {
+			c.Writer.Flush()
+		}
+		return nil
+	}, options...)
+
+	if err != nil && !started {
+		logger.WithContext(c).WithError(err).
+			Warn("responseNDJSON: Stream failed")
+		ResponseErrorAuto(c, CodeProcessFailed, err)
+		return
+	}
+	if err != nil { // the response is partially sent, nothing can be done
+		logger.WithContext(c).WithError(err).
+			Error("responseNDJSON: Stream interrupted")
+		return
+	}
+
+	if !started { // empty result set: no lines
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(CodeSuccess)
+	}
+	c.Writer.Flush()
+}
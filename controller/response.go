@@ -1,11 +1,21 @@
 package controller
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/service"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
 	"net/http"
+	"net/url"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // ErrorResponseBody builds the error response body:
@@ -46,15 +56,15 @@ func getResponseModelName(model any) string {
 	// and if model is a pointer or slice, try to get the element type.
 	switch reflectType.Kind() {
 	case reflect.Struct:
-		return reflectType.Name()
+		return ResponseKey(reflectType, false)
 	case reflect.Ptr:
 		return getResponseModelName(reflectValue.Elem().Interface())
 	case reflect.Slice, reflect.Array:
 		if reflectType.Elem().Kind() == reflect.Struct {
-			return reflectType.Elem().Name() + "s"
+			return ResponseKey(reflectType.Elem(), true)
 		}
 		if reflectType.Elem().Kind() == reflect.Ptr && reflectType.Elem().Elem().Kind() == reflect.Struct {
-			return reflectType.Elem().Elem().Name() + "s"
+			return ResponseKey(reflectType.Elem().Elem(), true)
 		}
 		if reflectValue.Len() > 0 {
 			return getResponseModelName(reflectValue.Index(0).Interface()) + "s"
@@ -67,26 +77,328 @@ func getResponseModelName(model any) string {
 	}
 }
 
-// ResponseError writes an error response to client in JSON.
+// ResponseNamer can be implemented by models to name themselves
+// in the success response bodies (see SuccessResponseBody):
+//    { singular: {...} }  or  { plural: [{...}, ...] }
+//
+// It is the orm.ResourceNamer with an irregular plural: the singular
+// names the model in the public API as the ResourceName does (see
+// ResourceNames).
+type ResponseNamer interface {
+	ResponseName() (singular, plural string)
+}
+
+var (
+	responseNamerType = reflect.TypeOf((*ResponseNamer)(nil)).Elem()
+	resourceNamerType = reflect.TypeOf((*orm.ResourceNamer)(nil)).Elem()
+)
+
+// responseKeys: reflect.Type of T => [2]string{singular, plural}
+var responseKeys sync.Map
+
+// RegisterResponseKey sets the keys of model T in the success response
+// bodies, instead of the type name ("T" and "Ts" by default), for example:
+//    RegisterResponseKey[Category]("category", "categories")
+// makes the responses { category: {...} } and { categories: [...] }.
+//
+// It takes precedence over the ResponseNamer implemented by T.
+// Use router.WithResponseKey to register it when building the router.
+func RegisterResponseKey[T any](singular, plural string) {
+	responseKeys.Store(reflect.TypeOf((*T)(nil)).Elem(), [2]string{singular, plural})
+}
+
+// ResponseKey returns the key of the model of type t in the success
+// response bodies, which is the keys registered by RegisterResponseKey,
+// or the ResourceNames of the model.
+func ResponseKey(t reflect.Type, plural bool) string {
+	pick := func(singular, pluralKey string) string {
+		if plural {
+			return pluralKey
+		}
+		return singular
+	}
+	if keys, ok := responseKeys.Load(t); ok {
+		return pick(keys.([2]string)[0], keys.([2]string)[1])
+	}
+	return pick(ResourceNames(t))
+}
+
+// ResourceNames returns the names of the model of type t in the public
+// API (the response keys, the route names of the router, ...), which are
+// (in order of precedence):
+//  - the names returned by the ResponseNamer implemented by the model,
+//  - the name returned by the orm.ResourceNamer implemented by the model,
+//    with an "s" appended for the plural,
+//  - the type name, with an "s" appended for the plural.
+func ResourceNames(t reflect.Type) (singular, plural string) {
+	if reflect.PointerTo(t).Implements(responseNamerType) {
+		return reflect.New(t).Interface().(ResponseNamer).ResponseName()
+	}
+	if reflect.PointerTo(t).Implements(resourceNamerType) {
+		name := reflect.New(t).Interface().(orm.ResourceNamer).ResourceName()
+		return name, name + "s"
+	}
+	return t.Name(), t.Name() + "s"
+}
+
+// APIErrorResponseBody builds the structured error response body:
+//    { error: { code: "NOT_FOUND", message: "error message", details: {...} } }
+// See APIError for more details.
+func APIErrorResponseBody(code int, err error) gin.H {
+	return gin.H{
+		"error": NewAPIError(code, err),
+	}
+}
+
+// ResponseError writes an error response to client in JSON (or XML, see Render).
+// The body is built by APIErrorResponseBody if APIErrorEnabled,
+// otherwise by ErrorResponseBody.
 func ResponseError(c *gin.Context, code int, err error) {
-	c.JSON(code, ErrorResponseBody(err))
+	if APIErrorEnabled() {
+		Render(c, code, APIErrorResponseBody(code, err))
+		return
+	}
+	Render(c, code, ErrorResponseBody(err))
 }
 
-// ResponseSuccess writes a success response to client in JSON.
+// ResponseErrorAuto is ResponseError with the code derived from err:
+// errors of the expired request context (see router.WithTimeout) are
+// responded as 504 Gateway Timeout (deadline exceeded) or 503 Service
+// Unavailable (canceled), and ErrInvalidID as 400 Bad Request.
+// Other errors are responded with the code.
+//
+// The handlers use it to respond the errors of the service calls.
+func ResponseErrorAuto(c *gin.Context, code int, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidID):
+		code = CodeBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		code = CodeTimeout
+	case errors.Is(err, context.Canceled):
+		code = CodeUnavailable
+	}
+	ResponseError(c, code, err)
+}
+
+// ResponseSuccess writes a success response to client in JSON
+// (or XML, see Render). The body is built by ResponseBody in the
+// ResponseEnvelope style.
 func ResponseSuccess(c *gin.Context, model any, addition ...gin.H) {
-	c.JSON(http.StatusOK, SuccessResponseBody(model, addition...))
+	setTotalHeader(c, addition...)
+	Render(c, http.StatusOK, ResponseBody(model, addition...))
+}
+
+// restStatusEnabled is set by WithRESTStatus, see RESTStatusEnabled.
+var restStatusEnabled atomic.Bool
+
+// RESTStatusEnabled reports whether the create handlers respond 201 Created
+// with a Location header of the created model (e.g. Location: /users/42),
+// instead of 200 OK. It is disabled by default for the compatibility.
+//
+// Use WithRESTStatus (or router.WithRESTStatus) to enable it.
+func RESTStatusEnabled() bool {
+	return restStatusEnabled.Load()
+}
+
+// WithRESTStatus enables the RESTStatusEnabled of the create handlers.
+// Call it before serving.
+func WithRESTStatus() {
+	restStatusEnabled.Store(true)
+}
+
+// ResponseCreated writes the created model to client like ResponseSuccess,
+// but with 201 Created and the Location header (the request path + the id
+// of model, unless the header is already set) if RESTStatusEnabled.
+func ResponseCreated(c *gin.Context, model any, addition ...gin.H) {
+	if !RESTStatusEnabled() {
+		ResponseSuccess(c, model, addition...)
+		return
+	}
+	if location, ok := locationOf(c, model); ok && c.Writer.Header().Get("Location") == "" {
+		c.Header("Location", location)
+	}
+	setTotalHeader(c, addition...)
+	Render(c, CodeCreated, ResponseBody(model, addition...))
+}
+
+// locationOf returns the URL path of the created model:
+// the request path + "/" + the id of model (an orm.Model).
+func locationOf(c *gin.Context, model any) (string, bool) {
+	m, ok := model.(orm.Model)
+	if !ok {
+		return "", false
+	}
+	_, id := m.Identity()
+	if id == nil || reflect.ValueOf(id).IsZero() {
+		return "", false
+	}
+	return strings.TrimSuffix(c.Request.URL.Path, "/") + "/" + url.PathEscape(fmt.Sprint(id)), true
+}
+
+// Render writes the response body to client in the format negotiated by
+// the Accept header of the request: XML if application/xml (or text/xml)
+// is preferred, otherwise JSON (the default).
+func Render(c *gin.Context, code int, body any) {
+	switch c.NegotiateFormat(binding.MIMEJSON, binding.MIMEXML, binding.MIMEXML2) {
+	case binding.MIMEXML, binding.MIMEXML2:
+		c.XML(code, body)
+	default:
+		renderJSON(c, code, body)
+	}
+}
+
+// jsonMarshal is the JSON encoder set by SetJSONEncoder,
+// nil for the default of gin.
+var jsonMarshal atomic.Pointer[func(v any) ([]byte, error)]
+
+// SetJSONEncoder sets the marshal function to encode the JSON responses
+// (including the NDJSON streams) of the crud handlers, instead of the
+// default of gin (encoding/json, or the one chosen by the gin build tags,
+// e.g. -tags=jsoniter). For example, to use json-iterator:
+//    SetJSONEncoder(jsoniter.ConfigCompatibleWithStandardLibrary.Marshal)
+// A nil marshal restores the default.
+//
+// The speedup depends on the encoder and the shape of the models, measure
+// it with BenchmarkRender in the tests of this package. The hook itself
+// costs nothing measurable: rendering 100 models with encoding/json set
+// takes ~209µs/op against ~216µs/op by gin (99.8 KB, 154 allocs/op), so
+// any gain comes from the encoder set.
+//
+// Set it before serving: the responses in flight may be encoded by either
+// of the encoders.
+func SetJSONEncoder(marshal func(v any) ([]byte, error)) {
+	if marshal == nil {
+		jsonMarshal.Store(nil)
+		return
+	}
+	jsonMarshal.Store(&marshal)
+}
+
+// marshalJSON encodes v by the encoder set by SetJSONEncoder,
+// or encoding/json by default.
+func marshalJSON(v any) ([]byte, error) {
+	if marshal := jsonMarshal.Load(); marshal != nil {
+		return (*marshal)(v)
+	}
+	return json.Marshal(v)
+}
+
+// renderJSON writes the JSON response body by the encoder set by
+// SetJSONEncoder, or gin.Context.JSON by default.
+func renderJSON(c *gin.Context, code int, body any) {
+	marshal := jsonMarshal.Load()
+	if marshal == nil {
+		c.JSON(code, body)
+		return
+	}
+	data, err := (*marshal)(body)
+	if err != nil {
+		logger.WithContext(c).WithError(err).
+			Error("renderJSON: marshal failed")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Data(code, binding.MIMEJSON+"; charset=utf-8", data)
+}
+
+// ResponseBindError writes the error of binding the request into model
+// to client. Validation errors (of the `binding:"..."` tags) are responded
+// field by field, with the json names of the fields:
+//    { error: "validation failed", fields: { "title": "required", "age": "min=18" } }
+// or, if APIErrorEnabled:
+//    { error: { code: "VALIDATION_FAILED", message: "validation failed", details: { fields: {...} } } }
+// Other errors are responded by ResponseError.
+func ResponseBindError(c *gin.Context, model any, err error) {
+	fields, ok := bindErrorFields(model, err)
+	if !ok {
+		ResponseError(c, CodeBadRequest, err)
+		return
+	}
+	responseFieldErrors(c, fields)
+}
+
+// bindErrorFields returns the validation errors in err field by field (see
+// ResponseBindError), or false if err is not a validation error.
+func bindErrorFields(model any, err error) (gin.H, bool) {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return nil, false
+	}
+
+	fields := make(gin.H, len(validationErrors))
+	for _, fieldError := range validationErrors {
+		name := fieldToJSONName(model, fieldError.Field())
+		rule := fieldError.Tag()
+		if fieldError.Param() != "" {
+			rule += "=" + fieldError.Param()
+		}
+		fields[name] = rule
+	}
+	return fields, true
+}
+
+// responseFieldErrors responds the validation errors of the fields,
+// see ResponseBindError.
+func responseFieldErrors(c *gin.Context, fields gin.H) {
+	if APIErrorEnabled() {
+		ResponseError(c, CodeBadRequest, &APIError{
+			Code:    ErrorCodeValidationFailed,
+			Message: ErrValidationFailed.Error(),
+			Details: gin.H{"fields": fields},
+		})
+		return
+	}
+	Render(c, CodeBadRequest, gin.H{
+		"error":  ErrValidationFailed.Error(),
+		"fields": fields,
+	})
 }
 
 const (
 	CodeSuccess       = http.StatusOK
+	CodeCreated       = http.StatusCreated
 	CodeNotFound      = http.StatusNotFound
 	CodeBadRequest    = http.StatusBadRequest
 	CodeProcessFailed = http.StatusUnprocessableEntity
+	CodeForbidden     = http.StatusForbidden
+	CodeTimeout       = http.StatusGatewayTimeout
+	CodeUnavailable   = http.StatusServiceUnavailable
+
+	CodeNotModified        = http.StatusNotModified
+	CodePreconditionFailed = http.StatusPreconditionFailed
+	CodeMethodNotAllowed   = http.StatusMethodNotAllowed
 )
 
 var (
-	ErrBindFailed      = errors.New("bind failed")
-	ErrMissingID       = errors.New("missing id")
-	ErrMissingParentID = errors.New("missing parent id")
-	ErrUpdateID        = errors.New("id can not be updated")
+	ErrBindFailed       = errors.New("bind failed")
+	ErrValidationFailed = errors.New("validation failed")
+	ErrMissingID        = errors.New("missing id")
+	ErrInvalidID        = errors.New("invalid id format")
+	ErrMissingParentID  = errors.New("missing parent id")
+	ErrUpdateID         = service.ErrUpdateID // the same error as the service one
+	ErrInvalidOrderBy   = errors.New("invalid order_by")
+	ErrInvalidFilterBy  = errors.New("invalid filter_by")
+	ErrInvalidBetween   = errors.New("invalid between_field")
+	ErrInvalidNull      = errors.New("invalid null or not_null")
+	ErrInvalidScope     = errors.New("unknown scope")
+	ErrInvalidFields    = errors.New("invalid fields")
+	ErrUnknownField     = errors.New("unknown field")
+	ErrInvalidPreload   = errors.New("invalid preload")
+	ErrForbidden        = errors.New("forbidden")
+	ErrTrashedForbidden = errors.New("soft-deleted records forbidden")
+	ErrHookFailed       = errors.New("hook failed")
+	ErrEmptyBatch       = errors.New("empty batch")
+	ErrBatchFailed      = errors.New("batch failed")
+	ErrBatchTooLarge    = errors.New("batch too large")
+
+	ErrMissingFile         = errors.New("missing file")
+	ErrFileTooLarge        = errors.New("file too large")
+	ErrUnsupportedFileType = errors.New("unsupported file type")
+	ErrPreconditionFailed  = errors.New("precondition failed")
+	ErrUnknownAssociation  = errors.New("unknown association")
+	ErrMethodNotAllowed    = errors.New("method not allowed")
+	ErrInvalidFieldValue   = errors.New("invalid field value")
+	ErrInvalidSearchField  = errors.New("invalid search field")
+
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused with another request")
 )
@@ -1,10 +1,13 @@
 package controller
 
 import (
+	"errors"
 	"github.com/cdfmlr/crud/log"
 	"github.com/cdfmlr/crud/orm"
 	"github.com/cdfmlr/crud/service"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"reflect"
 )
 
 // UpdateHandler handles
@@ -14,27 +17,65 @@ import (
 // Request body:
 //  - {"field": "new_value", ...}   // fields to update
 //
+// Validators: see RegisterValidator (UpdateModeFull only).
+// Hooks (see RegisterHooks): BeforeUpdate, AfterUpdate
+//
+// Update modes (see WithUpdateMode):
+//  - UpdateModeFull (default): the request body is bound onto the current
+//    model, which is validated and saved as a whole. A field omitted in the
+//    body keeps its value, but a field given its zero value (e.g. false) is
+//    saved as is.
+//  - UpdateModePartial: only the non-zero fields given in the request body
+//    are updated, so that an absent or zero value (e.g. false, 0, "") never
+//    overwrites the stored one. It is not possible to set a field to its
+//    zero value in this mode (use PatchHandler for that), and the binding
+//    validation of the model is skipped, as the body is bound into a map.
+//
+// Optimistic concurrency: with an If-Match header of the ETag given by
+// GetByIDHandler, the model is updated only if it is unchanged since then
+// (the current ETag matches), otherwise 412 Precondition Failed is
+// responded, so that the concurrent updates are not lost. The update is
+// conditional on the UpdatedAt read by the check, to fail with 412 as well
+// if the model is changed in between.
+//
 // Response:
-//  - 200 OK: { updated: true }
-//  - 400 Bad Request: { error: "missing id or bind fields failed" }
+//  - 200 OK: { T: {...} }
+//  - 400 Bad Request: { error: "missing id, invalid id format or bind fields failed" }
+//  - 400 Bad Request: { error: "validation failed", fields: { "title": "required" } }
+//  - 403 Forbidden: { error: "forbidden" }  // by hooks
 //  - 404 Not Found: { error: "record with id not found" }
+//  - 412 Precondition Failed: { error: "precondition failed" }  // If-Match mismatch
 //  - 422 Unprocessable Entity: { error: "update process failed" }
-func UpdateHandler[T orm.Model](idParam string) gin.HandlerFunc {
+func UpdateHandler[T orm.Model](idParam string, options ...UpdateOption) gin.HandlerFunc {
+	config := updateConfig{mode: UpdateModeFull}
+	for _, option := range options {
+		option.applyUpdate(&config)
+	}
+	if config.mode == UpdateModePartial {
+		return func(c *gin.Context) {
+			patchModel[T](c, idParam, "UpdateHandler", true, config.modelConfig)
+		}
+	}
+
 	return func(c *gin.Context) {
 		var model T
 
-		id := c.Param(idParam) // NOTICE: id is a string
-		if id == "" {
-			logger.WithContext(c).WithField("idParam", idParam).
-				Warn("UpdateHandler: Missing id")
-			ResponseError(c, CodeBadRequest, ErrMissingID)
+		id, err := paramID[T](c, idParam)
+		if err != nil {
+			logger.WithContext(c).WithField("idParam", idParam).WithError(err).
+				Warn("UpdateHandler: read id param failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		conditions, ok := checkIfMatch[T](c, idParam, "UpdateHandler", config.modelConfig)
+		if !ok {
 			return
 		}
 
-		if err := service.GetByID[T](c, id, &model); err != nil {
+		if err := service.GetByID[T](requestContext(c), id, &model, service.ReadPrimary()); err != nil {
 			logger.WithContext(c).WithError(err).
 				Warn("UpdateHandler: GetByID failed")
-			ResponseError(c, CodeNotFound, err)
+			ResponseErrorAuto(c, CodeNotFound, err)
 			return
 		}
 
@@ -42,7 +83,7 @@ func UpdateHandler[T orm.Model](idParam string) gin.HandlerFunc {
 		if err := c.ShouldBindJSON(&updatedModel); err != nil {
 			logger.WithContext(c).WithError(err).
 				Warn("UpdateHandler: Bind failed")
-			ResponseError(c, CodeBadRequest, err)
+			ResponseBindError(c, &updatedModel, err)
 			return
 		}
 
@@ -59,13 +100,183 @@ func UpdateHandler[T orm.Model](idParam string) gin.HandlerFunc {
 			return
 		}
 
-		_, err := service.Update(c, &updatedModel)
+		if !runValidators(c, &updatedModel) {
+			return
+		}
+
+		hooks := getHooks[T]()
+		if !runBeforeHooks(c, hooks.BeforeUpdate, &updatedModel) {
+			return
+		}
+
+		_, err = service.Update(requestContext(c), &updatedModel, conditions...)
 		if err != nil {
 			logger.WithContext(c).WithError(err).
 				Warn("UpdateHandler: Update failed")
-			ResponseError(c, CodeProcessFailed, err)
+			if !responsePreconditionFailed(c, err) {
+				ResponseErrorAuto(c, CodeProcessFailed, err)
+			}
+			return
+		}
+
+		if !runAfterHooks(c, hooks.AfterUpdate, &updatedModel) {
 			return
 		}
 		ResponseSuccess(c, &updatedModel)
 	}
 }
+
+// PatchHandler handles
+//    PATCH /T/:idParam
+// Partially updates the model T with the given id: only the fields given
+// in the request body are updated, so that a field can be set to its zero
+// value, while the omitted fields are untouched.
+// The id field in the request body is ignored.
+//
+// Request body:
+//  - {"field": "new_value", ...}   // fields to update
+//
+// Hooks (see RegisterHooks): BeforeUpdate, AfterUpdate
+// If-Match: see UpdateHandler.
+//
+// Response:
+//  - 200 OK: { T: {...} }
+//  - 400 Bad Request: { error: "missing id, invalid id format or bind fields failed" }
+//  - 403 Forbidden: { error: "forbidden" }  // by hooks
+//  - 404 Not Found: { error: "record with id not found" }
+//  - 412 Precondition Failed: { error: "precondition failed" }  // If-Match mismatch
+//  - 422 Unprocessable Entity: { error: "update process failed" }
+func PatchHandler[T orm.Model](idParam string, options ...ModelOption) gin.HandlerFunc {
+	var config modelConfig
+	for _, option := range options {
+		option(&config)
+	}
+	return func(c *gin.Context) {
+		patchModel[T](c, idParam, "PatchHandler", false, config)
+	}
+}
+
+// patchModel updates the fields given in the request body of the model T
+// with the id, and responds the updated model. It is the PatchHandler
+// (named name), or the partial UpdateHandler if skipZero, which ignores the
+// fields of zero values. The config is the ModelOptions of the handler.
+func patchModel[T orm.Model](c *gin.Context, idParam string, name string, skipZero bool, config modelConfig) {
+	id, err := paramID[T](c, idParam)
+	if err != nil {
+		logger.WithContext(c).WithField("idParam", idParam).WithError(err).
+			Warn(name + ": read id param failed")
+		ResponseError(c, CodeBadRequest, err)
+		return
+	}
+	conditions, ok := checkIfMatch[T](c, idParam, name, config)
+	if !ok {
+		return
+	}
+
+	var values map[string]any
+	if err := c.ShouldBindJSON(&values); err != nil {
+		logger.WithContext(c).WithError(err).
+			Warn(name + ": Bind failed")
+		ResponseError(c, CodeBadRequest, err)
+		return
+	}
+	if skipZero {
+		for key, value := range values {
+			if value == nil || reflect.ValueOf(value).IsZero() {
+				delete(values, key)
+			}
+		}
+	}
+
+	columns, err := valuesToColumns(new(T), values)
+	if err != nil {
+		logger.WithContext(c).WithError(err).
+			Warn(name + ": unknown fields")
+		ResponseError(c, CodeBadRequest, err)
+		return
+	}
+	_, idColumn := orm.IdentityColumn(*new(T))
+	delete(columns, idColumn)
+
+	hooks := getHooks[T]()
+	if len(hooks.BeforeUpdate) > 0 {
+		current, err := getModelByID[T](c, idParam, service.ReadPrimary())
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn(name + ": getModelByID failed")
+			ResponseErrorAuto(c, CodeNotFound, err)
+			return
+		}
+		if !runBeforeHooks(c, hooks.BeforeUpdate, current) {
+			return
+		}
+	}
+
+	logger.WithContext(c).
+		Tracef(name+": Update %T, id=%v, values=%v", *new(T), id, columns)
+
+	if _, err := service.UpdateFields[T](requestContext(c), id, columns, conditions...); err != nil {
+		logger.WithContext(c).WithError(err).
+			Warn(name + ": UpdateFields failed")
+		if responsePreconditionFailed(c, err) {
+			return
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ResponseErrorAuto(c, CodeNotFound, err)
+		} else {
+			ResponseErrorAuto(c, CodeProcessFailed, err)
+		}
+		return
+	}
+
+	model, err := getModelByID[T](c, idParam, service.ReadPrimary())
+	if err != nil {
+		logger.WithContext(c).WithError(err).
+			Warn(name + ": getModelByID failed")
+		ResponseErrorAuto(c, CodeProcessFailed, err)
+		return
+	}
+
+	if !runAfterHooks(c, hooks.AfterUpdate, model) {
+		return
+	}
+	ResponseSuccess(c, model)
+}
+
+// UpdateMode is the way UpdateHandler updates the model, see UpdateHandler.
+type UpdateMode string
+
+const (
+	UpdateModeFull    UpdateMode = "full"    // bind onto the current model and save it as a whole
+	UpdateModePartial UpdateMode = "partial" // update the non-zero fields given only
+)
+
+// UpdateOption is an option of UpdateHandler: WithUpdateMode or a
+// ModelOption.
+type UpdateOption interface {
+	applyUpdate(config *updateConfig)
+}
+
+type updateOptionFunc func(config *updateConfig)
+
+func (f updateOptionFunc) applyUpdate(config *updateConfig) { f(config) }
+
+type updateConfig struct {
+	modelConfig
+
+	mode UpdateMode
+}
+
+// WithUpdateMode sets the UpdateMode of UpdateHandler.
+// Unknown modes are ignored, leaving the default UpdateModeFull.
+func WithUpdateMode(mode UpdateMode) UpdateOption {
+	return updateOptionFunc(func(config *updateConfig) {
+		switch mode {
+		case UpdateModeFull, UpdateModePartial:
+			config.mode = mode
+		default:
+			logger.WithField("mode", mode).
+				Warn("WithUpdateMode: unknown update mode. Ignored.")
+		}
+	})
+}
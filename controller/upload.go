@@ -0,0 +1,239 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/service"
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// UploadFormField is the form field of the file in the requests of
+// FileUploadHandler.
+const UploadFormField = "file"
+
+// DefaultMaxFileSize is the size limit of the uploaded files by default.
+const DefaultMaxFileSize = 10 << 20 // 10 MiB
+
+// FileUploadHandler handles
+//    POST /T/:idParam/path
+// with a file in the multipart/form-data request body (the "file" form
+// field), saves it into the store, and sets the url of it to the field
+// (a string field, like AvatarURL) of the model T with the given id.
+// Responds with the updated model T.
+//
+// For example, for the avatars of users:
+//    store := service.NewLocalFileStore("./avatars", "/avatars/")
+//    r.POST("/users/:id/avatar", FileUploadHandler[User]("id", "AvatarURL", store,
+//        WithContentTypes("image/png", "image/jpeg"), WithMaxFileSize(1<<20)))
+//
+// The file is saved as "field-id-uuid.ext" (e.g. "avatarurl-1-6ba7...b810.png").
+// If the store is a service.FileDeleter, the file is deleted when the
+// model fails to update, and the file of the replaced url is deleted when
+// it succeeds.
+// The content type is detected from the content of the file (instead of
+// trusting the client), and checked against WithContentTypes (any type
+// is accepted by default). The size is limited by WithMaxFileSize
+// (DefaultMaxFileSize by default).
+//
+// Response:
+//  - 200 OK: { T: {...} }
+//  - 400 Bad Request: { error: "missing id, invalid id format or missing file" }
+//  - 404 Not Found: { error: "record not found" }
+//  - 413 Request Entity Too Large: { error: "file too large" }
+//  - 415 Unsupported Media Type: { error: "unsupported file type" }
+//  - 422 Unprocessable Entity: { error: "save file or update process failed" }
+func FileUploadHandler[T orm.Model](idParam string, field string, store service.FileStore, options ...UploadOption) gin.HandlerFunc {
+	config := uploadConfig{maxSize: DefaultMaxFileSize}
+	for _, option := range options {
+		option(&config)
+	}
+	field = nameToField(field, *new(T))
+
+	return func(c *gin.Context) {
+		id, err := paramID[T](c, idParam)
+		if err != nil {
+			logger.WithContext(c).WithField("idParam", idParam).WithError(err).
+				Warn("FileUploadHandler: read id param failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		column, err := modelColumn(new(T), field)
+		if err != nil {
+			logger.WithContext(c).WithField("field", field).WithError(err).
+				Warn("FileUploadHandler: unknown field")
+			ResponseError(c, CodeProcessFailed, err)
+			return
+		}
+		// the model must exist before saving the file, not to leave it orphan
+		current, err := getModelByID[T](c, idParam, service.ReadPrimary())
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("FileUploadHandler: getModelByID failed")
+			ResponseErrorAuto(c, CodeNotFound, err)
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.maxSize+multipartOverhead)
+		header, err := c.FormFile(UploadFormField)
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			err = ErrFileTooLarge
+		case errors.Is(err, http.ErrMissingFile):
+			err = ErrMissingFile
+		}
+		if err == nil && header.Size > config.maxSize {
+			err = ErrFileTooLarge
+		}
+		if errors.Is(err, ErrFileTooLarge) {
+			ResponseError(c, http.StatusRequestEntityTooLarge, fmt.Errorf("%w: limit %d bytes", err, config.maxSize))
+			return
+		}
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("FileUploadHandler: read file failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("FileUploadHandler: open file failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		defer file.Close()
+
+		content := bufio.NewReaderSize(file, 512)
+		head, _ := content.Peek(512) // fewer for the small files
+		contentType := http.DetectContentType(head)
+		if !config.acceptContentType(contentType) {
+			logger.WithContext(c).WithField("contentType", contentType).
+				Warn("FileUploadHandler: unsupported file type")
+			ResponseError(c, http.StatusUnsupportedMediaType, fmt.Errorf("%w: %s", ErrUnsupportedFileType, contentType))
+			return
+		}
+
+		filename := uploadFilename(field, id, header.Filename)
+		url, err := store.Save(requestContext(c), filename, content)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("FileUploadHandler: save file failed")
+			ResponseErrorAuto(c, CodeProcessFailed, err)
+			return
+		}
+
+		if _, err := service.UpdateFields[T](requestContext(c), id, map[string]any{column: url}); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("FileUploadHandler: UpdateFields failed")
+			deleteFile(c, store, url)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				ResponseErrorAuto(c, CodeNotFound, err)
+			} else {
+				ResponseErrorAuto(c, CodeProcessFailed, err)
+			}
+			return
+		}
+
+		if replaced := reflect.ValueOf(current).Elem().FieldByName(field); replaced.Kind() == reflect.String &&
+			replaced.String() != "" && replaced.String() != url {
+			deleteFile(c, store, replaced.String())
+		}
+
+		model, err := getModelByID[T](c, idParam, service.ReadPrimary())
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("FileUploadHandler: getModelByID failed")
+			ResponseErrorAuto(c, CodeProcessFailed, err)
+			return
+		}
+		ResponseSuccess(c, model)
+	}
+}
+
+// deleteFile deletes the file of the url from the store, if it is a
+// service.FileDeleter. It is done even if the request is canceled, and
+// the errors are only logged.
+func deleteFile(c *gin.Context, store service.FileStore, url string) {
+	deleter, ok := store.(service.FileDeleter)
+	if !ok {
+		return
+	}
+	if err := deleter.Delete(context.WithoutCancel(requestContext(c)), url); err != nil {
+		logger.WithContext(c).WithField("url", url).WithError(err).
+			Warn("FileUploadHandler: delete file failed")
+	}
+}
+
+// multipartOverhead is the room for the multipart boundaries and headers
+// in the request body, besides the file.
+const multipartOverhead = 1 << 20
+
+// uploadFilename builds the filename to save the uploaded file as:
+// "field-id-uuid.ext", with the extension of the original filename.
+func uploadFilename(field string, id any, original string) string {
+	ext := strings.ToLower(filepath.Ext(original))
+	if !identifierRegexp.MatchString(strings.TrimPrefix(ext, ".")) {
+		ext = "" // not a plain extension
+	}
+	name := strings.NewReplacer("/", "_", "\\", "_").Replace(fmt.Sprint(id))
+	return fmt.Sprintf("%s-%s-%s%s", strings.ToLower(field), name, uuid.Must(uuid.NewV4()), ext)
+}
+
+// UploadOption is an option of FileUploadHandler.
+type UploadOption func(config *uploadConfig)
+
+type uploadConfig struct {
+	maxSize      int64
+	contentTypes []string
+}
+
+// WithMaxFileSize limits the size of the uploaded files to bytes.
+// The larger ones are rejected with 413 Request Entity Too Large.
+func WithMaxFileSize(bytes int64) UploadOption {
+	return func(config *uploadConfig) {
+		config.maxSize = bytes
+	}
+}
+
+// WithContentTypes limits the content types (media types, like
+// "image/png") of the uploaded files. A type can be a wildcard of the
+// subtypes, like "image/*". The others are rejected with 415 Unsupported
+// Media Type.
+func WithContentTypes(contentTypes ...string) UploadOption {
+	return func(config *uploadConfig) {
+		config.contentTypes = append(config.contentTypes, contentTypes...)
+	}
+}
+
+// acceptContentType reports whether the contentType is accepted.
+func (config *uploadConfig) acceptContentType(contentType string) bool {
+	if len(config.contentTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, accepted := range config.contentTypes {
+		accepted = strings.ToLower(accepted)
+		if accepted == mediaType || accepted == "*/*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(accepted, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Validator validates a model T bound from the request body, for the rules
+// that the `binding:"..."` tags can not express, e.g. cross-field rules:
+//
+//    func(event *Event) error {
+//        if !event.Start.Before(event.End) {
+//            return FieldErrors{"end": "must be after start"}
+//        }
+//        return nil
+//    }
+//
+// A FieldErrors is responded field by field like the binding validation
+// errors (see ResponseBindError), other errors as a 400 Bad Request.
+type Validator[T any] func(model *T) error
+
+// FieldErrors is a validation error of fields: the json names of the
+// fields => the messages.
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field, message := range e {
+		fields = append(fields, field+": "+message)
+	}
+	sort.Strings(fields)
+	return "validation failed: " + strings.Join(fields, ", ")
+}
+
+// validatorsRegistry: reflect.Type of T => []Validator[T]
+var validatorsRegistry sync.Map
+
+// validatorsMu serializes RegisterValidator
+var validatorsMu sync.Mutex
+
+// RegisterValidator registers the validators of model T, which are invoked
+// in the registered order by CreateHandler and UpdateHandler (in the
+// UpdateModeFull) after binding the request body and before the hooks and
+// the database write. The first failed validator aborts the request.
+//
+// Notice: validators are registered per model type (not per route), use
+// router.WithValidator to register them when building the router.
+// PatchHandler and the UpdateModePartial, which bind the request body into
+// a map rather than a model, do not invoke them.
+func RegisterValidator[T any](validators ...Validator[T]) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+
+	registered := getValidators[T]()
+	all := make([]Validator[T], 0, len(registered)+len(validators)) // copy on write
+	all = append(append(all, registered...), validators...)
+	validatorsRegistry.Store(reflect.TypeOf((*T)(nil)), all)
+}
+
+// getValidators returns the registered validators of model T.
+func getValidators[T any]() []Validator[T] {
+	if validators, ok := validatorsRegistry.Load(reflect.TypeOf((*T)(nil))); ok {
+		return validators.([]Validator[T])
+	}
+	return nil
+}
+
+// runValidators runs the validators of model T and responds the error if
+// any. It returns false if the request is aborted.
+func runValidators[T any](c *gin.Context, model *T) bool {
+	err := validateModel(model)
+	if err == nil {
+		return true
+	}
+
+	logger.WithContext(c).WithError(err).
+		Warn("runValidators: validation failed")
+	if fields, ok := validatorErrorFields(err); ok {
+		responseFieldErrors(c, fields)
+	} else {
+		ResponseError(c, CodeBadRequest, fmt.Errorf("%w: %w", ErrValidationFailed, err))
+	}
+	return false
+}
+
+// validateModel runs the validators of model T,
+// and returns the error of the first failed one.
+func validateModel[T any](model *T) error {
+	for _, validator := range getValidators[T]() {
+		if err := validator(model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatorErrorFields returns the FieldErrors in err as the fields of
+// the response, or false if err is not a FieldErrors.
+func validatorErrorFields(err error) (gin.H, bool) {
+	var fieldErrors FieldErrors
+	if !errors.As(err, &fieldErrors) {
+		return nil, false
+	}
+	fields := make(gin.H, len(fieldErrors))
+	for field, message := range fieldErrors {
+		fields[field] = message
+	}
+	return fields, true
+}
@@ -0,0 +1,119 @@
+package log
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"io"
+	"sync"
+)
+
+// contextLoggerKey is the key of the logger entry in a context,
+// see ContextWithLogger.
+type contextLoggerKey struct{}
+
+// ContextWithLogger returns a copy of ctx carrying the logger entry, which
+// is used by the ContextLogger.WithContext(ctx) instead of the global
+// Logger, for example, to log a request at another level:
+//
+//    ctx = ContextWithLogger(ctx, LevelLogger(LevelTrace))
+//
+// For a gin request, put it into the context of the c.Request, which is
+// looked up for a *gin.Context:
+//
+//    c.Request = c.Request.WithContext(ContextWithLogger(c.Request.Context(), entry))
+func ContextWithLogger(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, contextLoggerKey{}, entry)
+}
+
+// LoggerFromContext returns the logger entry carried by ctx,
+// see ContextWithLogger.
+func LoggerFromContext(ctx context.Context) (entry *logrus.Entry, ok bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	if c, ok := ctx.(*gin.Context); ok { // without ContextWithFallback
+		if c.Request == nil {
+			return nil, false
+		}
+		ctx = c.Request.Context()
+	}
+	entry, ok = ctx.Value(contextLoggerKey{}).(*logrus.Entry)
+	return entry, ok && entry != nil
+}
+
+// LevelLogger returns an entry of a copy of the global Logger at the
+// level, sharing the output, formatter and hooks with it. The level of
+// the global Logger is left untouched.
+//
+// The writes of the copy and the global Logger to the output are
+// serialized by a lock shared by them (see sharedOutput): each
+// logrus.Logger locks only its own.
+func LevelLogger(level Level) *logrus.Entry {
+	hooks := make(logrus.LevelHooks, len(Logger.Hooks))
+	for l, h := range Logger.Hooks {
+		hooks[l] = h
+	}
+	return logrus.NewEntry(&logrus.Logger{
+		Out:          sharedOutput(),
+		Hooks:        hooks,
+		Formatter:    Logger.Formatter,
+		ReportCaller: Logger.ReportCaller,
+		Level:        getLogrusLevel(level),
+		ExitFunc:     Logger.ExitFunc,
+		BufferPool:   Logger.BufferPool,
+	})
+}
+
+// syncOutput is an output whose writes are serialized by its lock.
+type syncOutput struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (o *syncOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.w.Write(p)
+}
+
+// sharedOutputMu serializes the sharedOutput calls.
+var sharedOutputMu sync.Mutex
+
+// sharedOutput returns the output of the global Logger wrapped in a
+// syncOutput, which is set to the Logger as well (if it is not yet), so
+// that the loggers of LevelLogger write to it under the same lock as the
+// Logger.
+func sharedOutput() io.Writer {
+	sharedOutputMu.Lock()
+	defer sharedOutputMu.Unlock()
+
+	if o, ok := Logger.Out.(*syncOutput); ok {
+		return o
+	}
+	o := &syncOutput{w: Logger.Out}
+	Logger.SetOutput(o)
+	return o
+}
+
+// ContextLogger is a logger entry whose WithContext honors the logger
+// entry carried by the ctx (see ContextWithLogger).
+type ContextLogger struct {
+	*logrus.Entry
+}
+
+// ContextZoneLogger is ZoneLogger of a ContextLogger.
+func ContextZoneLogger(name string) *ContextLogger {
+	return &ContextLogger{ZoneLogger(name)}
+}
+
+// WithContext adds the ctx to the entry, as logrus.Entry.WithContext does.
+// If the ctx carries a logger entry (see ContextWithLogger), the returned
+// entry logs by it (with the fields of this one), instead of the Logger
+// of this one.
+func (l *ContextLogger) WithContext(ctx context.Context) *logrus.Entry {
+	if entry, ok := LoggerFromContext(ctx); ok {
+		return entry.WithFields(l.Data).WithContext(ctx)
+	}
+	return l.Entry.WithContext(ctx)
+}
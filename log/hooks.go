@@ -44,3 +44,24 @@ func RequestIDHook() logrus.Hook {
 		ContextKey: "request_id",
 	}
 }
+
+// ErrorHook reports the log entries at the error levels (Error, Fatal and
+// Panic) by the Report function, e.g. to an error tracking service.
+// Use it with WithHook:
+//    UseLogger(logger, WithHook(ErrorHook{Report: func(entry *logrus.Entry) error {
+//        return alert(entry.Message, entry.Data)
+//    }}))
+type ErrorHook struct {
+	Report func(entry *logrus.Entry) error
+}
+
+func (h ErrorHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+func (h ErrorHook) Fire(entry *logrus.Entry) error {
+	if h.Report == nil { // nothing to do
+		return nil
+	}
+	return h.Report(entry)
+}
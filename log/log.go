@@ -6,6 +6,8 @@ import (
 	"github.com/cdfmlr/crud/pkg/gormlogrus"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
 )
 
 // Level is the level of log: LevelDebug, LevelInfo, LevelWarn, LevelError
@@ -72,6 +74,34 @@ func WithReportCaller(reportCaller bool) LoggerOption {
 	}
 }
 
+// WithOutput sets the Logger to write logs to w (instead of stderr).
+//
+// Notice: WithOutput and WithRotatingFile are mutually exclusive,
+// the last one wins.
+func WithOutput(w io.Writer) LoggerOption {
+	return func(logger *logrus.Logger) {
+		logger.SetOutput(w)
+	}
+}
+
+// WithRotatingFile sets the Logger to write logs to the file at path,
+// which is rotated (by lumberjack) when it reaches maxSizeMB megabytes.
+// At most maxBackups old files are retained for at most maxAgeDays days
+// (0 means no limit).
+//
+// Notice: WithOutput and WithRotatingFile are mutually exclusive,
+// the last one wins.
+func WithRotatingFile(path string, maxSizeMB, maxBackups, maxAgeDays int) LoggerOption {
+	return func(logger *logrus.Logger) {
+		logger.SetOutput(&lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    maxSizeMB,
+			MaxBackups: maxBackups,
+			MaxAge:     maxAgeDays,
+		})
+	}
+}
+
 func WithHook(hook logrus.Hook) LoggerOption {
 	return func(logger *logrus.Logger) {
 		//logger.Debugf("WithHook: %v", hook)
@@ -0,0 +1,177 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithOutput(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	WithOutput(&buf)(logger)
+
+	logger.Info("hello output")
+	if !strings.Contains(buf.String(), "hello output") {
+		t.Errorf("output = %q, want it contains %q", buf.String(), "hello output")
+	}
+}
+
+func TestWithRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crud.log")
+
+	var buf bytes.Buffer
+	logger := logrus.New()
+	for _, option := range []LoggerOption{
+		WithOutput(&buf),
+		WithRotatingFile(path, 1, 3, 7), // the last one wins
+	} {
+		option(logger)
+	}
+
+	logger.Info("first line")
+	logger.Warn("second line")
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log file: %v", err)
+	}
+	for _, want := range []string{"first line", "second line"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("log file = %q, want it contains %q", content, want)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Errorf("output = %q, want nothing written", buf.String())
+	}
+}
+
+func TestSentryHook(t *testing.T) {
+	envelopes := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/42/envelope/" || !strings.Contains(r.Header.Get("X-Sentry-Auth"), "sentry_key=public") {
+			t.Errorf("unexpected request: %v %v", r.URL.Path, r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		envelopes <- string(body)
+	}))
+	defer server.Close()
+
+	dsn := strings.Replace(server.URL, "://", "://public@", 1) + "/42"
+	hook, err := NewSentryHook(dsn)
+	if err != nil {
+		t.Fatalf("NewSentryHook() error = %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.AddHook(hook)
+
+	logger.Warn("not captured")
+	logger.WithField("user", 42).
+		WithError(errors.New("boom")).
+		Error("something failed")
+
+	select {
+	case envelope := <-envelopes:
+		lines := strings.Split(strings.TrimSpace(envelope), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("envelope = %q, want 3 lines", envelope)
+		}
+		var event sentryEvent
+		if err := json.Unmarshal([]byte(lines[2]), &event); err != nil {
+			t.Fatalf("unmarshal event: %v", err)
+		}
+		if event.Message != "something failed" || event.Level != "error" {
+			t.Errorf("event = %q (%v), want %q (error)", event.Message, event.Level, "something failed")
+		}
+		if event.Exception == nil || event.Exception.Values[0].Value != "boom" {
+			t.Errorf("event exception = %+v, want boom", event.Exception)
+		}
+		if event.Extra["user"] != float64(42) {
+			t.Errorf("event extra = %v, want user=42", event.Extra)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no event sent")
+	}
+
+	select {
+	case envelope := <-envelopes:
+		t.Errorf("unexpected event: %s", envelope)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	logger.Error("flushed")
+	if !hook.Close(5 * time.Second) {
+		t.Errorf("Close() = false, want the queued event sent")
+	}
+	select {
+	case envelope := <-envelopes:
+		if !strings.Contains(envelope, "flushed") {
+			t.Errorf("flushed envelope = %q, want the flushed event", envelope)
+		}
+	default:
+		t.Errorf("Close() returned before the queued event is sent")
+	}
+
+	logger.Error("after close") // dropped
+	if got := hook.client.dropped.Load(); got != 1 {
+		t.Errorf("dropped = %d, want 1", got)
+	}
+
+	if _, err := NewSentryHook("https://sentry.io"); !errors.Is(err, ErrInvalidSentryDSN) {
+		t.Errorf("NewSentryHook() with bad dsn error = %v, want %v", err, ErrInvalidSentryDSN)
+	}
+}
+
+func TestLevelLogger(t *testing.T) {
+	var buf bytes.Buffer
+	output := Logger.Out
+	Logger.SetOutput(&buf)
+	t.Cleanup(func() { Logger.SetOutput(output) })
+
+	// the writes to the shared buf are serialized (go test -race)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			LevelLogger(LevelTrace).Trace("traced")
+		}()
+		go func() {
+			defer wg.Done()
+			Logger.Error("logged")
+		}()
+	}
+	wg.Wait()
+
+	if got := strings.Count(buf.String(), "traced"); got != 10 {
+		t.Errorf("traced lines = %v, want 10: %q", got, buf.String())
+	}
+	if got := strings.Count(buf.String(), "logged"); got != 10 {
+		t.Errorf("logged lines = %v, want 10", got)
+	}
+	if Logger.IsLevelEnabled(logrus.TraceLevel) {
+		t.Errorf("the level of the global logger is changed")
+	}
+
+	entry := LevelLogger(LevelTrace)
+	ctx := ContextWithLogger(context.Background(), entry)
+	if got, ok := LoggerFromContext(ctx); !ok || got != entry {
+		t.Errorf("LoggerFromContext() = %v, %v, want the entry", got, ok)
+	}
+	if _, ok := LoggerFromContext(context.WithValue(context.Background(), "crud/log.logger", entry)); ok {
+		t.Errorf("LoggerFromContext() found the entry by a string key")
+	}
+}
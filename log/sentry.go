@@ -0,0 +1,292 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sentryTimeout is the timeout of sending an event to Sentry.
+const sentryTimeout = 2 * time.Second
+
+// sentryQueueSize is the max number of events waiting to be sent,
+// events are dropped when the queue is full.
+const sentryQueueSize = 100
+
+// WithSentry forwards the Error, Fatal and Panic logs to Sentry at dsn,
+// with the message, fields and error (the logrus.ErrorKey field) captured.
+//
+// It is a thin client of the Sentry envelope API, so no Sentry SDK is
+// required. The events are sent in background, except the Fatal and Panic
+// ones, which are sent before the program exits.
+// If the dsn is invalid, the option is ignored with an error logged.
+//
+// To send the queued events before a normal exit, use NewSentryHook with
+// WithHook instead, and Close the hook:
+//    hook, err := NewSentryHook(dsn)
+//    ...
+//    defer hook.Close(2 * time.Second)
+//    UseLogger(logger, WithHook(hook))
+func WithSentry(dsn string) LoggerOption {
+	return func(logger *logrus.Logger) {
+		hook, err := NewSentryHook(dsn)
+		if err != nil {
+			logger.WithError(err).
+				Error("WithSentry: invalid sentry dsn. Ignored.")
+			return
+		}
+		logger.AddHook(hook)
+	}
+}
+
+// SentryHook is an ErrorHook that captures the log entries to Sentry.
+// See WithSentry.
+type SentryHook struct {
+	ErrorHook
+	client *sentryClient
+}
+
+// NewSentryHook creates a SentryHook sending the events to Sentry at dsn.
+// Close it before the program exits to send the queued events.
+func NewSentryHook(dsn string) (*SentryHook, error) {
+	client, err := newSentryClient(dsn)
+	if err != nil {
+		return nil, err
+	}
+	go client.run()
+
+	return &SentryHook{
+		ErrorHook: ErrorHook{Report: func(entry *logrus.Entry) error {
+			event := newSentryEvent(entry)
+			if entry.Level <= logrus.FatalLevel { // the program is going to exit
+				return client.send(event)
+			}
+			client.enqueue(event)
+			return nil
+		}},
+		client: client,
+	}, nil
+}
+
+// Flush waits until the queued events are sent, or the timeout expires.
+// It returns false if there are events not sent in time.
+func (h *SentryHook) Flush(timeout time.Duration) bool {
+	return h.client.flush(timeout)
+}
+
+// Close stops capturing the log entries (the later ones are dropped),
+// and flushes the queued events, see Flush.
+func (h *SentryHook) Close(timeout time.Duration) bool {
+	h.client.close()
+	return h.client.flush(timeout)
+}
+
+// sentryClient sends events to the Sentry envelope endpoint.
+type sentryClient struct {
+	dsn      string
+	endpoint string // https://host/api/{project}/envelope/
+	auth     string // X-Sentry-Auth header
+	http     *http.Client
+
+	mu      sync.RWMutex // guards closed against the enqueues
+	closed  bool
+	queue   chan *sentryEvent
+	pending atomic.Int64 // events queued or being sent
+	dropped atomic.Int64 // events dropped since the last report
+}
+
+// newSentryClient parses the dsn:
+//
+//	https://{public_key}@{host}/{project_id}
+func newSentryClient(dsn string) (*sentryClient, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	key := u.User.Username()
+	project := strings.TrimPrefix(u.Path, "/")
+	if key == "" || project == "" || u.Host == "" {
+		return nil, ErrInvalidSentryDSN
+	}
+
+	return &sentryClient{
+		dsn:      dsn,
+		endpoint: fmt.Sprintf("%s://%s/api/%s/envelope/", u.Scheme, u.Host, project),
+		auth:     fmt.Sprintf("Sentry sentry_version=7, sentry_client=crud/1.0, sentry_key=%s", key),
+		http:     &http.Client{Timeout: sentryTimeout},
+		queue:    make(chan *sentryEvent, sentryQueueSize),
+	}, nil
+}
+
+// enqueue the event to be sent by run. The event is dropped if the queue
+// is full (a flood of errors should not block the program) or the client
+// is closed.
+func (c *sentryClient) enqueue(event *sentryEvent) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		c.dropped.Add(1)
+		return
+	}
+	c.pending.Add(1)
+	select {
+	case c.queue <- event:
+	default:
+		c.pending.Add(-1)
+		c.dropped.Add(1)
+	}
+}
+
+// run sends the queued events, until the client is closed.
+func (c *sentryClient) run() {
+	for event := range c.queue {
+		if err := c.send(event); err != nil {
+			ZoneLogger("crud/log").WithField("event_id", event.EventID).
+				WithField("message", event.Message).
+				Warnf("sentry: failed to send event: %v", err)
+		}
+		c.pending.Add(-1)
+
+		if dropped := c.dropped.Swap(0); dropped > 0 {
+			ZoneLogger("crud/log").WithField("dropped", dropped).
+				Warn("sentry: queue is full, events dropped")
+		}
+	}
+}
+
+// flush waits until the pending events are sent, or the timeout expires.
+func (c *sentryClient) flush(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for c.pending.Load() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// close stops accepting events. run exits after the queued events are sent.
+func (c *sentryClient) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+}
+
+// send an event to Sentry in an envelope:
+//
+//	{"event_id": ..., "dsn": ...}
+//	{"type": "event"}
+//	{ ...event... }
+func (c *sentryClient) send(event *sentryEvent) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body) // Encode ends with a newline
+	_ = encoder.Encode(map[string]string{"event_id": event.EventID, "dsn": c.dsn})
+	_ = encoder.Encode(map[string]string{"type": "event"})
+	if err := encoder.Encode(event); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", c.auth)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sentry: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// sentryEvent is the Sentry event payload.
+type sentryEvent struct {
+	EventID   string            `json:"event_id"`
+	Timestamp time.Time         `json:"timestamp"`
+	Level     string            `json:"level"`
+	Platform  string            `json:"platform"`
+	Logger    string            `json:"logger,omitempty"`
+	Message   string            `json:"message"`
+	Extra     map[string]any    `json:"extra,omitempty"`
+	Exception *sentryExceptions `json:"exception,omitempty"`
+}
+
+type sentryExceptions struct {
+	Values []sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// newSentryEvent converts the log entry to a Sentry event.
+func newSentryEvent(entry *logrus.Entry) *sentryEvent {
+	id, _ := uuid.NewV4()
+
+	event := &sentryEvent{
+		EventID:   strings.ReplaceAll(id.String(), "-", ""),
+		Timestamp: entry.Time,
+		Level:     sentryLevel(entry.Level),
+		Platform:  "go",
+		Message:   entry.Message,
+		Extra:     map[string]any{},
+	}
+
+	for k, v := range entry.Data {
+		switch {
+		case k == logrus.ErrorKey:
+			if err, ok := v.(error); ok {
+				event.Exception = &sentryExceptions{Values: []sentryException{
+					{Type: fmt.Sprintf("%T", err), Value: err.Error()},
+				}}
+				continue
+			}
+		case k == "zone":
+			event.Logger = fmt.Sprint(v)
+		}
+		if err, ok := v.(error); ok { // errors are marshaled to {}
+			v = err.Error()
+		}
+		event.Extra[k] = v
+	}
+	return event
+}
+
+// logrus.Level -> sentry level
+func sentryLevel(level logrus.Level) string {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return "fatal"
+	case logrus.ErrorLevel:
+		return "error"
+	case logrus.WarnLevel:
+		return "warning"
+	case logrus.InfoLevel:
+		return "info"
+	default:
+		return "debug"
+	}
+}
+
+var ErrInvalidSentryDSN = errors.New("invalid sentry dsn")
@@ -0,0 +1,138 @@
+package orm
+
+import (
+	"context"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+// The fields (or columns) of the models set by the audit callbacks, see
+// RegisterAuditCallbacks. Set them before registering to use other names.
+var (
+	AuditCreatedByField = "CreatedBy" // set on create
+	AuditUpdatedByField = "UpdatedBy" // set on create and update
+)
+
+// names of the audit callbacks in the gorm callback chains.
+const (
+	auditCreateCallback = "crud:audit_create"
+	auditUpdateCallback = "crud:audit_update"
+)
+
+// auditUserFromCtx is the userFromCtx of RegisterAuditCallbacks.
+var auditUserFromCtx atomic.Pointer[func(context.Context) any]
+
+// RegisterAuditCallbacks installs gorm callbacks to the DB that fill the
+// audit fields of the models from the context of the statements:
+//   - create: AuditCreatedByField and AuditUpdatedByField
+//   - update: AuditUpdatedByField
+// The fields are looked up by the field name or the column name (i.e.
+// CreatedBy or created_by by default), a model without them is left
+// untouched. The user is whatever userFromCtx returns (of a type
+// assignable to the fields), and nothing is set if it returns nil, e.g.
+// for the background jobs:
+//
+//    type Post struct {
+//        orm.BasicModel
+//        CreatedBy string
+//        UpdatedBy string
+//    }
+//
+//    orm.RegisterAuditCallbacks(func(ctx context.Context) any {
+//        if user, ok := ctx.Value("user").(string); ok { // c.Set("user", ...) by an auth middleware
+//            return user
+//        }
+//        return nil
+//    })
+//
+// It works with the service package, which passes the request context to
+// the queries (WithContext), and with any gorm.DB.WithContext(ctx) calls.
+// The AuditCreatedByField is never updated after the create: it is omitted
+// from the updates, even if the client sends one.
+//
+// It returns ErrNotConnected if the DB is not connected yet. The callbacks
+// are installed to the databases connected later (by ConnectDB, Reconnect
+// and ConnectWithReplicas) as well. Registering again replaces the
+// userFromCtx.
+func RegisterAuditCallbacks(userFromCtx func(ctx context.Context) any) error {
+	db := Current()
+	if db == nil {
+		return ErrNotConnected
+	}
+	auditUserFromCtx.Store(&userFromCtx)
+	return installAuditCallbacks(db)
+}
+
+// installAuditCallbacks installs the audit callbacks to the db,
+// if they are registered (by RegisterAuditCallbacks) and not installed yet.
+func installAuditCallbacks(db *gorm.DB) error {
+	if auditUserFromCtx.Load() == nil {
+		return nil
+	}
+	if db.Callback().Create().Get(auditCreateCallback) == nil {
+		err := db.Callback().Create().Before("gorm:create").
+			Register(auditCreateCallback, auditCreate)
+		if err != nil {
+			return err
+		}
+	}
+	if db.Callback().Update().Get(auditUpdateCallback) == nil {
+		err := db.Callback().Update().Before("gorm:update").
+			Register(auditUpdateCallback, auditUpdate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditCreate is the create callback, see RegisterAuditCallbacks.
+func auditCreate(tx *gorm.DB) {
+	user, ok := auditUser(tx)
+	if !ok {
+		return
+	}
+	setAuditColumn(tx, AuditCreatedByField, user)
+	setAuditColumn(tx, AuditUpdatedByField, user)
+}
+
+// auditUpdate is the update callback, see RegisterAuditCallbacks.
+func auditUpdate(tx *gorm.DB) {
+	if tx.Error != nil || tx.Statement.Schema == nil {
+		return
+	}
+	if field := tx.Statement.Schema.LookUpField(AuditCreatedByField); field != nil {
+		tx.Statement.Omits = append(tx.Statement.Omits, field.DBName)
+	}
+
+	user, ok := auditUser(tx)
+	if !ok {
+		return
+	}
+	setAuditColumn(tx, AuditUpdatedByField, user)
+}
+
+// auditUser returns the user of the statement context by the userFromCtx
+// of RegisterAuditCallbacks. ok is false if there is nothing to set.
+func auditUser(tx *gorm.DB) (user any, ok bool) {
+	if tx.Error != nil || tx.Statement.Schema == nil {
+		return nil, false
+	}
+	userFromCtx := auditUserFromCtx.Load()
+	if userFromCtx == nil || tx.Statement.Context == nil {
+		return nil, false
+	}
+	user = (*userFromCtx)(tx.Statement.Context)
+	return user, user != nil
+}
+
+// setAuditColumn sets the field (or column) name of the models in the
+// statement to the user, if the model has the field.
+func setAuditColumn(tx *gorm.DB, name string, user any) {
+	field := tx.Statement.Schema.LookUpField(name)
+	if field == nil || field.DBName == "" {
+		return
+	}
+	tx.Statement.SetColumn(field.DBName, user, true)
+}
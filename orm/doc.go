@@ -6,4 +6,5 @@
 //
 // Call the ConnectDB() function to connect to the database.
 // And call RegisterModel() to register your models.
+// In tests, ConnectTestDB() does both on a fresh in-memory database.
 package orm
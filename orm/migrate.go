@@ -0,0 +1,132 @@
+package orm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// MigrationPlan reports what AutoMigrate (RegisterModel) would do to the
+// database for the given models, without altering anything. It returns a
+// human-readable list of the changes, empty if the database is up-to-date:
+//
+//    create table orders
+//    add column users.nickname
+//    alter column users.age type text -> bigint
+//    alter column users.email not null
+//    create index idx_user_status on orders
+//    create constraint chk_orders_amount on orders
+//
+// Notice: the missing tables (including the many2many join tables),
+// columns, indexes and check constraints are reported. For the existing
+// columns, the changes of the types (including the sizes) and the
+// nullability (to NOT NULL) are reported, as AutoMigrate compares them.
+// Other changes (like defaults or comments) are not compared.
+func MigrationPlan(models ...any) ([]string, error) {
+	db, release := Acquire()
+	defer release()
+	if db == nil {
+		return nil, ErrNotConnected
+	}
+	migrator := db.Migrator()
+
+	var plan []string
+	joinTables := map[string]bool{} // reported join tables
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, fmt.Errorf("parse %T: %w", model, err)
+		}
+		table := stmt.Schema.Table
+
+		if !migrator.HasTable(model) {
+			plan = append(plan, "create table "+table)
+		} else {
+			for _, column := range stmt.Schema.DBNames {
+				if !migrator.HasColumn(model, column) {
+					plan = append(plan, "add column "+table+"."+column)
+				}
+			}
+			alters, err := alteredColumns(db, model, stmt.Schema)
+			if err != nil {
+				return nil, fmt.Errorf("column types of %T: %w", model, err)
+			}
+			plan = append(plan, alters...)
+			for _, name := range sortedKeys(stmt.Schema.ParseIndexes()) {
+				if !migrator.HasIndex(model, name) {
+					plan = append(plan, "create index "+name+" on "+table)
+				}
+			}
+			for _, name := range sortedKeys(stmt.Schema.ParseCheckConstraints()) {
+				if !migrator.HasConstraint(model, name) {
+					plan = append(plan, "create constraint "+name+" on "+table)
+				}
+			}
+		}
+
+		for _, name := range sortedKeys(stmt.Schema.Relationships.Relations) {
+			joinTable := stmt.Schema.Relationships.Relations[name].JoinTable
+			if joinTable != nil && !joinTables[joinTable.Table] && !migrator.HasTable(joinTable.Table) {
+				joinTables[joinTable.Table] = true // shared by both sides
+				plan = append(plan, "create table "+joinTable.Table)
+			}
+		}
+	}
+	return plan, nil
+}
+
+// alteredColumns compares the columns of the model in the database (by
+// ColumnTypes) to the fields of the schema, like the Migrator.MigrateColumn
+// of gorm, and reports the types and the nullability to be altered.
+func alteredColumns(db *gorm.DB, model any, s *schema.Schema) ([]string, error) {
+	migrator := db.Migrator()
+	columnTypes, err := migrator.ColumnTypes(model)
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]gorm.ColumnType, len(columnTypes))
+	for _, columnType := range columnTypes {
+		columns[strings.ToLower(columnType.Name())] = columnType
+	}
+
+	var plan []string
+	for _, field := range s.Fields {
+		columnType, ok := columns[strings.ToLower(field.DBName)]
+		if field.DBName == "" || field.IgnoreMigration || field.PrimaryKey || !ok {
+			continue
+		}
+		column := s.Table + "." + field.DBName
+
+		fullDataType := strings.TrimSpace(strings.ToLower(migrator.FullDataTypeOf(field).SQL))
+		realDataType := strings.ToLower(columnType.DatabaseTypeName())
+		sameType := strings.HasPrefix(fullDataType, realDataType)
+		for _, alias := range migrator.GetTypeAliases(realDataType) {
+			sameType = sameType || strings.HasPrefix(fullDataType, alias)
+		}
+		if length, ok := columnType.Length(); ok && length > 0 && field.Size > 0 && length != int64(field.Size) {
+			sameType = false
+		}
+		if !sameType {
+			plan = append(plan, fmt.Sprintf("alter column %s type %s -> %s",
+				column, realDataType, strings.ToLower(db.Dialector.DataTypeOf(field))))
+		}
+
+		if nullable, ok := columnType.Nullable(); ok && nullable && field.NotNull {
+			plan = append(plan, "alter column "+column+" not null")
+		}
+	}
+	return plan, nil
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
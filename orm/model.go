@@ -2,17 +2,82 @@ package orm
 
 import (
 	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"sync"
+	"time"
 )
 
 // Model is the interface for all models.
 // It only requires an Identity() method to return the primary key field
 // name and value.
+//
+// The identity field is named in three places, resolved from it by
+// IdentityColumn:
+//  - the route param: the type name + the Go field name, e.g. ":UserUuid"
+//    for the field Uuid of User (see router.WithIDParam to rename it),
+//    whose value is parsed into the Go type of the field;
+//  - the struct field: the Go field name, e.g. Uuid, embedded ones included;
+//  - the database column: the column of the field, e.g. uuid (or the one
+//    set by `gorm:"column:..."`), used by the queries (see service.GetByID).
 type Model interface {
 	// Identity returns the primary key field of the model.
 	// A very common case is that the primary key field is ID.
+	// The fieldName is the Go field name, or the column name of it.
 	Identity() (fieldName string, value any)
 }
 
+// IdentityColumn returns the Go field name of the identity field of the
+// model (see Model) and its column in the database, resolved from the
+// schema of the model parsed by the DB (or by the default naming of gorm
+// if the DB is not connected). The fieldName returned by Identity is
+// returned as both if it is not a field of the model.
+func IdentityColumn(model Model) (field, column string) {
+	name, _ := model.Identity()
+	s, err := ParseSchema(model)
+	if err != nil {
+		return name, name
+	}
+	f := s.LookUpField(name)
+	if f == nil || f.Name == "" {
+		return name, name
+	}
+	if f.DBName == "" {
+		return f.Name, name
+	}
+	return f.Name, f.DBName
+}
+
+// schemaCache caches the schemas parsed without the DB.
+var schemaCache sync.Map
+
+// ParseSchema parses the gorm schema of the model by the DB, or by the
+// default naming of gorm if it is not connected, so that the fields from
+// the clients can be checked against the model whether or not the DB is
+// connected.
+func ParseSchema(model any) (*schema.Schema, error) {
+	if db := Current(); db != nil {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		return stmt.Schema, nil
+	}
+	return schema.Parse(model, &schemaCache, schema.NamingStrategy{})
+}
+
+// ResourceNamer can be implemented by models to name themselves in the
+// public API, instead of the Go type name, for example:
+//    func (UserModel) ResourceName() string { return "user" }
+// makes the responses { user: {...} } and { users: [...] } (see
+// controller.ResponseKey), the model name of the routes given to the
+// router.Authorizer and the OpenAPI tags "user", and the path suggested by
+// router.ResourcePath "/users". The plural is the name with an "s"
+// appended, implement controller.ResponseNamer (which takes precedence)
+// for the irregular ones. See controller.ResourceNames.
+type ResourceNamer interface {
+	ResourceName() string
+}
+
 // BasicModel implements Model interface with an auto increment primary key ID.
 //
 // BasicModel is actually the gorm.Model struct which contains the following
@@ -28,3 +93,26 @@ type BasicModel gorm.Model
 func (m BasicModel) Identity() (fieldName string, value any) {
 	return "ID", m.ID
 }
+
+// HardModel implements Model interface with an auto increment primary key ID,
+// like BasicModel but without the soft delete: the fields are
+//    ID, CreatedAt, UpdatedAt
+//
+// Embed BasicModel if the deleted records should be kept (and restorable)
+// in the database, which is the common case. Embed HardModel if they must be
+// truly removed (e.g. by law or for the table size), or if the records are
+// never deleted (e.g. ledger entries) so that there is no DeletedAt to
+// index and filter by:
+//    type LedgerEntry struct {
+//      orm.HardModel
+//    }
+// The service Delete and DeleteByID delete the rows of HardModel for real.
+type HardModel struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (m HardModel) Identity() (fieldName string, value any) {
+	return "ID", m.ID
+}
@@ -1,8 +1,16 @@
 package orm
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
 	"github.com/cdfmlr/crud/log"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
@@ -18,13 +26,128 @@ const (
 	DBDriverPostgres = "postgres"
 )
 
-// DB is the global database instance
+// DB is the global database instance.
+//
+// Deprecated: use Current (or Acquire) instead. DB is set by ConnectDB,
+// ConnectWithReplicas and SetDB, which are done before serving, but NOT by
+// Reconnect and ReconnectWithReplicas, which swap the database under the
+// running queries: after them, DB is the old, closed database. Assigning
+// DB directly is not seen by the package either, use SetDB.
 var DB *gorm.DB
 
-var logger = log.ZoneLogger("crud/orm")
+// current is the global database, see Current and Acquire.
+var current atomic.Pointer[pool]
+
+// pool is a global database, counting its users to be closed after they
+// release it, see Acquire and Reconnect.
+type pool struct {
+	db *gorm.DB
+
+	mu       sync.Mutex
+	users    int
+	closing  bool
+	released chan struct{} // closed when the last user releases a closing pool
+}
+
+// Current returns the global database set by ConnectDB (or swapped by
+// Reconnect, ConnectWithReplicas and SetDB), or nil if it is not connected
+// yet. It is safe to call concurrently with Reconnect.
+//
+// The returned DB may be closed by a following Reconnect, use Acquire
+// for the queries that must not run on a closed database.
+func Current() *gorm.DB {
+	if p := current.Load(); p != nil {
+		return p.db
+	}
+	return nil
+}
+
+// Acquire returns the global database (see Current) like Current, and
+// keeps it open until release is called, even if Reconnect swaps it in
+// between. The services of this package acquire it for each call:
+//
+//    db, release := orm.Acquire()
+//    defer release()
+//    db.WithContext(ctx).Find(&users)
+//
+// The db is nil if it is not connected yet. Calling release more than once
+// is a no-op.
+func Acquire() (db *gorm.DB, release func()) {
+	for {
+		p := current.Load()
+		if p == nil {
+			return nil, func() {}
+		}
+		if p.acquire() {
+			return p.db, sync.OnceFunc(p.release)
+		}
+		// p is closing: swapped by Reconnect, retry the new one
+	}
+}
+
+// SetDB sets the global database (see Current) and the deprecated DB
+// variable to the db, for example, a *gorm.DB opened with a custom
+// gorm.Config. The old one is left open. It is done by ConnectDB and
+// ConnectWithReplicas.
+//
+// Notice: it is not safe to call concurrently with the readers of DB, use
+// Reconnect to swap the database while serving.
+func SetDB(db *gorm.DB) {
+	swapDB(db)
+	DB = db
+}
+
+// swapDB sets the global database to the db and returns the old pool.
+// The DB variable is left untouched, see SetDB.
+func swapDB(db *gorm.DB) (old *pool) {
+	var p *pool
+	if db != nil {
+		p = &pool{db: db, released: make(chan struct{})}
+	}
+	return current.Swap(p)
+}
+
+func (p *pool) acquire() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closing {
+		return false
+	}
+	p.users++
+	return true
+}
+
+func (p *pool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.users--
+	if p.closing && p.users == 0 {
+		close(p.released)
+	}
+}
+
+// close closes the database of the pool once all its users release it.
+// The pool can not be acquired since.
+func (p *pool) close() error {
+	p.mu.Lock()
+	p.closing = true
+	users := p.users
+	p.mu.Unlock()
 
-// ConnectDB connects to the database and initializes the global crud.DB
-// instance. The driver should be one of the following:
+	if users > 0 {
+		<-p.released
+	}
+	sqlDB, err := p.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+var logger = log.ContextZoneLogger("crud/orm")
+
+// ConnectDB connects to the database and initializes the global database
+// (see Current). The driver should be one of the following:
 //    DBDriverMySQL, DBDriverSqlite, DBDriverPostgres
 // And the dsn is depends on the driver:
 //  - DBDriverSqlite: gorm.db
@@ -32,15 +155,170 @@ var logger = log.ZoneLogger("crud/orm")
 //  - DBDriverPostgres: host=localhost user=gorm password=gorm dbname=gorm port=9920 sslmode=disable TimeZone=Asia/Shanghai
 // See GORM docs for more information:
 // - https://gorm.io/docs/connecting_to_the_database.html
+//
+// It is ConnectDBContext with context.Background(), i.e. no time limit.
 func ConnectDB(driver DBDriver, dsn string) (*gorm.DB, error) {
-	var err error
+	return ConnectDBContext(context.Background(), driver, dsn)
+}
+
+// ConnectDBContext is ConnectDB bounded by the ctx: opening the database
+// and the initial ping fail fast with the ctx error when the ctx is done,
+// instead of waiting for the TCP timeout of an unreachable host:
+//
+//    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//    defer cancel()
+//    db, err := ConnectDBContext(ctx, DBDriverPostgres, dsn)
+//
+// The ping is sent with the ctx. The drivers do not take a ctx on opening
+// (e.g. mysql queries the server version), so the opening is abandoned
+// (and closed in the background once it returns) on the ctx done.
+//
+// The global DB is set only if it is connected successfully.
+func ConnectDBContext(ctx context.Context, driver DBDriver, dsn string) (*gorm.DB, error) {
+	db, err := openDB(ctx, driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	SetDB(db)
+	return db, nil
+}
+
+// openDB opens and pings the database bounded by the ctx, see
+// ConnectDBContext. It does not touch the global DB.
+func openDB(ctx context.Context, driver DBDriver, dsn string) (*gorm.DB, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	driverOpen := getDBOpener(driver)
 
-	DB, err = gorm.Open(driverOpen(dsn), &gorm.Config{
-		Logger: log.Logger4Gorm,
-	})
-	return DB, err
+	type opened struct {
+		db  *gorm.DB
+		err error
+	}
+	done := make(chan opened, 1)
+	go func() {
+		db, err := gorm.Open(driverOpen(dsn), &gorm.Config{
+			Logger:               log.Logger4Gorm,
+			DisableAutomaticPing: true, // pinged with the ctx below
+		})
+		done <- opened{db, err}
+	}()
+
+	var db *gorm.DB
+	select {
+	case <-ctx.Done():
+		go func() { // close the abandoned database once it is opened
+			if o := <-done; o.err == nil {
+				if sqlDB, err := o.db.DB(); err == nil {
+					_ = sqlDB.Close()
+				}
+			}
+		}()
+		logger.WithContext(ctx).WithField("driver", driver).WithError(ctx.Err()).
+			Warn("openDB: open database abandoned")
+		return nil, ctx.Err()
+	case o := <-done:
+		if o.err != nil {
+			return nil, o.err
+		}
+		db = o.db
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		_ = sqlDB.Close()
+		return nil, err
+	}
+	if err := installAuditCallbacks(db); err != nil {
+		_ = sqlDB.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Reconnect opens a new connection pool to the database (like ConnectDB),
+// swaps the global database (see Current) to it, and closes the old one in the background,
+// for example, on the DSN changed in the config:
+//
+//    watchFile, stop := config.WatchFileChange(func(oldConfig, newConfig any) {
+//        o, n := oldConfig.(*MyConfig), newConfig.(*MyConfig)
+//        if o.DB != n.DB {
+//            _, err := orm.Reconnect(orm.DBDriver(n.DB.Driver), n.DB.DSN)
+//            ...
+//        }
+//    })
+//    defer stop()
+//    config.Init(&conf, config.FromFile(path), watchFile)
+//
+// The new database is opened and pinged before the swap, so that the DB
+// is never a half-opened one; and it is left untouched on errors.
+//
+// The old pool is closed in the background once the users that acquired
+// it before the swap (see Acquire, which the services use) release it.
+// The queries started after the swap go to the new DB. The DB got by
+// Current before the swap is not counted, it may be closed under the
+// queries on it. The deprecated DB variable is not swapped, see DB.
+//
+// Notice: the migrations are not redone, call RegisterModel after
+// Reconnect if the new database may not be migrated. And the new database
+// has no read replicas: the ones of ConnectWithReplicas are dropped, use
+// ReconnectWithReplicas to keep (or change) them.
+func Reconnect(driver DBDriver, dsn string) (*gorm.DB, error) {
+	db, err := openDB(context.Background(), driver, dsn)
+	if err != nil {
+		logger.WithField("driver", driver).WithError(err).
+			Error("Reconnect: open database failed")
+		return nil, err
+	}
+
+	reconnect(db)
+	logger.WithField("driver", driver).Info("Reconnect: database swapped")
+	return db, nil
+}
+
+// reconnect swaps the global database to the db, and closes the old one
+// in the background once it is released, see Reconnect.
+func reconnect(db *gorm.DB) {
+	if old := swapDB(db); old != nil {
+		go func() {
+			if err := old.close(); err != nil {
+				logger.WithError(err).Warn("Reconnect: close old database failed")
+			}
+		}()
+	}
+}
+
+// Ping verifies the connection to the database (DB) is still alive,
+// establishing a connection if necessary.
+// It returns ErrNotConnected if the DB is not connected yet.
+func Ping(ctx context.Context) error {
+	db, release := Acquire()
+	defer release()
+	if db == nil {
+		return ErrNotConnected
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+var ErrNotConnected = errors.New("database not connected")
+
+// DialectName returns the name of the dialect of DB, like "sqlite",
+// "mysql" or "postgres", for the dialect-specific SQL.
+// It returns "" if the DB is not connected yet.
+func DialectName() string {
+	db := Current()
+	if db == nil || db.Dialector == nil {
+		return ""
+	}
+	return db.Dialector.Name()
 }
 
 // region dbOpener
@@ -75,13 +353,138 @@ func getDBOpener(driver DBDriver) DBOpener {
 // RegisterModel registers the given model to the database.
 // Arguments should be pointers to model structs.
 //
-// It calls gorm.AutoMigrate to migrate the database.
+// It calls gorm.AutoMigrate to migrate the database, model by model, and
+// the error is wrapped with the type of the model that caused it (e.g.
+// "migrate *main.User: ..."). It stops at the first failed model, unless
+// ContinueOnError is set, which migrates all the models and returns the
+// errors joined (see errors.Join).
+//
+// The MigrationPlan of the models is logged at debug level.
+// The migration behavior can be tuned by SetMigrationOptions.
 func RegisterModel(m ...any) error {
-	err := DB.AutoMigrate(m...)
-	if err != nil {
+	if logger.Logger.IsLevelEnabled(logrus.DebugLevel) {
+		if plan, err := MigrationPlan(m...); err != nil {
+			logger.WithError(err).Debug("RegisterModel: MigrationPlan failed")
+		} else {
+			logger.WithField("plan", plan).Debug("RegisterModel: migration plan")
+		}
+	}
+
+	db, release := Acquire()
+	defer release()
+	return autoMigrate(db, m...)
+}
+
+// autoMigrate migrates the models on the db, see RegisterModel.
+func autoMigrate(db *gorm.DB, m ...any) error {
+	db = migrationDB(db)
+	var errs []error
+	for _, model := range m {
+		if err := db.AutoMigrate(model); err != nil {
+			err = fmt.Errorf("migrate %T: %w", model, err)
+			logger.WithError(err).
+				Errorf("RegisterModel: AutoMigrate failed")
+			if !ContinueOnError {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ContinueOnError makes RegisterModel migrate the rest of the models
+// when one of them fails, instead of stopping at the first failure.
+var ContinueOnError = false
+
+// MigrationOptions are the flags of the migrations done by RegisterModel
+// (and RegisterModelWithMigrator). They are the migration flags of
+// gorm.Config, which is hidden by ConnectDB.
+type MigrationOptions struct {
+	// DisableForeignKeyConstraints makes the migrations not create the
+	// foreign key constraints of the relationships, which works around
+	// AutoMigrate failing to reorder the existing constraints on mysql.
+	// See gorm.Config.DisableForeignKeyConstraintWhenMigrating.
+	DisableForeignKeyConstraints bool
+
+	// IgnoreRelationships makes the migrations not migrate the associated
+	// models (and the many2many join tables) along with a model.
+	// See gorm.Config.IgnoreRelationshipsWhenMigrating.
+	IgnoreRelationships bool
+}
+
+// migrationOptions is set by SetMigrationOptions.
+var migrationOptions MigrationOptions
+
+// SetMigrationOptions sets the MigrationOptions used by the following
+// RegisterModel calls, for example:
+//
+//    orm.SetMigrationOptions(orm.MigrationOptions{DisableForeignKeyConstraints: true})
+//    orm.RegisterModel(&User{}, &Order{})
+//
+// Only the flags of MigrationOptions are supported. They apply to the
+// migrations only, the gorm.Config of DB is left untouched.
+func SetMigrationOptions(options MigrationOptions) {
+	migrationOptions = options
+}
+
+// migrationDB returns a session of the db with the migrationOptions applied.
+func migrationDB(db *gorm.DB) *gorm.DB {
+	db = db.Session(&gorm.Session{}).Clauses(dbresolver.Write) // the primary, see ConnectWithReplicas
+	config := *db.Config // a copy, not to affect DB
+	config.DisableForeignKeyConstraintWhenMigrating = migrationOptions.DisableForeignKeyConstraints
+	config.IgnoreRelationshipsWhenMigrating = migrationOptions.IgnoreRelationships
+	db.Config = &config
+	return db
+}
+
+// RegisterModelWithMigrator registers the given models (see RegisterModel),
+// and then calls fn with the migrator of DB to do the migrations that
+// AutoMigrate does not, like composite indexes or check constraints:
+//
+//    err := RegisterModelWithMigrator([]any{&Order{}}, func(m gorm.Migrator) error {
+//        if err := m.CreateIndex(&Order{}, "idx_user_status"); err != nil {
+//            return err
+//        }
+//        return m.CreateConstraint(&Order{}, "chk_orders_amount")
+//    })
+//
+// The CreateIndex and CreateConstraint of the migrator are idempotent: they
+// do nothing if the index or constraint exists. So that fn can be called
+// on every startup. An error returned by fn aborts the registration.
+func RegisterModelWithMigrator(models []any, fn func(m gorm.Migrator) error) error {
+	if err := RegisterModel(models...); err != nil {
+		return err
+	}
+	if fn == nil {
+		return nil
+	}
+	db, release := Acquire()
+	defer release()
+	if err := fn(idempotentMigrator{migrationDB(db).Migrator()}); err != nil {
 		logger.WithError(err).
-			Errorf("RegisterModel: AutoMigrate failed")
+			Errorf("RegisterModelWithMigrator: migrate failed")
 		return err
 	}
 	return nil
 }
+
+// idempotentMigrator is a gorm.Migrator that skips creating the existing
+// indexes and constraints.
+type idempotentMigrator struct {
+	gorm.Migrator
+}
+
+func (m idempotentMigrator) CreateIndex(dst any, name string) error {
+	if m.HasIndex(dst, name) {
+		return nil
+	}
+	return m.Migrator.CreateIndex(dst, name)
+}
+
+func (m idempotentMigrator) CreateConstraint(dst any, name string) error {
+	if m.HasConstraint(dst, name) {
+		return nil
+	}
+	return m.Migrator.CreateConstraint(dst, name)
+}
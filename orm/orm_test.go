@@ -0,0 +1,624 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"gorm.io/plugin/dbresolver"
+)
+
+type testOrder struct {
+	ID     uint
+	UserID uint   `gorm:"index:idx_user_status"`
+	Status string `gorm:"index:idx_user_status"`
+	Amount int    `gorm:"check:chk_orders_amount,amount >= 0"`
+}
+
+func setupTestDB(t *testing.T) {
+	t.Helper()
+
+	_, cleanup, err := ConnectTestDB(WithTestDBLogger(gormlogger.Discard))
+	if err != nil {
+		t.Fatalf("connect test db: %v", err)
+	}
+	t.Cleanup(cleanup)
+}
+
+func TestRegisterModelWithMigrator(t *testing.T) {
+	setupTestDB(t)
+
+	migrate := func(m gorm.Migrator) error {
+		if err := m.DropIndex(&testOrder{}, "idx_user_status"); err != nil {
+			return err
+		}
+		for i := 0; i < 2; i++ { // idempotent
+			if err := m.CreateIndex(&testOrder{}, "idx_user_status"); err != nil {
+				return err
+			}
+			if err := m.CreateConstraint(&testOrder{}, "chk_orders_amount"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := 0; i < 2; i++ { // on every startup
+		if err := RegisterModelWithMigrator([]any{&testOrder{}}, migrate); err != nil {
+			t.Fatalf("RegisterModelWithMigrator() error = %v", err)
+		}
+	}
+
+	if !DB.Migrator().HasIndex(&testOrder{}, "idx_user_status") {
+		t.Errorf("index not created")
+	}
+	if !DB.Migrator().HasConstraint(&testOrder{}, "chk_orders_amount") {
+		t.Errorf("constraint not created")
+	}
+}
+
+func TestRegisterModelWithMigrator_error(t *testing.T) {
+	setupTestDB(t)
+
+	errMigrate := errors.New("migrate failed")
+	err := RegisterModelWithMigrator([]any{&testOrder{}}, func(m gorm.Migrator) error {
+		return errMigrate
+	})
+	if !errors.Is(err, errMigrate) {
+		t.Errorf("RegisterModelWithMigrator() error = %v, want %v", err, errMigrate)
+	}
+}
+
+type testOrderV2 struct {
+	ID       uint
+	UserID   uint   `gorm:"index:idx_user_status"`
+	Status   string `gorm:"index:idx_user_status"`
+	Amount   int    `gorm:"check:chk_orders_amount,amount >= 0"`
+	Note     string
+	Tags     []*testTag `gorm:"many2many:test_order_tags"`
+	Currency string     `gorm:"index"`
+}
+
+func (testOrderV2) TableName() string {
+	return "test_orders"
+}
+
+type testTag struct {
+	ID   uint
+	Name string
+}
+
+func TestMigrationPlan(t *testing.T) {
+	setupTestDB(t)
+
+	plan, err := MigrationPlan(&testOrder{})
+	if err != nil {
+		t.Fatalf("MigrationPlan() error = %v", err)
+	}
+	if want := []string{"create table test_orders"}; !reflect.DeepEqual(plan, want) {
+		t.Errorf("MigrationPlan() before = %v, want %v", plan, want)
+	}
+
+	if err := RegisterModel(&testOrder{}); err != nil {
+		t.Fatalf("RegisterModel() error = %v", err)
+	}
+	if plan, _ := MigrationPlan(&testOrder{}); len(plan) != 0 {
+		t.Errorf("MigrationPlan() after = %v, want empty", plan)
+	}
+
+	plan, err = MigrationPlan(&testOrderV2{}, &testTag{})
+	if err != nil {
+		t.Fatalf("MigrationPlan() error = %v", err)
+	}
+	want := []string{
+		"add column test_orders.note",
+		"add column test_orders.currency",
+		"create index idx_test_orders_currency on test_orders",
+		"create table test_order_tags",
+		"create table test_tags",
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("MigrationPlan() = %v, want %v", plan, want)
+	}
+	if !DB.Migrator().HasTable(&testOrder{}) || DB.Migrator().HasColumn(&testOrderV2{}, "note") {
+		t.Errorf("MigrationPlan() altered the database")
+	}
+}
+
+type testOrderV3 struct {
+	ID     uint
+	UserID uint   `gorm:"index:idx_user_status"`
+	Status string `gorm:"index:idx_user_status;not null"`
+	Amount string `gorm:"check:chk_orders_amount,amount >= 0"`
+}
+
+func (testOrderV3) TableName() string {
+	return "test_orders"
+}
+
+func TestMigrationPlan_alterColumns(t *testing.T) {
+	setupTestDB(t)
+	if err := RegisterModel(&testOrder{}); err != nil {
+		t.Fatalf("RegisterModel() error = %v", err)
+	}
+
+	plan, err := MigrationPlan(&testOrderV3{})
+	if err != nil {
+		t.Fatalf("MigrationPlan() error = %v", err)
+	}
+	want := []string{
+		"alter column test_orders.status not null",
+		"alter column test_orders.amount type integer -> text",
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("MigrationPlan() = %v, want %v", plan, want)
+	}
+}
+
+type testBroken struct {
+	ID   uint
+	Data map[string]int // unsupported data type
+}
+
+func TestRegisterModel_error(t *testing.T) {
+	tests := []struct {
+		name            string
+		continueOnError bool
+		wantTag         bool // is the model after the broken one migrated?
+	}{
+		{"stop", false, false},
+		{"continue", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			ContinueOnError = tt.continueOnError
+			t.Cleanup(func() { ContinueOnError = false })
+
+			err := RegisterModel(&testOrder{}, &testBroken{}, &testTag{})
+			if err == nil || !strings.Contains(err.Error(), "migrate *orm.testBroken") {
+				t.Fatalf("RegisterModel() error = %v, want the broken model in it", err)
+			}
+			if !DB.Migrator().HasTable(&testOrder{}) {
+				t.Errorf("model before the broken one not migrated")
+			}
+			if got := DB.Migrator().HasTable(&testTag{}); got != tt.wantTag {
+				t.Errorf("model after the broken one migrated = %v, want %v", got, tt.wantTag)
+			}
+		})
+	}
+}
+
+type testAuthor struct {
+	ID    uint
+	Books []testBook `gorm:"foreignKey:AuthorID"`
+}
+
+type testBook struct {
+	ID       uint
+	AuthorID uint
+}
+
+func TestSetMigrationOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options MigrationOptions
+		wantFK  bool
+	}{
+		{"default", MigrationOptions{}, true},
+		{"disable foreign keys", MigrationOptions{DisableForeignKeyConstraints: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			SetMigrationOptions(tt.options)
+			t.Cleanup(func() { SetMigrationOptions(MigrationOptions{}) })
+
+			if err := RegisterModel(&testAuthor{}, &testBook{}); err != nil {
+				t.Fatalf("RegisterModel() error = %v", err)
+			}
+			if !DB.Migrator().HasTable(&testBook{}) {
+				t.Fatalf("table of testBook not created")
+			}
+			if got := DB.Migrator().HasConstraint(&testAuthor{}, "Books"); got != tt.wantFK {
+				t.Errorf("HasConstraint(Books) = %v, want %v", got, tt.wantFK)
+			}
+			if DB.Config.DisableForeignKeyConstraintWhenMigrating {
+				t.Errorf("the gorm.Config of DB is changed")
+			}
+		})
+	}
+}
+
+func TestConnectDBContext(t *testing.T) {
+	t.Cleanup(func() { SetDB(nil) })
+
+	db, err := ConnectDBContext(context.Background(), DBDriverSqlite, ":memory:")
+	if err != nil {
+		t.Fatalf("ConnectDBContext() error = %v", err)
+	}
+	if DB != db {
+		t.Errorf("DB = %v, want the connected %v", DB, db)
+	}
+	sqlDB, _ := db.DB()
+	_ = sqlDB.Close()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	SetDB(nil)
+	if _, err := ConnectDBContext(canceled, DBDriverSqlite, ":memory:"); !errors.Is(err, context.Canceled) {
+		t.Errorf("ConnectDBContext(canceled) error = %v, want %v", err, context.Canceled)
+	}
+	if DB != nil {
+		t.Errorf("DB = %v, want nil on failure", DB)
+	}
+
+	// a server accepting the connections but never responding:
+	// fails by the deadline instead of the connect_timeout
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				_ = conn.Close()
+			}
+		}()
+		for {
+			conn, err := listener.Accept()
+			if err != nil { // closed
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	addr := listener.Addr().(*net.TCPAddr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	dsn := fmt.Sprintf("host=127.0.0.1 port=%d user=u dbname=d connect_timeout=30 sslmode=disable", addr.Port)
+	_, err = ConnectDBContext(ctx, DBDriverPostgres, dsn)
+	if err == nil {
+		t.Errorf("ConnectDBContext(unresponsive) error = nil, want an error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ConnectDBContext(unresponsive) took %v, want it bounded by the deadline", elapsed)
+	}
+}
+
+func TestConnectTestDB(t *testing.T) {
+	db1, cleanup1, err := ConnectTestDB(&testTag{})
+	if err != nil {
+		t.Fatalf("ConnectTestDB() error = %v", err)
+	}
+	defer cleanup1()
+	db2, cleanup2, err := ConnectTestDB(&testTag{})
+	if err != nil {
+		t.Fatalf("ConnectTestDB() error = %v", err)
+	}
+	if DB != db2 {
+		t.Errorf("DB = %v, want the latest %v", DB, db2)
+	}
+
+	if err := db1.Create(&testTag{Name: "db1"}).Error; err != nil {
+		t.Fatalf("create tag: %v", err)
+	}
+	var count int64
+	db2.Model(&testTag{}).Count(&count)
+	if count != 0 {
+		t.Errorf("count in db2 = %v, want 0 (isolated from db1)", count)
+	}
+
+	cleanup2()
+	if DB != nil {
+		t.Errorf("DB = %v, want nil after cleanup", DB)
+	}
+	if err := db2.Exec("SELECT 1").Error; err == nil {
+		t.Errorf("db2 is not closed after cleanup")
+	}
+	db1.Model(&testTag{}).Count(&count)
+	if count != 1 {
+		t.Errorf("count in db1 = %v, want 1", count)
+	}
+}
+
+func TestDialectName(t *testing.T) {
+	SetDB(nil)
+	if got := DialectName(); got != "" {
+		t.Errorf("DialectName() before connecting = %q, want empty", got)
+	}
+	setupTestDB(t)
+	if got := DialectName(); got != "sqlite" {
+		t.Errorf("DialectName() = %q, want %q", got, "sqlite")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	dir := t.TempDir()
+	t.Cleanup(func() { SetDB(nil) })
+
+	old, err := ConnectDB(DBDriverSqlite, filepath.Join(dir, "old.db"))
+	if err != nil {
+		t.Fatalf("ConnectDB() error = %v", err)
+	}
+	if err := RegisterModel(&testTag{}); err != nil {
+		t.Fatal(err)
+	}
+	DB.Create(&testTag{Name: "old"})
+
+	if _, err := Reconnect(DBDriverSqlite, filepath.Join(dir, "missing", "new.db")); err == nil {
+		t.Errorf("Reconnect(bad dsn) error = nil, want an error")
+	}
+	if DB != old {
+		t.Errorf("DB is swapped on a failed Reconnect")
+	}
+
+	held, release := Acquire() // a query in flight on the old one
+	if held != old {
+		t.Fatalf("Acquire() = %v, want the connected %v", held, old)
+	}
+
+	db, err := Reconnect(DBDriverSqlite, filepath.Join(dir, "new.db"))
+	if err != nil {
+		t.Fatalf("Reconnect() error = %v", err)
+	}
+	if Current() != db || Current() == old {
+		t.Fatalf("Current() = %v, want the reconnected %v", Current(), db)
+	}
+	if DB != old {
+		t.Errorf("DB = %v, want the old %v: the deprecated DB is not swapped", DB, old)
+	}
+	if err := RegisterModel(&testTag{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&testTag{Name: "new"})
+
+	var tags []testTag
+	db.Find(&tags)
+	if len(tags) != 1 || tags[0].Name != "new" {
+		t.Errorf("tags in DB = %v, want only the new one", tags)
+	}
+
+	if acquired, release := Acquire(); acquired != db {
+		t.Errorf("Acquire() after Reconnect = %v, want the reconnected %v", acquired, db)
+	} else {
+		release()
+	}
+
+	// the old pool works until released, and is closed after that
+	time.Sleep(50 * time.Millisecond)
+	if err := held.Find(&tags).Error; err != nil || len(tags) != 1 || tags[0].Name != "old" {
+		t.Errorf("tags in old = %v (err=%v), want the old one", tags, err)
+	}
+	release()
+	release() // no-op
+	closed := false
+	for i := 0; i < 100 && !closed; i++ {
+		time.Sleep(10 * time.Millisecond)
+		closed = old.Exec("SELECT 1").Error != nil
+	}
+	if !closed {
+		t.Errorf("old database is not closed after released")
+	}
+	sqlDB, _ := db.DB()
+	_ = sqlDB.Close()
+}
+
+func TestConnectWithReplicas(t *testing.T) {
+	dir := t.TempDir()
+	primary, replica := filepath.Join(dir, "primary.db"), filepath.Join(dir, "replica.db")
+	t.Cleanup(func() { SetDB(nil) })
+
+	// a stale replica: the table is there, but not the rows in the primary
+	replicaDB, err := gorm.Open(sqlite.Open(replica), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replicaDB.AutoMigrate(&testTag{}); err != nil {
+		t.Fatal(err)
+	}
+	replicaDB.Create(&testTag{Name: "replica"})
+
+	db, err := ConnectWithReplicas(DBDriverSqlite, primary, []string{replica})
+	if err != nil {
+		t.Fatalf("ConnectWithReplicas() error = %v", err)
+	}
+	if DB != db {
+		t.Errorf("DB = %v, want the connected %v", DB, db)
+	}
+	if err := RegisterModel(&testTag{}); err != nil { // on the primary
+		t.Fatalf("RegisterModel() error = %v", err)
+	}
+	if err := DB.Create(&testTag{Name: "primary"}).Error; err != nil {
+		t.Fatalf("create on primary: %v", err)
+	}
+
+	var tags []testTag
+	DB.Find(&tags)
+	if len(tags) != 1 || tags[0].Name != "replica" {
+		t.Errorf("read = %v, want from the replica", tags)
+	}
+	DB.Clauses(dbresolver.Write).Find(&tags)
+	if len(tags) != 1 || tags[0].Name != "primary" {
+		t.Errorf("read with Write clause = %v, want from the primary", tags)
+	}
+	_ = DB.Transaction(func(tx *gorm.DB) error {
+		tx.Find(&tags)
+		return nil
+	})
+	if len(tags) != 1 || tags[0].Name != "primary" {
+		t.Errorf("read in transaction = %v, want from the primary", tags)
+	}
+
+	var count int64
+	replicaDB.Model(&testTag{}).Where("name = ?", "primary").Count(&count)
+	if count != 0 {
+		t.Errorf("the write went to the replica")
+	}
+
+	// the replicas are kept by ReconnectWithReplicas
+	db, err = ReconnectWithReplicas(DBDriverSqlite, filepath.Join(dir, "new.db"), []string{replica})
+	if err != nil {
+		t.Fatalf("ReconnectWithReplicas() error = %v", err)
+	}
+	if Current() != db {
+		t.Errorf("Current() = %v, want the reconnected %v", Current(), db)
+	}
+	if err := Current().Find(&tags).Error; err != nil || len(tags) != 1 || tags[0].Name != "replica" {
+		t.Errorf("read after ReconnectWithReplicas = %v (err=%v), want from the replica", tags, err)
+	}
+}
+
+type DocumentKey struct { // exported to be embedded in a gorm model
+	Uuid string `gorm:"primaryKey;column:doc_uuid"`
+}
+
+type testDocument struct {
+	DocumentKey
+	Title string
+}
+
+func (d testDocument) Identity() (fieldName string, value any) {
+	return "Uuid", d.Uuid
+}
+
+type testNote struct {
+	NoteKey string `gorm:"primaryKey"`
+}
+
+func (n testNote) Identity() (fieldName string, value any) {
+	return "note_key", n.NoteKey // the column name
+}
+
+type testNoIdentity struct {
+	Name string
+}
+
+func (testNoIdentity) Identity() (fieldName string, value any) {
+	return "Missing", nil
+}
+
+func TestIdentityColumn(t *testing.T) {
+	tests := []struct {
+		name       string
+		model      Model
+		wantField  string
+		wantColumn string
+	}{
+		{"basic", HardModel{}, "ID", "id"},
+		{"embedded with column tag", testDocument{}, "Uuid", "doc_uuid"},
+		{"by column name", testNote{}, "NoteKey", "note_key"},
+		{"unknown", testNoIdentity{}, "Missing", "Missing"},
+	}
+	for _, db := range []string{"disconnected", "connected"} {
+		if db == "connected" {
+			setupTestDB(t)
+		} else {
+			SetDB(nil)
+		}
+		for _, tt := range tests {
+			t.Run(db+"/"+tt.name, func(t *testing.T) {
+				field, column := IdentityColumn(tt.model)
+				if field != tt.wantField || column != tt.wantColumn {
+					t.Errorf("IdentityColumn() = (%q, %q), want (%q, %q)",
+						field, column, tt.wantField, tt.wantColumn)
+				}
+			})
+		}
+	}
+}
+
+type testPost struct {
+	ID        uint
+	Title     string
+	CreatedBy string
+	UpdatedBy string
+}
+
+func TestRegisterAuditCallbacks(t *testing.T) {
+	setupTestDB(t)
+	if err := DB.AutoMigrate(&testPost{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	type userKey struct{}
+	err := RegisterAuditCallbacks(func(ctx context.Context) any {
+		if user, ok := ctx.Value(userKey{}).(string); ok {
+			return user
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterAuditCallbacks: %v", err)
+	}
+	t.Cleanup(func() { auditUserFromCtx.Store(nil) })
+
+	alice := context.WithValue(context.Background(), userKey{}, "alice")
+	bob := context.WithValue(context.Background(), userKey{}, "bob")
+
+	check := func(name, wantCreatedBy, wantUpdatedBy string) {
+		t.Helper()
+		var got testPost
+		if err := DB.First(&got, "title = ?", name).Error; err != nil {
+			t.Fatalf("get %v: %v", name, err)
+		}
+		if got.CreatedBy != wantCreatedBy || got.UpdatedBy != wantUpdatedBy {
+			t.Errorf("%v: created_by, updated_by = %q, %q, want %q, %q",
+				name, got.CreatedBy, got.UpdatedBy, wantCreatedBy, wantUpdatedBy)
+		}
+	}
+
+	post := testPost{Title: "p", CreatedBy: "mallory"}
+	if err := DB.WithContext(alice).Create(&post).Error; err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	check("p", "alice", "alice")
+
+	posts := []*testPost{{Title: "q"}, {Title: "r"}}
+	if err := DB.WithContext(bob).Create(&posts).Error; err != nil {
+		t.Fatalf("create in batch: %v", err)
+	}
+	check("q", "bob", "bob")
+	check("r", "bob", "bob")
+
+	if err := DB.Create(&testPost{Title: "anonymous"}).Error; err != nil {
+		t.Fatalf("create without user: %v", err)
+	}
+	check("anonymous", "", "")
+
+	post.CreatedBy = "mallory"
+	if err := DB.WithContext(bob).Save(&post).Error; err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	check("p", "alice", "bob")
+
+	err = DB.WithContext(alice).Model(&testPost{}).Where("title = ?", "q").
+		Updates(map[string]any{"title": "q2"}).Error
+	if err != nil {
+		t.Fatalf("updates: %v", err)
+	}
+	check("q2", "bob", "alice")
+
+	// installed to the test databases connected later as well
+	db, cleanup, err := ConnectTestDB(&testPost{}, WithTestDBLogger(gormlogger.Discard))
+	if err != nil {
+		t.Fatalf("ConnectTestDB: %v", err)
+	}
+	t.Cleanup(cleanup)
+	if err := db.WithContext(alice).Create(&testPost{Title: "s"}).Error; err != nil {
+		t.Fatalf("create in the test db: %v", err)
+	}
+	check("s", "alice", "alice")
+}
@@ -0,0 +1,85 @@
+package orm
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// ConnectWithReplicas connects to the primary database (like ConnectDB)
+// with read replicas, and initializes the global DB: the reads (queries,
+// and raw SQL starting with SELECT) are routed to a replica chosen at
+// random, the writes (creates, updates, deletes and other raw SQL) to the
+// primary. It is transparent to the service layer:
+//
+//    ConnectWithReplicas(DBDriverPostgres, "host=primary ...",
+//        []string{"host=replica1 ...", "host=replica2 ..."})
+//
+// The replicas use the same driver as the primary.
+//
+// Notice: the replicas lag behind the primary, so that a read right after
+// a write (e.g. getting the model just created) may miss it. To read from
+// the primary, use the Write clause (service.ReadPrimary() as a
+// QueryOption) or a transaction, which stays on the primary for all its
+// statements (reads included):
+//
+//    orm.Current().Clauses(dbresolver.Write).First(&user, id)
+//    orm.Current().Transaction(func(tx *gorm.DB) error { ... })
+//
+// The row locking queries (SELECT ... FOR UPDATE) go to the primary too.
+// And RegisterModel migrates the primary only.
+//
+// Use ReconnectWithReplicas to swap it, Reconnect drops the replicas.
+func ConnectWithReplicas(driver DBDriver, primaryDSN string, replicaDSNs []string) (*gorm.DB, error) {
+	db, err := openWithReplicas(driver, primaryDSN, replicaDSNs)
+	if err != nil {
+		return nil, err
+	}
+	SetDB(db)
+	return db, nil
+}
+
+// ReconnectWithReplicas is Reconnect to the primary database with read
+// replicas, see ConnectWithReplicas.
+func ReconnectWithReplicas(driver DBDriver, primaryDSN string, replicaDSNs []string) (*gorm.DB, error) {
+	db, err := openWithReplicas(driver, primaryDSN, replicaDSNs)
+	if err != nil {
+		logger.WithField("driver", driver).WithError(err).
+			Error("ReconnectWithReplicas: open database failed")
+		return nil, err
+	}
+	reconnect(db)
+	logger.WithField("driver", driver).WithField("replicas", len(replicaDSNs)).
+		Info("ReconnectWithReplicas: database swapped")
+	return db, nil
+}
+
+// openWithReplicas opens the primary database with the read replicas
+// registered, see ConnectWithReplicas. It does not touch the global DB.
+func openWithReplicas(driver DBDriver, primaryDSN string, replicaDSNs []string) (*gorm.DB, error) {
+	db, err := openDB(context.Background(), driver, primaryDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	driverOpen := getDBOpener(driver)
+	replicas := make([]gorm.Dialector, 0, len(replicaDSNs))
+	for _, dsn := range replicaDSNs {
+		replicas = append(replicas, driverOpen(dsn))
+	}
+
+	err = db.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: replicas,
+		Policy:   dbresolver.RandomPolicy{},
+	}))
+	if err != nil {
+		logger.WithField("driver", driver).WithError(err).
+			Error("ConnectWithReplicas: register replicas failed")
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,155 @@
+package orm
+
+import (
+	"context"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// Scope is a gorm scope (see gorm.DB.Scopes) applied to the queries.
+// The context of the query is available as tx.Statement.Context.
+type Scope func(tx *gorm.DB) *gorm.DB
+
+var (
+	scopesMu sync.RWMutex
+	scopes   []namedScope // in the registered order
+)
+
+type namedScope struct {
+	name  string
+	scope Scope
+	optIn bool // applied only if the context opts in, see UseScope
+}
+
+// RegisterScope registers a global scope named name, which the service
+// package applies to every query, update and delete it makes (Get, GetMany,
+// Stream, Count, UpdateField, UpdateFields, Delete, ...). Registering a
+// scope of an existing name replaces it.
+//
+// It is used to enforce conditions without touching each call site, e.g.
+// scoping every query to the tenant of the request, which is set to the
+// context by a middleware (c.Set("tenant", tenantID)):
+//
+//    orm.RegisterScope("tenant", func(tx *gorm.DB) *gorm.DB {
+//        if tenant, ok := tx.Statement.Context.Value("tenant").(string); ok {
+//            return tx.Where("tenant_id = ?", tenant)
+//        }
+//        return tx
+//    })
+//
+// Scopes are additive to the query options: both are AND-ed. A scope
+// should check the tx.Statement.Model (or Dest) before touching the models
+// which it is not meant for, as it applies to all models.
+//
+// Notice: the scopes are not applied to the Create, the Update (Save)
+// which would insert the model out of the scope instead, the association
+// operations, and the raw SQL. Use AppliedScopes to apply them to the
+// custom queries.
+//
+// A context can opt out of the scope by SkipScope, e.g. for the admin
+// jobs across the tenants.
+func RegisterScope(name string, scope Scope) {
+	registerScope(namedScope{name, scope, false})
+}
+
+// RegisterOptInScope registers a scope like RegisterScope, but it is only
+// applied to the queries of the contexts opting in by UseScope:
+//
+//    orm.RegisterOptInScope("published", func(tx *gorm.DB) *gorm.DB {
+//        return tx.Where("published_at IS NOT NULL")
+//    })
+//
+//    service.GetMany[Post](orm.UseScope(ctx, "published"), &posts)
+func RegisterOptInScope(name string, scope Scope) {
+	registerScope(namedScope{name, scope, true})
+}
+
+// registerScope registers the scope, replacing the one of the same name.
+func registerScope(scope namedScope) {
+	scopesMu.Lock()
+	defer scopesMu.Unlock()
+
+	for i := range scopes {
+		if scopes[i].name == scope.name {
+			scopes[i] = scope
+			return
+		}
+	}
+	scopes = append(scopes, scope)
+}
+
+// AppliedScopes returns tx with the registered scopes applied: the ones of
+// RegisterScope, unless the context of tx skips them (by SkipScope), and
+// the ones of RegisterOptInScope the context uses (by UseScope).
+func AppliedScopes(tx *gorm.DB) *gorm.DB {
+	selection, _ := tx.Statement.Context.Value(scopeSelectionKey{}).(scopeSelection)
+
+	scopesMu.RLock()
+	defer scopesMu.RUnlock()
+
+	for _, s := range scopes {
+		if selection.skip[s.name] || (s.optIn && !selection.use[s.name]) {
+			continue
+		}
+		tx = tx.Scopes(s.scope)
+	}
+	return tx
+}
+
+// scopeSelectionKey is the context key of the scopeSelection.
+type scopeSelectionKey struct{}
+
+// scopeSelection is the scopes used (opted in) and skipped (opted out)
+// by a context, see UseScope and SkipScope.
+type scopeSelection struct {
+	use  map[string]bool
+	skip map[string]bool
+}
+
+// UseScope returns a copy of ctx that opts in the scopes of names
+// registered by RegisterOptInScope. The queries with the context (see
+// AppliedScopes) apply them.
+// It cancels the SkipScope of the names on ctx, if any.
+func UseScope(ctx context.Context, names ...string) context.Context {
+	return selectScopes(ctx, names, true)
+}
+
+// SkipScope returns a copy of ctx that opts out of the scopes of names
+// (registered by RegisterScope or RegisterOptInScope). The queries with
+// the context (see AppliedScopes) do not apply them.
+// It cancels the UseScope of the names on ctx, if any.
+func SkipScope(ctx context.Context, names ...string) context.Context {
+	return selectScopes(ctx, names, false)
+}
+
+// selectScopes returns a copy of ctx with the names used (or skipped).
+// The selection on ctx is copied, not changed.
+func selectScopes(ctx context.Context, names []string, use bool) context.Context {
+	old, _ := ctx.Value(scopeSelectionKey{}).(scopeSelection)
+	selection := scopeSelection{use: map[string]bool{}, skip: map[string]bool{}}
+	for name := range old.use {
+		selection.use[name] = true
+	}
+	for name := range old.skip {
+		selection.skip[name] = true
+	}
+	for _, name := range names {
+		selection.use[name] = use
+		selection.skip[name] = !use
+	}
+	return context.WithValue(ctx, scopeSelectionKey{}, selection)
+}
+
+// UnregisterScope removes the scope named name registered by RegisterScope.
+func UnregisterScope(name string) {
+	scopesMu.Lock()
+	defer scopesMu.Unlock()
+
+	for i := range scopes {
+		if scopes[i].name == name {
+			scopes = append(scopes[:i:i], scopes[i+1:]...)
+			return
+		}
+	}
+}
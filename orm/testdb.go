@@ -0,0 +1,95 @@
+package orm
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/cdfmlr/crud/log"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// testDBSeq numbers the databases opened by ConnectTestDB.
+var testDBSeq atomic.Int64
+
+// ConnectTestDB connects to a fresh in-memory sqlite database and migrates
+// the given models (see RegisterModel) for tests. The returned cleanup
+// closes the database, which is gone with nothing left on the disk:
+//
+//    func TestUser(t *testing.T) {
+//        db, cleanup, err := orm.ConnectTestDB(&User{}, &Order{})
+//        if err != nil {
+//            t.Fatal(err)
+//        }
+//        t.Cleanup(cleanup)
+//        ...
+//    }
+//
+// Each call opens a database of its own (by a unique dsn), isolated from
+// the others, so that it is safe to call in parallel tests.
+//
+// The TestDBOptions given among the models configure the database, e.g.
+// to silence the SQL logs:
+//
+//    orm.ConnectTestDB(&User{}, orm.WithTestDBLogger(gormlogger.Discard))
+//
+// The audit callbacks (see RegisterAuditCallbacks) are installed as
+// ConnectDB does.
+//
+// The global DB is set to the opened database, as ConnectDB does, and
+// reset to nil by the cleanup (if it is not replaced since). Notice that
+// the parallel tests share the global DB, use the returned db in them
+// instead.
+func ConnectTestDB(models ...any) (db *gorm.DB, cleanup func(), err error) {
+	config := &gorm.Config{
+		Logger: log.Logger4Gorm,
+	}
+	var toMigrate []any
+	for _, model := range models {
+		if option, ok := model.(TestDBOption); ok {
+			option(config)
+			continue
+		}
+		toMigrate = append(toMigrate, model)
+	}
+
+	dsn := fmt.Sprintf("file:crud_test_%d?mode=memory&cache=shared", testDBSeq.Add(1))
+	db, err = gorm.Open(sqlite.Open(dsn), config)
+	if err != nil {
+		return nil, nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup = func() {
+		_ = sqlDB.Close()
+		if Current() == db {
+			SetDB(nil)
+		}
+	}
+
+	if err = installAuditCallbacks(db); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if err = autoMigrate(db, toMigrate...); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	SetDB(db)
+	return db, cleanup, nil
+}
+
+// TestDBOption is an option of ConnectTestDB, given among the models.
+type TestDBOption func(config *gorm.Config)
+
+// WithTestDBLogger sets the logger of the test database, which is
+// log.Logger4Gorm by default.
+func WithTestDBLogger(logger gormlogger.Interface) TestDBOption {
+	return func(config *gorm.Config) {
+		config.Logger = logger
+	}
+}
@@ -4,14 +4,16 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/gofrs/uuid"
+	"regexp"
 	"time"
 )
 
 // RequestID is a middleware that adds a `request_id` value to the context as
 // well as a `X-Request-ID` header to the response.
 //
-// The `request_id` is got from the request header "X-Request-ID", and
-// if not found, a new one is generated as a UUIDv3 based on the client IP,
+// The `request_id` is got from the request header "X-Request-ID" (set by
+// the caller or the load balancer) if it is well-formed (see
+// isValidRequestID), otherwise a new one is generated as a UUIDv3 based on the client IP,
 // request method, request URI and time (time is got from
 // `c.GetString("start_time")` and if not found, it is set to time.Now())
 //
@@ -31,7 +33,7 @@ func RequestID() gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		id := c.Request.Header.Get("X-Request-Id")
-		if id == "" {
+		if !isValidRequestID(id) {
 			startTime := c.GetString("start_time")
 			if startTime == "" {
 				startTime = time.Now().String()
@@ -52,3 +54,18 @@ func RequestID() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// maxRequestIDLength is the max length of a request id from the header.
+const maxRequestIDLength = 128
+
+// requestIDRegexp: the characters allowed in a request id from the header,
+// which covers UUIDs, ULIDs, base64 and the trace ids of common proxies
+// (e.g. "Root=1-5759e988-bd862e3fe1be46a994272793" of AWS ELB).
+var requestIDRegexp = regexp.MustCompile(`^[A-Za-z0-9._:;=+/-]+$`)
+
+// isValidRequestID reports whether the request id from the header is
+// well-formed: not empty, not too long, and no unexpected characters
+// (which may be used to forge the logs).
+func isValidRequestID(id string) bool {
+	return id != "" && len(id) <= maxRequestIDLength && requestIDRegexp.MatchString(id)
+}
@@ -0,0 +1,48 @@
+package gin_request_id
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID())
+	r.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("request_id"))
+	})
+
+	tests := []struct {
+		name     string
+		header   string
+		wantSame bool // want the header reused
+	}{
+		{"known id", "3f2b8c1e-9d4a-4b7e-8f6a-1c2d3e4f5a6b", true},
+		{"aws trace id", "Root=1-5759e988-bd862e3fe1be46a994272793", true},
+		{"absent", "", false},
+		{"malformed", "id with spaces\nand newline", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header["X-Request-Id"] = []string{tt.header}
+			}
+			r.ServeHTTP(w, req)
+
+			inContext, inResponse := w.Body.String(), w.Header().Get("X-Request-Id")
+			if inContext == "" || inContext != inResponse {
+				t.Errorf("context id = %q, response id = %q, want the same non-empty id", inContext, inResponse)
+			}
+			if got := inContext == tt.header; got != tt.wantSame {
+				t.Errorf("id = %q, header = %q, want reused = %v", inContext, tt.header, tt.wantSame)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package gormlogrus
+
+import (
+	"bytes"
+	"context"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"testing"
+)
+
+func TestLogger_formatArgs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+	l := Use(logrus.NewEntry(logger))
+
+	ctx := context.Background()
+	l.Info(ctx, "info %s %d", "a", 1)
+	l.Warn(ctx, "warn %s %d", "b", 2)
+	l.Error(ctx, "error %s %d", "c", 3)
+
+	out := buf.String()
+	if strings.Contains(out, "%!") {
+		t.Errorf("bad format verbs in logs: %s", out)
+	}
+	for _, want := range []string{"info a 1", "warn b 2", "error c 3"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("logs %q does not contain %q", out, want)
+		}
+	}
+}
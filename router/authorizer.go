@@ -0,0 +1,84 @@
+package router
+
+import (
+	"github.com/cdfmlr/crud/controller"
+	"github.com/gin-gonic/gin"
+	"strconv"
+)
+
+// Authorizer authorizes a request to do the action (ActionList, ActionRead,
+// ActionCreate, ActionUpdate, ActionDelete) on the model (the type name,
+// or the name given by orm.ResourceNamer, see controller.ResourceNames).
+// The request is rejected with 403 Forbidden if a non-nil error returned.
+type Authorizer func(c *gin.Context, action string, model string) error
+
+// WithAuthorizer adds an Authorizer to every route of the Crud group.
+// The Authorizer runs before the handler does any database work.
+//
+// The nested routes are treated as actions on the parent model:
+//    - GetNested    => ActionRead   on the parent
+//    - CreateNested => ActionUpdate on the parent
+//    - DeleteNested => ActionUpdate on the parent
+//
+// The soft-deleted records (?with_trashed=true or ?only_trashed=true) are
+// denied by default (unless WithTrashedAccess): such requests are
+// authorized for ActionReadTrashed as well, and allowed if all the
+// Authorizers allow it (see controller.AllowTrashed).
+//
+// Example:
+//    Crud[User](r, "/users", WithAuthorizer(
+//        func(c *gin.Context, action string, model string) error {
+//            if action != ActionList && action != ActionRead && !isAdmin(c) {
+//                return errors.New("admin only")
+//            }
+//            return nil
+//        }),
+//    )
+func WithAuthorizer(authorizer Authorizer) CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		builder := getBuilder(group)
+		if builder == nil {
+			logger.Warn("WithAuthorizer: not in Crud, use group.Use() instead. Ignored.")
+			return group
+		}
+		builder.authorizers = append(builder.authorizers, authorizer)
+		return group
+	}
+}
+
+// authorize makes a middleware that authorizes the action on model
+// by the authorizer.
+func authorize(authorizer Authorizer, action string, model string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actions := []string{action}
+		if (action == ActionList || action == ActionRead) && wantsTrashed(c) {
+			actions = append(actions, ActionReadTrashed)
+		}
+		for _, action := range actions {
+			if err := authorizer(c, action, model); err != nil {
+				logger.WithContext(c).WithError(err).
+					WithField("action", action).
+					WithField("model", model).
+					Warn("authorize: forbidden")
+				controller.ResponseError(c, controller.CodeForbidden, err)
+				c.Abort()
+				return
+			}
+			if action == ActionReadTrashed {
+				controller.AllowTrashed(c)
+			}
+		}
+		c.Next()
+	}
+}
+
+// wantsTrashed reports whether the request asks for the soft-deleted
+// records, see controller.GetRequestOptions.
+func wantsTrashed(c *gin.Context) bool {
+	for _, key := range []string{"with_trashed", "only_trashed"} {
+		if ok, _ := strconv.ParseBool(c.Query(key)); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -4,8 +4,14 @@ import (
 	"fmt"
 	"github.com/cdfmlr/crud/controller"
 	"github.com/cdfmlr/crud/orm"
+	"github.com/cdfmlr/crud/service"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"net/http"
 	"reflect"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Crud add a group of CRUD routes for model T to the base router
@@ -24,10 +30,13 @@ import (
 //      POST /users/
 //       PUT /users/:UserId
 //    DELETE /users/:UserId
+// which can be limited by ReadOnly(), WriteOnly(), ExceptDelete() or Without().
 // and with options parameters, it's optional to add the following routes:
-//    - GetNested()    =>    GET /users/:UserId/friends
-//    - CreateNested() =>   POST /users/:UserId/friends
-//    - DeleteNested() => DELETE /users/:UserId/friends/:FriendId
+//    - GetNested()     =>    GET /users/:UserId/friends
+//    - CreateNested()  =>   POST /users/:UserId/friends
+//    - ReplaceNested() =>    PUT /users/:UserId/friends
+//    - DeleteNested()  => DELETE /users/:UserId/friends/:FriendId
+//    - Patch()         =>  PATCH /users/:UserId
 func Crud[T orm.Model](base gin.IRouter, relativePath string, options ...CrudOption) gin.IRouter {
 	group := base.Group(relativePath)
 
@@ -38,12 +47,18 @@ func Crud[T orm.Model](base gin.IRouter, relativePath string, options ...CrudOpt
 			Info("Crud: Adding CRUD routes for model")
 	}
 
+	builder := &crudBuilder{}
+	builders.Store(group, builder)
+	defer builders.Delete(group)
+
 	options = append(options, crud[T]())
 
 	for _, option := range options {
 		group = option(group)
 	}
 
+	builder.register(group)
+
 	return group
 }
 
@@ -55,6 +70,168 @@ func Crud[T orm.Model](base gin.IRouter, relativePath string, options ...CrudOpt
 // Or use CrudNested to add all three options above.
 type CrudOption func(group *gin.RouterGroup) *gin.RouterGroup
 
+// Actions of the crud routes, see Authorizer.
+const (
+	ActionList   = "list"
+	ActionRead   = "read"
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+
+	// ActionReadTrashed is authorized in addition to ActionList or
+	// ActionRead when the request asks for the soft-deleted records
+	// (with_trashed or only_trashed), which are allowed then.
+	ActionReadTrashed = "read_trashed"
+)
+
+// crudRoute is a route added by Crud and its options.
+type crudRoute struct {
+	method  string
+	path    string
+	action  string // ActionList, ActionRead, ...
+	model   string // type name of the model that the action is on
+	handler gin.HandlerFunc
+	base    bool // one of the base routes of model T (not nested)
+
+	// types of the request and response bodies (nil if no model in it),
+	// used to generate the OpenAPI spec.
+	request, response reflect.Type
+}
+
+// crudBuilder collects the routes and settings from the options of Crud,
+// and registers the routes after all options are applied. So that settings
+// (like WithAuthorizer) take effect on all routes of the group, no matter
+// the order of options.
+type crudBuilder struct {
+	routes      []pendingRoute
+	authorizers []Authorizer
+
+	// the id route param of model T, overrides the derived default
+	idParam string
+
+	// the id route param is a wildcard (*idParam), see WithWildcardID
+	wildcardID bool
+
+	// methods of the base routes that are not registered
+	excludedMethods map[string]bool
+
+	// options of the list route of model T
+	listOptions []controller.ListOption
+
+	// options of the create route of model T
+	createOptions []controller.CreateOption
+
+	// options of the update route of model T
+	updateOptions []controller.UpdateOption
+
+	// options of the bulk create route of model T
+	bulkCreateOptions []controller.BulkCreateOption
+
+	// options of all the routes of model T, see addModelOption
+	modelOptions []controller.ModelOption
+}
+
+// addModelOption adds the option to the options of all the routes of
+// model T.
+func (b *crudBuilder) addModelOption(option controller.ModelOption) {
+	b.listOptions = append(b.listOptions, option)
+	b.createOptions = append(b.createOptions, option)
+	b.updateOptions = append(b.updateOptions, option)
+	b.bulkCreateOptions = append(b.bulkCreateOptions, option)
+	b.modelOptions = append(b.modelOptions, option)
+}
+
+// pendingRoute is a route waiting for the id param of the group to be
+// determined (by WithIDParam or the derived default).
+type pendingRoute struct {
+	defaultIdParam string
+	newRoute       func(idParam string) crudRoute
+
+	// replaces the base route of the same method and path, see replaceRoute
+	replace bool
+}
+
+// builders: *gin.RouterGroup => *crudBuilder, for the groups being built by Crud.
+var builders sync.Map
+
+// getBuilder returns the crudBuilder of the group,
+// or nil if the group is not being built by Crud.
+func getBuilder(group *gin.RouterGroup) *crudBuilder {
+	if builder, ok := builders.Load(group); ok {
+		return builder.(*crudBuilder)
+	}
+	return nil
+}
+
+// addRoute adds a route built by newRoute with the id param of the group
+// (defaultIdParam if not set by WithIDParam). The route is registered by
+// Crud after all options applied, or immediately if the group is not being
+// built by Crud (e.g. an option is called by hand).
+func addRoute(group *gin.RouterGroup, defaultIdParam string, newRoute func(idParam string) crudRoute) {
+	if builder := getBuilder(group); builder != nil {
+		builder.routes = append(builder.routes, pendingRoute{defaultIdParam, newRoute, false})
+		return
+	}
+	route := newRoute(defaultIdParam)
+	group.Handle(route.method, route.path, route.handler)
+	registerSpecRoute(group, route)
+}
+
+// replaceRoute adds a route like addRoute, but it replaces the base route
+// of the same method and path (e.g. the list route GET /). It works only
+// in Crud, the name of the option is used for logging.
+func replaceRoute(name string, group *gin.RouterGroup, defaultIdParam string, newRoute func(idParam string) crudRoute) {
+	builder := getBuilder(group)
+	if builder == nil {
+		logger.WithField("option", name).
+			Warn("replaceRoute: not in Crud. Ignored.")
+		return
+	}
+	builder.routes = append(builder.routes, pendingRoute{defaultIdParam, newRoute, true})
+}
+
+// register registers the collected routes to the group.
+func (b *crudBuilder) register(group *gin.RouterGroup) {
+	routes := make([]crudRoute, len(b.routes))
+	replaced := map[string]bool{} // method + path
+	for i, pending := range b.routes {
+		idParam := pending.defaultIdParam
+		if b.idParam != "" {
+			idParam = b.idParam
+		}
+		routes[i] = pending.newRoute(idParam)
+		if b.wildcardID {
+			routes[i].path = wildcardIDPath(routes[i].path, idParam)
+		}
+		if pending.replace {
+			replaced[routes[i].method+" "+routes[i].path] = true
+		}
+	}
+
+	for i, route := range routes {
+		if route.base && b.excludedMethods[route.method] {
+			continue
+		}
+		if !b.routes[i].replace && replaced[route.method+" "+route.path] {
+			continue
+		}
+		if _, afterWildcard, ok := strings.Cut(route.path, "*"); ok && strings.Contains(afterWildcard, "/") {
+			logger.WithField("method", route.method).WithField("path", route.path).
+				Warn("register: route after the wildcard id. Ignored.")
+			continue
+		}
+
+		var handlers []gin.HandlerFunc
+		for _, authorizer := range b.authorizers {
+			handlers = append(handlers, authorize(authorizer, route.action, route.model))
+		}
+		handlers = append(handlers, route.handler)
+
+		group.Handle(route.method, route.path, handlers...)
+		registerSpecRoute(group, route)
+	}
+}
+
 // crud add CRUD routes for model T to the group:
 //       GET /
 //       GET /:idParam
@@ -62,15 +239,421 @@ type CrudOption func(group *gin.RouterGroup) *gin.RouterGroup
 //       PUT /:idParam
 //    DELETE /:idParam
 func crud[T orm.Model]() CrudOption {
-	idParam := getIdParam[T]()
+	defaultIdParam := getIdParam[T]()
+	model := getTypeName[T]()
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		builder := getBuilder(group)
+		addRoute(group, defaultIdParam, func(idParam string) crudRoute {
+			var listOptions []controller.ListOption
+			if builder != nil { // all options are applied by now
+				listOptions = builder.listOptions
+			}
+			return crudRoute{http.MethodGet, "", ActionList, model, controller.GetListHandler[T](listOptions...), true,
+				nil, typeOf[[]T]()}
+		})
+		addRoute(group, defaultIdParam, func(idParam string) crudRoute {
+			var modelOptions []controller.ModelOption
+			if builder != nil {
+				modelOptions = builder.modelOptions
+			}
+			return crudRoute{http.MethodGet, "/:" + idParam, ActionRead, model, controller.GetByIDHandler[T](idParam, modelOptions...), true,
+				nil, typeOf[T]()}
+		})
+		addRoute(group, defaultIdParam, func(idParam string) crudRoute {
+			var createOptions []controller.CreateOption
+			if builder != nil {
+				createOptions = builder.createOptions
+			}
+			return crudRoute{http.MethodPost, "", ActionCreate, model, controller.CreateHandler[T](createOptions...), true,
+				typeOf[T](), typeOf[T]()}
+		})
+		addRoute(group, defaultIdParam, func(idParam string) crudRoute {
+			var updateOptions []controller.UpdateOption
+			if builder != nil {
+				updateOptions = builder.updateOptions
+			}
+			return crudRoute{http.MethodPut, "/:" + idParam, ActionUpdate, model, controller.UpdateHandler[T](idParam, updateOptions...), true,
+				typeOf[T](), typeOf[T]()}
+		})
+		addRoute(group, defaultIdParam, func(idParam string) crudRoute {
+			return crudRoute{http.MethodDelete, "/:" + idParam, ActionDelete, model, controller.DeleteHandler[T](idParam), true,
+				nil, nil}
+		})
+		return group
+	}
+}
+
+// ReadOnly makes Crud register only the GET routes of model T:
+//       GET /
+//       GET /:idParam
+// The nested routes (GetNested, CreateNested, ...) still apply.
+func ReadOnly() CrudOption {
+	return excludeMethods("ReadOnly",
+		http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete)
+}
+
+// WriteOnly makes Crud register only the write (POST, PUT, PATCH, DELETE)
+// routes of model T, i.e. the GET routes are not registered.
+// The nested routes (GetNested, CreateNested, ...) still apply.
+func WriteOnly() CrudOption {
+	return excludeMethods("WriteOnly", http.MethodGet)
+}
+
+// ExceptDelete makes Crud register the routes of model T except the DELETE.
+// The nested routes (GetNested, CreateNested, ...) still apply.
+func ExceptDelete() CrudOption {
+	return excludeMethods("ExceptDelete", http.MethodDelete)
+}
+
+// Without makes Crud register the routes of model T except the ones of the
+// given methods ("POST", "PUT", "DELETE", etc.), for example:
+//    Crud[Invoice](r, "/invoices", Without("DELETE"))
+// The nested routes (GetNested, CreateNested, ...) still apply.
+func Without(methods ...string) CrudOption {
+	return excludeMethods("Without", methods...)
+}
+
+// excludeMethods excludes the base routes of given methods from the Crud
+// group. The name of the option is used for logging.
+func excludeMethods(name string, methods ...string) CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		builder := getBuilder(group)
+		if builder == nil {
+			logger.WithField("option", name).
+				Warn("excludeMethods: not in Crud. Ignored.")
+			return group
+		}
+		if builder.excludedMethods == nil {
+			builder.excludedMethods = map[string]bool{}
+		}
+		for _, method := range methods {
+			builder.excludedMethods[strings.ToUpper(method)] = true
+		}
+		return group
+	}
+}
+
+// WithStream makes the list route GET / stream all the records (under the
+// filters) for the NDJSON requests, ignoring the pagination, see
+// controller.WithStream. Without it, the NDJSON streams are capped by the
+// page size like the JSON lists.
+func WithStream() CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		builder := getBuilder(group)
+		if builder == nil {
+			logger.Warn("WithStream: not in Crud. Ignored.")
+			return group
+		}
+		builder.listOptions = append(builder.listOptions, controller.WithStream())
+		return group
+	}
+}
+
+// WithReload makes the create route POST / reload the created model with
+// all associations before responding, see controller.WithReload.
+func WithReload() CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		builder := getBuilder(group)
+		if builder == nil {
+			logger.Warn("WithReload: not in Crud. Ignored.")
+			return group
+		}
+		builder.createOptions = append(builder.createOptions, controller.WithReload())
+		return group
+	}
+}
+
+// WithIdempotency makes the create route POST / replay the response of
+// the first request for the repeated requests of the same Idempotency-Key
+// header in ttl, see controller.WithIdempotency.
+func WithIdempotency(store service.IdempotencyStore, ttl time.Duration) CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		builder := getBuilder(group)
+		if builder == nil {
+			logger.Warn("WithIdempotency: not in Crud. Ignored.")
+			return group
+		}
+		builder.createOptions = append(builder.createOptions, controller.WithIdempotency(store, ttl))
+		return group
+	}
+}
+
+// WithIdempotencyScope scopes the idempotency keys of WithIdempotency by
+// the client (e.g. the user id), see controller.WithIdempotencyScope.
+func WithIdempotencyScope(scope func(c *gin.Context) string) CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		builder := getBuilder(group)
+		if builder == nil {
+			logger.Warn("WithIdempotencyScope: not in Crud. Ignored.")
+			return group
+		}
+		builder.createOptions = append(builder.createOptions, controller.WithIdempotencyScope(scope))
+		return group
+	}
+}
+
+// WithBinding sets the binding of the request body of the create route
+// POST /, see controller.WithBinding. For example, to accept HTML forms:
+//    Crud[User](r, "/users", WithBinding(binding.Form))
+func WithBinding(b binding.Binding) CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		builder := getBuilder(group)
+		if builder == nil {
+			logger.Warn("WithBinding: not in Crud. Ignored.")
+			return group
+		}
+		builder.createOptions = append(builder.createOptions, controller.WithBinding(b))
+		return group
+	}
+}
+
+// WithUpdateMode sets how the update route PUT /:id updates the model,
+// see controller.UpdateHandler and controller.WithUpdateMode.
+func WithUpdateMode(mode controller.UpdateMode) CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		builder := getBuilder(group)
+		if builder == nil {
+			logger.Warn("WithUpdateMode: not in Crud. Ignored.")
+			return group
+		}
+		builder.updateOptions = append(builder.updateOptions, controller.WithUpdateMode(mode))
+		return group
+	}
+}
+
+// WithIDParam sets the name of the id route param of model T, instead of the
+// derived default "ModelID" (e.g. "UserID"), for example:
+//    Crud[User](r, "/users", WithIDParam("id"))
+// makes the routes GET /users/:id, PUT /users/:id, GET /users/:id/friends, ...
+//
+// The name is also used as the parent id param of the nested routes,
+// while the child id param of DeleteNested is still derived from the child
+// model (e.g. DELETE /users/:id/friends/:UserID) to avoid conflicts.
+func WithIDParam(name string) CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		builder := getBuilder(group)
+		if builder == nil {
+			logger.Warn("WithIDParam: not in Crud. Ignored.")
+			return group
+		}
+		builder.idParam = name
+		return group
+	}
+}
+
+// WithWildcardID makes the id route param of model T a wildcard, which
+// captures the rest of the path including slashes, for the models with
+// path-like ids (e.g. "org/team"):
+//    Crud[Team](r, "/teams", WithIDParam("id"), WithWildcardID())
+// makes the routes GET /teams/*id, PUT /teams/*id, DELETE /teams/*id,
+// so that GET /teams/org/team gets the team of id "org/team". The leading
+// slash of the wildcard is stripped by the handlers.
+//
+// Notice: only the last segment of a route can be a wildcard in gin, so
+// the nested routes of model T (e.g. GET /teams/*id/members) can not be
+// registered, they are ignored with a warning.
+func WithWildcardID() CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		builder := getBuilder(group)
+		if builder == nil {
+			logger.Warn("WithWildcardID: not in Crud. Ignored.")
+			return group
+		}
+		builder.wildcardID = true
+		return group
+	}
+}
+
+// wildcardIDPath replaces the id param segment (":idParam") in the path
+// with the wildcard ("*idParam").
+func wildcardIDPath(path string, idParam string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == ":"+idParam {
+			segments[i] = "*" + idParam
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Patch add a PATCH route to the group for partially updating a model:
+//    PATCH /:idParam
+func Patch[T orm.Model]() CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		builder := getBuilder(group)
+		addRoute(group, getIdParam[T](), func(idParam string) crudRoute {
+			var modelOptions []controller.ModelOption
+			if builder != nil {
+				modelOptions = builder.modelOptions
+			}
+			return crudRoute{http.MethodPatch, "/:" + idParam,
+				ActionUpdate, getTypeName[T](), controller.PatchHandler[T](idParam, modelOptions...), true,
+				typeOf[T](), typeOf[T]()}
+		})
+		return group
+	}
+}
+
+// WithBulkCreate add a POST route to the group for creating models in batch:
+//    POST /batch
+// with a JSON array of models T in the request body. The batch is
+// all-or-nothing, see controller.BulkCreateHandler.
+//
+// The batch size is limited to controller.DefaultMaxBatchSize items, use
+// the option controller.WithMaxBatchSize to change it:
+//    Crud[User](r, "/users", WithBulkCreate[User](controller.WithMaxBatchSize(100)))
+func WithBulkCreate[T orm.Model](options ...controller.BulkCreateOption) CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		builder := getBuilder(group)
+		addRoute(group, getIdParam[T](), func(idParam string) crudRoute {
+			var bulkCreateOptions []controller.BulkCreateOption
+			if builder != nil {
+				bulkCreateOptions = append(bulkCreateOptions, builder.bulkCreateOptions...)
+			}
+			bulkCreateOptions = append(bulkCreateOptions, options...)
+			return crudRoute{http.MethodPost, "/batch",
+				ActionCreate, getTypeName[T](), controller.BulkCreateHandler[T](bulkCreateOptions...), true,
+				typeOf[[]T](), typeOf[[]T]()}
+		})
+		return group
+	}
+}
+
+// WithFileUpload add a POST route to the group for uploading a file of
+// the model T into the store, and setting its url to the field:
+//    POST /:idParam/path
+// For example, for the avatars of users:
+//    Crud[User](r, "/users", WithFileUpload[User]("avatar", "AvatarURL", store,
+//        controller.WithContentTypes("image/*")))
+// handles POST /users/:UserID/avatar. See controller.FileUploadHandler.
+func WithFileUpload[T orm.Model](path string, field string, store service.FileStore, options ...controller.UploadOption) CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		addRoute(group, getIdParam[T](), func(idParam string) crudRoute {
+			return crudRoute{http.MethodPost, fmt.Sprintf("/:%s/%s", idParam, strings.Trim(path, "/")),
+				ActionUpdate, getTypeName[T](), controller.FileUploadHandler[T](idParam, field, store, options...), false,
+				nil, typeOf[T]()}
+		})
+		return group
+	}
+}
+
+// WithSearch replaces the list route GET / of model T with the
+// controller.SearchHandler, which searches the keyword in the fields
+// (e.g. "title", "body") by the q query param, for example:
+//    Crud[Article](r, "/articles", WithSearch[Article]("title", "body", "tags"))
+// handles GET /articles?q=golang&limit=10.
+func WithSearch[T orm.Model](fields ...string) CrudOption {
 	return func(group *gin.RouterGroup) *gin.RouterGroup {
-		group.GET("", controller.GetListHandler[T]())
-		group.GET(fmt.Sprintf("/:%s", idParam), controller.GetByIDHandler[T](idParam))
+		builder := getBuilder(group)
+		replaceRoute("WithSearch", group, getIdParam[T](), func(idParam string) crudRoute {
+			options := []controller.ListOption{controller.WithSearchFields(fields...)}
+			if builder != nil {
+				options = append(options, builder.listOptions...)
+			}
+			return crudRoute{http.MethodGet, "", ActionList, getTypeName[T](), controller.GetListHandler[T](options...), true,
+				nil, typeOf[[]T]()}
+		})
+		return group
+	}
+}
+
+// WithPageSize sets the default and the maximum limit of the list route
+// GET / of the Crud, for example:
+//    Crud[User](r, "/users", WithPageSize(50, 500))
+// See controller.WithPageSize and controller.SetPageSize for more details.
+func WithPageSize(defaultLimit, maxLimit int) CrudOption {
+	return withModelOption("WithPageSize", controller.WithPageSize(defaultLimit, maxLimit))
+}
+
+// WithClientID sets whether the clients can choose the primary key of the
+// models they create by the POST routes of the Crud, for example:
+//    Crud[User](r, "/users", WithClientID(true))
+// See controller.WithClientID and controller.AllowClientID for more details
+// and the default.
+func WithClientID(allowed bool) CrudOption {
+	return withModelOption("WithClientID", controller.WithClientID(allowed))
+}
+
+// WithTrashedAccess allows the clients to read the soft-deleted records
+// (?with_trashed=true or ?only_trashed=true) by the GET routes, which are
+// denied with 403 Forbidden by default, unless an Authorizer allows them
+// (see WithAuthorizer). See controller.WithTrashedAccess.
+func WithTrashedAccess() CrudOption {
+	return withModelOption("WithTrashedAccess", controller.WithTrashedAccess())
+}
+
+// WithDefaultPreloads sets the associations preloaded by default in the GET
+// routes of the Crud, unless the request has its own preload params, for
+// example:
+//    Crud[User](r, "/users", WithDefaultPreloads("Profile", "Orders"))
+// makes GET /users/1 equivalent to GET /users/1?preload=Profile&preload=Orders.
+// See controller.WithDefaultPreloads and controller.SetDefaultPreloads for
+// more details.
+func WithDefaultPreloads(fields ...string) CrudOption {
+	return withModelOption("WithDefaultPreloads", controller.WithDefaultPreloads(fields...))
+}
+
+// WithHooks registers the lifecycle hooks for model T, for example:
+//    Crud[User](r, "/users", WithHooks[User](
+//        controller.WithBeforeCreate(func(c *gin.Context, user *User) error {
+//            user.TenantID = c.GetString("tenant")
+//            return nil
+//        }),
+//    ))
+// The hooks are registered per model type, so they apply to all the routes
+// of model T, and an option given to several Crud is registered once.
+// See controller.RegisterHooks for more details.
+func WithHooks[T any](options ...controller.HookOption[T]) CrudOption {
+	var once sync.Once
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		once.Do(func() { controller.RegisterHooks[T](options...) })
+		return group
+	}
+}
+
+// WithValidator registers the validators for model T, which validate the
+// request bodies of the create and update routes, for example:
+//    Crud[Event](r, "/events", WithValidator(func(event *Event) error {
+//        if !event.Start.Before(event.End) {
+//            return controller.FieldErrors{"end": "must be after start"}
+//        }
+//        return nil
+//    }))
+// The validators are registered per model type, so they apply to all the
+// routes of model T, and an option given to several Crud is registered
+// once. See controller.RegisterValidator for more details.
+func WithValidator[T any](validators ...controller.Validator[T]) CrudOption {
+	var once sync.Once
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		once.Do(func() { controller.RegisterValidator[T](validators...) })
+		return group
+	}
+}
 
-		group.POST("", controller.CreateHandler[T]())
-		group.PUT(fmt.Sprintf("/:%s", idParam), controller.UpdateHandler[T](idParam))
-		group.DELETE(fmt.Sprintf("/:%s", idParam), controller.DeleteHandler[T](idParam))
+// withModelOption makes a CrudOption (named name) adding the option to the
+// routes of the Crud, see crudBuilder.addModelOption.
+//
+// The options only apply to the routes of the Crud they are given to,
+// unlike the per-type registries of the controller package.
+func withModelOption(name string, option controller.ModelOption) CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		builder := getBuilder(group)
+		if builder == nil {
+			logger.Warn(name + ": not in Crud. Ignored.")
+			return group
+		}
+		builder.addModelOption(option)
+		return group
+	}
+}
 
+// WithResponseKey sets the keys of model T in the success response bodies,
+// for example:
+//    Crud[Category](r, "/categories", WithResponseKey[Category]("category", "categories"))
+// See controller.RegisterResponseKey for more details.
+func WithResponseKey[T any](singular, plural string) CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		controller.RegisterResponseKey[T](singular, plural)
 		return group
 	}
 }
@@ -78,20 +661,27 @@ func crud[T orm.Model]() CrudOption {
 // GetNested add a GET route to the group for querying a nested model:
 //    GET /:parentIdParam/field
 func GetNested[P orm.Model, N orm.Model](field string) CrudOption {
-	parentIdParam := getIdParam[P]()
 	return func(group *gin.RouterGroup) *gin.RouterGroup {
-		relativePath := fmt.Sprintf("/:%s/%s", parentIdParam, field)
+		builder := getBuilder(group)
+		addRoute(group, getIdParam[P](), func(parentIdParam string) crudRoute {
+			relativePath := fmt.Sprintf("/:%s/%s", parentIdParam, field)
 
-		if !gin.IsDebugging() { // GIN_MODE == "release"
-			logger.WithField("parent", getTypeName[P]()).
-				WithField("child", getTypeName[N]()).
-				WithField("relativePath", relativePath).
-				Info("Crud: Adding GET route for getting nested model")
-		}
+			if !gin.IsDebugging() { // GIN_MODE == "release"
+				logger.WithField("parent", getTypeName[P]()).
+					WithField("child", getTypeName[N]()).
+					WithField("relativePath", relativePath).
+					Info("Crud: Adding GET route for getting nested model")
+			}
 
-		group.GET(relativePath,
-			controller.GetFieldHandler[P](parentIdParam, field),
-		)
+			var modelOptions []controller.ModelOption
+			if builder != nil {
+				modelOptions = builder.modelOptions
+			}
+			return crudRoute{http.MethodGet, relativePath,
+				ActionRead, getTypeName[P](), controller.GetFieldHandler[P](parentIdParam, field, modelOptions...), false,
+				nil, typeOf[[]N](),
+			}
+		})
 		// there is no GET /:parentIdParam/:field/:childIdParam,
 		// because it is equivalent to GET /:childModel/:childIdParam.
 		// So there is also no PUT /:parentIdParam/:field/:childIdParam.
@@ -102,21 +692,56 @@ func GetNested[P orm.Model, N orm.Model](field string) CrudOption {
 
 // CreateNested add a POST route to the group for creating a nested model:
 //    POST /:parentIdParam/field
-func CreateNested[P orm.Model, N orm.Model](field string) CrudOption {
-	parentIdParam := getIdParam[P]()
+// It responds the updated parent model, or the created child model by the
+// optional respond, for example:
+//    CreateNested[Project, Todo]("todos", controller.RespondChild)
+// See controller.NestedResponse for the available shapes.
+func CreateNested[P orm.Model, N orm.Model](field string, respond ...controller.NestedResponse) CrudOption {
+	response := typeOf[P]()
+	if len(respond) > 0 && respond[0] == controller.RespondChild {
+		response = typeOf[N]()
+	}
 	return func(group *gin.RouterGroup) *gin.RouterGroup {
-		relativePath := fmt.Sprintf("/:%s/%s", parentIdParam, field)
+		addRoute(group, getIdParam[P](), func(parentIdParam string) crudRoute {
+			relativePath := fmt.Sprintf("/:%s/%s", parentIdParam, field)
 
-		if !gin.IsDebugging() { // GIN_MODE == "release"
-			logger.WithField("parent", getTypeName[P]()).
-				WithField("child", getTypeName[N]()).
-				WithField("relativePath", relativePath).
-				Info("Crud: Adding POST route for creating nested model")
-		}
+			if !gin.IsDebugging() { // GIN_MODE == "release"
+				logger.WithField("parent", getTypeName[P]()).
+					WithField("child", getTypeName[N]()).
+					WithField("relativePath", relativePath).
+					Info("Crud: Adding POST route for creating nested model")
+			}
 
-		group.POST(relativePath,
-			controller.CreateNestedHandler[P, N](parentIdParam, field),
-		)
+			return crudRoute{http.MethodPost, relativePath,
+				ActionUpdate, getTypeName[P](), controller.CreateNestedHandler[P, N](parentIdParam, field, respond...), false,
+				typeOf[N](), response,
+			}
+		})
+		return group
+	}
+}
+
+// ReplaceNested add a PUT route to the group for replacing the nested
+// models (i.e. setting the complete list, the others are detached):
+//    PUT /:parentIdParam/field
+// It is not included in CrudNested, see controller.ReplaceNestedHandler.
+func ReplaceNested[P orm.Model, N orm.Model](field string) CrudOption {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		addRoute(group, getIdParam[P](), func(parentIdParam string) crudRoute {
+			relativePath := fmt.Sprintf("/:%s/%s", parentIdParam, field)
+
+			if !gin.IsDebugging() { // GIN_MODE == "release"
+				logger.WithField("parent", getTypeName[P]()).
+					WithField("child", getTypeName[N]()).
+					WithField("relativePath", relativePath).
+					Info("Crud: Adding PUT route for replacing nested models")
+			}
+
+			return crudRoute{http.MethodPut, relativePath,
+				ActionUpdate, getTypeName[P](), controller.ReplaceNestedHandler[P, N](parentIdParam, field), false,
+				typeOf[[]N](), typeOf[P](),
+			}
+		})
 		return group
 	}
 }
@@ -124,21 +749,23 @@ func CreateNested[P orm.Model, N orm.Model](field string) CrudOption {
 // DeleteNested add a DELETE route to the group for deleting a nested model:
 //    DELETE /:parentIdParam/field/:childIdParam
 func DeleteNested[P orm.Model, T orm.Model](field string) CrudOption {
-	parentIdParam := getIdParam[P]()
 	childIdParam := getIdParam[T]()
 	return func(group *gin.RouterGroup) *gin.RouterGroup {
-		relativePath := fmt.Sprintf("/:%s/%s/:%s", parentIdParam, field, childIdParam)
+		addRoute(group, getIdParam[P](), func(parentIdParam string) crudRoute {
+			relativePath := fmt.Sprintf("/:%s/%s/:%s", parentIdParam, field, childIdParam)
 
-		if !gin.IsDebugging() { // GIN_MODE == "release"
-			logger.WithField("parent", getTypeName[P]()).
-				WithField("child", getTypeName[T]()).
-				WithField("relativePath", relativePath).
-				Info("Crud: Adding DELETE route for deleting nested model")
-		}
+			if !gin.IsDebugging() { // GIN_MODE == "release"
+				logger.WithField("parent", getTypeName[P]()).
+					WithField("child", getTypeName[T]()).
+					WithField("relativePath", relativePath).
+					Info("Crud: Adding DELETE route for deleting nested model")
+			}
 
-		group.DELETE(relativePath,
-			controller.DeleteNestedHandler[P, T](parentIdParam, field, childIdParam),
-		)
+			return crudRoute{http.MethodDelete, relativePath,
+				ActionUpdate, getTypeName[P](), controller.DeleteNestedHandler[P, T](parentIdParam, field, childIdParam), false,
+				nil, nil,
+			}
+		})
 		return group
 	}
 }
@@ -157,14 +784,31 @@ func CrudNested[P orm.Model, T orm.Model](field string) CrudOption {
 func getIdParam[T orm.Model]() string {
 	model := *new(T)
 	modelName := reflect.TypeOf(model).Name()
-	idField, _ := model.Identity()
+	idField, _ := orm.IdentityColumn(model)
 	idParam := modelName + idField
 
 	return idParam
 }
 
-// getTypeName is a helper function to get the type name of a generic type T.
+// typeOf returns the reflect.Type of T.
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// ResourcePath suggests the relativePath of the model T for Crud: the
+// lower-cased plural key of T in the responses (see controller.ResponseKey),
+// for example, "/users" for a User, or for a model implementing the
+// orm.ResourceNamer with the name "user":
+//    Crud[User](r, ResourcePath[User]())
+func ResourcePath[T any]() string {
+	return "/" + strings.ToLower(controller.ResponseKey(typeOf[T](), true))
+}
+
+// getTypeName returns the name of the model T in the routes (for the
+// Authorizer and the OpenAPI spec): the singular of controller.ResourceNames,
+// i.e. the type name, unless T names itself by orm.ResourceNamer or
+// controller.ResponseNamer.
 func getTypeName[T any]() string {
-	model := *new(T)
-	return reflect.TypeOf(model).Name()
+	singular, _ := controller.ResourceNames(typeOf[T]())
+	return singular
 }
@@ -1,3 +1,712 @@
 package router
 
+import (
+	"encoding/json"
+	"errors"
+	"github.com/cdfmlr/crud/controller"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/gin-gonic/gin"
+	gormlogger "gorm.io/gorm/logger"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
 // TODO: test Crud
+
+type testTodo struct {
+	orm.BasicModel
+	Title string `json:"title"`
+}
+
+type testProject struct {
+	orm.BasicModel
+	Title string      `json:"title"`
+	Todos []*testTodo `json:"todos" gorm:"many2many:test_project_todos"`
+}
+
+// setupTestDB connects orm.DB to a fresh in-memory sqlite database
+// with the test models registered.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+
+	_, cleanup, err := orm.ConnectTestDB(&testTodo{}, &testProject{}, orm.WithTestDBLogger(gormlogger.Discard))
+	if err != nil {
+		t.Fatalf("connect test db: %v", err)
+	}
+	t.Cleanup(cleanup)
+}
+
+// request sends a request to the router and returns the status code.
+func request(r http.Handler, method, target, body string) int {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestWithAuthorizer(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	var calls []string
+	r := gin.New()
+	Crud[testProject](r, "/projects",
+		CrudNested[testProject, testTodo]("todos"), // before the authorizer
+		WithAuthorizer(func(c *gin.Context, action string, model string) error {
+			calls = append(calls, action+" "+model)
+			if action == ActionDelete || c.Query("deny") != "" {
+				return errors.New("denied")
+			}
+			return nil
+		}),
+	)
+
+	tests := []struct {
+		method, target, body string
+		wantCode             int
+		wantCall             string
+	}{
+		{http.MethodPost, "/projects", `{"title": "p"}`, http.StatusOK, "create testProject"},
+		{http.MethodGet, "/projects", "", http.StatusOK, "list testProject"},
+		{http.MethodGet, "/projects/1", "", http.StatusOK, "read testProject"},
+		{http.MethodPut, "/projects/1", `{"title": "q"}`, http.StatusOK, "update testProject"},
+		{http.MethodDelete, "/projects/1", "", http.StatusForbidden, "delete testProject"},
+		{http.MethodGet, "/projects/1/todos?deny=1", "", http.StatusForbidden, "read testProject"},
+		{http.MethodPost, "/projects/1/todos?deny=1", `{"title": "t"}`, http.StatusForbidden, "update testProject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			calls = nil
+			if got := request(r, tt.method, tt.target, tt.body); got != tt.wantCode {
+				t.Errorf("status = %v, want %v", got, tt.wantCode)
+			}
+			if len(calls) != 1 || calls[0] != tt.wantCall {
+				t.Errorf("authorizer calls = %v, want [%v]", calls, tt.wantCall)
+			}
+		})
+	}
+
+	var count int64
+	orm.DB.Model(&testProject{}).Count(&count)
+	if count != 1 {
+		t.Errorf("project count = %v, want 1 (delete forbidden)", count)
+	}
+}
+
+func TestWithAuthorizer_trashed(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	var calls []string
+	r := gin.New()
+	Crud[testProject](r, "/projects",
+		WithAuthorizer(func(c *gin.Context, action string, model string) error {
+			calls = append(calls, action)
+			if action == ActionReadTrashed && c.GetHeader("X-Admin") == "" {
+				return errors.New("admin only")
+			}
+			return nil
+		}),
+	)
+	orm.DB.Create(&testProject{Title: "p"})
+	orm.DB.Create(&testProject{Title: "q"})
+	orm.DB.Delete(&testProject{}, 1)
+
+	tests := []struct {
+		target    string
+		admin     bool
+		wantCode  int
+		wantCalls []string
+	}{
+		{"/projects", false, http.StatusOK, []string{ActionList}},
+		{"/projects?with_trashed=true", false, http.StatusForbidden, []string{ActionList, ActionReadTrashed}},
+		{"/projects?only_trashed=1", false, http.StatusForbidden, []string{ActionList, ActionReadTrashed}},
+		{"/projects/1?with_trashed=true", false, http.StatusForbidden, []string{ActionRead, ActionReadTrashed}},
+		{"/projects?with_trashed=false", false, http.StatusOK, []string{ActionList}},
+		{"/projects?with_trashed=true", true, http.StatusOK, []string{ActionList, ActionReadTrashed}},
+		{"/projects/1?only_trashed=true", true, http.StatusOK, []string{ActionRead, ActionReadTrashed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			calls = nil
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.admin {
+				req.Header.Set("X-Admin", "1")
+			}
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %v, want %v: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("authorizer calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestWithTrashedAccess(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	Crud[testProject](r, "/projects")
+	Crud[testProject](r, "/trash", WithTrashedAccess())
+	orm.DB.Create(&testProject{Title: "p"})
+	orm.DB.Delete(&testProject{}, 1)
+
+	tests := []struct {
+		target   string
+		wantCode int
+	}{
+		{"/projects", http.StatusOK},
+		{"/projects?with_trashed=true", http.StatusForbidden},
+		{"/projects/1?only_trashed=true", http.StatusForbidden},
+		{"/trash?with_trashed=true", http.StatusOK},
+		{"/trash/1?only_trashed=true", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := request(r, http.MethodGet, tt.target, ""); got != tt.wantCode {
+			t.Errorf("GET %s: status = %v, want %v", tt.target, got, tt.wantCode)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	Crud[testProject](r, "/projects", ReadOnly(), CrudNested[testProject, testTodo]("todos"))
+	orm.DB.Create(&testProject{Title: "p"})
+
+	tests := []struct {
+		method, target, body string
+		wantCode             int
+	}{
+		{http.MethodGet, "/projects", "", http.StatusOK},
+		{http.MethodGet, "/projects/1", "", http.StatusOK},
+		{http.MethodPost, "/projects", `{"title": "q"}`, http.StatusNotFound},
+		{http.MethodPut, "/projects/1", `{"title": "q"}`, http.StatusNotFound},
+		{http.MethodDelete, "/projects/1", "", http.StatusNotFound},
+		{http.MethodPost, "/projects/1/todos", `{"title": "t"}`, http.StatusOK}, // nested
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			if got := request(r, tt.method, tt.target, tt.body); got != tt.wantCode {
+				t.Errorf("status = %v, want %v", got, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestWithout(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	Crud[testTodo](r, "/invoices", Without("delete"), Patch[testTodo]())
+
+	tests := []struct {
+		method, target, body string
+		wantCode             int
+	}{
+		{http.MethodPost, "/invoices", `{"title": "i"}`, http.StatusOK},
+		{http.MethodGet, "/invoices/1", "", http.StatusOK},
+		{http.MethodPatch, "/invoices/1", `{"title": "j"}`, http.StatusOK},
+		{http.MethodDelete, "/invoices/1", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			if got := request(r, tt.method, tt.target, tt.body); got != tt.wantCode {
+				t.Errorf("status = %v, want %v", got, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestReplaceNested(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	Crud[testProject](r, "/projects", CrudNested[testProject, testTodo]("todos"), ReplaceNested[testProject, testTodo]("todos"))
+	orm.DB.Create(&testProject{Title: "p", Todos: []*testTodo{{Title: "t1"}, {Title: "t2"}}})
+
+	tests := []struct {
+		body      string
+		wantCode  int
+		wantTodos []uint
+	}{
+		{`[{"id": 2}, {"title": "t3"}]`, http.StatusOK, []uint{2, 3}},
+		{`[{"id": 404}]`, http.StatusNotFound, []uint{2, 3}},
+		{`[]`, http.StatusOK, []uint{}},
+	}
+	for _, tt := range tests {
+		if got := request(r, http.MethodPut, "/projects/1/todos", tt.body); got != tt.wantCode {
+			t.Errorf("PUT %s: status = %v, want %v", tt.body, got, tt.wantCode)
+		}
+		todoIDs := []uint{}
+		orm.DB.Table("test_project_todos").Where("test_project_id = 1").
+			Order("test_todo_id").Pluck("test_todo_id", &todoIDs)
+		if !reflect.DeepEqual(todoIDs, tt.wantTodos) {
+			t.Errorf("PUT %s: todos = %v, want %v", tt.body, todoIDs, tt.wantTodos)
+		}
+	}
+}
+
+func TestWithSearch(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	Crud[testTodo](r, "/todos", WithSearch[testTodo]("title"))
+	orm.DB.Create(&[]testTodo{{Title: "learn golang"}, {Title: "shopping"}})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos?q=go", nil))
+
+	var body struct {
+		Todos []testTodo `json:"testTodos"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body %q: %v", w.Body, err)
+	}
+	if len(body.Todos) != 1 || body.Todos[0].Title != "learn golang" {
+		t.Errorf("GET /todos?q=go = %+v, want [learn golang]", body.Todos)
+	}
+}
+
+func TestWithStream(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	Crud[testTodo](r, "/todos")
+	Crud[testTodo](r, "/streamed", WithStream())
+	Crud[testTodo](r, "/searched", WithSearch[testTodo]("title"), WithStream())
+	orm.DB.Create(&[]testTodo{{Title: "learn golang"}, {Title: "go shopping"}})
+
+	tests := []struct {
+		target    string
+		wantLines int
+	}{
+		{"/todos?stream=true&limit=1", 1},
+		{"/streamed?stream=true&limit=1", 2},
+		{"/searched?stream=true&limit=1&q=go", 2},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if lines := strings.Count(w.Body.String(), "\n"); lines != tt.wantLines {
+			t.Errorf("GET %s: %v lines, want %v: %s", tt.target, lines, tt.wantLines, w.Body)
+		}
+	}
+}
+
+func TestWithModelOptions(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	Crud[testTodo](r, "/todos")
+	Crud[testTodo](r, "/limited", WithPageSize(1, 1), WithClientID(true))
+	orm.DB.Create(&[]testTodo{{Title: "a"}, {Title: "b"}})
+
+	if code := request(r, http.MethodPost, "/limited", `{"ID": 99, "title": "c"}`); code != http.StatusOK {
+		t.Fatalf("POST /limited = %v, want %v", code, http.StatusOK)
+	}
+	if code := request(r, http.MethodPost, "/todos", `{"ID": 98, "title": "d"}`); code != http.StatusOK {
+		t.Fatalf("POST /todos = %v, want %v", code, http.StatusOK)
+	}
+	var ids []uint
+	orm.DB.Model(&testTodo{}).Order("id").Pluck("id", &ids)
+	if want := []uint{1, 2, 99, 100}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("ids = %v, want %v: the client id is only allowed by /limited", ids, want)
+	}
+
+	count := func(target string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		var body struct {
+			Todos []testTodo `json:"testTodos"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode body %q: %v", w.Body, err)
+		}
+		return len(body.Todos)
+	}
+	if got := count("/limited"); got != 1 {
+		t.Errorf("GET /limited = %v todos, want 1", got)
+	}
+	if got := count("/todos"); got != 4 {
+		t.Errorf("GET /todos = %v todos, want 4", got)
+	}
+}
+
+func TestWithHooks(t *testing.T) {
+	// a model of this test only: the hooks are registered per model type
+	type testNote struct {
+		orm.BasicModel
+		Title string `json:"title"`
+	}
+	_, cleanup, err := orm.ConnectTestDB(&testNote{}, orm.WithTestDBLogger(gormlogger.Discard))
+	if err != nil {
+		t.Fatalf("connect test db: %v", err)
+	}
+	t.Cleanup(cleanup)
+	gin.SetMode(gin.TestMode)
+
+	calls := 0
+	hooks := WithHooks(controller.WithBeforeDelete(func(c *gin.Context, note *testNote) error {
+		calls++
+		if note.Title == "kept" {
+			return controller.ErrForbidden
+		}
+		return nil
+	}))
+
+	r := gin.New()
+	Crud[testNote](r, "/notes", hooks)
+	Crud[testNote](r, "/archived", hooks)
+	orm.DB.Create(&[]testNote{{Title: "kept"}, {Title: "b"}})
+
+	tests := []struct {
+		target   string
+		wantCode int
+	}{
+		{"/notes/1", http.StatusForbidden},
+		{"/archived/1", http.StatusForbidden},
+		{"/archived/2", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := request(r, http.MethodDelete, tt.target, ""); code != tt.wantCode {
+			t.Errorf("DELETE %s = %v, want %v", tt.target, code, tt.wantCode)
+		}
+	}
+	if calls != len(tests) {
+		t.Errorf("hook invoked %v times, want %v: registered more than once", calls, len(tests))
+	}
+}
+
+func TestWithValidator(t *testing.T) {
+	// a model of this test only: the validators are registered per model type
+	type testEvent struct {
+		orm.BasicModel
+		Title string `json:"title"`
+	}
+	_, cleanup, err := orm.ConnectTestDB(&testEvent{}, orm.WithTestDBLogger(gormlogger.Discard))
+	if err != nil {
+		t.Fatalf("connect test db: %v", err)
+	}
+	t.Cleanup(cleanup)
+	gin.SetMode(gin.TestMode)
+
+	calls := 0
+	validate := WithValidator(func(event *testEvent) error {
+		calls++
+		if event.Title == "bad" {
+			return errors.New("bad title")
+		}
+		return nil
+	})
+
+	r := gin.New()
+	Crud[testEvent](r, "/events", validate, WithBulkCreate[testEvent]())
+	Crud[testEvent](r, "/archived", validate)
+	orm.DB.Create(&testEvent{Title: "a"})
+
+	tests := []struct {
+		method   string
+		target   string
+		body     string
+		wantCode int
+	}{
+		{http.MethodPost, "/events", `{"title": "bad"}`, http.StatusBadRequest},
+		{http.MethodPost, "/events/batch", `[{"title": "bad"}]`, http.StatusBadRequest},
+		{http.MethodPut, "/events/1", `{"title": "bad"}`, http.StatusBadRequest},
+		{http.MethodPost, "/archived", `{"title": "bad"}`, http.StatusBadRequest},
+		{http.MethodPost, "/archived", `{"title": "b"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := request(r, tt.method, tt.target, tt.body); code != tt.wantCode {
+			t.Errorf("%s %s = %v, want %v", tt.method, tt.target, code, tt.wantCode)
+		}
+	}
+	if calls != len(tests) {
+		t.Errorf("validator invoked %v times, want %v: registered more than once", calls, len(tests))
+	}
+}
+
+func TestWithBulkCreate(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	Crud[testTodo](r, "/todos", WithBulkCreate[testTodo]())
+
+	if code := request(r, http.MethodPost, "/todos/batch", `[{"title": "a"}, {"title": "b"}]`); code != http.StatusOK {
+		t.Errorf("POST /todos/batch = %v, want %v", code, http.StatusOK)
+	}
+	var count int64
+	orm.DB.Model(&testTodo{}).Count(&count)
+	if count != 2 {
+		t.Errorf("count = %v, want 2", count)
+	}
+}
+
+func TestWithBulkCreate_maxBatchSize(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	Crud[testTodo](r, "/todos", WithBulkCreate[testTodo](controller.WithMaxBatchSize(1)))
+
+	if code := request(r, http.MethodPost, "/todos/batch", `[{"title": "a"}, {"title": "b"}]`); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST /todos/batch = %v, want %v", code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestWithIDParam(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	Crud[testProject](r, "/projects",
+		CrudNested[testProject, testTodo]("todos"), // before WithIDParam
+		WithIDParam("id"),
+	)
+	orm.DB.Create(&testProject{Title: "p"})
+
+	paths := map[string]bool{}
+	for _, route := range r.Routes() {
+		paths[route.Method+" "+route.Path] = true
+	}
+	for _, want := range []string{
+		"GET /projects/:id",
+		"PUT /projects/:id",
+		"DELETE /projects/:id",
+		"GET /projects/:id/todos",
+		"POST /projects/:id/todos",
+		"DELETE /projects/:id/todos/:testTodoID",
+	} {
+		if !paths[want] {
+			t.Errorf("route %q not found in %v", want, paths)
+		}
+	}
+
+	if got := request(r, http.MethodGet, "/projects/1", ""); got != http.StatusOK {
+		t.Errorf("GET /projects/1 status = %v, want %v", got, http.StatusOK)
+	}
+	if got := request(r, http.MethodPost, "/projects/1/todos", `{"title": "t"}`); got != http.StatusOK {
+		t.Errorf("POST /projects/1/todos status = %v, want %v", got, http.StatusOK)
+	}
+}
+
+type testTeam struct {
+	Path string `json:"path" gorm:"primaryKey"`
+	Name string `json:"name"`
+}
+
+func (m testTeam) Identity() (fieldName string, value any) {
+	return "Path", m.Path
+}
+
+func TestWithWildcardID(t *testing.T) {
+	setupTestDB(t)
+	if err := orm.RegisterModel(&testTeam{}); err != nil {
+		t.Fatalf("register model: %v", err)
+	}
+	orm.DB.Create(&testTeam{Path: "org/team", Name: "a"})
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	Crud[testTeam](r, "/teams",
+		WithIDParam("id"),
+		WithWildcardID(),
+		Patch[testTeam](),
+		CrudNested[testTeam, testTodo]("todos"), // ignored
+	)
+
+	paths := map[string]bool{}
+	for _, route := range r.Routes() {
+		paths[route.Method+" "+route.Path] = true
+	}
+	for _, want := range []string{"GET /teams", "GET /teams/*id", "PUT /teams/*id", "PATCH /teams/*id", "DELETE /teams/*id"} {
+		if !paths[want] {
+			t.Errorf("route %q not found in %v", want, paths)
+		}
+	}
+
+	tests := []struct {
+		method, target, body string
+		wantCode             int
+	}{
+		{http.MethodGet, "/teams/org/team", "", http.StatusOK},
+		{http.MethodPatch, "/teams/org/team", `{"name": "b"}`, http.StatusOK},
+		{http.MethodGet, "/teams/org", "", http.StatusUnprocessableEntity}, // not found
+		{http.MethodGet, "/teams", "", http.StatusOK},
+		{http.MethodDelete, "/teams/org/team", "", http.StatusOK},
+		{http.MethodGet, "/teams/org/team", "", http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		if got := request(r, tt.method, tt.target, tt.body); got != tt.wantCode {
+			t.Errorf("%s %s status = %v, want %v", tt.method, tt.target, got, tt.wantCode)
+		}
+	}
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	Crud[testProject](r, "/api/projects", CrudNested[testProject, testTodo]("todos"))
+
+	other := gin.New()
+	Crud[testTodo](other, "/other/todos")
+
+	data, err := OpenAPISpec(r)
+	if err != nil {
+		t.Fatalf("OpenAPISpec(r) err = %v", err)
+	}
+	var spec struct {
+		Paths      map[string]map[string]any
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]any
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatalf("unmarshal spec: %v", err)
+	}
+
+	wantPaths := map[string][]string{
+		"/api/projects":                                    {"get", "post"},
+		"/api/projects/{testProjectID}":                    {"get", "put", "delete"},
+		"/api/projects/{testProjectID}/todos":              {"get", "post"},
+		"/api/projects/{testProjectID}/todos/{testTodoID}": {"delete"},
+	}
+	for p, methods := range wantPaths {
+		for _, method := range methods {
+			if _, ok := spec.Paths[p][method]; !ok {
+				t.Errorf("spec missing %s %s", method, p)
+			}
+		}
+	}
+
+	if _, ok := spec.Paths["/other/todos"]; ok {
+		t.Errorf("spec includes the routes of another engine")
+	}
+
+	project := spec.Components.Schemas["router.testProject"].Properties
+	for _, field := range []string{"ID", "CreatedAt", "title", "todos"} {
+		if _, ok := project[field]; !ok {
+			t.Errorf("testProject schema missing property %q: %v", field, project)
+		}
+	}
+	if project["todos"]["type"] != "array" {
+		t.Errorf("testProject.todos = %v, want an array", project["todos"])
+	}
+	if _, ok := spec.Components.Schemas["router.testTodo"]; !ok {
+		t.Errorf("spec missing testTodo schema")
+	}
+}
+
+type testPerson struct {
+	orm.BasicModel
+	Name string `json:"name"`
+}
+
+func (testPerson) ResourceName() string {
+	return "member"
+}
+
+func TestResourcePath(t *testing.T) {
+	if got := ResourcePath[testTodo](); got != "/testtodos" {
+		t.Errorf("ResourcePath[testTodo]() = %q, want %q", got, "/testtodos")
+	}
+	if got := ResourcePath[testPerson](); got != "/members" {
+		t.Errorf("ResourcePath[testPerson]() = %q, want %q", got, "/members")
+	}
+	if got := getTypeName[testPerson](); got != "member" {
+		t.Errorf("getTypeName[testPerson]() = %q, want %q", got, "member")
+	}
+	if got := getTypeName[testTodo](); got != "testTodo" {
+		t.Errorf("getTypeName[testTodo]() = %q, want %q", got, "testTodo")
+	}
+}
+
+type testDocument struct {
+	Uuid  string      `json:"uuid" gorm:"primaryKey"`
+	Title string      `json:"title"`
+	Todos []*testTodo `json:"todos" gorm:"many2many:test_document_todos"`
+}
+
+func (m testDocument) Identity() (fieldName string, value any) {
+	return "Uuid", m.Uuid
+}
+
+func TestCrud_nonIDIdentity(t *testing.T) {
+	setupTestDB(t)
+	if err := orm.RegisterModel(&testDocument{}); err != nil {
+		t.Fatalf("register model: %v", err)
+	}
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	Crud[testDocument](r, "/documents", CrudNested[testDocument, testTodo]("todos"), Patch[testDocument]())
+
+	paths := map[string]bool{}
+	for _, route := range r.Routes() {
+		paths[route.Method+" "+route.Path] = true
+	}
+	for _, want := range []string{
+		"GET /documents/:testDocumentUuid",
+		"DELETE /documents/:testDocumentUuid/todos/:testTodoID",
+	} {
+		if !paths[want] {
+			t.Errorf("route %q not found in %v", want, paths)
+		}
+	}
+
+	tests := []struct {
+		method, target, body string
+		wantCode             int
+	}{
+		{http.MethodPost, "/documents", `{"uuid": "a1", "title": "a"}`, http.StatusOK},
+		{http.MethodPost, "/documents", `{"uuid": "b2", "title": "b"}`, http.StatusOK},
+		{http.MethodGet, "/documents/a1", "", http.StatusOK},
+		{http.MethodGet, "/documents?filter_by=uuid&filter_value=b2&order_by=Uuid", "", http.StatusOK},
+		{http.MethodPut, "/documents/a1", `{"uuid": "a1", "title": "aa"}`, http.StatusOK},
+		{http.MethodPut, "/documents/a1", `{"uuid": "c3", "title": "aa"}`, http.StatusBadRequest}, // id can not be changed
+		{http.MethodPatch, "/documents/a1", `{"title": "aaa"}`, http.StatusOK},
+		{http.MethodPost, "/documents/a1/todos", `{"title": "t"}`, http.StatusOK},
+		{http.MethodGet, "/documents/a1/todos", "", http.StatusOK},
+		{http.MethodDelete, "/documents/a1/todos/1", "", http.StatusOK},
+		{http.MethodDelete, "/documents/b2", "", http.StatusOK},
+		{http.MethodGet, "/documents/b2", "", http.StatusUnprocessableEntity}, // not found
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != tt.wantCode {
+			t.Errorf("%s %s status = %v, want %v: %s", tt.method, tt.target, w.Code, tt.wantCode, w.Body)
+		}
+	}
+
+	var doc testDocument
+	if err := orm.DB.Preload("Todos").Take(&doc, "uuid = ?", "a1").Error; err != nil {
+		t.Fatal(err)
+	}
+	if doc.Title != "aaa" || len(doc.Todos) != 0 {
+		t.Errorf("document = %+v, want title aaa without todos", doc)
+	}
+}
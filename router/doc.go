@@ -5,4 +5,5 @@
 // preferred middlewares.
 //
 // Call Crud() function to add a group of CRUD routes for your models.
+// And OpenAPISpec(engine) generates an OpenAPI spec of the routes added by Crud().
 package router
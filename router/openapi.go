@@ -0,0 +1,407 @@
+package router
+
+import (
+	"encoding/json"
+	"github.com/cdfmlr/crud/controller"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// specRoute is a route registered by Crud, recorded for OpenAPISpec.
+type specRoute struct {
+	method string
+	path   string // the full path, e.g. /users/:UserID
+	action string
+	model  string
+
+	request, response reflect.Type
+}
+
+var (
+	specRoutes   = map[uintptr][]specRoute{} // engine => routes
+	specRoutesMu sync.Mutex
+)
+
+// engineOf returns the address of the engine of the group, which keys the
+// specRoutes. gin does not export the engine of a group, so that it is
+// read by reflection (the pointer only, never dereferenced).
+func engineOf(group *gin.RouterGroup) uintptr {
+	return reflect.ValueOf(group).Elem().FieldByName("engine").Pointer()
+}
+
+// registerSpecRoute records the route registered to the group.
+func registerSpecRoute(group *gin.RouterGroup, route crudRoute) {
+	fullPath := path.Join(group.BasePath(), route.path)
+	if strings.HasSuffix(route.path, "/") && !strings.HasSuffix(fullPath, "/") {
+		fullPath += "/"
+	}
+	engine := engineOf(group)
+
+	specRoutesMu.Lock()
+	defer specRoutesMu.Unlock()
+
+	specRoutes[engine] = append(specRoutes[engine], specRoute{
+		method:   route.method,
+		path:     fullPath,
+		action:   route.action,
+		model:    route.model,
+		request:  route.request,
+		response: route.response,
+	})
+}
+
+// OpenAPISpec generates an OpenAPI 3 spec (in JSON) of the routes
+// registered by Crud to the engine (including the nested routes), for
+// example, to publish with Swagger UI:
+//    r.GET("/openapi.json", func(c *gin.Context) {
+//        spec, _ := router.OpenAPISpec(r)
+//        c.Data(http.StatusOK, "application/json", spec)
+//    })
+//
+// The schemas are reflected from the models: fields are named by their json
+// tags, and fields with `binding:"required"` are required. The schemas are
+// named by the package and the type name (e.g. "models.User"), so that the
+// same-named types of different packages are told apart.
+// It is a minimal spec: paths, methods, parameters, request bodies and
+// responses (200, 400, 404, 422) are included.
+func OpenAPISpec(engine *gin.Engine) ([]byte, error) {
+	specRoutesMu.Lock()
+	routes := make([]specRoute, len(specRoutes[engineOf(&engine.RouterGroup)]))
+	copy(routes, specRoutes[engineOf(&engine.RouterGroup)])
+	specRoutesMu.Unlock()
+
+	schemas := &specSchemas{
+		schemas: map[string]any{"Error": errorSchema()},
+		names:   map[reflect.Type]string{},
+	}
+	paths := map[string]map[string]any{}
+
+	for _, route := range routes {
+		openAPIPath, parameters := openAPIPathOf(route.path)
+		hasPathParameters := len(parameters) > 0
+		if paths[openAPIPath] == nil {
+			paths[openAPIPath] = map[string]any{}
+		}
+
+		if route.response != nil && route.response.Kind() == reflect.Slice {
+			parameters = append(parameters, listQueryParameters()...)
+		}
+
+		operation := map[string]any{
+			"tags":      []string{route.model},
+			"summary":   route.action + " " + route.model,
+			"responses": openAPIResponses(route, hasPathParameters, schemas),
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if route.request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{
+						"schema": schemaOf(route.request, schemas),
+					},
+				},
+			}
+		}
+
+		paths[openAPIPath][strings.ToLower(route.method)] = operation
+	}
+
+	spec := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "CRUD API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas.schemas,
+		},
+	}
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+// openAPIPathOf converts a gin path to an OpenAPI path with its parameters:
+//    /users/:UserID => /users/{UserID}, [UserID]
+func openAPIPathOf(ginPath string) (string, []any) {
+	var parameters []any
+
+	segments := strings.Split(ginPath, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") && !strings.HasPrefix(segment, "*") {
+			continue
+		}
+		name := segment[1:]
+		segments[i] = "{" + name + "}"
+		parameters = append(parameters, map[string]any{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "string"},
+		})
+	}
+	return strings.Join(segments, "/"), parameters
+}
+
+// listQueryParameters are the query parameters of the list routes,
+// see controller.GetRequestOptions.
+func listQueryParameters() []any {
+	query := func(name, typ, description string) any {
+		return map[string]any{
+			"name":        name,
+			"in":          "query",
+			"description": description,
+			"schema":      map[string]any{"type": typ},
+		}
+	}
+	return []any{
+		query("limit", "integer", "max number of results"),
+		query("offset", "integer", "number of results to skip"),
+		query("order_by", "string", "field to order by: field[:asc|desc]"),
+		query("desc", "boolean", "order descending"),
+		query("filter_by", "string", "field to filter by"),
+		query("filter_value", "string", "value of the filter_by field"),
+		query("filter_op", "string", "operator of the filter_value: eq (default), ne, gt, gte, lt, lte"),
+		query("between_field", "string", "field of the range"),
+		query("between_low", "string", "lower bound of the range (inclusive)"),
+		query("between_high", "string", "upper bound of the range (inclusive)"),
+		query("null", "string", "field that is null"),
+		query("not_null", "string", "field that is not null"),
+		query("scope", "string", "name of the scope to apply"),
+		query("fields", "string", "comma separated fields to respond (sparse fieldset)"),
+		query("stream", "boolean", "stream the records in NDJSON (all of them with WithStream)"),
+		query("preload", "string", "association to preload"),
+		query("total", "boolean", "include the total count"),
+		query("with_trashed", "boolean", "include the soft-deleted records"),
+		query("only_trashed", "boolean", "only the soft-deleted records"),
+	}
+}
+
+// openAPIResponses builds the responses of the route.
+func openAPIResponses(route specRoute, hasPathParameters bool, schemas *specSchemas) map[string]any {
+	var success map[string]any
+	if route.response == nil {
+		success = map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"deleted":      map[string]any{"type": "boolean"},
+				"rowsAffected": map[string]any{"type": "integer"},
+			},
+		}
+	} else {
+		modelSchema := schemaOf(route.response, schemas)
+		key := responseModelName(route.response)
+		switch controller.ResponseEnvelope() {
+		case controller.EnvelopeBare:
+			success = modelSchema
+		case controller.EnvelopeData:
+			key = "data"
+			fallthrough
+		default:
+			success = map[string]any{
+				"type":       "object",
+				"properties": map[string]any{key: modelSchema},
+			}
+		}
+	}
+
+	errorResponse := func(description string) any {
+		return map[string]any{
+			"description": description,
+			"content": map[string]any{
+				"application/json": map[string]any{
+					"schema": map[string]any{"$ref": "#/components/schemas/Error"},
+				},
+			},
+		}
+	}
+
+	responses := map[string]any{
+		"200": map[string]any{
+			"description": http.StatusText(http.StatusOK),
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": success},
+			},
+		},
+		"400": errorResponse(http.StatusText(http.StatusBadRequest)),
+		"422": errorResponse(http.StatusText(http.StatusUnprocessableEntity)),
+	}
+	if hasPathParameters { // model not found
+		responses["404"] = errorResponse(http.StatusText(http.StatusNotFound))
+	}
+	return responses
+}
+
+// responseModelName is the key of the model in the response body
+// of the typed envelope, see controller.SuccessResponseBody.
+func responseModelName(t reflect.Type) string {
+	plural := t.Kind() == reflect.Slice
+	if plural {
+		t = t.Elem()
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return controller.ResponseKey(t, plural)
+}
+
+// errorSchema is the schema of the error responses,
+// see controller.ResponseError.
+func errorSchema() map[string]any {
+	if controller.APIErrorEnabled() {
+		return map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"error": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"code":    map[string]any{"type": "string"},
+						"message": map[string]any{"type": "string"},
+						"details": map[string]any{"type": "object"},
+					},
+				},
+			},
+		}
+	}
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"error": map[string]any{"type": "string"},
+		},
+	}
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+)
+
+// specSchemas are the component schemas of a spec.
+type specSchemas struct {
+	schemas map[string]any          // name => schema
+	names   map[reflect.Type]string // type => name in schemas
+}
+
+// schemaNameRegexp matches the characters not allowed in a schema name.
+var schemaNameRegexp = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// name returns the name of the named type t in the schemas: "pkg.Type",
+// or the full package path (e.g. "github.com.x.models.Type") if the short
+// one is taken by another type.
+func (s *specSchemas) name(t reflect.Type) string {
+	if name, ok := s.names[t]; ok {
+		return name
+	}
+	pkg := t.PkgPath()
+	name := schemaNameRegexp.ReplaceAllString(path.Base(pkg)+"."+t.Name(), "_")
+	if _, taken := s.schemas[name]; taken {
+		name = schemaNameRegexp.ReplaceAllString(strings.ReplaceAll(pkg, "/", ".")+"."+t.Name(), "_")
+	}
+	s.names[t] = name
+	return name
+}
+
+// schemaOf reflects the schema of type t. Named structs are added to
+// the schemas as components, and referenced by $ref.
+func schemaOf(t reflect.Type, schemas *specSchemas) map[string]any {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case deletedAtType:
+		return map[string]any{"type": "string", "format": "date-time", "nullable": true}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		_, seen := schemas.names[t]
+		name := schemas.name(t)
+		if !seen {
+			schemas.schemas[name] = map[string]any{} // placeholder for recursive types
+			schemas.schemas[name] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{} // any type
+}
+
+// structSchema reflects the schema of the struct type t.
+func structSchema(t reflect.Type, schemas *specSchemas) map[string]any {
+	properties := map[string]any{}
+	var required []string
+
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+
+			fieldType := field.Type
+			for fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+				collect(fieldType) // embedded fields are promoted
+				continue
+			}
+
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = schemaOf(field.Type, schemas)
+
+			for _, rule := range strings.Split(field.Tag.Get("binding"), ",") {
+				if rule == "required" {
+					required = append(required, name)
+				}
+			}
+		}
+	}
+	collect(t)
+
+	schema := map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
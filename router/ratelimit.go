@@ -0,0 +1,109 @@
+package router
+
+import (
+	"errors"
+	"github.com/cdfmlr/crud/controller"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiterTTL is how long the limiter of an idle client is kept.
+const rateLimiterTTL = 3 * time.Minute
+
+// WithRateLimit limits the rate of requests from each client (by
+// gin.Context.ClientIP) to rps requests per second, with bursts of at
+// most burst requests. Requests exceeding the limit are responded as
+// 429 Too Many Requests, with a Retry-After header.
+//
+// It protects the whole router, use WithMiddleware in Crud to limit the
+// routes of a model.
+//
+// Notice: ClientIP trusts the X-Forwarded-For headers from the trusted
+// proxies, see gin.Engine.SetTrustedProxies.
+func WithRateLimit(rps float64, burst int) RouterOption {
+	return func(router gin.IRouter) gin.IRouter {
+		router.Use(newRateLimiters(rate.Limit(rps), burst).middleware)
+		return router
+	}
+}
+
+// rateLimiters are the rate limiters of clients, expired after idle
+// for rateLimiterTTL.
+type rateLimiters struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiters(limit rate.Limit, burst int) *rateLimiters {
+	return &rateLimiters{
+		limit:     limit,
+		burst:     burst,
+		clients:   map[string]*clientLimiter{},
+		lastSweep: time.Now(),
+	}
+}
+
+// get returns the limiter of the client, creating it if necessary.
+// The expired limiters are swept every rateLimiterTTL.
+func (l *rateLimiters) get(client string, now time.Time) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimiterTTL {
+		for k, v := range l.clients {
+			if now.Sub(v.lastSeen) > rateLimiterTTL {
+				delete(l.clients, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	c, ok := l.clients[client]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[client] = c
+	}
+	c.lastSeen = now
+	return c.limiter
+}
+
+func (l *rateLimiters) middleware(c *gin.Context) {
+	now := time.Now()
+	reservation := l.get(c.ClientIP(), now).ReserveN(now, 1)
+
+	delay := time.Duration(math.MaxInt64) // never: burst is 0
+	if reservation.OK() {
+		delay = reservation.DelayFrom(now)
+	}
+	if delay == 0 {
+		c.Next()
+		return
+	}
+	reservation.CancelAt(now) // not served
+
+	retryAfter := int(math.Ceil(delay.Seconds()))
+	if !reservation.OK() {
+		retryAfter = int(rateLimiterTTL.Seconds())
+	}
+	logger.WithContext(c).WithField("client", c.ClientIP()).
+		Warn("rateLimiters: too many requests")
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	controller.ResponseError(c, http.StatusTooManyRequests, ErrTooManyRequests)
+	c.Abort()
+}
+
+var ErrTooManyRequests = errors.New("too many requests")
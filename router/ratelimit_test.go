@@ -0,0 +1,59 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := NewRouter(WithRateLimit(1, 2))
+	r.GET("/todos", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	get := func(ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+		req.RemoteAddr = ip + ":1234"
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ { // burst
+		if w := get("10.0.0.1"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d", i, w.Code, http.StatusOK)
+		}
+	}
+	w := get("10.0.0.1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("exceeded: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("exceeded: Retry-After = %q, want %q", got, "1")
+	}
+
+	if w := get("10.0.0.2"); w.Code != http.StatusOK { // limited per client
+		t.Errorf("another client: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestRateLimiters_expire(t *testing.T) {
+	limiters := newRateLimiters(1, 1)
+	now := time.Now()
+
+	limiters.get("10.0.0.1", now)
+	limiters.get("10.0.0.2", now.Add(rateLimiterTTL/2))
+	limiters.get("10.0.0.2", now.Add(rateLimiterTTL+time.Second)) // sweep
+
+	if _, ok := limiters.clients["10.0.0.1"]; ok {
+		t.Errorf("idle client not expired")
+	}
+	if _, ok := limiters.clients["10.0.0.2"]; !ok {
+		t.Errorf("active client expired")
+	}
+}
@@ -1,13 +1,25 @@
 package router
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cdfmlr/crud/config"
+	"github.com/cdfmlr/crud/controller"
 	"github.com/cdfmlr/crud/log"
+	"github.com/cdfmlr/crud/orm"
 	gin_request_id "github.com/cdfmlr/crud/pkg/gin-request-id"
+	"github.com/cdfmlr/crud/service"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"os"
+	"time"
 )
 
-var logger = log.ZoneLogger("crud/router")
+var logger = log.ContextZoneLogger("crud/router")
 
 // NewRouter creates a new router (a gin.New() router)
 // with gin.Recovery() middleware, the log.Logger4Gin middleware,
@@ -17,6 +29,8 @@ func NewRouter(options ...RouterOption) *gin.Engine {
 	router := gin.New()
 	router.Use(gin.Recovery(), log.Logger4Gin, gin_request_id.RequestID())
 
+	HandleMethodNotAllowed(router)
+
 	for _, option := range options {
 		router = option(router).(*gin.Engine)
 	}
@@ -24,6 +38,32 @@ func NewRouter(options ...RouterOption) *gin.Engine {
 	return router
 }
 
+// HandleMethodNotAllowed makes the engine respond the requests to a
+// registered path with an unregistered method (e.g. POST /users/123 of the
+// Crud routes) as 405 Method Not Allowed (see controller.ResponseError),
+// instead of 404 Not Found, with an Allow header of the registered methods.
+// The OPTIONS requests to them (which are not the CORS preflights handled
+// by the CORS middleware, see WithCORS) are responded as 204 No Content
+// with the Allow header.
+//
+// It is enabled by NewRouter. Call it on the engines created otherwise,
+// e.g. by gin.Default().
+func HandleMethodNotAllowed(engine *gin.Engine) {
+	engine.HandleMethodNotAllowed = true
+	engine.NoMethod(methodNotAllowedHandler)
+}
+
+// methodNotAllowedHandler responds the requests with a method not allowed,
+// the Allow header is set by gin, see HandleMethodNotAllowed.
+func methodNotAllowedHandler(c *gin.Context) {
+	if c.Request.Method == http.MethodOptions {
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+	controller.ResponseError(c, controller.CodeMethodNotAllowed, controller.ErrMethodNotAllowed)
+	c.Abort()
+}
+
 // RouterOption is an option to construct the router.
 type RouterOption func(router gin.IRouter) gin.IRouter
 
@@ -37,6 +77,44 @@ func AllowAllCors() RouterOption {
 	}
 }
 
+// WithCORS adds the CORS middleware configured by cfg.
+// If cfg is invalid (see cors.Config.Validate), the option is ignored
+// with an error logged.
+func WithCORS(cfg cors.Config) RouterOption {
+	return func(router gin.IRouter) gin.IRouter {
+		if err := cfg.Validate(); err != nil {
+			logger.WithError(err).
+				Error("WithCORS: invalid cors config. Ignored.")
+			return router
+		}
+		router.Use(cors.New(cfg))
+		return router
+	}
+}
+
+// WithAllowedOrigins allows the CORS requests from the origins
+// (e.g. "https://app.example.com") only, with the methods used by the
+// crud routes and the common headers:
+//    Allow-Methods: GET, POST, PUT, PATCH, DELETE, HEAD, OPTIONS
+//    Allow-Headers: Origin, Content-Type, Content-Length, Accept, Authorization, X-Request-Id, If-Match, If-None-Match
+//    Expose-Headers: X-Request-Id, X-Total-Count, ETag
+// Use WithCORS for other configs.
+func WithAllowedOrigins(origins ...string) RouterOption {
+	return WithCORS(cors.Config{
+		AllowOrigins: origins,
+		AllowMethods: []string{
+			http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+			http.MethodDelete, http.MethodHead, http.MethodOptions,
+		},
+		AllowHeaders: []string{
+			"Origin", "Content-Type", "Content-Length", "Accept",
+			"Authorization", "X-Request-Id", "If-Match", "If-None-Match",
+		},
+		ExposeHeaders: []string{"X-Request-Id", "X-Total-Count", "ETag"},
+		MaxAge:        12 * time.Hour,
+	})
+}
+
 // WithRequestID adds the gin_request_id.RequestID() middleware,
 // which adds a request_id in the context for each request.
 // And the request_id will be writen to the X-Request-Id response header.
@@ -47,6 +125,33 @@ func WithRequestID() RouterOption {
 	}
 }
 
+// WithRequestDebug logs the requests with the header (e.g. "X-Debug")
+// at the trace level, without changing the level of the global logger:
+// the logs of the crud packages (controller, service, ...) for these
+// requests are verbose, for debugging a specific client in production.
+//
+// Notice: anyone can send the header. Put an authentication middleware
+// before it (or strip the header at the gateway) to prevent flooding the
+// logs.
+func WithRequestDebug(headerName string) RouterOption {
+	return func(router gin.IRouter) gin.IRouter {
+		router.Use(requestDebugMiddleware(headerName))
+		return router
+	}
+}
+
+// requestDebugMiddleware puts a trace level logger (see
+// log.ContextWithLogger) into the context of the requests with the header.
+func requestDebugMiddleware(headerName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader(headerName) != "" {
+			entry := log.LevelLogger(log.LevelTrace)
+			c.Request = c.Request.WithContext(log.ContextWithLogger(c.Request.Context(), entry))
+		}
+		c.Next()
+	}
+}
+
 // WithMiddleware adds custom middlewares to the router.
 func WithMiddleware(middleware ...gin.HandlerFunc) RouterOption {
 	return func(router gin.IRouter) gin.IRouter {
@@ -54,3 +159,179 @@ func WithMiddleware(middleware ...gin.HandlerFunc) RouterOption {
 		return router
 	}
 }
+
+// WithAPIError enables the structured error responses:
+//    { error: { code: "NOT_FOUND", message: "...", details: {...} } }
+// instead of { error: "..." }, for the crud handlers of all the routers.
+// See controller.WithAPIError and controller.APIError for more details.
+func WithAPIError() RouterOption {
+	return func(router gin.IRouter) gin.IRouter {
+		controller.WithAPIError()
+		return router
+	}
+}
+
+// WithRESTStatus makes the create routes (of all the routers) respond
+// 201 Created with a Location header of the created model, instead of
+// 200 OK. See controller.RESTStatusEnabled.
+func WithRESTStatus() RouterOption {
+	return func(router gin.IRouter) gin.IRouter {
+		controller.WithRESTStatus()
+		return router
+	}
+}
+
+// WithEnvelope sets the style of the success response bodies of the crud
+// handlers of all the routers, see controller.EnvelopeStyle for the
+// available styles.
+func WithEnvelope(style controller.EnvelopeStyle) RouterOption {
+	return func(router gin.IRouter) gin.IRouter {
+		controller.WithEnvelope(style)
+		return router
+	}
+}
+
+// WithTimeout sets a deadline of d to the context of each request, so that
+// the service calls (which take the context of the request) are canceled,
+// and the in-flight database queries are aborted on the drivers that
+// support it.
+//
+// Expired requests are responded as 504 Gateway Timeout (see
+// controller.ResponseErrorAuto), or, if the handler wrote nothing, by this
+// middleware.
+//
+// Notice: it enables gin.Engine.ContextWithFallback, so that gin.Context
+// passes the deadline of the request context through, for the custom
+// handlers using c as a context.Context.
+func WithTimeout(d time.Duration) RouterOption {
+	return func(router gin.IRouter) gin.IRouter {
+		if engine, ok := router.(*gin.Engine); ok {
+			engine.ContextWithFallback = true
+		} else {
+			logger.Warn("WithTimeout: router is not a *gin.Engine, " +
+				"make sure ContextWithFallback is enabled.")
+		}
+		router.Use(timeoutMiddleware(d))
+		return router
+	}
+}
+
+// timeoutMiddleware cancels the request context after d.
+func timeoutMiddleware(d time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		if err := ctx.Err(); err != nil && !c.Writer.Written() {
+			logger.WithContext(c).WithError(err).
+				Warn("timeoutMiddleware: request expired")
+			if errors.Is(err, context.DeadlineExceeded) {
+				controller.ResponseError(c, controller.CodeTimeout, err)
+			} else {
+				controller.ResponseError(c, controller.CodeUnavailable, err)
+			}
+			c.Abort()
+		}
+	}
+}
+
+// WithHealthCheck registers a health check handler at path (default
+// "/healthz"), for the liveness and readiness probes. It responds
+//    200 { status: "ok" }
+// or, if the database is connected but unreachable (see orm.Ping):
+//    503 { status: "unavailable" }
+// The error is logged, but not responded, to not leak the internals
+// (e.g. the addresses of the database) to the probes.
+func WithHealthCheck(path string) RouterOption {
+	if path == "" {
+		path = "/healthz"
+	}
+	return func(router gin.IRouter) gin.IRouter {
+		router.GET(path, healthCheckHandler)
+		router.HEAD(path, healthCheckHandler)
+		return router
+	}
+}
+
+// healthCheckHandler responds the health of the service, see WithHealthCheck.
+func healthCheckHandler(c *gin.Context) {
+	if orm.Current() != nil {
+		if err := orm.Ping(c.Request.Context()); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("healthCheckHandler: database unreachable")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// WithMetrics enables the metrics of the service calls, registered to the
+// registerer (see service.WithMetrics), and exposes the metrics of the
+// gatherer at GET path, behind the middleware (e.g. an authentication),
+// so that they are not public:
+//
+//    registry := prometheus.NewRegistry()
+//    r := NewRouter(WithMetrics("/metrics", registry, registry, gin.BasicAuth(accounts)))
+//
+// The path "" exposes nothing, for the metrics served by another
+// listener (e.g. promhttp.HandlerFor on an internal port). A nil registerer
+// or gatherer is the prometheus.DefaultRegisterer or DefaultGatherer.
+func WithMetrics(path string, registerer prometheus.Registerer, gatherer prometheus.Gatherer, middleware ...gin.HandlerFunc) RouterOption {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	if gatherer == nil {
+		gatherer = prometheus.DefaultGatherer
+	}
+	return func(router gin.IRouter) gin.IRouter {
+		if err := service.WithMetrics(registerer); err != nil {
+			logger.WithError(err).
+				Error("WithMetrics: enable service metrics failed")
+		}
+		if path != "" {
+			handlers := append(append([]gin.HandlerFunc{}, middleware...),
+				gin.WrapH(promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})))
+			router.GET(path, handlers...)
+		}
+		return router
+	}
+}
+
+// RunFromConfig runs the engine (see gin.Engine.Run) at the cfg.Addr,
+// serving HTTPS (see gin.Engine.RunTLS) with the cfg.TLSCertPath and
+// cfg.TLSKeyPath if cfg.Https is true, or plain HTTP otherwise:
+//
+//    r := NewRouter()
+//    Crud[Todo](r, "/todos")
+//    err := RunFromConfig(r, conf.HTTP)
+//
+// The cert and key files are checked before serving, so that a wrong path
+// fails fast instead of at the first TLS handshake.
+// It blocks unless an error happens.
+func RunFromConfig(engine *gin.Engine, cfg config.HTTPConfig) error {
+	if !cfg.Https {
+		logger.WithField("addr", cfg.Addr).Info("RunFromConfig: serving HTTP")
+		return engine.Run(cfg.Addr)
+	}
+
+	for _, file := range []struct{ name, path string }{
+		{"cert", cfg.TLSCertPath},
+		{"key", cfg.TLSKeyPath},
+	} {
+		if file.path == "" {
+			return fmt.Errorf("%w: missing tls %s file", ErrTLSConfig, file.name)
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			return fmt.Errorf("%w: tls %s file: %w", ErrTLSConfig, file.name, err)
+		}
+	}
+
+	logger.WithField("addr", cfg.Addr).Info("RunFromConfig: serving HTTPS")
+	return engine.RunTLS(cfg.Addr, cfg.TLSCertPath, cfg.TLSKeyPath)
+}
+
+var ErrTLSConfig = errors.New("invalid tls config")
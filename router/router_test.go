@@ -0,0 +1,326 @@
+package router
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"github.com/cdfmlr/crud/config"
+	"github.com/cdfmlr/crud/controller"
+	"github.com/cdfmlr/crud/log"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/sirupsen/logrus"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := NewRouter(WithTimeout(20 * time.Millisecond))
+	slow := func(c *gin.Context) error { // a slow query that respects the context
+		select {
+		case <-c.Done():
+			return c.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+	r.GET("/slow", func(c *gin.Context) {
+		if err := slow(c); err != nil {
+			controller.ResponseErrorAuto(c, controller.CodeProcessFailed, err)
+			return
+		}
+		c.String(http.StatusOK, "done")
+	})
+	r.GET("/silent", func(c *gin.Context) {
+		_ = slow(c)
+	})
+	r.GET("/fast", func(c *gin.Context) {
+		c.String(http.StatusOK, "done")
+	})
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/slow", http.StatusGatewayTimeout},
+		{"/silent", http.StatusGatewayTimeout},
+		{"/fast", http.StatusOK},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		if got := request(r, http.MethodGet, tt.path, ""); got != tt.want {
+			t.Errorf("GET %s: status = %d, want %d", tt.path, got, tt.want)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("GET %s: not canceled in time: %v", tt.path, elapsed)
+		}
+	}
+}
+
+func TestWithAllowedOrigins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := NewRouter(WithAllowedOrigins("https://app.example.com"))
+	r.GET("/todos", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		wantCode   int
+		wantOrigin string
+	}{
+		{"allowed", http.MethodGet, "https://app.example.com", http.StatusOK, "https://app.example.com"},
+		{"preflight", http.MethodOptions, "https://app.example.com", http.StatusNoContent, "https://app.example.com"},
+		{"disallowed", http.MethodGet, "https://evil.example.com", http.StatusForbidden, ""},
+		{"same origin", http.MethodGet, "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/todos", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+			}
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+		})
+	}
+}
+
+func TestWithCORS_invalid(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// no origins allowed: ignored instead of panicking
+	r := NewRouter(WithCORS(cors.Config{}))
+	r.GET("/todos", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	if got := request(r, http.MethodGet, "/todos", ""); got != http.StatusOK {
+		t.Errorf("status = %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestWithHealthCheck(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+
+	r := NewRouter(WithHealthCheck(""))
+	if got := request(r, http.MethodGet, "/healthz", ""); got != http.StatusOK {
+		t.Errorf("GET /healthz: status = %d, want %d", got, http.StatusOK)
+	}
+
+	r = NewRouter(WithHealthCheck("/ready"))
+	if got := request(r, http.MethodHead, "/ready", ""); got != http.StatusOK {
+		t.Errorf("HEAD /ready: status = %d, want %d", got, http.StatusOK)
+	}
+
+	sqlDB, _ := orm.DB.DB()
+	_ = sqlDB.Close() // database unreachable
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /ready: status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if body := strings.TrimSpace(w.Body.String()); body != `{"status":"unavailable"}` {
+		t.Errorf("GET /ready: body = %s, want only the status", body)
+	}
+}
+
+func TestWithMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector())
+	auth := gin.BasicAuth(gin.Accounts{"admin": "secret"})
+	r := NewRouter(WithMetrics("/internal/metrics", registry, registry, auth))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/internal/metrics", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status without auth = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/internal/metrics", nil)
+	req.SetBasicAuth("admin", "secret")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if !strings.Contains(w.Body.String(), "go_goroutines") {
+		t.Errorf("body = %q, want the metrics of the registry", w.Body.String())
+	}
+
+	r = NewRouter(WithMetrics("", registry, registry))
+	r.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+	if code := request(r, http.MethodGet, "/metrics", ""); code != http.StatusNotFound {
+		t.Errorf("status with no path = %v, want %v", code, http.StatusNotFound)
+	}
+}
+
+func TestRunFromConfig_tls(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := writeSelfSignedCert(t, certPath, keyPath)
+
+	r := NewRouter()
+	r.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+
+	err := RunFromConfig(r, config.HTTPConfig{Addr: "127.0.0.1:0", Https: true, TLSCertPath: filepath.Join(dir, "missing.pem"), TLSKeyPath: keyPath})
+	if !errors.Is(err, ErrTLSConfig) {
+		t.Errorf("RunFromConfig(missing cert) error = %v, want %v", err, ErrTLSConfig)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	go func() {
+		_ = RunFromConfig(r, config.HTTPConfig{Addr: addr, Https: true, TLSCertPath: certPath, TLSKeyPath: keyPath})
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	var resp *http.Response
+	for i := 0; i < 50; i++ { // wait for the server
+		if resp, err = client.Get("https://" + addr + "/ping"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("GET over tls: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+		t.Errorf("status = %v, tls = %v, want 200 over tls", resp.StatusCode, resp.TLS != nil)
+	}
+}
+
+// writeSelfSignedCert writes a self-signed cert of 127.0.0.1 and its key
+// into the files, and returns the cert in PEM.
+func writeSelfSignedCert(t *testing.T, certPath, keyPath string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "crud test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certPath, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPEM
+}
+
+func TestWithRequestDebug(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	output := log.Logger.Out
+	log.Logger.SetOutput(&buf)
+	t.Cleanup(func() { log.Logger.SetOutput(output) })
+
+	r := NewRouter(WithRequestDebug("X-Debug"))
+	r.GET("/ping", func(c *gin.Context) {
+		logger.WithContext(c).Trace("ping traced")
+		c.String(http.StatusOK, "pong")
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ping", nil))
+	if strings.Contains(buf.String(), "ping traced") {
+		t.Errorf("traced without the debug header")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.Header.Set("X-Debug", "1")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if !strings.Contains(buf.String(), "ping traced") || !strings.Contains(buf.String(), "zone=crud/router") {
+		t.Errorf("log = %q, want the trace with the zone", buf.String())
+	}
+	if log.Logger.IsLevelEnabled(logrus.TraceLevel) {
+		t.Errorf("the level of the global logger is changed")
+	}
+}
+
+func TestHandleMethodNotAllowed(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+	r := NewRouter()
+	Crud[testTodo](r, "/todos")
+
+	tests := []struct {
+		method, target string
+		wantCode       int
+		wantAllow      string
+	}{
+		{http.MethodPost, "/todos/1", http.StatusMethodNotAllowed, "GET, PUT, DELETE"},
+		{http.MethodPatch, "/todos", http.StatusMethodNotAllowed, "GET, POST"},
+		{http.MethodOptions, "/todos/1", http.StatusNoContent, "GET, PUT, DELETE"},
+		{http.MethodGet, "/nope", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != tt.wantCode {
+			t.Errorf("%s %s status = %v, want %v", tt.method, tt.target, w.Code, tt.wantCode)
+		}
+		if allow := w.Header().Get("Allow"); allow != tt.wantAllow {
+			t.Errorf("%s %s Allow = %q, want %q", tt.method, tt.target, allow, tt.wantAllow)
+		}
+		if tt.wantCode == http.StatusMethodNotAllowed && !strings.Contains(w.Body.String(), "method not allowed") {
+			t.Errorf("%s %s body = %s, want the error", tt.method, tt.target, w.Body)
+		}
+	}
+}
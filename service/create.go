@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"github.com/cdfmlr/crud/orm"
 	"gorm.io/gorm"
 )
@@ -21,8 +22,65 @@ import (
 //    group := GetByID[Group](123)
 //    Create(&user, NestInto(&group, "users"))
 //    // user is already in the database: just add it into group.users
-func Create(ctx context.Context, model any, in CreateMode) error {
-	return in(ctx, model)
+func Create(ctx context.Context, model any, in CreateMode) (err error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	defer observe(opCreate, model)(&err)
+
+	err = in(ctx, model)
+	if err == nil {
+		emit(ctx, EventCreate, model)
+	}
+	return err
+}
+
+// CreateMany creates the models in the database in a transaction: either
+// all of them are created, or none of them (all-or-nothing). The models
+// are created one by one, so that the ids are filled in, and the failed
+// one is reported by an *ItemError with its index in models.
+// Nested models associated with the models will be created as well.
+func CreateMany[T any](ctx context.Context, models []*T) (err error) {
+	defer observe(opCreate, (*T)(nil))(&err)
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	logger.WithContext(ctx).
+		WithField("count", len(models)).
+		Trace("CreateMany")
+
+	db, release := orm.Acquire()
+	defer release()
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i, model := range models {
+			if err := tx.Create(model).Error; err != nil {
+				return &ItemError{Index: i, Err: err}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.WithContext(ctx).WithError(err).
+			Warn("CreateMany: failed")
+		return err
+	}
+	for _, model := range models {
+		emit(ctx, EventCreate, model)
+	}
+	return nil
+}
+
+// ItemError is an error of the item at Index of a batch operation.
+type ItemError struct {
+	Index int
+	Err   error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
 }
 
 // CreateMode is the way to create a model:
@@ -61,11 +119,47 @@ func NestInto(parent any, field string) CreateMode {
 			WithField("modelToCreate", modelToCreate).
 			Trace("Create Nested")
 
-		return orm.DB.WithContext(ctx).Session(&gorm.Session{FullSaveAssociations: true}).
+		db, release := orm.Acquire()
+		defer release()
+		return db.WithContext(ctx).Session(&gorm.Session{FullSaveAssociations: true}).
 			Model(parent).Association(field).Append(modelToCreate)
 	}
 }
 
+// ReplaceAssociation replaces the associations parent.field with children
+// (a slice of the child models), i.e. sets them as the complete list:
+// new children are created and linked, the ones already in the database are
+// linked (without updating their fields), and the previously linked ones
+// not in children are detached:
+//    ReplaceAssociation(ctx, &project, "Todos", []*Todo{todo1, todo3})
+//    // project.Todos: [todo1, todo2] => [todo1, todo3]
+//
+// While NestInto appends children to the associations, and keeps the
+// existing ones. The detached children are not deleted: for many2many,
+// the join table rows are removed; for has one / has many, the foreign
+// keys of them are set to NULL.
+//
+// This is useful to handle PUTs like /api/projects/{project_id}/todos
+func ReplaceAssociation[P orm.Model](ctx context.Context, parent *P, field string, children any) error {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	logger.WithContext(ctx).
+		WithField("parent", parent).
+		WithField("field", field).
+		WithField("children", children).
+		Trace("ReplaceAssociation")
+
+	db, release := orm.Acquire()
+	defer release()
+	err := db.WithContext(ctx).Model(parent).Association(field).Replace(children)
+	if err != nil {
+		logger.WithContext(ctx).
+			WithError(err).Warn("ReplaceAssociation: failed")
+	}
+	return err
+}
+
 // IfNotExist creates a model if it does not exist.
 func IfNotExist() CreateMode {
 	return func(ctx context.Context, modelToCreate any) error {
@@ -73,6 +167,8 @@ func IfNotExist() CreateMode {
 			WithField("modelToCreate", modelToCreate).
 			Trace("Create IfNotExist")
 
-		return orm.DB.WithContext(ctx).Create(modelToCreate).Error
+		db, release := orm.Acquire()
+		defer release()
+		return db.WithContext(ctx).Create(modelToCreate).Error
 	}
 }
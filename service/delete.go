@@ -2,19 +2,36 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
 )
 
 // Delete a model from database.
 func Delete(ctx context.Context, model any) (rowsAffected int64, err error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	defer observe(opDelete, model)(&err)
+
 	logger.WithContext(ctx).
 		WithField("model", model).Trace("Delete model")
-	result := orm.DB.WithContext(ctx).Delete(model)
+	db, release := scopedDB(ctx)
+	defer release()
+	result := db.Delete(model)
+	if result.Error == nil && result.RowsAffected > 0 {
+		emit(ctx, EventDelete, model)
+	}
 	return result.RowsAffected, result.Error
 }
 
 // DeleteByID deletes a model from database by its ID.
 func DeleteByID[T orm.Model](ctx context.Context, id any) (rowsAffected int64, err error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	defer observe(opDelete, (*T)(nil))(&err)
+
 	logger.WithContext(ctx).
 		WithField("id", id).
 		Trace("DeleteByID: Delete model by ID")
@@ -26,29 +43,152 @@ func DeleteByID[T orm.Model](ctx context.Context, id any) (rowsAffected int64, e
 			Warn("DeleteByID: GetByID failed")
 		return 0, err
 	}
-	result := orm.DB.WithContext(ctx).Delete(&model)
+	db, release := scopedDB(ctx)
+	defer release()
+	result := db.Delete(&model)
 	if result.Error != nil {
 		logger.WithContext(ctx).
 			WithError(result.Error).Warn("DeleteByID: failed")
+	} else if result.RowsAffected > 0 {
+		emit(ctx, EventDelete, &model)
+	}
+	return result.RowsAffected, result.Error
+}
+
+// HardDelete permanently deletes a model from database by its ID.
+//
+// Unlike DeleteByID, which soft-deletes models with a DeletedAt field (for
+// example, the ones embedding orm.BasicModel), HardDelete removes the record
+// from the table, and it can purge an already soft-deleted record as well.
+func HardDelete[T orm.Model](ctx context.Context, id any) (rowsAffected int64, err error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	logger.WithContext(ctx).
+		WithField("id", id).
+		Trace("HardDelete: Permanently delete model by ID")
+
+	var model T
+	if err := GetByID[T](ctx, id, &model, WithTrashed()); err != nil {
+		logger.WithContext(ctx).
+			WithField("id", id).WithError(err).
+			Warn("HardDelete: GetByID failed")
+		return 0, err
+	}
+	db, release := scopedDB(ctx)
+	defer release()
+	result := db.Unscoped().Delete(&model)
+	if result.Error != nil {
+		logger.WithContext(ctx).
+			WithError(result.Error).Warn("HardDelete: failed")
+	} else if result.RowsAffected > 0 {
+		emit(ctx, EventDelete, &model)
 	}
 	return result.RowsAffected, result.Error
 }
 
 // DeleteNested remove the association between parent and child.
 func DeleteNested[P orm.Model, T any](ctx context.Context, parent *P, field string, child *T) error {
-	err := orm.DB.WithContext(ctx).Model(parent).Association(field).Delete(child)
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	db, release := orm.Acquire()
+	defer release()
+	err := db.WithContext(ctx).Model(parent).Association(field).Delete(child)
 	if err != nil {
 		logger.WithContext(ctx).
 			WithError(err).Warn("DeleteNested: failed")
 		return err
 	}
-	// TODO: check if child has no more parents, if none, delete it
-	// ™️ 砂仁还要猪心啊 /( ◕‿‿◕ )\
+	// child is kept even if it has no more parents,
+	// use DeleteNestedCascade to delete the orphans.
 	return err
 }
 
+// DeleteNestedCascade removes the association between parent and child
+// like DeleteNested, and then deletes the child if it has no more parents
+// (i.e. it is orphaned). For example, removing a todo from its last project
+// (many2many project_todos) deletes the todo as well.
+//
+// The parents are counted by the relationship of parent.field:
+//   - many2many: the rows referencing child in the join table;
+//   - belongs to: the parents referencing child;
+//   - has one / has many: none, the child is always orphaned.
+// Both are done in a transaction.
+func DeleteNestedCascade[P orm.Model, T any](ctx context.Context, parent *P, field string, child *T) error {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	logger := logger.WithContext(ctx).
+		WithField("parent", fmt.Sprintf("%T", parent)).
+		WithField("field", field)
+
+	db, release := orm.Acquire()
+	defer release()
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(parent).Association(field).Delete(child); err != nil {
+			logger.WithError(err).Warn("DeleteNestedCascade: delete association failed")
+			return err
+		}
+
+		parents, err := countParents(tx, parent, field, child)
+		if err != nil {
+			logger.WithError(err).Warn("DeleteNestedCascade: count parents failed")
+			return err
+		}
+		if parents > 0 {
+			return nil
+		}
+
+		logger.Trace("DeleteNestedCascade: delete the orphaned child")
+		if err := tx.Delete(child).Error; err != nil {
+			logger.WithError(err).Warn("DeleteNestedCascade: delete child failed")
+			return err
+		}
+		return nil
+	})
+}
+
+// countParents counts the parents (of the relationship parent.field)
+// referencing the child.
+func countParents(tx *gorm.DB, parent any, field string, child any) (count int64, err error) {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(parent); err != nil {
+		return 0, err
+	}
+	rel, ok := stmt.Schema.Relationships.Relations[field]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", gorm.ErrUnsupportedRelation, field)
+	}
+
+	var query *gorm.DB
+	switch rel.Type {
+	case schema.Many2Many:
+		query = tx.Table(rel.JoinTable.Table)
+	case schema.BelongsTo:
+		query = tx.Model(reflect.New(rel.Schema.ModelType).Interface())
+	default: // has one, has many: the foreign key of child is cleared
+		return 0, nil
+	}
+
+	childValue := reflect.Indirect(reflect.ValueOf(child))
+	for _, ref := range rel.References {
+		if ref.OwnPrimaryKey || ref.PrimaryKey == nil { // references to the parent
+			continue
+		}
+		value, _ := ref.PrimaryKey.ValueOf(tx.Statement.Context, childValue)
+		query = query.Where(map[string]any{ref.ForeignKey.DBName: value})
+	}
+
+	err = query.Count(&count).Error
+	return count, err
+}
+
 // DeleteNestedByID remove the association between parent and child.
 func DeleteNestedByID[P orm.Model, T orm.Model](ctx context.Context, parentID any, field string, childID any) error {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
 	logger.WithContext(ctx).
 		WithField("parentID", parentID).
 		WithField("field", field).
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"reflect"
+	"sync"
+)
+
+// Event is a kind of the write operations on models.
+type Event string
+
+const (
+	EventCreate Event = "create"
+	EventUpdate Event = "update"
+	EventDelete Event = "delete"
+)
+
+// EventOption is an option of the event handlers (see OnCreate).
+type EventOption func(handler *eventHandler)
+
+// Async makes the event handler run in a new goroutine, so that it does not
+// block the write operation. The context passed to it is not canceled when
+// the write operation returns (see context.WithoutCancel), and a
+// *gin.Context is replaced by a copy of it (see detachContext).
+func Async() EventOption {
+	return func(handler *eventHandler) {
+		handler.async = true
+	}
+}
+
+type eventHandler struct {
+	handle func(ctx context.Context, model any)
+	async  bool
+}
+
+type eventKey struct {
+	event Event
+	model reflect.Type
+}
+
+var (
+	eventsMu sync.RWMutex
+	events   = map[eventKey][]*eventHandler{}
+)
+
+// OnCreate registers a handler invoked after a model T is created by Create
+// (or CreateMany) successfully, with the created model. For example, to
+// publish the changes of users to a message queue:
+//
+//     OnCreate(func(ctx context.Context, user *User) {
+//         publish(ctx, "user.created", user)
+//     }, Async())
+//
+// The handlers run synchronously in the registered order by default, use
+// the Async option to run them in goroutines. A panic in a handler is
+// recovered and logged, which does not affect the write operation or the
+// other handlers. As the changes are committed already, there is no way for
+// a handler to abort them: use the database hooks of gorm for that.
+func OnCreate[T any](handle func(ctx context.Context, model *T), options ...EventOption) {
+	onEvent(EventCreate, handle, options...)
+}
+
+// OnUpdate registers a handler invoked after a model T is updated by
+// Update, UpdateField or UpdateFields successfully, with the updated model.
+// See OnCreate for more details.
+func OnUpdate[T any](handle func(ctx context.Context, model *T), options ...EventOption) {
+	onEvent(EventUpdate, handle, options...)
+}
+
+// OnDelete registers a handler invoked after a model T is deleted by
+// Delete, DeleteByID or HardDelete successfully, with the deleted model.
+// See OnCreate for more details.
+func OnDelete[T any](handle func(ctx context.Context, model *T), options ...EventOption) {
+	onEvent(EventDelete, handle, options...)
+}
+
+func onEvent[T any](event Event, handle func(ctx context.Context, model *T), options ...EventOption) {
+	handler := &eventHandler{
+		handle: func(ctx context.Context, model any) {
+			handle(ctx, model.(*T))
+		},
+	}
+	for _, option := range options {
+		option(handler)
+	}
+
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+
+	key := eventKey{event, reflect.TypeOf((*T)(nil)).Elem()}
+	events[key] = append(events[key], handler)
+}
+
+// emit invokes the handlers of the event on the model (a pointer to the
+// model struct, pointers to it are dereferenced).
+func emit(ctx context.Context, event Event, model any) {
+	value := reflect.ValueOf(model)
+	for value.Kind() == reflect.Ptr && value.Elem().Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return
+	}
+
+	eventsMu.RLock()
+	handlers := events[eventKey{event, value.Type().Elem()}]
+	eventsMu.RUnlock()
+
+	for _, handler := range handlers {
+		if handler.async {
+			go handleEvent(detachContext(ctx), event, handler, value.Interface())
+		} else {
+			handleEvent(ctx, event, handler, value.Interface())
+		}
+	}
+}
+
+// detachContext returns a context of ctx for the Async handlers, which
+// outlive the write operation: it is not canceled with ctx, and a
+// *gin.Context (which gin recycles for the later requests once the request
+// is handled) is replaced by its copy (gin.Context.Copy), keeping the
+// values set on it (e.g. "request_id").
+func detachContext(ctx context.Context) context.Context {
+	if c, ok := ctx.(*gin.Context); ok {
+		ctx = c.Copy()
+	}
+	return context.WithoutCancel(ctx)
+}
+
+// handleEvent calls the handler, recovering the panic in it.
+func handleEvent(ctx context.Context, event Event, handler *eventHandler, model any) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.WithContext(ctx).
+				WithField("event", event).
+				WithField("model", model).
+				WithField("panic", r).
+				Error("handleEvent: event handler panicked")
+		}
+	}()
+	handler.handle(ctx, model)
+}
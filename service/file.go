@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore stores the uploaded files, like the local disk or an object
+// storage (S3, OSS, ...).
+type FileStore interface {
+	// Save stores the content read from r as filename,
+	// and returns the url to access it.
+	Save(ctx context.Context, filename string, r io.Reader) (url string, err error)
+}
+
+// FileDeleter is an optional interface of a FileStore, deleting the files
+// it saved. The files replaced or left orphan by the handlers (see
+// controller.FileUploadHandler) are deleted if the store implements it.
+type FileDeleter interface {
+	// Delete deletes the file of the url returned by Save.
+	// Deleting a file that does not exist is not an error.
+	Delete(ctx context.Context, url string) error
+}
+
+// LocalFileStore is a FileStore storing the files in the directory Dir,
+// which are expected to be served at URLPrefix, for example:
+//    store := NewLocalFileStore("./uploads", "/uploads/")
+//    r.Static("/uploads", "./uploads")
+// saves "avatar.png" to ./uploads/avatar.png, with the url /uploads/avatar.png.
+// A file of the same name is overwritten.
+type LocalFileStore struct {
+	Dir       string
+	URLPrefix string
+}
+
+// NewLocalFileStore creates a LocalFileStore.
+func NewLocalFileStore(dir, urlPrefix string) *LocalFileStore {
+	return &LocalFileStore{Dir: dir, URLPrefix: urlPrefix}
+}
+
+// Save writes the file into the Dir, which is created if not exists.
+// The filename must be a plain name: ErrInvalidFilename is returned
+// for the names with a path (e.g. "../x").
+func (s *LocalFileStore) Save(ctx context.Context, filename string, r io.Reader) (string, error) {
+	if filename == "" || filename != filepath.Base(filename) || filename == "." || filename == ".." {
+		return "", fmt.Errorf("%w: %q", ErrInvalidFilename, filename)
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(s.Dir, filename)
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logger.WithContext(ctx).WithError(err).WithField("path", path).
+			Warn("LocalFileStore: Save failed")
+		_ = os.Remove(path)
+		return "", err
+	}
+
+	prefix := s.URLPrefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix + url.PathEscape(filename), nil
+}
+
+// Delete removes the file of the url from the Dir. The url must be one
+// returned by Save, i.e. a plain name under the URLPrefix: ErrInvalidFilename
+// is returned for the others.
+func (s *LocalFileStore) Delete(ctx context.Context, fileURL string) error {
+	prefix := s.URLPrefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	escaped, ok := strings.CutPrefix(fileURL, prefix)
+	filename, err := url.PathUnescape(escaped)
+	if !ok || err != nil || filename == "" || filename != filepath.Base(filename) || filename == "." || filename == ".." {
+		return fmt.Errorf("%w: %q", ErrInvalidFilename, fileURL)
+	}
+
+	path := filepath.Join(s.Dir, filename)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.WithContext(ctx).WithError(err).WithField("path", path).
+			Warn("LocalFileStore: Delete failed")
+		return err
+	}
+	return nil
+}
+
+var ErrInvalidFilename = errors.New("invalid filename")
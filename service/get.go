@@ -7,6 +7,11 @@ import (
 	"github.com/cdfmlr/crud/orm"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 )
 
 // Get fetch a single model T into dest.
@@ -28,7 +33,11 @@ import (
 //     SELECT * FROM sessions WHERE user_id = 10;  // into user.Sessions
 // Because this getting model by id is a common operation, a shortcut GetByID
 // is provided. (but you still have to add Preload options if needed)
-func Get[T any](ctx context.Context, dest any, options ...QueryOption) error {
+func Get[T any](ctx context.Context, dest any, options ...QueryOption) (err error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	defer observe(opGet, (*T)(nil))(&err)
+
 	vT := *new(T)
 	logger := logger.WithContext(ctx).
 		WithField("model", fmt.Sprintf("%T", vT)).
@@ -36,7 +45,9 @@ func Get[T any](ctx context.Context, dest any, options ...QueryOption) error {
 
 	logger.Trace("Get model into dest")
 
-	query := orm.DB.WithContext(ctx).Model(new(T))
+	db, release := scopedDB(ctx)
+	defer release()
+	query := db.Model(new(T))
 	for _, option := range options {
 		query = option(query)
 	}
@@ -52,10 +63,38 @@ func Get[T any](ctx context.Context, dest any, options ...QueryOption) error {
 	return ret.Error
 }
 
+// GetOne is Get into a new T, returning it instead of filling a dest:
+//    user, err := GetOne[User](ctx, FilterBy("name", "John"))
+// The err is ErrNoRecord (wrapping gorm.ErrRecordNotFound) if no record
+// matches. Use Get to get into other types (e.g. view models).
+func GetOne[T any](ctx context.Context, options ...QueryOption) (*T, error) {
+	var model T
+	if err := Get[T](ctx, &model, options...); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = fmt.Errorf("%w: %w", ErrNoRecord, err)
+		}
+		return nil, err
+	}
+	return &model, nil
+}
+
+// List is GetMany into a new slice, returning it instead of filling a dest:
+//    users, err := List[User](ctx, FilterBy("age", 18), WithPage(10, 0))
+// An empty (non-nil) slice is returned if no record matches.
+// Use GetMany to get into other types (e.g. view models).
+func List[T any](ctx context.Context, options ...QueryOption) ([]*T, error) {
+	models := []*T{}
+	if err := GetMany[T](ctx, &models, options...); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
 // GetByID is a shortcut for Get[T](&T, FilterBy("id", id))
 //
-// Notice: "id" here is the column (or field) name of the primary key of the
-// model which is indicated by the Identity method of orm.Model.
+// Notice: "id" here is the column name of the primary key of the model,
+// resolved from the field indicated by the Identity method of orm.Model
+// (e.g. field ID with `gorm:"column:uid"` => column uid).
 // So GetByID only works for models that implement the orm.Model interface.
 func GetByID[T orm.Model](ctx context.Context, id any, dest any, options ...QueryOption) error {
 	logger.WithContext(ctx).WithField("model", fmt.Sprintf("%T", *new(T))).
@@ -66,15 +105,49 @@ func GetByID[T orm.Model](ctx context.Context, id any, dest any, options ...Quer
 		logger.WithContext(ctx).Warn("GetByID skipped: id is nil")
 		return ErrNilID
 	}
-	idField, _ := (*new(T)).Identity()
+	idField, idColumn := identityColumn[T]()
 	if idField == "" {
 		logger.WithContext(ctx).Warn("GetByID skipped: unknown id field")
 		return ErrNoIdentityField
 	}
-	options = append(options, FilterBy(idField, id))
+	options = append(options, FilterBy(idColumn, id))
 	return Get[T](ctx, dest, options...)
 }
 
+// identityColumn returns the identity field (see orm.Model) of model T
+// and its column name in the database, see orm.IdentityColumn.
+func identityColumn[T orm.Model]() (idField, idColumn string) {
+	return orm.IdentityColumn(*new(T))
+}
+
+// Reload re-fetches the model (a pointer to a struct with the primary key
+// set) from the database, to fill in the fields generated by the database
+// (defaults, timestamps, ...). Pass PreloadAll to reload the associations:
+//    Reload(ctx, &user, PreloadAll())  // user.Profile.ID is loaded
+// It reads from the primary database (see ReadPrimary), which has the
+// writes just done, unlike the read replicas.
+func Reload(ctx context.Context, model any, options ...QueryOption) error {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	logger.WithContext(ctx).WithField("model", fmt.Sprintf("%T", model)).
+		Trace("Reload: Get model by its primary key")
+
+	db, release := scopedDB(ctx)
+	defer release()
+	query := ReadPrimary()(db)
+	for _, option := range options {
+		query = option(query)
+	}
+	// gorm queries by the primary key of model when it is set
+	err := query.Take(model).Error
+	if err != nil {
+		logger.WithContext(ctx).WithError(err).
+			Warn("Reload: failed")
+	}
+	return err
+}
+
 // GetMany returns a list of models T into dest.
 // The dest should be a pointer to a slice of "view model" struct (e.g. *[]*T).
 // See the documentation of Get function above for more details.
@@ -82,8 +155,15 @@ func GetByID[T orm.Model](ctx context.Context, id any, dest any, options ...Quer
 // Adding options parameters, you can query with specific conditions:
 //   - WithPage(limit, offset) => pagination
 //   - OrderBy(field, descending) => ordering
+//      - OrderByCI(field, descending) => case-insensitive ordering
 //   - FilterBy(field, value) => WHERE field=value condition
+//      - FilterByCI(field, value) => case-insensitive WHERE field=value
+//      - FilterIn(field, values...) => WHERE field IN (values...) condition
+//      - Between(field, low, high) => WHERE field BETWEEN low AND high condition
+//      - IsNull(field), IsNotNull(field) => WHERE field IS [NOT] NULL condition
 //      - Where(query, args...) => for more complicated queries
+//      - Or(options...) => (cond1 OR cond2 ...) group of conditions
+//   - WithTrashed() => include soft-deleted records
 //   - Preload(field) => preload a relationship
 //      - PreloadAll() => preload all associations
 //
@@ -97,13 +177,19 @@ func GetByID[T orm.Model](ctx context.Context, id any, dest any, options ...Quer
 //         WHERE name = "John"
 //         ORDER BY age desc
 //         LIMIT 10 OFFSET 0;  // into users
-func GetMany[T any](ctx context.Context, dest any, options ...QueryOption) error {
+func GetMany[T any](ctx context.Context, dest any, options ...QueryOption) (err error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	defer observe(opList, (*T)(nil))(&err)
+
 	logger := logger.WithContext(ctx).
 		WithField("model", fmt.Sprintf("%T", *new(T))).
 		WithField("dest", fmt.Sprintf("%T", dest))
 	logger.Trace("GetMany: Get models into dest")
 
-	query := orm.DB.WithContext(ctx).Model(new(T))
+	db, release := scopedDB(ctx)
+	defer release()
+	query := db.Model(new(T))
 	for _, option := range options {
 		query = option(query)
 	}
@@ -115,13 +201,127 @@ func GetMany[T any](ctx context.Context, dest any, options ...QueryOption) error
 	return ret.Error
 }
 
+// GetManyWithTotal is GetMany with the total number of models under the
+// conditions (ignoring the pagination), counted in the same query by the
+// window function COUNT(*) OVER(), instead of a separate Count query:
+//     SELECT users.*, COUNT(*) OVER() AS crud_total FROM users
+//         WHERE name = "John"
+//         LIMIT 10 OFFSET 0;  // into dest, and the total
+//
+// Options are applied as in GetMany, except that Preload options have no
+// effect: associations are not loaded with the window count.
+//
+// Notice: the total is 0 if the page is empty (e.g. the offset is beyond
+// the last record), use Count for it then. The query fails with
+// ErrUnsupportedDialect on the databases other than postgres and sqlite
+// (mysql supports window functions only since 8.0).
+func GetManyWithTotal[T any](ctx context.Context, dest *[]*T, options ...QueryOption) (total int64, err error) {
+	defer observe(opList, (*T)(nil))(&err)
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T)))
+	logger.Trace("GetManyWithTotal: Get models with the total")
+
+	db, release := scopedDB(ctx)
+	defer release()
+	query := db.Model(new(T))
+	for _, option := range options {
+		query = option(query)
+	}
+	if name := query.Dialector.Name(); name != "postgres" && name != "sqlite" {
+		err = fmt.Errorf("%w: GetManyWithTotal on %s", ErrUnsupportedDialect, name)
+		logger.WithError(err).Warn("GetManyWithTotal: window count unavailable")
+		return 0, err
+	}
+	if err = query.Statement.Parse(new(T)); err != nil {
+		logger.WithError(err).Warn("GetManyWithTotal: parse model failed")
+		return 0, err
+	}
+
+	selects := query.Statement.Selects
+	if len(selects) == 0 {
+		selects = []string{query.Statement.Quote(query.Statement.Schema.Table) + ".*"}
+	}
+	query.Statement.Preloads = nil
+
+	var rows []rowWithTotal[T]
+	ret := query.Select(append(selects, "COUNT(*) OVER() AS crud_total")).Find(&rows)
+	if ret.Error != nil {
+		logger.WithError(ret.Error).
+			Warn("GetManyWithTotal: Get models failed")
+		return 0, ret.Error
+	}
+
+	*dest = make([]*T, len(rows))
+	for i := range rows {
+		(*dest)[i] = &rows[i].Model
+		total = rows[i].Total
+	}
+	return total, nil
+}
+
+// rowWithTotal is a row of GetManyWithTotal: the model and the total.
+type rowWithTotal[T any] struct {
+	Model T     `gorm:"embedded"`
+	Total int64 `gorm:"column:crud_total"`
+}
+
+// Stream queries models T and calls fn with each of them, row by row,
+// without loading the whole result set into memory. It stops at the first
+// error returned by fn, and returns it.
+//
+// Options are applied as in GetMany, except that Preload options have no
+// effect: associations are not loaded in streaming.
+func Stream[T any](ctx context.Context, fn func(model *T) error, options ...QueryOption) (err error) {
+	defer observe(opStream, (*T)(nil))(&err)
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T)))
+	logger.Trace("Stream: Stream models")
+
+	db, release := scopedDB(ctx)
+	defer release()
+	query := db.Model(new(T))
+	for _, option := range options {
+		query = option(query)
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		logger.WithError(err).Warn("Stream: query models failed")
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var model T
+		if err := query.ScanRows(rows, &model); err != nil {
+			logger.WithError(err).Warn("Stream: scan model failed")
+			return err
+		}
+		if err := fn(&model); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
 // Count returns the number of models.
 func Count[T any](ctx context.Context, options ...QueryOption) (count int64, err error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	defer observe(opCount, (*T)(nil))(&err)
+
 	logger := logger.WithContext(ctx).
 		WithField("model", fmt.Sprintf("%T", *new(T)))
 	logger.Trace("Count: Count models")
 
-	query := orm.DB.WithContext(ctx).Model(new(T))
+	db, release := scopedDB(ctx)
+	defer release()
+	query := db.Model(new(T))
 	for _, option := range options {
 		query = option(query)
 	}
@@ -134,7 +334,19 @@ func Count[T any](ctx context.Context, options ...QueryOption) (count int64, err
 }
 
 // GetAssociations find matched associations (model.field) into dest.
+//
+// The query options scope the associations (not the model): conditions
+// (FilterBy, FilterIn, Where, Or), OrderBy, WithPage, WithTrashed and
+// Preload (of the associations' fields) are supported. For example, the
+// latest 10 sessions of a user:
+//    GetAssociations(ctx, &user, "Sessions", &sessions,
+//        OrderBy("created_at", true), WithPage(10, 0))
+// Notice that the columns are not qualified, prefix them with the table
+// names if they are ambiguous (e.g. "sessions.id" in a many2many query).
 func GetAssociations(ctx context.Context, model any, field string, dest any, options ...QueryOption) error {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
 	logger := logger.WithContext(ctx).
 		WithField("model", fmt.Sprintf("%T", model)).
 		WithField("field", field).
@@ -142,7 +354,9 @@ func GetAssociations(ctx context.Context, model any, field string, dest any, opt
 
 	logger.Trace("GetAssociation: Get association into dest")
 
-	err := associationQuery(ctx, model, field, options...).Find(dest)
+	association, release := associationQuery(ctx, model, field, options...)
+	defer release()
+	err := association.Find(dest)
 	if err != nil {
 		logger.WithError(err).
 			Warn("GetAssociation: Get association into dest failed")
@@ -151,23 +365,39 @@ func GetAssociations(ctx context.Context, model any, field string, dest any, opt
 }
 
 // CountAssociations count matched associations (model.field).
+// It accepts the same options as GetAssociations, while the pagination
+// (WithPage) and the ordering are ignored, so that the total of a page can
+// be counted with the same options.
 func CountAssociations(ctx context.Context, model any, field string, options ...QueryOption) (count int64, err error) {
-	logger.WithContext(ctx).
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	logger := logger.WithContext(ctx).
 		WithField("model", fmt.Sprintf("%T", model)).
-		WithField("field", field).
-		Trace("CountAssociations: Count associations")
+		WithField("field", field)
 
-	count = associationQuery(ctx, model, field, options...).Count()
+	logger.Trace("CountAssociations: Count associations")
+
+	options = append(options, withoutPage())
+	association, release := associationQuery(ctx, model, field, options...)
+	defer release()
+	count = association.Count() // the error is kept in association.Error
+	if err = association.Error; err != nil {
+		logger.WithError(err).
+			Warn("CountAssociations: Count associations failed")
+	}
 	return count, err
 }
 
-// associationQuery builds a gorm association query
-func associationQuery(ctx context.Context, model any, field string, options ...QueryOption) *gorm.Association {
-	query := orm.DB.WithContext(ctx).Model(model)
+// associationQuery builds a gorm association query on the orm.DB acquired
+// (see orm.Acquire) until release is called.
+func associationQuery(ctx context.Context, model any, field string, options ...QueryOption) (association *gorm.Association, release func()) {
+	db, release := orm.Acquire()
+	query := db.WithContext(ctx).Model(model)
 	for _, option := range options {
 		query = option(query)
 	}
-	return query.Association(field)
+	return query.Association(field), release
 }
 
 // QueryOption is a function that can be used to construct a query.
@@ -198,6 +428,67 @@ func PreloadAll() QueryOption {
 	}
 }
 
+// WithTrashed is a query option that includes the soft-deleted records
+// (the ones with a non-null DeletedAt) into the results.
+func WithTrashed() QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Unscoped()
+	}
+}
+
+// LockForUpdate is a query option that locks the selected rows for update:
+//     SELECT ... FOR UPDATE
+// LockForShare is the shared version of it:
+//     SELECT ... FOR SHARE
+//
+// The locks are held until the end of the transaction, so they only take
+// effect inside a transaction, for example:
+//     orm.Current().Transaction(func(tx *gorm.DB) error {
+//         var product Product
+//         LockForUpdate()(tx).First(&product, id)
+//         return tx.Model(&product).Update("stock", product.Stock-1).Error
+//     })
+// Notice: they are ignored by sqlite, which has no row-level locks.
+func LockForUpdate() QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate})
+	}
+}
+
+// LockForShare see LockForUpdate.
+func LockForShare() QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Clauses(clause.Locking{Strength: clause.LockingStrengthShare})
+	}
+}
+
+// ReadPrimary is a query option that reads from the primary database
+// instead of a read replica (see orm.ConnectWithReplicas), for the reads
+// that must see the latest writes, which the replicas may lag behind.
+// It does nothing without replicas.
+func ReadPrimary() QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Clauses(dbresolver.Write)
+	}
+}
+
+// Select is a query option that selects only the columns,
+// instead of all the columns (SELECT *). The other fields of the
+// results are left zero values.
+//
+// Example:
+//     GetMany[User](&users, Select("id", "name"))
+// means:
+//     SELECT id, name FROM users ;  // into users
+//
+// Notice: keep the primary key and the foreign keys selected for the
+// preloading to work.
+func Select(columns ...string) QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Select(columns)
+	}
+}
+
 // WithPage is a query option that sets pagination for GetMany.
 func WithPage(limit int, offset int) QueryOption {
 	return func(tx *gorm.DB) *gorm.DB {
@@ -205,6 +496,13 @@ func WithPage(limit int, offset int) QueryOption {
 	}
 }
 
+// withoutPage removes the pagination set by WithPage.
+func withoutPage() QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Limit(-1).Offset(-1)
+	}
+}
+
 // OrderBy is a query option that sets ordering for GetMany.
 // It can be applied multiple times (for multiple orders).
 func OrderBy(field string, descending bool) QueryOption {
@@ -217,6 +515,31 @@ func OrderBy(field string, descending bool) QueryOption {
 	}
 }
 
+// OrderByCI is the case-insensitive version of OrderBy: it orders by
+// LOWER(field), so that "alice" precedes "Zoe" regardless of the collation
+// of the database.
+//
+// Example:
+//     GetMany[User](&users, OrderByCI("name", false))
+// means:
+//     SELECT * FROM users ORDER BY LOWER(name) ;  // into users
+//
+// The query fails with ErrInvalidColumn if field is not a column name
+// (optionally qualified by the table name).
+func OrderByCI(field string, descending bool) QueryOption {
+	order := "LOWER(?)"
+	if descending {
+		order += " DESC"
+	}
+	return func(tx *gorm.DB) *gorm.DB {
+		if !columnRegexp.MatchString(field) {
+			_ = tx.AddError(fmt.Errorf("%w: %q", ErrInvalidColumn, field))
+			return tx
+		}
+		return tx.Order(clause.OrderBy{Expression: clause.Expr{SQL: order, Vars: []any{clause.Column{Name: field}}}})
+	}
+}
+
 // FilterBy is a query option that sets WHERE field=value condition for GetMany.
 // It can be applied multiple times (for multiple conditions).
 //
@@ -230,6 +553,239 @@ func FilterBy(field string, value any) QueryOption {
 	}
 }
 
+// FilterByOp is FilterBy with a comparison operator: WHERE field op value.
+// The operators are
+//     eq (=), ne (<>), gt (>), gte (>=), lt (<), lte (<=)
+//
+// Example:
+//     GetMany[User](&users, FilterByOp("status", "ne", "done"), FilterByOp("age", "gte", 18))
+// means:
+//     SELECT * FROM users WHERE status <> "done" AND age >= 18 ;  // into users
+//
+// Notice: by SQL, the NULLs match none of them, e.g. ne does not match a
+// NULL status, use IsNull for it.
+// The query fails with ErrInvalidColumn if field is not a column name, or
+// ErrInvalidOperator if op is not one of the above (see IsFilterOperator).
+func FilterByOp(field string, op string, value any) QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		if !columnRegexp.MatchString(field) {
+			_ = tx.AddError(fmt.Errorf("%w: %q", ErrInvalidColumn, field))
+			return tx
+		}
+		operator, ok := filterOperators[op]
+		if !ok {
+			_ = tx.AddError(fmt.Errorf("%w: %q", ErrInvalidOperator, op))
+			return tx
+		}
+		return tx.Where(clause.Expr{SQL: "? " + operator + " ?", Vars: []any{clause.Column{Name: field}, value}})
+	}
+}
+
+// filterOperators: the operators of FilterByOp => SQL
+var filterOperators = map[string]string{
+	"eq":  "=",
+	"ne":  "<>",
+	"gt":  ">",
+	"gte": ">=",
+	"lt":  "<",
+	"lte": "<=",
+}
+
+// IsFilterOperator reports whether op is an operator of FilterByOp.
+func IsFilterOperator(op string) bool {
+	_, ok := filterOperators[op]
+	return ok
+}
+
+// FilterByCI is the case-insensitive version of FilterBy:
+//     WHERE LOWER(field) = LOWER(value)
+// The query fails with ErrInvalidColumn if field is not a column name.
+func FilterByCI(field string, value any) QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		if !columnRegexp.MatchString(field) {
+			_ = tx.AddError(fmt.Errorf("%w: %q", ErrInvalidColumn, field))
+			return tx
+		}
+		return tx.Where(clause.Expr{SQL: "LOWER(?) = LOWER(?)", Vars: []any{clause.Column{Name: field}, value}})
+	}
+}
+
+// columnRegexp matches a column name, optionally qualified by a table name.
+var columnRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// Between is a query option that sets WHERE field BETWEEN low AND high
+// condition (inclusive). Open-ended ranges are supported: with a nil high,
+// it is WHERE field >= low; with a nil low, WHERE field <= high.
+//
+// Example:
+//     GetMany[Order](&orders, Between("created_at", monthStart, monthEnd))
+// means:
+//     SELECT * FROM orders
+//         WHERE created_at BETWEEN monthStart AND monthEnd ;  // into orders
+func Between(field string, low, high any) QueryOption {
+	column := clause.Column{Name: field}
+	return func(tx *gorm.DB) *gorm.DB {
+		switch {
+		case low != nil && high != nil:
+			return tx.Where("? BETWEEN ? AND ?", column, low, high)
+		case low != nil:
+			return tx.Where(clause.Gte{Column: column, Value: low})
+		case high != nil:
+			return tx.Where(clause.Lte{Column: column, Value: high})
+		}
+		return tx
+	}
+}
+
+// IsNull is a query option that sets WHERE field IS NULL condition.
+//
+// Example:
+//     GetMany[Task](&tasks, IsNull("assignee_id"))
+// means:
+//     SELECT * FROM tasks WHERE assignee_id IS NULL ;  // into tasks
+func IsNull(field string) QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where(clause.Eq{Column: clause.Column{Name: field}, Value: nil})
+	}
+}
+
+// IsNotNull is a query option that sets WHERE field IS NOT NULL condition.
+// See IsNull.
+func IsNotNull(field string) QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where(clause.Neq{Column: clause.Column{Name: field}, Value: nil})
+	}
+}
+
+// FilterJSON is a query option that sets WHERE condition on a value in a
+// JSON column: the value at the path (keys separated by dots, like "plan"
+// or "billing.plan") of the column equals to value. The JSON path SQL is
+// built for the dialect of the database:
+//
+//     postgres: WHERE metadata->>'plan' = 'pro'  (or metadata#>>'{billing,plan}' for a nested path)
+//     mysql:    WHERE JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.plan')) = 'pro'
+//     sqlite:   WHERE JSON_EXTRACT(metadata, '$.plan') = 'pro'
+//
+// Example:
+//     GetMany[Account](&accounts, FilterJSON("metadata", "plan", "pro"))
+//
+// Notice: the JSON value is compared as text on postgres and mysql, pass
+// the value as a string (e.g. "42" instead of 42) for them. The keys in
+// the path must be letters, digits, '_' or '-'. The query fails with
+// ErrInvalidJSONPath for an invalid path, and ErrUnsupportedDialect for
+// other databases.
+func FilterJSON(column string, path string, value any) QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		keys := strings.Split(path, ".")
+		for _, key := range keys {
+			if !jsonKeyRegexp.MatchString(key) {
+				_ = tx.AddError(fmt.Errorf("%w: %q", ErrInvalidJSONPath, path))
+				return tx
+			}
+		}
+
+		var sql string
+		switch name := tx.Dialector.Name(); name {
+		case "postgres":
+			if len(keys) == 1 {
+				sql = "?->>'" + keys[0] + "' = ?"
+			} else {
+				sql = "?#>>'{" + strings.Join(keys, ",") + "}' = ?"
+			}
+		case "mysql":
+			sql = "JSON_UNQUOTE(JSON_EXTRACT(?, '$." + path + "')) = ?"
+		case "sqlite":
+			sql = "JSON_EXTRACT(?, '$." + path + "') = ?"
+		default:
+			_ = tx.AddError(fmt.Errorf("%w: FilterJSON on %s", ErrUnsupportedDialect, name))
+			return tx
+		}
+		return tx.Where(clause.Expr{SQL: sql, Vars: []any{clause.Column{Name: column}, value}})
+	}
+}
+
+// jsonKeyRegexp matches a key in the path of FilterJSON.
+var jsonKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// FilterByJoin is a query option that joins the association (belongs to
+// or has one, e.g. "Profile") and sets WHERE association.field=value:
+// filtering models by the field of their associated model.
+// The field is the column name of the associated model.
+//
+// Example:
+//     GetMany[User](&users, FilterByJoin("Profile", "city", "Paris"))
+// means:
+//     SELECT users.*, Profile.* FROM users  // user.Profile is loaded as well
+//         LEFT JOIN profiles Profile ON Profile.user_id = users.id
+//         WHERE Profile.city = "Paris" ;  // into users
+//
+// Notice: has many and many2many associations can not be joined this way
+// (gorm joins only the one-to-one relations), use Where with a subquery
+// instead. And filter an association by multiple fields with Where rather
+// than multiple FilterByJoin, which joins the association multiple times.
+func FilterByJoin(association string, field string, value any) QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Joins(association).Where(clause.Eq{
+			Column: clause.Column{Table: association, Name: field},
+			Value:  value,
+		})
+	}
+}
+
+// FilterIn is a query option that sets WHERE field IN (values...) condition.
+// An empty values list matches nothing.
+//
+// Example:
+//     GetMany[User](&users, FilterIn("status", "open", "closed"))
+// means:
+//     SELECT * FROM users WHERE status IN ("open", "closed") ;  // into users
+func FilterIn[V any](field string, values ...V) QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where(map[string]any{field: values})
+	}
+}
+
+// WhereIn is a query option that sets WHERE field IN (subquery) condition,
+// with a subquery built by gorm from another model, for example, the users
+// who have placed an order:
+//     orders := orm.Current().Model(&Order{}).Select("user_id")
+//     GetMany[User](&users, WhereIn("id", orders))
+// means:
+//     SELECT * FROM users WHERE id IN (SELECT user_id FROM orders) ;  // into users
+// The subquery should select a single column. Its conditions are built with
+// gorm (e.g. orm.Current().Model(&Order{}).Select("user_id").Where("amount > ?", 100)),
+// and the scopes (see orm.RegisterScope) are not applied to it.
+//
+// The query fails with ErrInvalidColumn if field is not a column name,
+// or ErrNilSubquery if subquery is nil.
+func WhereIn(field string, subquery *gorm.DB) QueryOption {
+	return whereSubquery(field, "IN", subquery)
+}
+
+// WhereNotIn is the negation of WhereIn:
+//     WHERE field NOT IN (subquery)
+// Notice: it matches nothing if the subquery selects a NULL, by SQL.
+func WhereNotIn(field string, subquery *gorm.DB) QueryOption {
+	return whereSubquery(field, "NOT IN", subquery)
+}
+
+func whereSubquery(field string, operator string, subquery *gorm.DB) QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		if !columnRegexp.MatchString(field) {
+			_ = tx.AddError(fmt.Errorf("%w: %q", ErrInvalidColumn, field))
+			return tx
+		}
+		if subquery == nil {
+			_ = tx.AddError(ErrNilSubquery)
+			return tx
+		}
+		return tx.Where(clause.Expr{
+			SQL:  "? " + operator + " (?)",
+			Vars: []any{clause.Column{Name: field}, subquery},
+		})
+	}
+}
+
 // Where offers a more flexible way to set WHERE conditions.
 // Equivalent to gorm.DB.Where(...), see:
 //   https://gorm.io/docs/query.html#Conditions
@@ -244,7 +800,182 @@ func Where(query any, args ...any) QueryOption {
 	}
 }
 
+// Search is a query option that matches the keyword in any of the columns:
+// WHERE (column1 LIKE %keyword% OR column2 LIKE %keyword% ...).
+// The keyword is a parameter of the query, with the wildcards (% and _) in
+// it escaped. On postgres, ILIKE is used for case-insensitivity (LIKE is
+// case-insensitive on sqlite and mysql by default).
+//
+// Example:
+//     GetMany[Article](&articles, Search("golang", "title", "body"))
+// means:
+//     SELECT * FROM articles
+//         WHERE (title LIKE "%golang%" OR body LIKE "%golang%") ;  // into articles
+func Search(keyword string, columns ...string) QueryOption {
+	pattern := "%" + likeEscaper.Replace(keyword) + "%"
+	return func(tx *gorm.DB) *gorm.DB {
+		like := "LIKE"
+		if tx.Dialector.Name() == "postgres" {
+			like = "ILIKE"
+		}
+		var options []QueryOption
+		for _, column := range columns {
+			query := fmt.Sprintf("%s %s ? ESCAPE '!'", tx.Statement.Quote(column), like)
+			options = append(options, Where(query, pattern))
+		}
+		if len(options) == 0 {
+			return tx
+		}
+		return Or(options...)(tx)
+	}
+}
+
+// likeEscaper escapes the LIKE wildcards with '!'.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// Options combines the options into one, applied in order, to define
+// reusable presets of options:
+//     activeAdults := Options(FilterBy("active", true), Where("age >= ?", 18))
+//     GetMany[User](&users, activeAdults, WithPage(10, 0))
+//     Count[User](activeAdults)
+// means:
+//     SELECT * FROM users WHERE active = true AND age >= 18 LIMIT 10 ;  // into users
+//     SELECT count(*) FROM users WHERE active = true AND age >= 18 ;
+// In Or, the combined conditions are grouped: Or(Options(a, b), c) means
+// WHERE (a AND b) OR c.
+func Options(options ...QueryOption) QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		for _, option := range options {
+			tx = option(tx)
+		}
+		return tx
+	}
+}
+
+// Or groups the given options into a parenthesized OR condition.
+// Each option is applied on a fresh sub-scope (so conditions inside a
+// single option are still AND-ed), and the sub-scopes are joined by OR.
+// Or can be nested, and it is AND-ed with other options as usual.
+//
+// Example:
+//     GetMany[User](&users,
+//                   Or(FilterBy("status", "a"), FilterBy("status", "b")),
+//                   FilterBy("active", true))
+// means:
+//     SELECT * FROM users
+//         WHERE (status = "a" OR status = "b") AND active = true ;  // into users
+func Or(options ...QueryOption) QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		var group *gorm.DB
+		for _, option := range options {
+			cond := option(tx.Session(&gorm.Session{NewDB: true}))
+			if group == nil {
+				group = cond
+			} else {
+				group = group.Or(cond)
+			}
+		}
+		if group == nil {
+			return tx
+		}
+		return tx.Where(group)
+	}
+}
+
+// scopeRegistry: scopeKey => QueryOption
+var scopeRegistry sync.Map
+
+// scopeKey is the name of a query scope and the model type (*T) it is
+// registered for, or nil for all the models.
+type scopeKey struct {
+	model reflect.Type
+	name  string
+}
+
+// RegisterScope registers a named query scope, a reusable set of
+// conditions (or other query options), to be applied by Scope:
+//
+//     RegisterScope("active", func(tx *gorm.DB) *gorm.DB {
+//         return tx.Where("status = ?", "active")
+//     })
+//     GetMany[User](&users, Scope("active"), FilterBy("age", 10))
+//
+// Registering a scope of an existing name replaces it.
+// Unlike orm.RegisterScope, which applies to all the queries, the scopes
+// registered here are applied on demand only.
+func RegisterScope(name string, fn func(*gorm.DB) *gorm.DB) {
+	scopeRegistry.Store(scopeKey{nil, name}, QueryOption(fn))
+}
+
+// Scope is a query option that applies the scope named name registered by
+// RegisterScope. It composes with the other options (e.g. FilterBy, Where)
+// as they are AND-ed. The query fails with ErrUnknownScope if the scope is
+// not registered.
+func Scope(name string) QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return applyScope(tx, nil, name)
+	}
+}
+
+// RegisterModelScope registers a named query scope like RegisterScope, but
+// for model T only, to be applied by ModelScope. It takes precedence over
+// the one of the same name registered by RegisterScope for T.
+//
+// The scopes requested by the clients (by the scope query param, see
+// controller.GetRequestOptions) are looked up by ScopeOf, so a scope of
+// a model can not be applied to the others by them.
+func RegisterModelScope[T any](name string, fn func(*gorm.DB) *gorm.DB) {
+	scopeRegistry.Store(scopeKey{reflect.TypeOf((*T)(nil)), name}, QueryOption(fn))
+}
+
+// ModelScope is Scope for model T: it applies the scope named name
+// registered for T by RegisterModelScope, or else the one registered by
+// RegisterScope.
+func ModelScope[T any](name string) QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return applyScope(tx, reflect.TypeOf((*T)(nil)), name)
+	}
+}
+
+// ScopeOf returns the query scope named name available to the model, a
+// *T: the one registered for T by RegisterModelScope, or else the one
+// registered by RegisterScope. ok is false if there is neither.
+func ScopeOf(model any, name string) (scope QueryOption, ok bool) {
+	return lookupScope(reflect.TypeOf(model), name)
+}
+
+// lookupScope looks up the scope named name of the model type, falling
+// back to the one for all the models. model is nil for the latter only.
+func lookupScope(model reflect.Type, name string) (scope QueryOption, ok bool) {
+	v, ok := scopeRegistry.Load(scopeKey{model, name})
+	if !ok && model != nil {
+		v, ok = scopeRegistry.Load(scopeKey{nil, name})
+	}
+	if !ok {
+		return nil, false
+	}
+	return v.(QueryOption), true
+}
+
+// applyScope applies the scope looked up by lookupScope to tx, or adds an
+// ErrUnknownScope to it if there is not.
+func applyScope(tx *gorm.DB, model reflect.Type, name string) *gorm.DB {
+	scope, ok := lookupScope(model, name)
+	if !ok {
+		_ = tx.AddError(fmt.Errorf("%w: %q", ErrUnknownScope, name))
+		return tx
+	}
+	return scope(tx)
+}
+
 var (
 	ErrNoIdentityField = errors.New("no identity field found")
 	ErrNilID           = errors.New("id is nil")
+	ErrUnknownScope    = errors.New("unknown scope")
+
+	ErrInvalidColumn      = errors.New("invalid column")
+	ErrInvalidJSONPath    = errors.New("invalid json path")
+	ErrUnsupportedDialect = errors.New("unsupported dialect")
+	ErrNilSubquery        = errors.New("subquery is nil")
+	ErrInvalidOperator    = errors.New("invalid operator")
 )
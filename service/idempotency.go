@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IdempotentResponse is the stored response of a request with an
+// idempotency key, to be replayed for the repeated requests.
+type IdempotentResponse struct {
+	RequestHash string // of the request body, to tell a reused key
+	Status      int
+	ContentType string
+	Location    string
+	Body        []byte
+}
+
+// IdempotencyStore stores the responses by the idempotency keys.
+type IdempotencyStore interface {
+	// Get returns the response stored with the key,
+	// or nil if there is none or it is expired.
+	Get(ctx context.Context, key string) (*IdempotentResponse, error)
+	// Put stores the response with the key for ttl.
+	Put(ctx context.Context, key string, response *IdempotentResponse, ttl time.Duration) error
+}
+
+// IdempotencyRecord is the table of the GormIdempotencyStore.
+type IdempotencyRecord struct {
+	Key         string `gorm:"primaryKey;size:255"`
+	RequestHash string `gorm:"size:64"`
+	Status      int
+	ContentType string
+	Location    string
+	Body        []byte
+	ExpiresAt   time.Time `gorm:"index"`
+}
+
+// GormIdempotencyStore is an IdempotencyStore storing the responses in the
+// table of IdempotencyRecord in orm.DB, which is migrated on the first use.
+// The expired records are purged on Put.
+type GormIdempotencyStore struct {
+	mu       sync.Mutex
+	migrated *gorm.DB // the orm.DB migrated
+}
+
+// NewGormIdempotencyStore creates a GormIdempotencyStore.
+func NewGormIdempotencyStore() *GormIdempotencyStore {
+	return &GormIdempotencyStore{}
+}
+
+// db returns the orm.DB with the table migrated, acquired (see
+// orm.Acquire) until release is called.
+func (s *GormIdempotencyStore) db(ctx context.Context) (db *gorm.DB, release func(), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	db, release = orm.Acquire()
+	if s.migrated != db {
+		if err := db.AutoMigrate(&IdempotencyRecord{}); err != nil {
+			release()
+			return nil, nil, err
+		}
+		s.migrated = db
+	}
+	return db.WithContext(ctx), release, nil
+}
+
+func (s *GormIdempotencyStore) Get(ctx context.Context, key string) (*IdempotentResponse, error) {
+	db, release, err := s.db(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var record IdempotencyRecord
+	// the columns are quoted by the clauses: key is a reserved word in MySQL
+	err = db.Where(clause.Eq{Column: "key", Value: key}).
+		Where(clause.Gt{Column: "expires_at", Value: time.Now()}).
+		Take(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.WithContext(ctx).WithError(err).
+			Warn("GormIdempotencyStore: Get failed")
+		return nil, err
+	}
+	return &IdempotentResponse{
+		RequestHash: record.RequestHash,
+		Status:      record.Status,
+		ContentType: record.ContentType,
+		Location:    record.Location,
+		Body:        record.Body,
+	}, nil
+}
+
+func (s *GormIdempotencyStore) Put(ctx context.Context, key string, response *IdempotentResponse, ttl time.Duration) error {
+	db, release, err := s.db(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	now := time.Now()
+	if err := db.Where(clause.Lte{Column: "expires_at", Value: now}).Delete(&IdempotencyRecord{}).Error; err != nil {
+		logger.WithContext(ctx).WithError(err).
+			Warn("GormIdempotencyStore: purge expired failed")
+	}
+	err = db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&IdempotencyRecord{
+		Key:         key,
+		RequestHash: response.RequestHash,
+		Status:      response.Status,
+		ContentType: response.ContentType,
+		Location:    response.Location,
+		Body:        response.Body,
+		ExpiresAt:   now.Add(ttl),
+	}).Error
+	if err != nil {
+		logger.WithContext(ctx).WithError(err).
+			Warn("GormIdempotencyStore: Put failed")
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// serviceMetrics are the prometheus collectors of the service calls,
+// see WithMetrics.
+type serviceMetrics struct {
+	duration   *prometheus.HistogramVec
+	operations *prometheus.CounterVec
+}
+
+// metrics is set by WithMetrics, nil for disabled.
+var metrics atomic.Pointer[serviceMetrics]
+
+// the operation labels of the metrics
+const (
+	opGet    = "get"
+	opList   = "list"
+	opStream = "stream"
+	opCount  = "count"
+	opCreate = "create"
+	opUpdate = "update"
+	opDelete = "delete"
+)
+
+// WithMetrics instruments the service calls (Get, GetMany,
+// GetManyWithTotal, Stream, Count, Create, CreateMany, Update,
+// UpdateFields, Delete and DeleteByID) with the prometheus metrics
+// registered to the registerer (e.g. prometheus.DefaultRegisterer):
+//
+//  - crud_service_duration_seconds: a histogram of the latency of the calls
+//  - crud_service_operations_total: a counter of the calls
+//
+// labeled by the model (e.g. "User"), the operation ("get", "list",
+// "stream", "count", "create", "update" or "delete") and the result ("ok"
+// or "error"; the latency is labeled by the model and operation only).
+// The latency of "stream" includes the time spent by its fn.
+//
+// It is idempotent: the collectors already registered to the registerer
+// are reused. The metrics are disabled by default, when the calls are not
+// instrumented at all; once enabled, all the service calls are.
+func WithMetrics(registerer prometheus.Registerer) error {
+	m := &serviceMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "crud",
+			Subsystem: "service",
+			Name:      "duration_seconds",
+			Help:      "Latency of the crud service calls.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"model", "operation"}),
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "crud",
+			Subsystem: "service",
+			Name:      "operations_total",
+			Help:      "Number of the crud service calls.",
+		}, []string{"model", "operation", "result"}),
+	}
+	if err := register(registerer, &m.duration); err != nil {
+		return err
+	}
+	if err := register(registerer, &m.operations); err != nil {
+		return err
+	}
+	metrics.Store(m)
+	return nil
+}
+
+// register registers the collector to the registerer, or replaces it with
+// the existing one if it is already registered.
+func register[C prometheus.Collector](registerer prometheus.Registerer, collector *C) error {
+	err := registerer.Register(*collector)
+	var already prometheus.AlreadyRegisteredError
+	if errors.As(err, &already) {
+		if existing, ok := already.ExistingCollector.(C); ok {
+			*collector = existing
+			return nil
+		}
+	}
+	if err != nil {
+		logger.WithError(err).Error("WithMetrics: register collector failed")
+	}
+	return err
+}
+
+// observe starts measuring a service call of the operation on the model
+// (a *T, where only the type matters). The returned function ends it with
+// the error of the call:
+//
+//    defer observe(opGet, (*T)(nil))(&err)
+//
+// It costs nothing more than an atomic load if the metrics are disabled.
+func observe(operation string, model any) func(err *error) {
+	m := metrics.Load()
+	if m == nil {
+		return observeNothing
+	}
+	start := time.Now()
+	name := modelName(model)
+	return func(err *error) {
+		m.duration.WithLabelValues(name, operation).Observe(time.Since(start).Seconds())
+		result := "ok"
+		if *err != nil {
+			result = "error"
+		}
+		m.operations.WithLabelValues(name, operation, result).Inc()
+	}
+}
+
+func observeNothing(*error) {}
+
+// modelName returns the name of the model type, with the pointers, slices
+// and arrays (e.g. *[]*User) unwrapped: "User".
+func modelName(model any) string {
+	t := reflect.TypeOf(model)
+	if t == nil {
+		return ""
+	}
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	return t.Name()
+}
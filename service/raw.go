@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/cdfmlr/crud/orm"
+)
+
+// Raw runs a raw SQL query and scans the results into a list of T.
+// It is an escape hatch for the queries that can not be expressed with
+// QueryOptions (reports, for example), while still logging through crud
+// and carrying the ctx (request_id, cancellation, ...) to the database.
+//
+// Example:
+//     Raw[UserStat](ctx, "SELECT status, COUNT(*) AS count FROM users GROUP BY status")
+//
+// Notice: never concatenate inputs from clients into sql, use args instead.
+func Raw[T any](ctx context.Context, sql string, args ...any) ([]*T, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T))).
+		WithField("sql", sql)
+	logger.Trace("Raw: query raw sql")
+
+	var dest []*T
+	db, release := orm.Acquire()
+	defer release()
+	ret := db.WithContext(ctx).Raw(sql, args...).Scan(&dest)
+	if ret.Error != nil {
+		logger.WithError(ret.Error).Warn("Raw: query failed")
+	}
+	return dest, ret.Error
+}
+
+// Exec executes a raw SQL statement (UPDATE, DELETE, ...) that returns
+// no rows, and returns the number of rows affected.
+// See Raw for more details.
+func Exec(ctx context.Context, sql string, args ...any) (rowsAffected int64, err error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	logger := logger.WithContext(ctx).
+		WithField("sql", sql)
+	logger.Trace("Exec: execute raw sql")
+
+	db, release := orm.Acquire()
+	defer release()
+	ret := db.WithContext(ctx).Exec(sql, args...)
+	if ret.Error != nil {
+		logger.WithError(ret.Error).Warn("Exec: execute failed")
+	}
+	return ret.RowsAffected, ret.Error
+}
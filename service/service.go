@@ -1,11 +1,68 @@
 // Package service implements the basic CRUD operations for models.
 //
 // For any not-in-the-box lower level database operations, you can implement
-// your own services with the orm.DB (a *gorm.DB) instance.
+// your own services with the orm.Current() (a *gorm.DB) instance,
+// or run raw SQL with Raw and Exec.
 package service
 
-import "github.com/cdfmlr/crud/log"
+import (
+	"context"
+	"github.com/cdfmlr/crud/log"
+	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
+	"sync/atomic"
+	"time"
+)
 
 // TODO: use orm.Model instead of any
 
-var logger = log.ZoneLogger("crud/service")
+var logger = log.ContextZoneLogger("crud/service")
+
+// defaultTimeout is the timeout of the service calls, 0 for no timeout.
+var defaultTimeout atomic.Int64
+
+// SetDefaultTimeout caps the database time of each service call (Get,
+// GetMany, Count, Create, Update, Delete, ...) to d, by wrapping the ctx
+// passed in with context.WithTimeout. So that a runaway query is canceled
+// instead of blocking forever, e.g. in batch jobs.
+//
+// A zero (or negative) d disables it, which is the default.
+// An earlier deadline of the ctx passed in is respected.
+// Notice that the timeout of Stream covers the whole streaming.
+func SetDefaultTimeout(d time.Duration) {
+	defaultTimeout.Store(int64(d))
+}
+
+// withDefaultTimeout wraps ctx with the default timeout if it is set.
+func withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d := time.Duration(defaultTimeout.Load()); d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return ctx, func() {}
+}
+
+// scopedDB returns the orm.DB with the ctx and the scopes registered by
+// orm.RegisterScope (and orm.RegisterOptInScope). It is used by the
+// queries, updates and deletes. The DB is acquired (see orm.Acquire) until
+// release is called.
+func scopedDB(ctx context.Context) (db *gorm.DB, release func()) {
+	db, release = orm.Acquire()
+	return orm.AppliedScopes(db.WithContext(ctx)), release
+}
+
+// UseScope opts in the scopes registered by orm.RegisterOptInScope for the
+// service calls with the returned context, for example:
+//    GetMany[Post](UseScope(ctx, "published"), &posts)
+// It is the same as orm.UseScope.
+func UseScope(ctx context.Context, names ...string) context.Context {
+	return orm.UseScope(ctx, names...)
+}
+
+// SkipScope opts out of the scopes registered by orm.RegisterScope for the
+// service calls with the returned context, for example, to count the
+// users of all the tenants in an admin job:
+//    Count[User](SkipScope(ctx, "tenant"))
+// It is the same as orm.SkipScope.
+func SkipScope(ctx context.Context, names ...string) context.Context {
+	return orm.SkipScope(ctx, names...)
+}
@@ -1,3 +1,1223 @@
 package service
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cdfmlr/crud/orm"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
 // TODO: CRUD operations tests
+
+type testUser struct {
+	ID     uint
+	Name   string
+	Status string
+	Active bool
+}
+
+func (u testUser) Identity() (fieldName string, value any) {
+	return "ID", u.ID
+}
+
+// setupTestDB connects orm.DB to a fresh in-memory sqlite database with
+// testUser registered and the given users created.
+func setupTestDB(t *testing.T, users ...*testUser) {
+	t.Helper()
+
+	db, cleanup, err := orm.ConnectTestDB(&testUser{}, orm.WithTestDBLogger(gormlogger.Discard))
+	if err != nil {
+		t.Fatalf("connect test db: %v", err)
+	}
+	t.Cleanup(cleanup)
+
+	for _, user := range users {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+}
+
+// dryRunDBs opens dry-run (no query is executed) databases of each dialect.
+func dryRunDBs(t *testing.T) map[string]*gorm.DB {
+	t.Helper()
+
+	dialectors := map[string]gorm.Dialector{
+		"sqlite": sqlite.Open(":memory:"),
+		"mysql": mysql.New(mysql.Config{
+			DSN:                       "user:pass@tcp(127.0.0.1:3306)/db",
+			SkipInitializeWithVersion: true,
+		}),
+		"postgres": postgres.Open("host=localhost user=u password=p dbname=db"),
+	}
+
+	dbs := make(map[string]*gorm.DB, len(dialectors))
+	for name, dialector := range dialectors {
+		db, err := gorm.Open(dialector, &gorm.Config{
+			DryRun:               true,
+			DisableAutomaticPing: true,
+			Logger:               gormlogger.Discard,
+		})
+		if err != nil {
+			t.Fatalf("open %s: %v", name, err)
+		}
+		dbs[name] = db
+	}
+	return dbs
+}
+
+// querySQL builds the SELECT statement for model T with the given options.
+func querySQL[T any](db *gorm.DB, options ...QueryOption) string {
+	return db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		query := tx.Model(new(T))
+		for _, option := range options {
+			query = option(query)
+		}
+		var dest []T
+		return query.Find(&dest)
+	})
+}
+
+// normalizeSQL removes dialect specific quotes from sql.
+func normalizeSQL(sql string) string {
+	return strings.TrimSpace(strings.NewReplacer("`", "", `"`, "", "'", "").Replace(sql))
+}
+
+func TestOr(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []QueryOption
+		want    string
+	}{
+		{"group",
+			[]QueryOption{
+				Or(FilterBy("status", "a"), FilterBy("status", "b")),
+				FilterBy("active", true),
+			},
+			"WHERE (status = a OR status = b) AND active = true",
+		},
+		{"nested",
+			[]QueryOption{
+				FilterBy("active", true),
+				Or(FilterBy("name", "John"),
+					Or(FilterBy("status", "a"), FilterBy("status", "b"))),
+			},
+			"WHERE active = true AND (name = John OR (status = a OR status = b))",
+		},
+		{"single",
+			[]QueryOption{Or(FilterBy("status", "a"))},
+			"WHERE status = a",
+		},
+		{"empty",
+			[]QueryOption{Or()},
+			"FROM test_users",
+		},
+	}
+	for dialect, db := range dryRunDBs(t) {
+		for _, tt := range tests {
+			t.Run(dialect+"/"+tt.name, func(t *testing.T) {
+				got := normalizeSQL(querySQL[testUser](db, tt.options...))
+				if !strings.HasSuffix(got, tt.want) {
+					t.Errorf("Or() got = %v, want suffix %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	activeAdmins := Options(FilterBy("active", true), FilterIn("status", "admin", "root"))
+	tests := []struct {
+		name    string
+		options []QueryOption
+		want    string
+	}{
+		{"combined", []QueryOption{activeAdmins, OrderBy("name", false)},
+			"WHERE active = true AND status IN (admin,root) ORDER BY name"},
+		{"reused", []QueryOption{FilterBy("name", "John"), activeAdmins},
+			"WHERE name = John AND active = true AND status IN (admin,root)"},
+		{"in or", []QueryOption{Or(activeAdmins, FilterBy("name", "John")), FilterBy("status", "a")},
+			"WHERE (active = true AND status IN (admin,root) OR name = John) AND status = a"},
+		{"empty", []QueryOption{Options()}, "FROM test_users"},
+	}
+	for dialect, db := range dryRunDBs(t) {
+		for _, tt := range tests {
+			t.Run(dialect+"/"+tt.name, func(t *testing.T) {
+				got := normalizeSQL(querySQL[testUser](db, tt.options...))
+				if !strings.HasSuffix(got, tt.want) {
+					t.Errorf("Options() got = %v, want suffix %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestFilterIn(t *testing.T) {
+	tests := []struct {
+		name   string
+		option QueryOption
+		want   string
+	}{
+		{"values", FilterIn("status", "open", "closed"), "WHERE status IN (open,closed)"},
+		{"empty", FilterIn[string]("status"), "WHERE status IN (NULL)"},
+	}
+	for dialect, db := range dryRunDBs(t) {
+		for _, tt := range tests {
+			t.Run(dialect+"/"+tt.name, func(t *testing.T) {
+				got := normalizeSQL(querySQL[testUser](db, tt.option))
+				if !strings.HasSuffix(got, tt.want) {
+					t.Errorf("FilterIn() got = %v, want suffix %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestBetween(t *testing.T) {
+	tests := []struct {
+		name   string
+		option QueryOption
+		want   string
+	}{
+		{"both", Between("age", 18, 30), "WHERE age BETWEEN 18 AND 30"},
+		{"low only", Between("age", 18, nil), "WHERE age >= 18"},
+		{"high only", Between("age", nil, 30), "WHERE age <= 30"},
+		{"open", Between("age", nil, nil), "FROM test_users"},
+	}
+	for dialect, db := range dryRunDBs(t) {
+		for _, tt := range tests {
+			t.Run(dialect+"/"+tt.name, func(t *testing.T) {
+				got := normalizeSQL(querySQL[testUser](db, tt.option))
+				if !strings.HasSuffix(got, tt.want) {
+					t.Errorf("Between() got = %v, want suffix %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestOrderByCI(t *testing.T) {
+	setupTestDB(t,
+		&testUser{Name: "Zoe", Status: "Active"},
+		&testUser{Name: "alice", Status: "active"},
+		&testUser{Name: "Bob", Status: "ACTIVE"},
+		&testUser{Name: "carol", Status: "banned"},
+	)
+	names := func(users []testUser) (names []string) {
+		for _, user := range users {
+			names = append(names, user.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name    string
+		options []QueryOption
+		want    []string
+	}{
+		{"case-sensitive", []QueryOption{OrderBy("name", false)}, []string{"Bob", "Zoe", "alice", "carol"}},
+		{"asc", []QueryOption{OrderByCI("name", false)}, []string{"alice", "Bob", "carol", "Zoe"}},
+		{"desc", []QueryOption{OrderByCI("name", true)}, []string{"Zoe", "carol", "Bob", "alice"}},
+		{"filter", []QueryOption{FilterByCI("status", "active"), OrderByCI("name", false)}, []string{"alice", "Bob", "Zoe"}},
+		{"qualified", []QueryOption{FilterByCI("test_users.status", "BANNED")}, []string{"carol"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var users []testUser
+			if err := GetMany[testUser](context.Background(), &users, tt.options...); err != nil {
+				t.Fatalf("GetMany() error = %v", err)
+			}
+			if got := names(users); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetMany() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, option := range []QueryOption{OrderByCI("name; DROP TABLE test_users", false), FilterByCI("LOWER(name)", "a")} {
+		var users []testUser
+		if err := GetMany[testUser](context.Background(), &users, option); !errors.Is(err, ErrInvalidColumn) {
+			t.Errorf("GetMany() error = %v, want %v", err, ErrInvalidColumn)
+		}
+	}
+}
+
+func TestFilterJSON(t *testing.T) {
+	tests := []struct {
+		dialect, path string
+		want          string
+	}{
+		{"postgres", "plan", `WHERE metadata->>plan = pro`},
+		{"postgres", "billing.plan", `WHERE metadata#>>{billing,plan} = pro`},
+		{"mysql", "plan", `WHERE JSON_UNQUOTE(JSON_EXTRACT(metadata, $.plan)) = pro`},
+		{"sqlite", "billing.plan", `WHERE JSON_EXTRACT(metadata, $.billing.plan) = pro`},
+	}
+	dbs := dryRunDBs(t)
+	for _, tt := range tests {
+		t.Run(tt.dialect+"/"+tt.path, func(t *testing.T) {
+			got := normalizeSQL(querySQL[testUser](dbs[tt.dialect], FilterJSON("metadata", tt.path, "pro")))
+			if !strings.HasSuffix(got, tt.want) {
+				t.Errorf("FilterJSON() got = %v, want suffix %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("sqlite query", func(t *testing.T) {
+		setupTestDB(t)
+		orm.DB.Exec("ALTER TABLE test_users ADD COLUMN metadata TEXT")
+		orm.DB.Exec(`INSERT INTO test_users (name, metadata) VALUES ('a', '{"plan": "pro"}'), ('b', '{"plan": "free"}')`)
+
+		var users []testUser
+		if err := GetMany[testUser](context.Background(), &users, FilterJSON("metadata", "plan", "pro")); err != nil {
+			t.Fatalf("GetMany() error = %v", err)
+		}
+		if len(users) != 1 || users[0].Name != "a" {
+			t.Errorf("GetMany() got = %+v, want [a]", users)
+		}
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		setupTestDB(t)
+		var users []testUser
+		err := GetMany[testUser](context.Background(), &users, FilterJSON("metadata", "plan') OR 1=1 --", "pro"))
+		if !errors.Is(err, ErrInvalidJSONPath) {
+			t.Errorf("GetMany() error = %v, want %v", err, ErrInvalidJSONPath)
+		}
+	})
+}
+
+func TestIsNull(t *testing.T) {
+	tests := []struct {
+		name   string
+		option QueryOption
+		want   string
+	}{
+		{"null", IsNull("status"), "WHERE status IS NULL"},
+		{"not null", IsNotNull("status"), "WHERE status IS NOT NULL"},
+		{"both", func(tx *gorm.DB) *gorm.DB { return IsNotNull("name")(IsNull("status")(tx)) },
+			"WHERE status IS NULL AND name IS NOT NULL"},
+	}
+	for dialect, db := range dryRunDBs(t) {
+		for _, tt := range tests {
+			t.Run(dialect+"/"+tt.name, func(t *testing.T) {
+				got := normalizeSQL(querySQL[testUser](db, tt.option))
+				if !strings.HasSuffix(got, tt.want) {
+					t.Errorf("IsNull() got = %v, want suffix %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestUpdateFields(t *testing.T) {
+	setupTestDB(t, &testUser{Name: "John", Status: "a", Active: true})
+	ctx := context.Background()
+
+	rows, err := UpdateFields[testUser](ctx, 1, map[string]any{"status": "b", "active": false})
+	if err != nil || rows != 1 {
+		t.Fatalf("UpdateFields() = %v, %v, want 1, nil", rows, err)
+	}
+	var got testUser
+	_ = orm.DB.First(&got, 1)
+	if want := (testUser{ID: 1, Name: "John", Status: "b", Active: false}); got != want {
+		t.Errorf("UpdateFields() got = %+v, want %+v", got, want)
+	}
+
+	for _, key := range []string{"id", "ID"} {
+		_, err = UpdateFields[testUser](ctx, 1, map[string]any{key: 2, "name": "Jane"})
+		if !errors.Is(err, ErrUpdateID) {
+			t.Errorf("UpdateFields(%q) err = %v, want %v", key, err, ErrUpdateID)
+		}
+	}
+
+	_, err = UpdateFields[testUser](ctx, 2, map[string]any{"name": "Jane"})
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("UpdateFields() err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+}
+
+func TestRawAndExec(t *testing.T) {
+	setupTestDB(t,
+		&testUser{Name: "John", Status: "a"},
+		&testUser{Name: "Jane", Status: "a"},
+		&testUser{Name: "Jack", Status: "b"},
+	)
+	ctx := context.Background()
+
+	rows, err := Exec(ctx, "UPDATE test_users SET active = ? WHERE status = ?", true, "a")
+	if err != nil || rows != 2 {
+		t.Fatalf("Exec() = %v, %v, want 2, nil", rows, err)
+	}
+
+	type stat struct {
+		Status string
+		Count  int
+	}
+	stats, err := Raw[stat](ctx,
+		"SELECT status, COUNT(*) AS count FROM test_users WHERE active = ? GROUP BY status", true)
+	if err != nil {
+		t.Fatalf("Raw() err = %v", err)
+	}
+	if len(stats) != 1 || *stats[0] != (stat{Status: "a", Count: 2}) {
+		t.Errorf("Raw() got = %v, want [{a 2}]", stats)
+	}
+
+	if _, err := Raw[stat](ctx, "SELECT * FROM nope"); err == nil {
+		t.Errorf("Raw() err = nil, want an error")
+	}
+}
+
+func TestLock(t *testing.T) {
+	tests := []struct {
+		name   string
+		option QueryOption
+		want   map[string]string // dialect => suffix
+	}{
+		{"update", LockForUpdate(), map[string]string{
+			"sqlite": "FROM test_users", "mysql": "FOR UPDATE", "postgres": "FOR UPDATE"}},
+		{"share", LockForShare(), map[string]string{
+			"sqlite": "FROM test_users", "mysql": "FOR SHARE", "postgres": "FOR SHARE"}},
+	}
+	for dialect, db := range dryRunDBs(t) {
+		for _, tt := range tests {
+			t.Run(dialect+"/"+tt.name, func(t *testing.T) {
+				got := normalizeSQL(querySQL[testUser](db, tt.option))
+				if !strings.HasSuffix(got, tt.want[dialect]) {
+					t.Errorf("got = %v, want suffix %v", got, tt.want[dialect])
+				}
+			})
+		}
+	}
+}
+
+type testTodo struct {
+	ID    uint
+	Title string
+}
+
+func (t testTodo) Identity() (fieldName string, value any) {
+	return "ID", t.ID
+}
+
+type testProject struct {
+	ID    uint
+	Title string
+	Todos []*testTodo `gorm:"many2many:test_project_todos"`
+}
+
+func (p testProject) Identity() (fieldName string, value any) {
+	return "ID", p.ID
+}
+
+// setupTestProjects creates projects p1 and p2 sharing the todo t1,
+// with testTodo and testProject registered.
+func setupTestProjects(t *testing.T) (p1, p2 *testProject, t1 *testTodo) {
+	t.Helper()
+	setupTestDB(t)
+
+	if err := orm.RegisterModel(&testTodo{}, &testProject{}); err != nil {
+		t.Fatalf("register model: %v", err)
+	}
+	t1 = &testTodo{Title: "t1"}
+	p1 = &testProject{Title: "p1", Todos: []*testTodo{t1}}
+	p2 = &testProject{Title: "p2", Todos: []*testTodo{t1}}
+	for _, p := range []*testProject{p1, p2} {
+		if err := orm.DB.Create(p).Error; err != nil {
+			t.Fatalf("create project: %v", err)
+		}
+	}
+	return p1, p2, t1
+}
+
+type testEntry struct {
+	orm.HardModel
+	Amount int
+}
+
+func TestDeleteByID_hardModel(t *testing.T) {
+	setupTestDB(t)
+	if err := orm.RegisterModel(&testEntry{}); err != nil {
+		t.Fatalf("register model: %v", err)
+	}
+	entry := &testEntry{Amount: 42}
+	if err := orm.DB.Create(entry).Error; err != nil {
+		t.Fatalf("create entry: %v", err)
+	}
+
+	rows, err := DeleteByID[testEntry](context.Background(), entry.ID)
+	if err != nil || rows != 1 {
+		t.Fatalf("DeleteByID() = %v, %v, want 1, nil", rows, err)
+	}
+	var count int64
+	orm.DB.Unscoped().Model(&testEntry{}).Count(&count)
+	if count != 0 {
+		t.Errorf("got %v entries (including the deleted), want 0", count)
+	}
+}
+
+type testTenantKey struct{}
+
+func TestRegisterScope(t *testing.T) {
+	setupTestDB(t,
+		&testUser{Name: "John", Status: "a"},
+		&testUser{Name: "Jane", Status: "b"},
+		&testUser{Name: "Jack", Status: "a"},
+	)
+	orm.RegisterScope("tenant", func(tx *gorm.DB) *gorm.DB {
+		if tenant, ok := tx.Statement.Context.Value(testTenantKey{}).(string); ok {
+			return tx.Where("status = ?", tenant)
+		}
+		return tx
+	})
+	t.Cleanup(func() { orm.UnregisterScope("tenant") })
+
+	ctx := context.WithValue(context.Background(), testTenantKey{}, "a")
+
+	var users []testUser
+	if err := GetMany[testUser](ctx, &users, FilterBy("name", "Jane")); err != nil || len(users) != 0 {
+		t.Errorf("GetMany() out of scope = %v, %v, want none", users, err)
+	}
+	if count, err := Count[testUser](ctx); err != nil || count != 2 {
+		t.Errorf("Count() = %v, %v, want 2", count, err)
+	}
+	if count, err := Count[testUser](context.Background()); err != nil || count != 3 {
+		t.Errorf("Count() without tenant = %v, %v, want 3", count, err)
+	}
+	if err := GetByID[testUser](ctx, 2, &testUser{}); err == nil {
+		t.Errorf("GetByID() out of scope, want error")
+	}
+	if _, err := UpdateFields[testUser](ctx, 2, map[string]any{"name": "x"}); err == nil {
+		t.Errorf("UpdateFields() out of scope, want error")
+	}
+	if rows, _ := Delete(ctx, &testUser{ID: 2}); rows != 0 {
+		t.Errorf("Delete() out of scope deleted %v rows, want 0", rows)
+	}
+	if rows, err := DeleteByID[testUser](ctx, 1); err != nil || rows != 1 {
+		t.Errorf("DeleteByID() in scope = %v, %v, want 1, nil", rows, err)
+	}
+	if count, err := Count[testUser](SkipScope(ctx, "tenant")); err != nil || count != 2 {
+		t.Errorf("Count() skipping the scope = %v, %v, want 2", count, err)
+	}
+}
+
+func TestUseScope(t *testing.T) {
+	setupTestDB(t,
+		&testUser{Name: "John", Active: true},
+		&testUser{Name: "Jane", Active: false},
+	)
+	orm.RegisterOptInScope("active", func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("active = ?", true)
+	})
+	t.Cleanup(func() { orm.UnregisterScope("active") })
+	ctx := context.Background()
+
+	if count, err := Count[testUser](ctx); err != nil || count != 2 {
+		t.Errorf("Count() without opting in = %v, %v, want 2", count, err)
+	}
+	used := UseScope(ctx, "active")
+	if count, err := Count[testUser](used); err != nil || count != 1 {
+		t.Errorf("Count() opting in = %v, %v, want 1", count, err)
+	}
+	if count, err := Count[testUser](SkipScope(used, "active")); err != nil || count != 2 {
+		t.Errorf("Count() opting in and out = %v, %v, want 2", count, err)
+	}
+	if count, err := Count[testUser](used); err != nil || count != 1 {
+		t.Errorf("Count() of the parent context = %v, %v, want 1", count, err)
+	}
+}
+
+func TestScope(t *testing.T) {
+	setupTestDB(t,
+		&testUser{Name: "John", Status: "a", Active: true},
+		&testUser{Name: "Jane", Status: "b", Active: true},
+		&testUser{Name: "Jack", Status: "a", Active: false},
+	)
+	RegisterScope("active", func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("active = ?", true)
+	})
+	t.Cleanup(func() { scopeRegistry.Delete(scopeKey{nil, "active"}) })
+	ctx := context.Background()
+
+	var users []testUser
+	if err := GetMany[testUser](ctx, &users, Scope("active"), FilterBy("status", "a")); err != nil {
+		t.Fatalf("GetMany() error = %v", err)
+	}
+	if len(users) != 1 || users[0].Name != "John" {
+		t.Errorf("GetMany() = %v, want [John]", users)
+	}
+	if count, err := Count[testUser](ctx, Scope("active")); err != nil || count != 2 {
+		t.Errorf("Count() = %v, %v, want 2", count, err)
+	}
+	if err := GetMany[testUser](ctx, &users, Scope("nope")); !errors.Is(err, ErrUnknownScope) {
+		t.Errorf("GetMany() unknown scope error = %v, want %v", err, ErrUnknownScope)
+	}
+}
+
+func TestModelScope(t *testing.T) {
+	setupTestDB(t,
+		&testUser{Name: "John", Status: "a", Active: true},
+		&testUser{Name: "Jane", Status: "b", Active: true},
+	)
+	RegisterScope("named", func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("1 = 0")
+	})
+	RegisterModelScope[testUser]("named", func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("name = ?", "Jane")
+	})
+	RegisterModelScope[testUser]("statusA", func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("status = ?", "a")
+	})
+	t.Cleanup(func() {
+		scopeRegistry.Delete(scopeKey{nil, "named"})
+		scopeRegistry.Delete(scopeKey{reflect.TypeOf((*testUser)(nil)), "named"})
+		scopeRegistry.Delete(scopeKey{reflect.TypeOf((*testUser)(nil)), "statusA"})
+	})
+	ctx := context.Background()
+
+	var users []testUser
+	if err := GetMany[testUser](ctx, &users, ModelScope[testUser]("named")); err != nil {
+		t.Fatalf("GetMany() error = %v", err)
+	}
+	if len(users) != 1 || users[0].Name != "Jane" { // the scope of the model takes precedence
+		t.Errorf("GetMany() = %v, want [Jane]", users)
+	}
+	if count, err := Count[testUser](ctx, Scope("named")); err != nil || count != 0 {
+		t.Errorf("Count() with the global scope = %v, %v, want 0", count, err)
+	}
+	if err := GetMany[testUser](ctx, &users, Scope("statusA")); !errors.Is(err, ErrUnknownScope) {
+		t.Errorf("GetMany() model scope by Scope error = %v, want %v", err, ErrUnknownScope)
+	}
+
+	if _, ok := ScopeOf(new(testUser), "statusA"); !ok {
+		t.Errorf("ScopeOf(statusA) ok = false, want true")
+	}
+	if _, ok := ScopeOf(new(testEntry), "statusA"); ok {
+		t.Errorf("ScopeOf() of another model ok = true, want false")
+	}
+	if _, ok := ScopeOf(new(testEntry), "named"); !ok {
+		t.Errorf("ScopeOf() of a global scope ok = false, want true")
+	}
+	if _, ok := ScopeOf(new(testUser), "nope"); ok {
+		t.Errorf("ScopeOf(nope) ok = true, want false")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	setupTestDB(t)
+	t.Cleanup(func() {
+		eventsMu.Lock()
+		events = map[eventKey][]*eventHandler{}
+		eventsMu.Unlock()
+	})
+	ctx := context.Background()
+
+	var got []string
+	record := func(event Event) func(ctx context.Context, user *testUser) {
+		return func(ctx context.Context, user *testUser) {
+			got = append(got, fmt.Sprintf("%s %d %s", event, user.ID, user.Name))
+		}
+	}
+	OnCreate(func(ctx context.Context, user *testUser) { panic("boom") })
+	OnCreate(record(EventCreate))
+	OnUpdate(record(EventUpdate))
+	OnDelete(record(EventDelete))
+	OnCreate(func(ctx context.Context, todo *testTodo) { t.Errorf("handler of another model called") })
+
+	async := make(chan *testUser, 1)
+	OnDelete(func(ctx context.Context, user *testUser) { async <- user }, Async())
+
+	user := &testUser{Name: "John"}
+	if err := Create(ctx, user, IfNotExist()); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := UpdateFields[testUser](ctx, user.ID, map[string]any{"name": "Jack"}); err != nil {
+		t.Fatalf("UpdateFields() error = %v", err)
+	}
+	if _, err := UpdateFields[testUser](ctx, 42, map[string]any{"name": "Jane"}); err == nil {
+		t.Fatalf("UpdateFields() not found, want error")
+	}
+	if _, err := DeleteByID[testUser](ctx, user.ID); err != nil {
+		t.Fatalf("DeleteByID() error = %v", err)
+	}
+	if rows, _ := Delete(ctx, &testUser{ID: 42}); rows != 0 {
+		t.Fatalf("Delete() not found deleted %v rows", rows)
+	}
+
+	want := []string{"create 1 John", "update 1 Jack", "delete 1 Jack"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	select {
+	case deleted := <-async:
+		if deleted.ID != user.ID {
+			t.Errorf("async delete event of %v, want %v", deleted.ID, user.ID)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("async handler not called")
+	}
+}
+
+func TestEvents_asyncGinContext(t *testing.T) {
+	t.Cleanup(func() {
+		eventsMu.Lock()
+		events = map[eventKey][]*eventHandler{}
+		eventsMu.Unlock()
+	})
+
+	release := make(chan struct{})
+	got := make(chan [2]any, 2)
+	OnCreate(func(ctx context.Context, user *testUser) {
+		<-release // after the gin.Context is recycled
+		got <- [2]any{user.Name, ctx.Value("request_id")}
+	}, Async())
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/users", func(c *gin.Context) {
+		c.Set("request_id", c.Query("name"))
+		emit(c, EventCreate, &testUser{Name: c.Query("name")})
+	})
+	for _, name := range []string{"a", "b"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/users?name="+name, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST %s: status = %v, want %v", name, w.Code, http.StatusOK)
+		}
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		select {
+		case v := <-got:
+			if v[0] != v[1] {
+				t.Errorf("async handler of %v got request_id %v", v[0], v[1])
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("async handler not called")
+		}
+	}
+}
+
+func TestGormIdempotencyStore(t *testing.T) {
+	setupTestDB(t)
+	store := NewGormIdempotencyStore()
+	ctx := context.Background()
+
+	if got, err := store.Get(ctx, "k"); got != nil || err != nil {
+		t.Fatalf("Get() before Put = %v, %v, want nil, nil", got, err)
+	}
+
+	response := &IdempotentResponse{Status: 201, ContentType: "application/json", Location: "/users/1", Body: []byte(`{}`)}
+	if err := store.Put(ctx, "k", response, time.Hour); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if got, err := store.Get(ctx, "k"); err != nil || !reflect.DeepEqual(got, response) {
+		t.Errorf("Get() = %v, %v, want %v", got, err, response)
+	}
+
+	if err := store.Put(ctx, "k", response, -time.Second); err != nil {
+		t.Fatalf("Put() expired error = %v", err)
+	}
+	if got, err := store.Get(ctx, "k"); got != nil || err != nil {
+		t.Errorf("Get() expired = %v, %v, want nil, nil", got, err)
+	}
+}
+
+func TestDeleteNestedCascade(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("default", func(t *testing.T) {
+		p1, p2, t1 := setupTestProjects(t)
+		for _, p := range []*testProject{p1, p2} {
+			if err := DeleteNested(ctx, p, "Todos", t1); err != nil {
+				t.Fatalf("DeleteNested() error = %v", err)
+			}
+		}
+		if err := GetByID[testTodo](ctx, t1.ID, &testTodo{}); err != nil {
+			t.Errorf("DeleteNested() deleted the orphaned child: %v", err)
+		}
+	})
+
+	t.Run("cascade", func(t *testing.T) {
+		p1, p2, t1 := setupTestProjects(t)
+
+		if err := DeleteNestedCascade(ctx, p1, "Todos", t1); err != nil {
+			t.Fatalf("DeleteNestedCascade() error = %v", err)
+		}
+		if err := GetByID[testTodo](ctx, t1.ID, &testTodo{}); err != nil {
+			t.Errorf("still referenced by p2, but deleted: %v", err)
+		}
+
+		if err := DeleteNestedCascade(ctx, p2, "Todos", t1); err != nil {
+			t.Fatalf("DeleteNestedCascade() error = %v", err)
+		}
+		if err := GetByID[testTodo](ctx, t1.ID, &testTodo{}); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("orphaned, want deleted, got error = %v", err)
+		}
+	})
+}
+
+func TestReplaceAssociation(t *testing.T) {
+	ctx := context.Background()
+	p1, _, t1 := setupTestProjects(t)
+
+	t2 := &testTodo{Title: "t2"}
+	if err := Create(ctx, t2, NestInto(p1, "Todos")); err != nil {
+		t.Fatalf("NestInto() error = %v", err)
+	}
+
+	t3 := &testTodo{Title: "t3"}
+	if err := ReplaceAssociation(ctx, p1, "Todos", []*testTodo{t2, t3}); err != nil {
+		t.Fatalf("ReplaceAssociation() error = %v", err)
+	}
+
+	var todos []*testTodo
+	if err := GetAssociations(ctx, p1, "Todos", &todos, OrderBy("id", false)); err != nil {
+		t.Fatalf("GetAssociations() error = %v", err)
+	}
+	var titles []string
+	for _, todo := range todos {
+		titles = append(titles, todo.Title)
+	}
+	if got := strings.Join(titles, ","); got != "t2,t3" {
+		t.Errorf("todos of p1 = %v, want t2,t3", got)
+	}
+
+	// detached, not deleted
+	if err := GetByID[testTodo](ctx, t1.ID, &testTodo{}); err != nil {
+		t.Errorf("detached todo deleted: %v", err)
+	}
+}
+
+type testAccount struct {
+	ID       uint
+	Name     string
+	Sessions []*testSession
+}
+
+func (a testAccount) Identity() (fieldName string, value any) {
+	return "ID", a.ID
+}
+
+type testSession struct {
+	ID            uint
+	TestAccountID uint
+	CreatedAt     time.Time
+}
+
+func TestGetAssociations_orderAndPage(t *testing.T) {
+	ctx := context.Background()
+	setupTestDB(t)
+	if err := orm.RegisterModel(&testAccount{}, &testSession{}); err != nil {
+		t.Fatalf("register model: %v", err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	account := testAccount{Name: "a"}
+	for i := 0; i < 5; i++ { // created_at: ids 1..5 => day 5..1 (reversed)
+		account.Sessions = append(account.Sessions, &testSession{CreatedAt: start.AddDate(0, 0, 5-i)})
+	}
+	orm.DB.Create(&account)
+	orm.DB.Create(&testAccount{Name: "b", Sessions: []*testSession{{CreatedAt: start.AddDate(1, 0, 0)}}})
+
+	tests := []struct {
+		name      string
+		options   []QueryOption
+		wantIDs   []uint
+		wantCount int64
+	}{
+		{"order", []QueryOption{OrderBy("created_at", true)}, []uint{1, 2, 3, 4, 5}, 5},
+		{"order asc", []QueryOption{OrderBy("created_at", false)}, []uint{5, 4, 3, 2, 1}, 5},
+		{"order and limit", []QueryOption{OrderBy("created_at", false), WithPage(2, 0)}, []uint{5, 4}, 5},
+		{"page", []QueryOption{OrderBy("created_at", false), WithPage(2, 2)}, []uint{3, 2}, 5},
+		{"filter", []QueryOption{Where("created_at > ?", start.AddDate(0, 0, 3)), OrderBy("id", true)}, []uint{2, 1}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sessions []*testSession
+			if err := GetAssociations(ctx, &account, "Sessions", &sessions, tt.options...); err != nil {
+				t.Fatalf("GetAssociations() error = %v", err)
+			}
+			var ids []uint
+			for _, session := range sessions {
+				ids = append(ids, session.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("GetAssociations() = %v, want %v", ids, tt.wantIDs)
+			}
+
+			count, err := CountAssociations(ctx, &account, "Sessions", tt.options...)
+			if err != nil || count != tt.wantCount {
+				t.Errorf("CountAssociations() = %v, %v, want %v", count, err, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestCountAssociations(t *testing.T) {
+	ctx := context.Background()
+	p1, _, _ := setupTestProjects(t)
+	for _, title := range []string{"a", "b", "a"} {
+		if err := Create(ctx, &testTodo{Title: title}, NestInto(p1, "Todos")); err != nil {
+			t.Fatalf("NestInto() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		field   string
+		options []QueryOption
+		want    int64
+		wantErr bool
+	}{
+		{"all", "Todos", nil, 4, false},
+		{"filtered", "Todos", []QueryOption{FilterBy("title", "a")}, 2, false},
+		{"filter in", "Todos", []QueryOption{FilterIn("title", "a", "t1")}, 3, false},
+		{"unknown field", "Nope", nil, 0, true},
+		{"bad condition", "Todos", []QueryOption{Where("nope = ?", 1)}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CountAssociations(ctx, p1, tt.field, tt.options...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CountAssociations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CountAssociations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetDefaultTimeout(t *testing.T) {
+	setupTestDB(t, &testUser{Name: "a"})
+	t.Cleanup(func() { SetDefaultTimeout(0) })
+
+	get := func(ctx context.Context) error {
+		var users []*testUser
+		return GetMany[testUser](ctx, &users)
+	}
+
+	SetDefaultTimeout(time.Nanosecond)
+	if err := get(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("with timeout: error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := Count[testUser](context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("with timeout: Count error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	SetDefaultTimeout(time.Minute)
+	if err := get(context.Background()); err != nil {
+		t.Errorf("with long timeout: error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond) // shorter
+	defer cancel()
+	if err := get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("with shorter ctx deadline: error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	SetDefaultTimeout(0)
+	if err := get(context.Background()); err != nil {
+		t.Errorf("disabled: error = %v", err)
+	}
+}
+
+type testDevice struct {
+	ID   string `gorm:"column:uid;primaryKey"`
+	Name string
+}
+
+func (d testDevice) Identity() (fieldName string, value any) {
+	return "ID", d.ID
+}
+
+func TestGetByID_columnName(t *testing.T) {
+	ctx := context.Background()
+	setupTestDB(t)
+	if err := orm.RegisterModel(&testDevice{}); err != nil {
+		t.Fatalf("register model: %v", err)
+	}
+	orm.DB.Create(&[]testDevice{{ID: "d1", Name: "a"}, {ID: "d2", Name: "b"}})
+
+	var device testDevice
+	if err := GetByID[testDevice](ctx, "d2", &device); err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if device.Name != "b" {
+		t.Errorf("GetByID() = %+v, want d2", device)
+	}
+
+	if _, err := UpdateFields[testDevice](ctx, "d1", map[string]any{"name": "c"}); err != nil {
+		t.Errorf("UpdateFields() error = %v", err)
+	}
+	if _, err := UpdateFields[testDevice](ctx, "d1", map[string]any{"uid": "d3"}); !errors.Is(err, ErrUpdateID) {
+		t.Errorf("UpdateFields(uid) error = %v, want %v", err, ErrUpdateID)
+	}
+	if _, err := DeleteByID[testDevice](ctx, "d1"); err != nil {
+		t.Errorf("DeleteByID() error = %v", err)
+	}
+}
+
+type testCustomer struct {
+	ID      uint
+	Name    string
+	Profile *testCustomerProfile
+}
+
+type testCustomerProfile struct {
+	ID             uint
+	TestCustomerID uint
+	City           string
+}
+
+type testOrder struct {
+	ID             uint
+	TestCustomerID uint
+	TestCustomer   *testCustomer
+}
+
+func TestFilterByJoin(t *testing.T) {
+	ctx := context.Background()
+	setupTestDB(t)
+	if err := orm.RegisterModel(&testCustomer{}, &testCustomerProfile{}, &testOrder{}); err != nil {
+		t.Fatalf("register model: %v", err)
+	}
+	for i, city := range []string{"Paris", "Tokyo", "Paris"} {
+		customer := testCustomer{Name: fmt.Sprint("c", i+1), Profile: &testCustomerProfile{City: city}}
+		orm.DB.Create(&customer)
+		orm.DB.Create(&testOrder{TestCustomerID: customer.ID})
+	}
+	orm.DB.Create(&testCustomer{Name: "no profile"})
+
+	t.Run("has one", func(t *testing.T) {
+		var customers []*testCustomer
+		err := GetMany[testCustomer](ctx, &customers, FilterByJoin("Profile", "city", "Paris"), OrderBy("test_customers.id", false))
+		if err != nil {
+			t.Fatalf("GetMany() error = %v", err)
+		}
+		var names []string
+		for _, customer := range customers {
+			names = append(names, customer.Name)
+		}
+		if got := strings.Join(names, ","); got != "c1,c3" {
+			t.Errorf("customers in Paris = %v, want c1,c3", got)
+		}
+	})
+
+	t.Run("belongs to", func(t *testing.T) {
+		var orders []*testOrder
+		err := GetMany[testOrder](ctx, &orders, FilterByJoin("TestCustomer", "name", "c2"))
+		if err != nil {
+			t.Fatalf("GetMany() error = %v", err)
+		}
+		if len(orders) != 1 || orders[0].TestCustomerID != 2 {
+			t.Errorf("orders of c2 = %+v, want 1 order of customer 2", orders)
+		}
+	})
+
+	t.Run("count", func(t *testing.T) {
+		count, err := Count[testCustomer](ctx, FilterByJoin("Profile", "city", "Tokyo"))
+		if err != nil || count != 1 {
+			t.Errorf("Count() = %v, %v, want 1", count, err)
+		}
+	})
+}
+
+func TestWithMetrics(t *testing.T) {
+	setupTestDB(t)
+	t.Cleanup(func() { metrics.Store(nil) })
+	ctx := context.Background()
+
+	registry := prometheus.NewRegistry()
+	if err := WithMetrics(registry); err != nil {
+		t.Fatalf("WithMetrics() error = %v", err)
+	}
+	if err := WithMetrics(registry); err != nil {
+		t.Fatalf("WithMetrics() again error = %v, want reusing the collectors", err)
+	}
+	m := metrics.Load()
+
+	if err := Create(ctx, &testUser{Name: "John"}, IfNotExist()); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(m.operations.WithLabelValues("testUser", opCreate, "ok")); got != 1 {
+		t.Errorf("create ok = %v, want 1", got)
+	}
+
+	var user testUser
+	_ = GetByID[testUser](ctx, 404, &user)
+	if got := testutil.ToFloat64(m.operations.WithLabelValues("testUser", opGet, "error")); got != 1 {
+		t.Errorf("get error = %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(m.duration); got != 2 {
+		t.Errorf("duration series = %v, want 2 (create and get)", got)
+	}
+
+	if err := CreateMany(ctx, []*testUser{{Name: "Jane"}, {Name: "Jack"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(m.operations.WithLabelValues("testUser", opCreate, "ok")); got != 2 {
+		t.Errorf("create ok = %v, want 2 with CreateMany", got)
+	}
+	var users []*testUser
+	if _, err := GetManyWithTotal[testUser](ctx, &users); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(m.operations.WithLabelValues("testUser", opList, "ok")); got != 1 {
+		t.Errorf("list ok = %v, want 1 by GetManyWithTotal", got)
+	}
+	_ = Stream[testUser](ctx, func(*testUser) error { return errors.New("stop") })
+	if got := testutil.ToFloat64(m.operations.WithLabelValues("testUser", opStream, "error")); got != 1 {
+		t.Errorf("stream error = %v, want 1", got)
+	}
+}
+
+func TestGetOneAndList(t *testing.T) {
+	setupTestDB(t, &testUser{Name: "John", Active: true}, &testUser{Name: "Jane", Active: true})
+	ctx := context.Background()
+
+	user, err := GetOne[testUser](ctx, FilterBy("name", "Jane"))
+	if err != nil || user.Name != "Jane" {
+		t.Errorf("GetOne() = %v, %v, want Jane", user, err)
+	}
+	_, err = GetOne[testUser](ctx, FilterBy("name", "Nobody"))
+	if !errors.Is(err, ErrNoRecord) || !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("GetOne(not found) error = %v, want ErrNoRecord wrapping gorm.ErrRecordNotFound", err)
+	}
+
+	users, err := List[testUser](ctx, FilterBy("active", true), OrderBy("name", false))
+	if err != nil || len(users) != 2 || users[0].Name != "Jane" {
+		t.Errorf("List() = %v, %v, want [Jane John]", users, err)
+	}
+	users, err = List[testUser](ctx, FilterBy("name", "Nobody"))
+	if err != nil || users == nil || len(users) != 0 {
+		t.Errorf("List(not found) = %#v, %v, want an empty slice", users, err)
+	}
+}
+
+type testPurchase struct {
+	ID     uint
+	UserID uint
+	Amount int
+}
+
+func TestWhereIn(t *testing.T) {
+	john, jane, jack := &testUser{Name: "John"}, &testUser{Name: "Jane"}, &testUser{Name: "Jack"}
+	setupTestDB(t, john, jane, jack)
+	if err := orm.RegisterModel(&testPurchase{}); err != nil {
+		t.Fatal(err)
+	}
+	orm.DB.Create([]*testPurchase{{UserID: john.ID, Amount: 10}, {UserID: jane.ID, Amount: 200}})
+	ctx := context.Background()
+
+	names := func(options ...QueryOption) []string {
+		t.Helper()
+		users, err := List[testUser](ctx, append(options, OrderBy("name", false))...)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		var names []string
+		for _, user := range users {
+			names = append(names, user.Name)
+		}
+		return names
+	}
+
+	purchases := orm.DB.Model(&testPurchase{}).Select("user_id")
+	if got := names(WhereIn("id", purchases)); !reflect.DeepEqual(got, []string{"Jane", "John"}) {
+		t.Errorf("WhereIn(purchases) = %v, want [Jane John]", got)
+	}
+	bigPurchases := orm.DB.Model(&testPurchase{}).Select("user_id").Where("amount > ?", 100)
+	if got := names(WhereIn("id", bigPurchases)); !reflect.DeepEqual(got, []string{"Jane"}) {
+		t.Errorf("WhereIn(big purchases) = %v, want [Jane]", got)
+	}
+	if got := names(WhereNotIn("id", purchases)); !reflect.DeepEqual(got, []string{"Jack"}) {
+		t.Errorf("WhereNotIn(purchases) = %v, want [Jack]", got)
+	}
+
+	if _, err := List[testUser](ctx, WhereIn("id; DROP TABLE users", purchases)); !errors.Is(err, ErrInvalidColumn) {
+		t.Errorf("WhereIn(invalid column) error = %v, want %v", err, ErrInvalidColumn)
+	}
+	if _, err := List[testUser](ctx, WhereIn("id", nil)); !errors.Is(err, ErrNilSubquery) {
+		t.Errorf("WhereIn(nil) error = %v, want %v", err, ErrNilSubquery)
+	}
+}
+
+func TestLocalFileStore_Delete(t *testing.T) {
+	store := NewLocalFileStore(t.TempDir(), "/files")
+	ctx := context.Background()
+
+	url, err := store.Save(ctx, "a b.txt", strings.NewReader("a"))
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := store.Delete(ctx, url); err != nil {
+		t.Errorf("Delete(%q) error = %v", url, err)
+	}
+	if _, err := os.Stat(filepath.Join(store.Dir, "a b.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file is not deleted: %v", err)
+	}
+	if err := store.Delete(ctx, url); err != nil {
+		t.Errorf("Delete(%q) again error = %v, want nil", url, err)
+	}
+
+	for _, invalid := range []string{"/other/a.txt", "/files/..%2Fa.txt", "/files/", "https://example.com/a.txt"} {
+		if err := store.Delete(ctx, invalid); !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("Delete(%q) error = %v, want %v", invalid, err, ErrInvalidFilename)
+		}
+	}
+}
+
+func TestReload_readPrimary(t *testing.T) {
+	dir := t.TempDir()
+	replica := filepath.Join(dir, "replica.db")
+	replicaDB, err := gorm.Open(sqlite.Open(replica), &gorm.Config{Logger: gormlogger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replicaDB.AutoMigrate(&testUser{}); err != nil { // lagging: no rows
+		t.Fatal(err)
+	}
+	db, err := orm.ConnectWithReplicas(orm.DBDriverSqlite, filepath.Join(dir, "primary.db"), []string{replica})
+	if err != nil {
+		t.Fatalf("ConnectWithReplicas() error = %v", err)
+	}
+	t.Cleanup(func() {
+		orm.SetDB(nil)
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	if err := orm.RegisterModel(&testUser{}); err != nil {
+		t.Fatal(err)
+	}
+
+	user := testUser{Name: "a"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := GetByID[testUser](context.Background(), user.ID, new(testUser)); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("GetByID() error = %v, want not found on the replica", err)
+	}
+	if err := GetByID[testUser](context.Background(), user.ID, new(testUser), ReadPrimary()); err != nil {
+		t.Errorf("GetByID(ReadPrimary) error = %v, want found on the primary", err)
+	}
+	if err := Reload(context.Background(), &testUser{ID: user.ID}); err != nil {
+		t.Errorf("Reload() error = %v, want found on the primary", err)
+	}
+}
@@ -5,10 +5,20 @@ import (
 	"errors"
 	"fmt"
 	"github.com/cdfmlr/crud/orm"
+	"gorm.io/gorm"
 )
 
 // Update all fields of an existing model in database.
-func Update(ctx context.Context, model any) (rowsAffected int64, err error) {
+//
+// The conditions, if any, are checked besides the primary key in the same
+// query, e.g. an optimistic lock on the UpdatedAt read before:
+//    Update(ctx, &user, Where("updated_at = ?", readUpdatedAt))
+// ErrNotUpdated is returned if they do not match (or the record is gone).
+func Update(ctx context.Context, model any, conditions ...QueryOption) (rowsAffected int64, err error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	defer observe(opUpdate, model)(&err)
+
 	logger.WithContext(ctx).
 		WithField("model", model).Trace("Update model")
 
@@ -18,10 +28,28 @@ func Update(ctx context.Context, model any) (rowsAffected int64, err error) {
 		return 0, ErrNoRecord
 	}
 
-	result := orm.DB.WithContext(ctx).Save(model)
+	var result *gorm.DB
+	if len(conditions) == 0 {
+		db, release := orm.Acquire()
+		defer release()
+		result = db.WithContext(ctx).Save(model)
+	} else { // Save inserts the model if no row matches
+		db, release := orm.Acquire()
+		defer release()
+		tx := db.WithContext(ctx).Model(model)
+		for _, condition := range conditions {
+			tx = condition(tx)
+		}
+		result = tx.Select("*").Updates(model)
+		if result.Error == nil && result.RowsAffected == 0 {
+			result.Error = ErrNotUpdated
+		}
+	}
 	if result.Error != nil {
 		logger.WithContext(ctx).
 			WithError(result.Error).Warn("Update: failed")
+	} else {
+		emit(ctx, EventUpdate, model)
 	}
 	return result.RowsAffected, result.Error
 }
@@ -29,11 +57,16 @@ func Update(ctx context.Context, model any) (rowsAffected int64, err error) {
 var (
 	ErrNoRecord        = errors.New("no record found")
 	ErrMultipleRecords = errors.New("multiple records found")
+	ErrUpdateID        = errors.New("id can not be updated")
+	ErrNotUpdated      = errors.New("no record updated by the conditions")
 )
 
 // UpdateField updates a single fields of an existing model in database.
 // It will try to GetByID first, to make sure the model exists, before updating.
 func UpdateField[T orm.Model](ctx context.Context, id any, field string, value interface{}) (rowsAffected int64, err error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
 	logger.WithContext(ctx).
 		WithField("model", fmt.Sprintf("%T", *new(T))).
 		WithField("id", id).WithField("field", field).
@@ -46,10 +79,79 @@ func UpdateField[T orm.Model](ctx context.Context, id any, field string, value i
 			Warn("UpdateField: GetByID failed")
 		return 0, err
 	}
-	result := orm.DB.WithContext(ctx).Model(&record).Update(field, value)
+	db, release := scopedDB(ctx)
+	defer release()
+	result := db.Model(&record).Update(field, value)
 	if result.Error != nil {
 		logger.WithContext(ctx).
 			WithError(result.Error).Warn("UpdateField: failed")
+	} else {
+		emit(ctx, EventUpdate, &record)
+	}
+	return result.RowsAffected, result.Error
+}
+
+// UpdateFields updates the given fields (column => value) of an existing
+// model in database in a single query. Only the fields in values are updated,
+// which makes it possible to update a field to its zero value.
+// It will try to GetByID first, to make sure the model exists, before updating.
+//
+// The identity field (see orm.Model) can not be updated: ErrUpdateID is
+// returned if values contains it.
+//
+// The conditions, if any, are checked in the update query as the ones of
+// Update, and ErrNotUpdated is returned if they do not match.
+func UpdateFields[T orm.Model](ctx context.Context, id any, values map[string]any, conditions ...QueryOption) (rowsAffected int64, err error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	defer observe(opUpdate, (*T)(nil))(&err)
+
+	logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T))).
+		WithField("id", id).WithField("values", values).
+		Trace("UpdateFields")
+
+	if hasIdentityField[T](values) {
+		logger.WithContext(ctx).
+			WithField("id", id).
+			Warn("UpdateFields: cannot update id")
+		return 0, ErrUpdateID
+	}
+
+	var record T
+	if err := GetByID[T](ctx, id, &record); err != nil {
+		logger.WithContext(ctx).
+			WithField("id", id).WithError(err).
+			Warn("UpdateFields: GetByID failed")
+		return 0, err
+	}
+	db, release := scopedDB(ctx)
+	defer release()
+	tx := db.Model(&record)
+	for _, condition := range conditions {
+		tx = condition(tx)
+	}
+	result := tx.Updates(values)
+	if result.Error == nil && len(conditions) > 0 && result.RowsAffected == 0 {
+		result.Error = ErrNotUpdated
+	}
+	if result.Error != nil {
+		logger.WithContext(ctx).
+			WithError(result.Error).Warn("UpdateFields: failed")
+	} else {
+		emit(ctx, EventUpdate, &record)
 	}
 	return result.RowsAffected, result.Error
 }
+
+// hasIdentityField checks whether the identity field of model T is
+// a key (by field name or column name) of values.
+func hasIdentityField[T orm.Model](values map[string]any) bool {
+	idField, idColumn := identityColumn[T]()
+	for key := range values {
+		if key == idField || key == idColumn {
+			return true
+		}
+	}
+	return false
+}